@@ -1,6 +1,7 @@
 package controllers
 
 import (
+	"database/sql"
 	"errors"
 	"os"
 
@@ -21,12 +22,14 @@ type Auth struct {
 }
 
 type AuthHandler struct {
+	db          *sql.DB
 	userRepo    sso_user.UserRepo
 	sessionRepo sso_session.SessionRepo
 }
 
-func NewAuthHandler(userRepo sso_user.UserRepo, sessionRepo sso_session.SessionRepo) *AuthHandler {
+func NewAuthHandler(db *sql.DB, userRepo sso_user.UserRepo, sessionRepo sso_session.SessionRepo) *AuthHandler {
 	return &AuthHandler{
+		db:          db,
 		userRepo:    userRepo,
 		sessionRepo: sessionRepo,
 	}
@@ -52,7 +55,7 @@ func (h *AuthHandler) SSOAuthLogin(c *fiber.Ctx) error {
 	user_id := int(token_data.Claims.(jwt.MapClaims)["user_id"].(float64))
 
 	// check session on db if valid or not
-	tx, err := database.DB.Begin()
+	tx, err := h.db.Begin()
 	if err != nil {
 		return errors.New("Internal server error on setup db tx: " + err.Error())
 	}
@@ -1,23 +1,74 @@
 package controllers
 
 import (
+	"database/sql"
 	"fmt"
+	"scrapper-test/repository/llmusage"
 	"scrapper-test/utils"
-	"scrapper-test/utils/claude"
-	"scrapper-test/utils/openai"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/moderation"
+	"scrapper-test/utils/pricing"
+	"time"
+
+	sso_models "github.com/momokii/go-sso-web/pkg/models"
 
 	"github.com/gofiber/fiber/v2"
 )
 
+// bakuHantamPrompt create the analysis prompt from the scrapped tweet of the topic
+func bakuHantamPrompt(topicName string, topicData string) string {
+	return fmt.Sprintf(`
+	Analisis kumpulan tweet dari X tentang topik '%s'. Data berisi tweet individual dengan informasi owner (pemilik tweet) dan quoted (jika tweet tersebut mengutip tweet lain).
+
+	Berikan 2 bagian analisis dengan gaya bahasa santai/gaul (ala Jakarta):
+
+	1. Highlight & Analisis (maks 3-4 paragraf):
+	- Temuan menarik/unik dari tweet-tweet tersebut
+	- Pattern atau tren yang terlihat
+	- Interaksi antar user yang eye-catching
+	- Feel free buat roasting secara playful ke tweet/user tertentu yang mencolok
+	- Sebutkan username spesifik kalau relevan
+
+	2. TL;DR / Ringkasan (1-2 paragraf):
+	- Intisari dari drama/discourse yang terjadi
+	- Tone & sentiment dominan dari percakapan
+	- Quick take kamu tentang topik ini overall
+
+	Format output dalam HTML tags untuk readability. Hindari penggunaan header/judul section yaitu tidak perlu ditulis (Highlight/ Ringkasan) sebagai pembuka paragraf. Ketika terdapat beda paragraf gunakan <br> untuk line break.
+
+	Data tweet:
+
+	'%s'
+	`, topicName, topicData)
+}
+
+// bakuHantamPromptTrimmer trim the end of the scrapped tweet when the topic is too large for the model
+func bakuHantamPromptTrimmer(topicName string, topicData string) promptTrimmer {
+	return func(req *llm.Request, excessTokens int) bool {
+		var ok bool
+		topicData, ok = trimTextTokens(topicData, excessTokens)
+		req.Messages[0].Content = bakuHantamPrompt(topicName, topicData)
+		return ok
+	}
+}
+
 type BakuHantamController struct {
-	claude claude.ClaudeAPI
-	openai openai.OpenAI
+	db          *sql.DB
+	llm         *llm.Registry
+	usageRepo   llmusage.LLMUsageRepo
+	pricing     *pricing.PriceTable
+	moderator   *moderation.Moderator
+	generations *GenerationController
 }
 
-func NewBakuHantamController(claude claude.ClaudeAPI, openai openai.OpenAI) *BakuHantamController {
+func NewBakuHantamController(db *sql.DB, llm *llm.Registry, usageRepo llmusage.LLMUsageRepo, pricing *pricing.PriceTable, moderator *moderation.Moderator, generations *GenerationController) *BakuHantamController {
 	return &BakuHantamController{
-		claude: claude,
-		openai: openai,
+		db:          db,
+		llm:         llm,
+		usageRepo:   usageRepo,
+		pricing:     pricing,
+		moderator:   moderator,
+		generations: generations,
 	}
 }
 
@@ -28,7 +79,11 @@ func (h *BakuHantamController) ViewBakuHantam(c *fiber.Ctx) error {
 }
 
 func (h *BakuHantamController) GetBakuHantamTopic(c *fiber.Ctx) error {
-	topicList := utils.GetBakuHantamTopic()
+	topicList, err := utils.GetBakuHantamTopicWithContext(c.UserContext())
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusGatewayTimeout, "Failed to scrap bakuhantam topic: "+err.Error())
+	}
+
 	return utils.ResponseWithData(c, fiber.StatusOK, "List of Bakuhantam Topic", fiber.Map{
 		"topic": topicList,
 	})
@@ -36,72 +91,59 @@ func (h *BakuHantamController) GetBakuHantamTopic(c *fiber.Ctx) error {
 
 func (h *BakuHantamController) PostBakuHantam(c *fiber.Ctx) error {
 
-	var content string
-
 	topic := c.FormValue("topic")
 	topicName := c.FormValue("topicName")
 	type_llm := c.FormValue("model")
 
-	topicData := utils.DetailBakuHantamData(topic)
-
-	prompt := fmt.Sprintf(`
-	Analisis kumpulan tweet dari X tentang topik '%s'. Data berisi tweet individual dengan informasi owner (pemilik tweet) dan quoted (jika tweet tersebut mengutip tweet lain).
-
-	Berikan 2 bagian analisis dengan gaya bahasa santai/gaul (ala Jakarta):
-
-	1. Highlight & Analisis (maks 3-4 paragraf):
-	- Temuan menarik/unik dari tweet-tweet tersebut
-	- Pattern atau tren yang terlihat
-	- Interaksi antar user yang eye-catching
-	- Feel free buat roasting secara playful ke tweet/user tertentu yang mencolok
-	- Sebutkan username spesifik kalau relevan
-
-	2. TL;DR / Ringkasan (1-2 paragraf):
-	- Intisari dari drama/discourse yang terjadi
-	- Tone & sentiment dominan dari percakapan
-	- Quick take kamu tentang topik ini overall
-
-	Format output dalam HTML tags untuk readability. Hindari penggunaan header/judul section yaitu tidak perlu ditulis (Highlight/ Ringkasan) sebagai pembuka paragraf. Ketika terdapat beda paragraf gunakan <br> untuk line break.
+	provider, err := h.llm.Select(type_llm)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-	Data tweet:
+	topicData, err := utils.DetailBakuHantamDataWithContext(c.UserContext(), topic)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusGatewayTimeout, "Failed to scrap bakuhantam topic: "+err.Error())
+	}
 
-	'%v'
-	`, topicName, topicData)
+	tweets := fmt.Sprintf("%v", topicData)
 
-	if type_llm == "claude" {
-		prompt_input := []claude.ClaudeMessageReq{
+	userId := c.Locals("user").(sso_models.UserSession).Id
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, userId, utils.FEATURE_BAKU_HANTAM)
+	llmReq := llm.Request{
+		Messages: []llm.Message{
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: bakuHantamPrompt(topicName, tweets),
 			},
-		}
-
-		claudeResp, err := h.claude.ClaudeGetFirstContentDataResp(&prompt_input, 256*10, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-		}
-
-		content = claudeResp.Text
+		},
+		MaxTokens:   256 * 10,
+		MaxContinue: 1, // the debate cut in the middle of the sentence is continued once
+	}
 
-	} else {
-		prompt_input := []openai.OAMessageReq{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		}
+	// baku hantam is not charged, so only the context window is checked
+	estimate, err := guardPrompt(c.UserContext(), provider, &llmReq, h.pricing, promptGuardNoCredit, bakuHantamPromptTrimmer(topicName, tweets))
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		gptResp, err := h.openai.OpenAIGetFirstContentDataResp(&prompt_input, false, nil, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-		}
+	start := time.Now()
+	llmResp, err := provider.Generate(c.UserContext(), llmReq)
+	usage.recordLLM(provider.Name(), llmResp, start, err)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		content = gptResp.Content
+	if err := h.moderator.CheckOutput(c.UserContext(), llmResp.Content); err != nil {
+		return llmErrorResponse(c, err)
 	}
 
+	h.generations.save(userId, utils.FEATURE_BAKU_HANTAM, topicName, llmResp.Content)
+
 	return utils.ResponseWithData(c, fiber.StatusOK, "Bakuhantam Response", fiber.Map{
-		"content":    content,
+		"content":    llmResp.Content,
 		"topic_name": topicName,
 		"topic":      "https://bakuhantam.dev" + topic,
+		"estimate":   estimate,
+		"served_by":  usage.servedBy(),
 	})
 }
@@ -0,0 +1,146 @@
+package controllers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"scrapper-test/repository/generation"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/llm/llmtest"
+	"scrapper-test/utils/moderation"
+	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
+	"strings"
+	"testing"
+
+	sso_models "github.com/momokii/go-sso-web/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// id of the logged in user on the test app
+const testUserId = 1
+
+// testResponse is the json response written by utils.ErrorResponse and utils.ResponseWithData
+type testResponse struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// newTestApp create fiber app that serve the handler on path as the logged in test user with creditToken on the session,
+// the session middleware is not used
+func newTestApp(method string, path string, creditToken int, handler fiber.Handler) *fiber.App {
+	app := fiber.New()
+
+	app.Add(method, path, func(c *fiber.Ctx) error {
+		c.Locals("user", sso_models.UserSession{
+			Id:          testUserId,
+			Username:    "user1",
+			CreditToken: creditToken,
+		})
+		return c.Next()
+	}, handler)
+
+	return app
+}
+
+// sendTestRequest send the request to the app and return the status with the raw body
+func sendTestRequest(t *testing.T, app *fiber.App, req *http.Request) (int, []byte) {
+	t.Helper()
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode, body
+}
+
+// sendTestJSONRequest send the request to the app and decode the json response
+func sendTestJSONRequest(t *testing.T, app *fiber.App, req *http.Request) (int, testResponse) {
+	t.Helper()
+
+	status, body := sendTestRequest(t, app, req)
+
+	var resp testResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("decode response %q: %v", body, err)
+	}
+
+	return status, resp
+}
+
+// testEnv is the controller dependency backed by the fake LLM server and the fake db,
+// the moderation flag the text containing "BAD" and the generation is embedded from its words
+type testEnv struct {
+	srv         *llmtest.Server
+	registry    *llm.Registry
+	openai      openai.OpenAI
+	db          *sql.DB
+	fake        *fakeDB
+	moderator   *moderation.Moderator
+	generations *GenerationController
+}
+
+// newTestEnv create the test dependency with the test user having creditToken, claude is the default provider
+func newTestEnv(t *testing.T, creditToken int) *testEnv {
+	t.Helper()
+
+	srv := llmtest.New()
+	t.Cleanup(srv.Close)
+
+	srv.Handle(llmtest.EndpointOpenAIModerations, func(req llmtest.Request) llmtest.Reply {
+		if strings.Contains(string(req.Body), "BAD") {
+			return llmtest.Moderation(map[string]float64{"harassment": 0.99})
+		}
+		return llmtest.Moderation(nil)
+	})
+	srv.Handle(llmtest.EndpointOpenAIEmbeddings, func(req llmtest.Request) llmtest.Reply {
+		return llmtest.Embedding()
+	})
+
+	registry, err := srv.Registry(llm.ProviderClaude)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := srv.OpenAIClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, fake := newFakeDB(t)
+	fake.addUser(testUserId, creditToken)
+
+	return &testEnv{
+		srv:         srv,
+		registry:    registry,
+		openai:      client,
+		db:          db,
+		fake:        fake,
+		moderator:   moderation.New(client, moderation.DefaultPolicy()),
+		generations: NewGenerationController(db, client, *generation.NewGenerationRepo(), *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable()),
+	}
+}
+
+// chargedCredits return the credit the user should be charged for every llm usage recorded
+func (e *testEnv) chargedCredits() int {
+	var cost float64
+	for _, usage := range e.fake.llmUsages() {
+		cost += usage.Cost
+	}
+	if cost == 0 {
+		return 0
+	}
+
+	return pricing.DefaultPriceTable().Credits(cost)
+}
@@ -1,16 +1,21 @@
 package controllers
 
 import (
+	"database/sql"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
 	"path/filepath"
 	"scrapper-test/database"
 	"scrapper-test/models"
+	"scrapper-test/repository/llmusage"
 	"scrapper-test/utils"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/moderation"
 	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
 	"strings"
+	"time"
 
 	sso_models "github.com/momokii/go-sso-web/pkg/models"
 	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
@@ -19,15 +24,41 @@ import (
 	"github.com/gofiber/fiber/v2"
 )
 
+// json schema for the image analysis and the content recommendation response, generated from the struct the response is decoded to
+var (
+	creativeImageAnalysisSchema = llm.MustSchema("image_analysis", models.ImageAnalysisRes{})
+	creativeContentSchema       = llm.MustSchema("creative_content_maker", models.CreativeContentRecommendationRes{})
+)
+
+// max output tokens of the content recommendation (max 5 content with max 4096 characters each).
+// claude 3.5 sonnet only accept more than 4096 output tokens with the max-tokens beta header, the header is not sent
+// so the claude request is capped to 4096
+const (
+	creativeContentMaxTokens       = 8192
+	creativeContentClaudeMaxTokens = 4096
+)
+
 type CreativeContentController struct {
-	openai   openai.OpenAI
-	userRepo sso_user.UserRepo
+	db          *sql.DB
+	llm         *llm.Registry
+	openai      openai.OpenAI
+	userRepo    sso_user.UserRepo
+	usageRepo   llmusage.LLMUsageRepo
+	pricing     *pricing.PriceTable
+	moderator   *moderation.Moderator
+	generations *GenerationController
 }
 
-func NewCreativeContentController(openai openai.OpenAI, userRepo sso_user.UserRepo) *CreativeContentController {
+func NewCreativeContentController(db *sql.DB, llm *llm.Registry, openai openai.OpenAI, userRepo sso_user.UserRepo, usageRepo llmusage.LLMUsageRepo, pricing *pricing.PriceTable, moderator *moderation.Moderator, generations *GenerationController) *CreativeContentController {
 	return &CreativeContentController{
-		openai:   openai,
-		userRepo: userRepo,
+		db:          db,
+		llm:         llm,
+		openai:      openai,
+		userRepo:    userRepo,
+		usageRepo:   usageRepo,
+		pricing:     pricing,
+		moderator:   moderator,
+		generations: generations,
 	}
 }
 
@@ -41,6 +72,7 @@ func (h *CreativeContentController) GetImageAnalysis(c *fiber.Ctx) error {
 
 	// FORM INPUT AND CHECKER
 	language := c.FormValue("language", "indonesia")
+	type_llm := c.FormValue("model")
 	// process uploaded image to base64
 	uploaded_image, err := c.FormFile("image")
 	if err != nil {
@@ -87,62 +119,12 @@ func (h *CreativeContentController) GetImageAnalysis(c *fiber.Ctx) error {
 
 	`)
 
-	format_response_image_analysis := openai.OACreateResponseFormat(
-		"image_analysis",
-		map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"have_emotion": map[string]interface{}{
-					"type": "boolean",
-				},
-				"image_description": map[string]interface{}{
-					"type": "string",
-				},
-				"emotion_detection": map[string]interface{}{
-					"type": "string",
-				},
-				"object_detection": map[string]interface{}{
-					"type": "array",
-					"items": map[string]interface{}{
-						"type": "string",
-					},
-				},
-				"visual_element": map[string]interface{}{
-					"type": "array",
-					"items": map[string]interface{}{
-						"type": "string",
-					},
-				},
-			},
-		},
-	)
-
-	format_response_creative_content_maker := openai.OACreateResponseFormat(
-		"creative_content_maker",
-		map[string]interface{}{
-			"type": "object",
-			"properties": map[string]interface{}{
-				"creative_content": map[string]interface{}{
-					"type": "array",
-					"items": map[string]interface{}{
-						"content_type": map[string]interface{}{
-							"type": "string",
-						},
-						"content": map[string]interface{}{
-							"type": "string",
-						},
-					},
-				},
-			},
-		},
-	)
-
 	// --------- main phase ------------
 
 	// get user and check user validity
 	user_session := c.Locals("user").(sso_models.UserSession)
 
-	tx, err := database.DB.Begin()
+	tx, err := h.db.BeginTx(c.UserContext(), nil)
 	if err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
@@ -166,7 +148,11 @@ func (h *CreativeContentController) GetImageAnalysis(c *fiber.Ctx) error {
 
 	// get image extension
 	imageExtension := filepath.Ext(uploaded_image.Filename)
-	imgExt := strings.TrimPrefix(imageExtension, ".")
+	imgExt := strings.ToLower(strings.TrimPrefix(imageExtension, "."))
+	// claude only accept image/jpeg media type
+	if imgExt == "jpg" {
+		imgExt = "jpeg"
+	}
 
 	// -- convert to base64 string
 	// open image
@@ -187,68 +173,111 @@ func (h *CreativeContentController) GetImageAnalysis(c *fiber.Ctx) error {
 	// encode from bytes to base 64
 	image_base64 := base64.StdEncoding.EncodeToString(imageBytes)
 
-	// create content vision data
-	messageData, err := openai.OACreateOneContentVision("image/"+imgExt, false, image_base64, prompt_image_analysis)
-	if err != nil {
-		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-	}
-
-	// base context window model chat
-	messageReq := []openai.OAMessageReq{
+	// base context window model chat, the image sent with the analysis prompt
+	messageReq := []llm.Message{
 		{
 			Role:    "user",
-			Content: messageData,
+			Content: prompt_image_analysis,
+			Image: &llm.Image{
+				MediaType: "image/" + imgExt,
+				Data:      image_base64,
+			},
 		},
 	}
 
-	// send first req for image analysis
-	openaiResp, err := h.openai.OpenAIGetFirstContentDataResp(&messageReq, true, &format_response_image_analysis, false, nil)
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user.Id, utils.FEATURE_CONTENT_GENERATOR)
+	provider, err := h.llm.Select(type_llm)
 	if err != nil {
-		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+		return llmErrorResponse(c, err)
 	}
 
-	imageAnalysisJSON := openaiResp.Content // get json response data
+	// send first req for image analysis
+	llmReq := llm.Request{
+		Messages:  messageReq,
+		MaxTokens: 256 * 10,
+		Schema:    creativeImageAnalysisSchema,
+	}
 
-	// decode model json response to struct
-	if err := json.NewDecoder(strings.NewReader(imageAnalysisJSON)).Decode(&contentImageAnalysisRes); err != nil {
-		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	// the estimate of every request sent, the content recommendation is only sent for image with emotion
+	estimates := make([]*promptEstimate, 0, 2)
+
+	estimate, err := guardPrompt(c.UserContext(), provider, &llmReq, h.pricing, usage.creditLeft(user.CreditToken), nil)
+	if err != nil {
+		return llmErrorResponse(c, err)
 	}
+	estimates = append(estimates, estimate)
+
+	// decode model json response to struct, the invalid answer is asked again
+	llmResp, err := generateStructured(c.UserContext(), provider, llmReq, usage, &contentImageAnalysisRes)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	imageAnalysisJSON := llmResp.Content // get json response data
 
 	// SECOND REQUEST TO MODEL AFTER GET THE IMAGE ANALYSIS
 	// do the 2nd JUST IF the image analysis result say the image "have_emotion" is true
 	if contentImageAnalysisRes.HaveEmotion {
 		// make chaining request data, add image anaylsis result and prompt content recommendation for next request
-		messageReq = append(messageReq, openai.OAMessageReq{
+		messageReq = append(messageReq, llm.Message{
 			Role:    "assistant",
 			Content: imageAnalysisJSON,
 		})
 
-		messageReq = append(messageReq, openai.OAMessageReq{
+		messageReq = append(messageReq, llm.Message{
 			Role:    "user",
 			Content: prompt_content_recommendation,
 		})
 
+		maxTokens := creativeContentMaxTokens
+		if provider.Name() == llm.ProviderClaude {
+			maxTokens = creativeContentClaudeMaxTokens
+		}
+
 		// send 2nd req
-		openaiResp, err = h.openai.OpenAIGetFirstContentDataResp(&messageReq, true, &format_response_creative_content_maker, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+		llmReq = llm.Request{
+			Messages:  messageReq,
+			MaxTokens: maxTokens,
+			Schema:    creativeContentSchema,
 		}
 
-		creative_content_recommendation := openaiResp.Content
+		estimate, err = guardPrompt(c.UserContext(), provider, &llmReq, h.pricing, usage.creditLeft(user.CreditToken), nil)
+		if err != nil {
+			return llmErrorResponse(c, err)
+		}
+		estimates = append(estimates, estimate)
 
-		if err = json.NewDecoder(strings.NewReader(creative_content_recommendation)).Decode(&contentRecommendationRes); err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+		if _, err = generateStructured(c.UserContext(), provider, llmReq, usage, &contentRecommendationRes); err != nil {
+			return llmErrorResponse(c, err)
 		}
 	}
 
+	// all generated text checked before returned, blocked result is not charged
+	generatedText := []string{contentImageAnalysisRes.ImageDescription, contentImageAnalysisRes.EmotionDetection}
+	generatedText = append(generatedText, contentImageAnalysisRes.ObjectDetection...)
+	generatedText = append(generatedText, contentImageAnalysisRes.VisualElement...)
+	for _, content := range contentRecommendationRes.CreativeContent {
+		generatedText = append(generatedText, content.Content)
+	}
+	if err := h.moderator.CheckOutput(c.UserContext(), generatedText...); err != nil {
+		return llmErrorResponse(c, err)
+	}
+
 	// feature success executed, reduce user credit token
-	if err := sso_utils.UpdateUserCredit(tx, h.userRepo, user, utils.FEATURE_CONTENT_GENERATOR_COST); err != nil {
+	if err := sso_utils.UpdateUserCredit(tx, h.userRepo, user, usage.credits()); err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
 
+	h.generations.save(user.Id, utils.FEATURE_CONTENT_GENERATOR, "Image Analysis", contentImageAnalysisRes.ImageDescription)
+	for _, content := range contentRecommendationRes.CreativeContent {
+		h.generations.save(user.Id, utils.FEATURE_CONTENT_GENERATOR, content.ContentType, content.Content)
+	}
+
 	return utils.ResponseWithData(c, fiber.StatusOK, "list analysis images", fiber.Map{
 		"analysis":               contentImageAnalysisRes,
 		"content_recommendation": contentRecommendationRes,
+		"estimates":              estimates,
+		"served_by":              usage.servedBy(),
 	})
 }
 
@@ -267,8 +296,38 @@ func (h *CreativeContentController) CreateImageDallE(c *fiber.Ctx) error {
 		Size:           &size,
 		ResponseFormat: &response,
 	}
-	imageData, err := h.openai.OpenAICreateImageDallE(&imageReqBody)
+	// image price is fixed, so the cost can be checked before generating the image, the user must keep at least 1 credit after charged
+	imageCost := h.pricing.ImageCost(imageReqBody.Model, "", size, 1)
+
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	user, err := findUser(c.UserContext(), h.db, h.userRepo, user_session.Id)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if user.Id == 0 {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "user not found")
+	}
+
+	if user.CreditToken < utils.FEATURE_IMAGE_GENERATOR_COST || user.CreditToken <= h.pricing.Credits(imageCost) {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
+	}
+
+	if err := h.moderator.CheckInput(c.UserContext(), imageReqBody.Prompt); err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user_session.Id, utils.FEATURE_IMAGE_GENERATOR)
+	start := time.Now()
+	imageData, err := h.openai.OpenAICreateImageDallEWithContext(c.UserContext(), &imageReqBody)
+	usage.record(llm.ProviderOpenAI, imageReqBody.Model, 0, 0, imageCost, start, err)
 	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	// feature success executed, reduce user credit token
+	if err := chargeUserCredit(h.db, h.userRepo, user_session.Id, usage.credits()); err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
 
@@ -294,8 +353,38 @@ func (h *CreativeContentController) CreateTTS(c *fiber.Ctx) error {
 		ResponseFormat: "mp3",
 	}
 
-	ttsData, err := h.openai.OpenAITextToSpeech(&ttsReqBody)
+	// tts price is per input character, so the cost can be checked before generating the audio, the user must keep at least 1 credit after charged
+	ttsCost := h.pricing.TTSCost(ttsReqBody.Model, ttsReqBody.Input)
+
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	user, err := findUser(c.UserContext(), h.db, h.userRepo, user_session.Id)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if user.Id == 0 {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "user not found")
+	}
+
+	if user.CreditToken < utils.FEATURE_TTS_COST || user.CreditToken <= h.pricing.Credits(ttsCost) {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
+	}
+
+	if err := h.moderator.CheckInput(c.UserContext(), ttsReqBody.Input); err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user_session.Id, utils.FEATURE_TTS)
+	start := time.Now()
+	ttsData, err := h.openai.OpenAITextToSpeechWithContext(c.UserContext(), &ttsReqBody)
+	usage.record(llm.ProviderOpenAI, ttsReqBody.Model, 0, 0, ttsCost, start, err)
 	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	// feature success executed, reduce user credit token
+	if err := chargeUserCredit(h.db, h.userRepo, user_session.Id, usage.credits()); err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
 
@@ -0,0 +1,377 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"scrapper-test/models"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils"
+	"scrapper-test/utils/llm/llmtest"
+	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
+	"strings"
+	"testing"
+
+	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestCreativeContentController create creative content controller on the test env
+func newTestCreativeContentController(t *testing.T, creditToken int) (*CreativeContentController, *testEnv) {
+	t.Helper()
+
+	env := newTestEnv(t, creditToken)
+
+	return NewCreativeContentController(env.db, env.registry, env.openai, *sso_user.NewUserRepo(), *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), env.moderator, env.generations), env
+}
+
+// newImageAnalysisRequest create the multipart request with the png image, no image is sent when withImage is false
+func newImageAnalysisRequest(t *testing.T, withImage bool) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("language", "indonesia")
+
+	if withImage {
+		image, err := base64.StdEncoding.DecodeString(llmtest.PNG())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := form.CreateFormFile("image", "foto.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write(image)
+	}
+
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, "/creative-content", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestGetImageAnalysis(t *testing.T) {
+	analysis := models.ImageAnalysisRes{
+		HaveEmotion:      true,
+		ImageDescription: "Senja di pantai dengan perahu nelayan",
+		EmotionDetection: "Tenang dan sedikit sendu",
+		ObjectDetection:  []string{"Perahu", "Matahari"},
+		VisualElement:    []string{"Langit Jingga"},
+	}
+	noEmotion := analysis
+	noEmotion.HaveEmotion = false
+
+	recommendation := models.CreativeContentRecommendationRes{CreativeContent: []models.CreativeContentData{
+		{ContentType: "Puisi", Content: "Perahu pulang membawa senja"},
+		{ContentType: "Cerita Pendek", Content: "Pak Darto menunggu ombak reda"},
+	}}
+
+	tests := []struct {
+		name            string
+		creditToken     int
+		withImage       bool
+		replies         []llmtest.Reply
+		wantStatus      int
+		wantRequests    int
+		wantGenerations int
+	}{
+		{
+			name:            "image with emotion get content recommendation",
+			creditToken:     100,
+			withImage:       true,
+			replies:         []llmtest.Reply{llmtest.JSON(analysis).WithUsage(1500, 300), llmtest.JSON(recommendation).WithUsage(2000, 600)},
+			wantStatus:      fiber.StatusOK,
+			wantRequests:    2,
+			wantGenerations: 3,
+		},
+		{
+			name:            "image without emotion only analyzed",
+			creditToken:     100,
+			withImage:       true,
+			replies:         []llmtest.Reply{llmtest.JSON(noEmotion).WithUsage(1500, 300)},
+			wantStatus:      fiber.StatusOK,
+			wantRequests:    1,
+			wantGenerations: 1,
+		},
+		{
+			name:         "blocked content not charged",
+			creditToken:  100,
+			withImage:    true,
+			replies:      []llmtest.Reply{llmtest.JSON(analysis), llmtest.JSON(models.CreativeContentRecommendationRes{CreativeContent: []models.CreativeContentData{{ContentType: "Puisi", Content: "BAD puisi"}}})},
+			wantStatus:   fiber.StatusUnprocessableEntity,
+			wantRequests: 2,
+		},
+		{
+			name:         "second request error not charged",
+			creditToken:  100,
+			withImage:    true,
+			replies:      []llmtest.Reply{llmtest.JSON(analysis), llmtest.Overloaded()},
+			wantStatus:   fiber.StatusServiceUnavailable,
+			wantRequests: 2,
+		},
+		{
+			name:        "no image",
+			creditToken: 100,
+			wantStatus:  fiber.StatusBadRequest,
+		},
+		{
+			name:        "not enough credit",
+			creditToken: 0,
+			withImage:   true,
+			wantStatus:  fiber.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestCreativeContentController(t, tt.creditToken)
+			env.srv.Enqueue(llmtest.EndpointClaudeMessages, tt.replies...)
+
+			app := newTestApp(fiber.MethodPost, "/creative-content", tt.creditToken, controller.GetImageAnalysis)
+			status, resp := sendTestJSONRequest(t, app, newImageAnalysisRequest(t, tt.withImage))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			requests := env.srv.RequestsTo(llmtest.EndpointClaudeMessages)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("message requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+
+			// the image is sent on the first request, the second request continue from the analysis answer
+			if tt.wantRequests > 0 && !strings.Contains(string(requests[0].Body), llmtest.PNG()) {
+				t.Error("first request not contain the uploaded image")
+			}
+			if tt.wantRequests > 1 && !strings.Contains(string(requests[1].Body), `"role":"assistant"`) {
+				t.Errorf("second request not contain the analysis answer: %s", requests[1].Body)
+			}
+			if tt.wantRequests > 1 && !strings.Contains(string(requests[1].Body), `"max_tokens":4096`) {
+				t.Errorf("second claude request not capped to 4096 output tokens: %s", requests[1].Body)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != tt.creditToken {
+					t.Errorf("credit token = %d, want %d not charged", got, tt.creditToken)
+				}
+				return
+			}
+
+			var data struct {
+				Analysis              models.ImageAnalysisRes                 `json:"analysis"`
+				ContentRecommendation models.CreativeContentRecommendationRes `json:"content_recommendation"`
+				Estimates             []json.RawMessage                       `json:"estimates"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Analysis.ImageDescription != analysis.ImageDescription || len(data.Estimates) != tt.wantRequests {
+				t.Errorf("data = %+v", data)
+			}
+			if got := len(data.ContentRecommendation.CreativeContent); got != tt.wantGenerations-1 {
+				t.Errorf("creative content = %d, want %d", got, tt.wantGenerations-1)
+			}
+
+			// both request is charged on one update
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != tt.creditToken-charged {
+				t.Errorf("credit token = %d, want %d charged from %d", got, charged, tt.creditToken)
+			}
+
+			for _, generation := range env.fake.waitGenerations(t, tt.wantGenerations) {
+				if generation.Feature != utils.FEATURE_CONTENT_GENERATOR {
+					t.Errorf("saved generation = %+v", generation)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateImageDallE(t *testing.T) {
+	contentPolicy := llmtest.Error(fiber.StatusBadRequest, "invalid_request_error", "llmtest: content policy")
+	contentPolicy.Err.Code = "content_policy_violation"
+
+	tests := []struct {
+		name         string
+		creditToken  int
+		prompt       string
+		reply        llmtest.Reply
+		wantStatus   int
+		wantRequests int
+	}{
+		{
+			name:         "image created",
+			creditToken:  100,
+			prompt:       "kucing oranye tidur di atas genteng",
+			reply:        llmtest.Image(llmtest.PNG()),
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 1,
+		},
+		{
+			name:        "blocked prompt not sent",
+			creditToken: 100,
+			prompt:      "BAD kucing",
+			wantStatus:  fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:         "content policy error not charged",
+			creditToken:  100,
+			prompt:       "kucing oranye",
+			reply:        contentPolicy,
+			wantStatus:   fiber.StatusUnprocessableEntity,
+			wantRequests: 1,
+		},
+		{
+			name:        "credit not enough for the image price",
+			creditToken: pricing.DefaultPriceTable().Credits(pricing.DefaultPriceTable().ImageCost("dall-e-3", "", "1792x1024", 1)),
+			prompt:      "kucing oranye",
+			wantStatus:  fiber.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestCreativeContentController(t, tt.creditToken)
+			if tt.wantRequests > 0 {
+				env.srv.Enqueue(llmtest.EndpointOpenAIImages, tt.reply)
+			}
+
+			app := newTestApp(fiber.MethodPost, "/image", tt.creditToken, controller.CreateImageDallE)
+			status, resp := sendTestJSONRequest(t, app, newStoriesRequest(t, "/image", models.CreateImageGenerator{Prompt: tt.prompt}))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			requests := env.srv.RequestsTo(llmtest.EndpointOpenAIImages)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("image requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != tt.creditToken {
+					t.Errorf("credit token = %d, want %d not charged", got, tt.creditToken)
+				}
+				return
+			}
+
+			if body := string(requests[0].Body); !strings.Contains(body, `"model":"dall-e-3"`) || !strings.Contains(body, `"response_format":"b64_json"`) || !strings.Contains(body, tt.prompt) {
+				t.Errorf("image request = %s", body)
+			}
+
+			var data struct {
+				ImageData openai.OAImageGeneratorDallEResp `json:"image_data"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if len(data.ImageData.Data) != 1 || data.ImageData.Data[0].B64JSON != llmtest.PNG() {
+				t.Errorf("image data = %+v", data.ImageData)
+			}
+
+			// the image is charged with its fixed price
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != tt.creditToken-charged {
+				t.Errorf("credit token = %d, want %d charged from %d", got, charged, tt.creditToken)
+			}
+		})
+	}
+}
+
+func TestCreateTTS(t *testing.T) {
+	audio := []byte("ID3 suara narator")
+
+	tests := []struct {
+		name         string
+		creditToken  int
+		prompt       string
+		reply        llmtest.Reply
+		wantStatus   int
+		wantRequests int
+	}{
+		{
+			name:         "speech created",
+			creditToken:  100,
+			prompt:       "Selamat datang di cerita malam ini",
+			reply:        llmtest.Speech(audio),
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 1,
+		},
+		{
+			name:        "blocked prompt not sent",
+			creditToken: 100,
+			prompt:      "BAD kata",
+			wantStatus:  fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:         "provider error not charged",
+			creditToken:  100,
+			prompt:       "Selamat datang di cerita malam ini",
+			reply:        llmtest.InsufficientQuota(),
+			wantStatus:   fiber.StatusServiceUnavailable,
+			wantRequests: 1,
+		},
+		{
+			name:        "not enough credit",
+			creditToken: 0,
+			prompt:      "Selamat datang",
+			wantStatus:  fiber.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestCreativeContentController(t, tt.creditToken)
+			if tt.wantRequests > 0 {
+				env.srv.Enqueue(llmtest.EndpointOpenAISpeech, tt.reply)
+			}
+
+			app := newTestApp(fiber.MethodPost, "/tts", tt.creditToken, controller.CreateTTS)
+			status, resp := sendTestJSONRequest(t, app, newStoriesRequest(t, "/tts", models.CreateTTS{Prompt: tt.prompt, Language: "indonesia"}))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			requests := env.srv.RequestsTo(llmtest.EndpointOpenAISpeech)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("speech requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != tt.creditToken {
+					t.Errorf("credit token = %d, want %d not charged", got, tt.creditToken)
+				}
+				return
+			}
+
+			if body := string(requests[0].Body); !strings.Contains(body, `"model":"tts-1"`) || !strings.Contains(body, tt.prompt) {
+				t.Errorf("speech request = %s", body)
+			}
+
+			var data struct {
+				AudioFormat string `json:"audio_format"`
+				B64JSON     string `json:"b64_json"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.AudioFormat == "" || data.B64JSON != base64.StdEncoding.EncodeToString(audio) {
+				t.Errorf("data = %+v", data)
+			}
+
+			// the speech is charged per input character
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != tt.creditToken-charged {
+				t.Errorf("credit token = %d, want %d charged from %d", got, charged, tt.creditToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"scrapper-test/models"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	sso_models "github.com/momokii/go-sso-web/pkg/models"
+)
+
+// fakeDB is in-memory database for the controller test, it answer only the query made by the user, llm usage,
+// and generation repositories. The transaction is not isolated, the change is kept even when it is rolled back
+type fakeDB struct {
+	mu          sync.Mutex
+	users       map[int]*sso_models.User
+	usages      []models.LLMUsage
+	generations []models.Generation
+
+	// pgvector search, vectorResults is returned as it is and the query limit saved on vectorLimit
+	hasVector     bool
+	vectorResults []models.GenerationSearchResult
+	vectorLimit   int
+}
+
+var (
+	fakeDBs       sync.Map // dsn -> *fakeDB
+	fakeDBCounter int64
+	fakeDBOnce    sync.Once
+)
+
+// newFakeDB open *sql.DB backed by new fakeDB, the db is closed after the test
+func newFakeDB(t *testing.T) (*sql.DB, *fakeDB) {
+	t.Helper()
+
+	fakeDBOnce.Do(func() {
+		sql.Register("fakedb", fakeDriver{})
+	})
+
+	fake := &fakeDB{
+		users: make(map[int]*sso_models.User),
+	}
+	dsn := fmt.Sprintf("fakedb-%d", atomic.AddInt64(&fakeDBCounter, 1))
+	fakeDBs.Store(dsn, fake)
+
+	db, err := sql.Open("fakedb", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeDBs.Delete(dsn)
+	})
+
+	return db, fake
+}
+
+func (f *fakeDB) addUser(id int, creditToken int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.users[id] = &sso_models.User{
+		Id:          id,
+		Username:    fmt.Sprintf("user%d", id),
+		CreditToken: creditToken,
+	}
+}
+
+func (f *fakeDB) creditToken(id int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.users[id].CreditToken
+}
+
+func (f *fakeDB) llmUsages() []models.LLMUsage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]models.LLMUsage(nil), f.usages...)
+}
+
+func (f *fakeDB) addGeneration(generation models.Generation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	generation.Id = len(f.generations) + 1
+	f.generations = append(f.generations, generation)
+}
+
+// waitGenerations wait until n generation saved, the generation is saved on the background after the response sent
+func (f *fakeDB) waitGenerations(t *testing.T, n int) []models.Generation {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		f.mu.Lock()
+		generations := append([]models.Generation(nil), f.generations...)
+		f.mu.Unlock()
+
+		if len(generations) >= n {
+			return generations
+		}
+	}
+
+	t.Fatalf("generation saved is less than %d", n)
+	return nil
+}
+
+func (f *fakeDB) exec(query string, args []driver.Value) (driver.Result, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "FROM users WHERE id = $1 FOR UPDATE"):
+		return driver.RowsAffected(1), nil
+
+	case strings.Contains(query, "UPDATE users SET credit_token"):
+		user, ok := f.users[toInt(args[len(args)-1])]
+		if !ok {
+			return driver.RowsAffected(0), nil
+		}
+		user.CreditToken = toInt(args[0])
+		if len(args) == 3 {
+			user.LastFirstLLMUsed = args[1].(string)
+		}
+		return driver.RowsAffected(1), nil
+
+	case strings.Contains(query, "INSERT INTO llm_usages"):
+		f.usages = append(f.usages, models.LLMUsage{
+			Id:               len(f.usages) + 1,
+			UserId:           toInt(args[0]),
+			Feature:          args[1].(string),
+			Provider:         args[2].(string),
+			Model:            args[3].(string),
+			PromptTokens:     toInt(args[4]),
+			CompletionTokens: toInt(args[5]),
+			CacheReadTokens:  toInt(args[6]),
+			CacheWriteTokens: toInt(args[7]),
+			Cost:             args[8].(float64),
+			LatencyMs:        toInt(args[9]),
+			Status:           args[10].(string),
+			CreatedAt:        time.Now(),
+		})
+		return driver.RowsAffected(1), nil
+
+	case strings.Contains(query, "INSERT INTO generations"):
+		var embedding pq.Float64Array
+		if err := embedding.Scan(args[5]); err != nil {
+			return nil, err
+		}
+		f.generations = append(f.generations, models.Generation{
+			Id:             len(f.generations) + 1,
+			UserId:         toInt(args[0]),
+			Feature:        args[1].(string),
+			Title:          args[2].(string),
+			Content:        args[3].(string),
+			EmbeddingModel: args[4].(string),
+			Embedding:      embedding,
+			CreatedAt:      time.Now(),
+		})
+		return driver.RowsAffected(1), nil
+	}
+
+	return nil, fmt.Errorf("fakedb: unexpected exec %q", strings.TrimSpace(query))
+}
+
+func (f *fakeDB) query(query string, args []driver.Value) (driver.Rows, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case strings.Contains(query, "FROM users WHERE id = $1"):
+		rows := &fakeRows{columns: []string{"id", "username", "password", "credit_token", "last_first_llm_used"}}
+		if user, ok := f.users[toInt(args[0])]; ok {
+			rows.values = append(rows.values, []driver.Value{int64(user.Id), user.Username, user.Password, int64(user.CreditToken), user.LastFirstLLMUsed})
+		}
+		return rows, nil
+
+	case strings.Contains(query, "FROM pg_extension"):
+		return &fakeRows{
+			columns: []string{"exists"},
+			values:  [][]driver.Value{{f.hasVector}},
+		}, nil
+
+	case strings.Contains(query, "embedding::vector"):
+		f.vectorLimit = toInt(args[4])
+
+		rows := &fakeRows{columns: []string{"id", "user_id", "feature", "title", "content", "created_at", "similarity"}}
+		for _, result := range f.vectorResults {
+			rows.values = append(rows.values, []driver.Value{int64(result.Id), int64(result.UserId), result.Feature, result.Title, result.Content, result.CreatedAt, result.Similarity})
+		}
+		return rows, nil
+
+	case strings.Contains(query, "FROM generations WHERE user_id = $1 AND embedding_model = $2 AND embedding IS NOT NULL"):
+		rows := &fakeRows{columns: []string{"id", "user_id", "feature", "title", "content", "embedding_model", "embedding", "created_at"}}
+		// latest first
+		for i := len(f.generations) - 1; i >= 0 && len(rows.values) < toInt(args[2]); i-- {
+			generation := f.generations[i]
+			if generation.UserId != toInt(args[0]) || generation.EmbeddingModel != args[1].(string) || generation.Embedding == nil {
+				continue
+			}
+
+			embedding, err := pq.Float64Array(generation.Embedding).Value()
+			if err != nil {
+				return nil, err
+			}
+			rows.values = append(rows.values, []driver.Value{int64(generation.Id), int64(generation.UserId), generation.Feature, generation.Title, generation.Content, generation.EmbeddingModel, embedding, generation.CreatedAt})
+		}
+		return rows, nil
+	}
+
+	return nil, fmt.Errorf("fakedb: unexpected query %q", strings.TrimSpace(query))
+}
+
+func toInt(value driver.Value) int {
+	switch v := value.(type) {
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+
+	return 0
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	db, ok := fakeDBs.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("fakedb: unknown dsn %q", dsn)
+	}
+
+	return &fakeConn{db: db.(*fakeDB)}, nil
+}
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error {
+	return nil
+}
+
+func (fakeTx) Rollback() error {
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.db.exec(s.query, args)
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.db.query(s.query, args)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+
+	return nil
+}
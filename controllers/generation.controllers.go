@@ -0,0 +1,221 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math"
+	"scrapper-test/database"
+	"scrapper-test/models"
+	"scrapper-test/repository/generation"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
+	"sort"
+	"strings"
+	"time"
+
+	sso_models "github.com/momokii/go-sso-web/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// deadline for embedding and saving one generation, it run after the feature response is sent
+	generationSaveTimeout = 30 * time.Second
+	// max generation compared on the app when pgvector is not installed, the latest one is used
+	generationSearchScanLimit = 2000
+
+	generationSearchDefaultLimit = 5
+	generationSearchMaxLimit     = 20
+)
+
+type GenerationController struct {
+	db             *sql.DB
+	openai         openai.OpenAI
+	generationRepo generation.GenerationRepo
+	usageRepo      llmusage.LLMUsageRepo
+	pricing        *pricing.PriceTable
+}
+
+func NewGenerationController(db *sql.DB, openai openai.OpenAI, generationRepo generation.GenerationRepo, usageRepo llmusage.LLMUsageRepo, pricing *pricing.PriceTable) *GenerationController {
+	return &GenerationController{
+		db:             db,
+		openai:         openai,
+		generationRepo: generationRepo,
+		usageRepo:      usageRepo,
+		pricing:        pricing,
+	}
+}
+
+// embed create the embedding of the text and record the usage, the embedding is cheap so it is recorded but not charged
+func (h *GenerationController) embed(ctx context.Context, userId int, feature string, text string) (string, []float64, error) {
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, userId, feature)
+
+	start := time.Now()
+	resp, err := h.openai.OpenAIEmbeddingsWithContext(ctx, &openai.OAReqEmbeddings{
+		Input: text,
+		Model: openai.OAEmbeddingModel,
+	})
+	if err != nil {
+		usage.record(llm.ProviderOpenAI, openai.OAEmbeddingModel, 0, 0, 0, start, err)
+		return "", nil, err
+	}
+	usage.record(llm.ProviderOpenAI, resp.Model, resp.Usage.PromptTokens, 0, h.pricing.EmbeddingCost(openai.OAEmbeddingModel, resp.Usage.PromptTokens), start, nil)
+
+	if len(resp.Data) == 0 {
+		return "", nil, errors.New("Failed to embed: embedding response is empty")
+	}
+
+	// the requested model is saved instead of resp.Model, so the search compare the same model name
+	return openai.OAEmbeddingModel, resp.Data[0].Embedding, nil
+}
+
+// save embed and save the generated output on the background so the feature response is not delayed,
+// failing to save only logged because it should not fail the feature. nil controller save nothing
+func (h *GenerationController) save(userId int, feature string, title string, content string) {
+	if h == nil || strings.TrimSpace(content) == "" {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), generationSaveTimeout)
+		defer cancel()
+
+		output := models.Generation{
+			UserId:  userId,
+			Feature: feature,
+			Title:   title,
+			Content: content,
+		}
+
+		// the generation is still saved without embedding, so it can be embedded again later
+		model, embedding, err := h.embed(ctx, userId, feature, title+"\n\n"+content)
+		if err != nil {
+			log.Println("Failed to embed generation: ", err)
+		} else {
+			output.EmbeddingModel = model
+			output.Embedding = embedding
+		}
+
+		tx, err := h.db.BeginTx(ctx, nil)
+		if err != nil {
+			log.Println("Failed to save generation: ", err)
+			return
+		}
+		defer func() {
+			database.CommitOrRollback(tx, nil, err)
+		}()
+
+		if err = h.generationRepo.Create(tx, &output); err != nil {
+			log.Println("Failed to save generation: ", err)
+		}
+	}()
+}
+
+// SearchGenerations return the user past generations most similar to the query "q", ordered by the similarity.
+// "limit" query is the max result, default 5 and max 20
+func (h *GenerationController) SearchGenerations(c *fiber.Ctx) error {
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "q is required")
+	}
+
+	limit := c.QueryInt("limit", generationSearchDefaultLimit)
+	if limit <= 0 {
+		limit = generationSearchDefaultLimit
+	} else if limit > generationSearchMaxLimit {
+		limit = generationSearchMaxLimit
+	}
+
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	model, embedding, err := h.embed(c.UserContext(), user_session.Id, utils.FEATURE_SEARCH, query)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	tx, err := h.db.BeginTx(c.UserContext(), nil)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+	defer func() {
+		database.CommitOrRollback(tx, c, err)
+	}()
+
+	hasVector, err := h.generationRepo.HasVectorExtension(tx)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	var results []models.GenerationSearchResult
+	if hasVector {
+		results, err = h.generationRepo.SearchByVector(tx, user_session.Id, model, embedding, limit)
+		if err != nil {
+			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+		}
+	} else {
+		var generations []models.Generation
+		generations, err = h.generationRepo.FindEmbeddedByUserID(tx, user_session.Id, model, generationSearchScanLimit)
+		if err != nil {
+			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+		}
+
+		results = rankGenerations(generations, embedding, limit)
+	}
+
+	if results == nil {
+		results = []models.GenerationSearchResult{}
+	}
+
+	return utils.ResponseWithData(c, fiber.StatusOK, "search generations", fiber.Map{
+		"query":   query,
+		"results": results,
+	})
+}
+
+// rankGenerations return the limit generations most similar to the embedding, generation with different dimension is skipped
+func rankGenerations(generations []models.Generation, embedding []float64, limit int) []models.GenerationSearchResult {
+	results := make([]models.GenerationSearchResult, 0, len(generations))
+
+	for _, output := range generations {
+		if len(output.Embedding) != len(embedding) {
+			continue
+		}
+
+		results = append(results, models.GenerationSearchResult{
+			Generation: output,
+			Similarity: cosineSimilarity(output.Embedding, embedding),
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Similarity > results[j].Similarity
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+
+	return results
+}
+
+// cosineSimilarity of two vector with the same length, 0 if one of them is zero vector
+func cosineSimilarity(a []float64, b []float64) float64 {
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"scrapper-test/models"
+	"scrapper-test/repository/generation"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils"
+	"scrapper-test/utils/llm/llmtest"
+	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a    []float64
+		b    []float64
+		want float64
+	}{
+		{name: "same vector", a: []float64{1, 2, 3}, b: []float64{1, 2, 3}, want: 1},
+		{name: "scaled vector", a: []float64{1, 2, 3}, b: []float64{2, 4, 6}, want: 1},
+		{name: "opposite vector", a: []float64{1, 0}, b: []float64{-1, 0}, want: -1},
+		{name: "orthogonal vector", a: []float64{1, 0}, b: []float64{0, 1}, want: 0},
+		{name: "45 degree", a: []float64{1, 0}, b: []float64{1, 1}, want: 1 / math.Sqrt2},
+		{name: "zero vector", a: []float64{0, 0}, b: []float64{1, 1}, want: 0},
+		{name: "empty vector", a: []float64{}, b: []float64{}, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cosineSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("cosineSimilarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankGenerations(t *testing.T) {
+	generations := []models.Generation{
+		{Id: 1, Embedding: []float64{0, 1}},
+		{Id: 2, Embedding: []float64{1, 0}},
+		{Id: 3, Embedding: []float64{1, 1}},
+		{Id: 4, Embedding: []float64{1, 0, 0}}, // other dimension
+		{Id: 5, Embedding: []float64{2, 0}},
+	}
+
+	tests := []struct {
+		name    string
+		limit   int
+		wantIds []int
+	}{
+		// the same similarity keep the original order, the latest generation first
+		{name: "ordered by similarity", limit: 10, wantIds: []int{2, 5, 3, 1}},
+		{name: "limited", limit: 2, wantIds: []int{2, 5}},
+		{name: "zero limit", limit: 0, wantIds: []int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := rankGenerations(generations, []float64{1, 0}, tt.limit)
+
+			ids := make([]int, 0, len(results))
+			for _, result := range results {
+				ids = append(ids, result.Id)
+			}
+			if !equalInts(ids, tt.wantIds) {
+				t.Errorf("ranked ids = %v, want %v", ids, tt.wantIds)
+			}
+		})
+	}
+
+	if results := rankGenerations(nil, []float64{1, 0}, 5); len(results) != 0 {
+		t.Errorf("rankGenerations(nil) = %v, want empty", results)
+	}
+}
+
+func equalInts(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newTestGenerationController create generation controller on the fake db and the fake server,
+// every search query is embedded as [1, 0]
+func newTestGenerationController(t *testing.T) (*GenerationController, *fakeDB, *llmtest.Server) {
+	t.Helper()
+
+	srv := llmtest.New()
+	t.Cleanup(srv.Close)
+	srv.Handle(llmtest.EndpointOpenAIEmbeddings, func(req llmtest.Request) llmtest.Reply {
+		return llmtest.Embedding(1, 0)
+	})
+
+	client, err := srv.OpenAIClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, fake := newFakeDB(t)
+	fake.addUser(testUserId, 10)
+
+	return NewGenerationController(db, client, *generation.NewGenerationRepo(), *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable()), fake, srv
+}
+
+func TestSearchGenerations(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       string
+		hasVector   bool
+		generations int
+		wantStatus  int
+		wantIds     []int
+		wantLimit   int // limit sent to the pgvector query
+	}{
+		{name: "missing query", query: "", wantStatus: fiber.StatusBadRequest},
+		{name: "default limit", query: "q=roast", generations: 7, wantStatus: fiber.StatusOK, wantIds: []int{1, 2, 3, 4, 5}},
+		{name: "zero limit use default", query: "q=roast&limit=0", generations: 7, wantStatus: fiber.StatusOK, wantIds: []int{1, 2, 3, 4, 5}},
+		{name: "negative limit use default", query: "q=roast&limit=-1", generations: 7, wantStatus: fiber.StatusOK, wantIds: []int{1, 2, 3, 4, 5}},
+		{name: "small limit", query: "q=roast&limit=2", generations: 7, wantStatus: fiber.StatusOK, wantIds: []int{1, 2}},
+		{name: "limit capped", query: "q=roast&limit=50", generations: 25, wantStatus: fiber.StatusOK, wantIds: []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20}},
+		{name: "no generation", query: "q=roast", wantStatus: fiber.StatusOK, wantIds: []int{}},
+		{name: "pgvector", query: "q=roast", hasVector: true, generations: 7, wantStatus: fiber.StatusOK, wantIds: []int{100}, wantLimit: 5},
+		{name: "pgvector limit capped", query: "q=roast&limit=50", hasVector: true, wantStatus: fiber.StatusOK, wantIds: []int{100}, wantLimit: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, fake, srv := newTestGenerationController(t)
+
+			// the generation i has lower similarity to the query than the generation i-1
+			for i := 1; i <= tt.generations; i++ {
+				fake.addGeneration(models.Generation{
+					UserId:         testUserId,
+					Feature:        utils.FEATURE_MEDIUM,
+					Content:        "roast",
+					EmbeddingModel: openai.OAEmbeddingModel,
+					Embedding:      []float64{1, float64(i)},
+					CreatedAt:      time.Now(),
+				})
+			}
+			// other user generation is never returned
+			fake.addGeneration(models.Generation{
+				UserId:         testUserId + 1,
+				Content:        "roast",
+				EmbeddingModel: openai.OAEmbeddingModel,
+				Embedding:      []float64{1, 0},
+			})
+
+			fake.hasVector = tt.hasVector
+			fake.vectorResults = []models.GenerationSearchResult{
+				{Generation: models.Generation{Id: 100, UserId: testUserId, Content: "from pgvector"}, Similarity: 0.9},
+			}
+
+			app := newTestApp(fiber.MethodGet, "/search", 10, controller.SearchGenerations)
+			status, resp := sendTestJSONRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/search?"+tt.query, nil))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+			if status != fiber.StatusOK {
+				if got := len(srv.RequestsTo(llmtest.EndpointOpenAIEmbeddings)); got != 0 {
+					t.Errorf("embedding requests = %d, want 0", got)
+				}
+				return
+			}
+
+			var data struct {
+				Query   string                          `json:"query"`
+				Results []models.GenerationSearchResult `json:"results"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+
+			ids := make([]int, 0, len(data.Results))
+			for _, result := range data.Results {
+				ids = append(ids, result.Id)
+			}
+			if !equalInts(ids, tt.wantIds) {
+				t.Errorf("result ids = %v, want %v", ids, tt.wantIds)
+			}
+
+			if tt.hasVector && fake.vectorLimit != tt.wantLimit {
+				t.Errorf("pgvector limit = %d, want %d", fake.vectorLimit, tt.wantLimit)
+			}
+
+			values, _ := url.ParseQuery(tt.query)
+			if data.Query != values.Get("q") {
+				t.Errorf("query = %q, want %q", data.Query, values.Get("q"))
+			}
+
+			// the query embedding is recorded but not charged
+			usages := fake.llmUsages()
+			if len(usages) != 1 || usages[0].Feature != utils.FEATURE_SEARCH || usages[0].Status != models.LLM_USAGE_STATUS_SUCCESS {
+				t.Errorf("usages = %+v, want one success search usage", usages)
+			}
+			if fake.creditToken(testUserId) != 10 {
+				t.Errorf("credit token = %d, want 10", fake.creditToken(testUserId))
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"scrapper-test/utils"
+	"scrapper-test/utils/breaker"
+	"scrapper-test/utils/claude"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/moderation"
+	"scrapper-test/utils/openai"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// llmErrorStatus map error from the LLM provider to http status and message that can be shown to the user,
+// the raw provider error is only logged. Unknown error keep returned as internal server error with its message
+func llmErrorStatus(err error) (int, string) {
+	var claudeErr *claude.APIError
+	var openaiErr *openai.APIError
+	var blockedErr *moderation.BlockedError
+	var tooLargeErr *promptTooLargeError
+	var creditErr *promptCreditError
+	var refusalErr *openai.RefusalError
+	var parseErr *llm.ParseError
+	var openErr *breaker.OpenError
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &tooLargeErr):
+		return fiber.StatusRequestEntityTooLarge, "Your input is too long for the selected model (" + strconv.Itoa(tooLargeErr.estimate.InputTokens) +
+			" tokens, max " + strconv.Itoa(tooLargeErr.estimate.ContextWindow-tooLargeErr.estimate.MaxOutputTokens) + "), please make it shorter"
+
+	case errors.As(err, &creditErr):
+		return fiber.StatusUnauthorized, "Not enough credit token for this request, it may need up to " + strconv.Itoa(creditErr.estimate.MaxCredits) + " credit"
+
+	case errors.As(err, &blockedErr):
+		if blockedErr.Source == moderation.SourceOutput {
+			return fiber.StatusUnprocessableEntity, "The generated content was blocked by the content policy, please try again with different input"
+		}
+		return fiber.StatusUnprocessableEntity, "Your input was blocked by the content policy (" + strings.Join(blockedErr.Categories, ", ") + "), please change it and try again"
+
+	case errors.As(err, &openErr):
+		// the upstream is failing, so the request is not sent and the user is asked to come back later
+		if openErr.RetryAfter > time.Second {
+			return fiber.StatusServiceUnavailable, "This feature is temporarily unavailable, please try again in " + openErr.RetryAfter.Round(time.Second).String()
+		}
+		return fiber.StatusServiceUnavailable, "This feature is temporarily unavailable, please try again in a moment"
+
+	case errors.Is(err, llm.ErrModelNotAllowed):
+		return fiber.StatusBadRequest, "The selected model is not available, please choose another model"
+
+	case errors.Is(err, llm.ErrModelNotSupported):
+		return fiber.StatusBadRequest, "The selected model doesn't support this feature, please choose another model"
+
+	case errors.Is(err, llm.ErrAudioNotSupported):
+		return fiber.StatusBadRequest, "Audio narration is not supported by the selected model"
+
+	case errors.Is(err, llm.ErrTruncated):
+		log.Println("llm response truncated: ", err)
+		return fiber.StatusBadGateway, "The AI answer was cut off because it is too long, please try again"
+
+	case errors.Is(err, llm.ErrEmptyContent):
+		return fiber.StatusBadGateway, "The AI returned an empty answer, please try again"
+
+	case errors.As(err, &parseErr):
+		log.Println("llm structured response invalid: ", parseErr.Error())
+		return fiber.StatusBadGateway, "The AI answer was not in the expected format, please try again"
+
+	case errors.As(err, &refusalErr):
+		return fiber.StatusUnprocessableEntity, "The AI refused to answer: " + refusalErr.Refusal
+
+	case errors.Is(err, openai.ErrContentFiltered):
+		return fiber.StatusUnprocessableEntity, "The generated content was stopped by the AI content filter, please try again with different input"
+
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return fiber.StatusGatewayTimeout, "The AI took too long to respond, please try again"
+
+	case errors.Is(err, context.Canceled):
+		return fiber.StatusRequestTimeout, "Request canceled"
+
+	case errors.As(err, &claudeErr):
+		log.Printf("claude api error: request id %s: %s", claudeErr.RequestID, claudeErr.Error())
+		return providerErrorStatus(claudeErr.StatusCode, claudeErr.Type)
+
+	case errors.As(err, &openaiErr):
+		log.Printf("openai api error: request id %s: %s", openaiErr.RequestID, openaiErr.Error())
+		if openaiErr.Code == "content_policy_violation" {
+			return fiber.StatusUnprocessableEntity, "Your input was rejected by the AI content policy, please change it and try again"
+		}
+		if openaiErr.Code == "insufficient_quota" || openaiErr.Type == "insufficient_quota" {
+			return fiber.StatusServiceUnavailable, "The AI service is temporarily unavailable, please try again later"
+		}
+		return providerErrorStatus(openaiErr.StatusCode, openaiErr.Type)
+	}
+
+	return fiber.StatusInternalServerError, err.Error()
+}
+
+// providerErrorStatus map the upstream status code, both provider use the same meaning for the status code
+func providerErrorStatus(status int, errType string) (int, string) {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		// bad api key or permission is our configuration problem, not the user
+		return fiber.StatusBadGateway, "The AI service is misconfigured, please contact the admin"
+	case status == http.StatusRequestEntityTooLarge:
+		return fiber.StatusRequestEntityTooLarge, "Your input is too large, please make it shorter"
+	case status == http.StatusTooManyRequests:
+		return fiber.StatusTooManyRequests, "Too many requests to the AI service, please try again in a moment"
+	case status == 529 || status == http.StatusServiceUnavailable || errType == "overloaded_error":
+		return fiber.StatusServiceUnavailable, "The AI service is overloaded right now, please try again later"
+	case status >= 400 && status < 500:
+		return fiber.StatusBadRequest, "The AI service rejected the request, please check your input"
+	}
+
+	return fiber.StatusBadGateway, "The AI service is having a problem, please try again later"
+}
+
+// llmErrorResponse send error response from LLM provider error
+func llmErrorResponse(c *fiber.Ctx, err error) error {
+	code, message := llmErrorStatus(err)
+	return utils.ErrorResponse(c, code, message)
+}
@@ -0,0 +1,156 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// max trim attempt before the prompt is refused, each attempt count the tokens again
+	promptGuardMaxTrim = 5
+	// credit token for the feature that is not charged, the credit is not checked
+	promptGuardNoCredit = -1
+	// added to the end of the trimmed text so the model know the data is not complete
+	promptTrimmedMarker = "\n...(dipotong)"
+	// max wait for the provider token count, the local estimate is used after that so the feature is not delayed by the counting
+	promptGuardCountTimeout = 5 * time.Second
+)
+
+// promptEstimate is the pre-flight estimate of one LLM call, sent on the response as "estimate" so the UI can show it
+type promptEstimate struct {
+	Provider        string  `json:"provider"`
+	Model           string  `json:"model"`
+	InputTokens     int     `json:"input_tokens"`
+	MaxOutputTokens int     `json:"max_output_tokens"`
+	ContextWindow   int     `json:"context_window"`
+	MinCost         float64 `json:"min_cost"` // USD, the input tokens only
+	MaxCost         float64 `json:"max_cost"` // USD, the input tokens and all the max output tokens
+	MinCredits      int     `json:"min_credits"`
+	MaxCredits      int     `json:"max_credits"`
+	Estimated       bool    `json:"estimated"` // counted by the local tokenizer instead of the provider
+	Trimmed         bool    `json:"trimmed"`   // part of the prompt was removed to fit the context window
+}
+
+// promptTooLargeError is returned when the prompt not fit the model context window even after trimmed
+type promptTooLargeError struct {
+	estimate *promptEstimate
+}
+
+func (e *promptTooLargeError) Error() string {
+	return "prompt too large: " + strconv.Itoa(e.estimate.InputTokens) + " input tokens and " + strconv.Itoa(e.estimate.MaxOutputTokens) +
+		" max output tokens is more than the " + e.estimate.Model + " context window " + strconv.Itoa(e.estimate.ContextWindow)
+}
+
+// promptCreditError is returned when the user credit is not enough for the input and the max output tokens
+type promptCreditError struct {
+	estimate    *promptEstimate
+	creditToken int
+}
+
+func (e *promptCreditError) Error() string {
+	return "not enough credit: the prompt may need up to " + strconv.Itoa(e.estimate.MaxCredits) + " credit, the user has " + strconv.Itoa(e.creditToken)
+}
+
+// promptTrimmer remove part of the request prompt so it is smaller by about excessTokens,
+// it return false when nothing can be removed anymore
+type promptTrimmer func(req *llm.Request, excessTokens int) bool
+
+// guardPrompt count the request tokens before it sent and refuse the prompt that exceed the model context window
+// or the user remaining credit (promptGuardNoCredit skip it). When trim is not nil the too large prompt is trimmed and counted again.
+//
+// The token count from the provider is used when available, if counting failed or took longer than promptGuardCountTimeout
+// the local estimate is used so the feature is not failed or delayed because of the counting. The returned estimate is also returned with the error
+func guardPrompt(ctx context.Context, provider llm.Provider, req *llm.Request, table *pricing.PriceTable, creditToken int, trim promptTrimmer) (*promptEstimate, error) {
+	estimate := &promptEstimate{
+		Provider:        provider.Name(),
+		MaxOutputTokens: req.MaxTokens,
+	}
+	if estimate.MaxOutputTokens == 0 {
+		estimate.MaxOutputTokens = llm.DefaultMaxTokens
+	}
+
+	for attempt := 0; ; attempt++ {
+		countCtx, cancel := context.WithTimeout(ctx, promptGuardCountTimeout)
+		count, err := provider.CountTokens(countCtx, *req)
+		cancel()
+		if err != nil {
+			log.Println("Failed to count prompt tokens, estimated locally: ", err)
+			count = &llm.TokenCount{
+				Model:       estimate.Model,
+				InputTokens: llm.EstimateTokens(*req),
+				Estimated:   true,
+			}
+		}
+
+		estimate.Model = count.Model
+		estimate.InputTokens = count.InputTokens
+		estimate.Estimated = count.Estimated
+		estimate.ContextWindow = count.ContextWindow
+		if estimate.ContextWindow == 0 {
+			estimate.ContextWindow = llm.ContextWindow(count.Model)
+		}
+
+		excess := estimate.InputTokens + estimate.MaxOutputTokens - estimate.ContextWindow
+		if excess <= 0 {
+			break
+		}
+
+		if trim == nil || attempt >= promptGuardMaxTrim || !trim(req, excess) {
+			return estimate, &promptTooLargeError{estimate: estimate}
+		}
+		estimate.Trimmed = true
+	}
+
+	estimate.MinCost = table.TokenCost(estimate.Model, estimate.InputTokens, 0)
+	estimate.MaxCost = table.TokenCost(estimate.Model, estimate.InputTokens, estimate.MaxOutputTokens)
+	estimate.MinCredits = table.Credits(estimate.MinCost)
+	estimate.MaxCredits = table.Credits(estimate.MaxCost)
+
+	// the output tokens is unknown before the call, so the request is refused when the user can't pay all the max output tokens.
+	// the user must keep at least 1 credit after charged, UpdateUserCredit refuse to reduce the credit to 0
+	if creditToken != promptGuardNoCredit && estimate.MaxCredits >= creditToken {
+		return estimate, &promptCreditError{estimate: estimate, creditToken: creditToken}
+	}
+
+	return estimate, nil
+}
+
+// trimTextTokens remove about the given tokens from the end of the text, cut on the last line or word boundary.
+// it return false when the text would be empty
+func trimTextTokens(text string, tokens int) (string, bool) {
+	runes := []rune(strings.TrimSuffix(text, promptTrimmedMarker))
+
+	// the local estimate is about 4 character per token, cut 10% more so the next count likely fit
+	cut := tokens * 4 * 11 / 10
+	if cut >= len(runes) {
+		return text, false
+	}
+
+	trimmed := string(runes[:len(runes)-cut])
+	if i := strings.LastIndexAny(trimmed, "\n "); i > len(trimmed)/2 {
+		trimmed = trimmed[:i]
+	}
+
+	return trimmed + promptTrimmedMarker, true
+}
+
+// trimOldestParts remove the oldest part after the first part until about excessTokens removed,
+// the first part and the last keep parts is never removed. Used for the story that is sent one paragraph each part
+func trimOldestParts(parts []llm.Part, keep int, excessTokens int) ([]llm.Part, bool) {
+	removed := 0
+	trimmed := false
+
+	for removed < excessTokens && len(parts) > keep+1 {
+		removed += openai.OAEstimateTokens(parts[1].Text)
+		parts = append(parts[:1:1], parts[2:]...)
+		trimmed = true
+	}
+
+	return parts, trimmed
+}
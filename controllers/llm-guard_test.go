@@ -0,0 +1,165 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"scrapper-test/utils/claude"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/llm/llmtest"
+	"scrapper-test/utils/pricing"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuardPromptCountTokens(t *testing.T) {
+	tests := []struct {
+		name          string
+		reply         llmtest.Reply
+		wantTokens    int
+		wantEstimated bool
+	}{
+		{name: "counted by claude", reply: llmtest.Text("").WithUsage(1000, 0), wantTokens: 1000},
+		// the failed count is not retried, the local estimate is used right away
+		{name: "overloaded use local estimate", reply: llmtest.Overloaded(), wantEstimated: true},
+		{name: "rate limited use local estimate", reply: llmtest.RateLimited(), wantEstimated: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := llmtest.New()
+			defer srv.Close()
+			srv.Enqueue(llmtest.EndpointClaudeCountTokens, tt.reply)
+
+			// the message request go through the gate, the count tokens must not
+			gateCalls := 0
+			client, err := srv.ClaudeClient(
+				claude.WithRetryPolicy(claude.RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}),
+				claude.WithRequestGate(func(req *http.Request) (func(resp *http.Response, err error), error) {
+					gateCalls++
+					return func(resp *http.Response, err error) {}, nil
+				}),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			req := llm.Request{
+				Messages:  []llm.Message{{Role: "user", Content: "roast medium profile ini"}},
+				MaxTokens: 256,
+			}
+			estimate, err := guardPrompt(context.Background(), llm.NewClaudeProvider(client), &req, pricing.DefaultPriceTable(), promptGuardNoCredit, nil)
+			if err != nil {
+				t.Fatalf("guardPrompt() error = %v", err)
+			}
+
+			if estimate.Estimated != tt.wantEstimated {
+				t.Errorf("Estimated = %v, want %v", estimate.Estimated, tt.wantEstimated)
+			}
+			if tt.wantTokens > 0 && estimate.InputTokens != tt.wantTokens {
+				t.Errorf("InputTokens = %d, want %d", estimate.InputTokens, tt.wantTokens)
+			}
+			if estimate.InputTokens <= 0 {
+				t.Errorf("InputTokens = %d, want more than 0", estimate.InputTokens)
+			}
+
+			if got := len(srv.RequestsTo(llmtest.EndpointClaudeCountTokens)); got != 1 {
+				t.Errorf("count tokens requests = %d, want 1", got)
+			}
+			if gateCalls != 0 {
+				t.Errorf("gate calls = %d, want 0", gateCalls)
+			}
+		})
+	}
+}
+
+func TestGuardPromptLimits(t *testing.T) {
+	srv := llmtest.New()
+	defer srv.Close()
+
+	registry, err := srv.Registry(llm.ProviderOpenAI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider := registry.Get(llm.ProviderOpenAI)
+	table := pricing.DefaultPriceTable()
+
+	// openai count the tokens locally, so the max credits of the prompt is known before the test
+	base := llm.Request{
+		Messages:  []llm.Message{{Role: "user", Content: mediumRoastPrompt("Name: test")}},
+		MaxTokens: 2560,
+	}
+	estimate, err := guardPrompt(context.Background(), provider, &base, table, promptGuardNoCredit, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maxCredits := estimate.MaxCredits
+
+	largeData := strings.Repeat("judul tulisan medium yang panjang sekali\n", 20000)
+
+	tests := []struct {
+		name        string
+		req         llm.Request
+		creditToken int
+		trim        bool
+		wantErr     interface{}
+		wantTrimmed bool
+	}{
+		{name: "enough credit", req: base, creditToken: maxCredits + 1},
+		{name: "not charged feature", req: base, creditToken: promptGuardNoCredit},
+		// the user must keep 1 credit after paying all the max output tokens
+		{name: "credit equal to max credits", req: base, creditToken: maxCredits, wantErr: &promptCreditError{}},
+		{name: "credit less than max credits", req: base, creditToken: 1, wantErr: &promptCreditError{}},
+		{
+			name:        "too large without trim",
+			req:         llm.Request{Messages: []llm.Message{{Role: "user", Content: mediumRoastPrompt(largeData)}}, MaxTokens: 2560},
+			creditToken: promptGuardNoCredit,
+			wantErr:     &promptTooLargeError{},
+		},
+		{
+			name:        "too large trimmed",
+			req:         llm.Request{Messages: []llm.Message{{Role: "user", Content: mediumRoastPrompt(largeData)}}, MaxTokens: 2560},
+			creditToken: promptGuardNoCredit,
+			trim:        true,
+			wantTrimmed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var trim promptTrimmer
+			if tt.trim {
+				trim = mediumPromptTrimmer(largeData)
+			}
+
+			req := tt.req
+			estimate, err := guardPrompt(context.Background(), provider, &req, table, tt.creditToken, trim)
+
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("guardPrompt() error = %v", err)
+				}
+			case *promptCreditError:
+				if !errors.As(err, &want) {
+					t.Fatalf("guardPrompt() error = %v, want *promptCreditError", err)
+				}
+			case *promptTooLargeError:
+				if !errors.As(err, &want) {
+					t.Fatalf("guardPrompt() error = %v, want *promptTooLargeError", err)
+				}
+			}
+
+			if estimate.Trimmed != tt.wantTrimmed {
+				t.Errorf("Trimmed = %v, want %v", estimate.Trimmed, tt.wantTrimmed)
+			}
+			if tt.wantTrimmed && estimate.InputTokens+estimate.MaxOutputTokens > estimate.ContextWindow {
+				t.Errorf("trimmed prompt %d tokens still exceed the context window %d", estimate.InputTokens, estimate.ContextWindow)
+			}
+			if tt.wantTrimmed && !strings.HasSuffix(req.Messages[0].Content, promptTrimmedMarker) {
+				t.Error("trimmed prompt not end with the trimmed marker")
+			}
+		})
+	}
+}
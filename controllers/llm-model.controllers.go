@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"scrapper-test/utils"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/pricing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type ModelController struct {
+	llm     *llm.Registry
+	pricing *pricing.PriceTable
+}
+
+func NewModelController(llm *llm.Registry, pricing *pricing.PriceTable) *ModelController {
+	return &ModelController{
+		llm:     llm,
+		pricing: pricing,
+	}
+}
+
+// GetModels list the enabled model on the catalog for the UI model dropdown, the "id" is sent back as the "model" value.
+// The model without context window or price on the catalog is shown with the value used when it is called
+func (h *ModelController) GetModels(c *fiber.Ctx) error {
+	enabled := h.llm.Catalog().Enabled()
+
+	models := make([]llm.Model, 0, len(enabled))
+	for _, m := range enabled {
+		if m.ContextWindow == 0 {
+			m.ContextWindow = llm.ContextWindow(m.ID)
+		}
+		if m.Price == nil {
+			price := h.pricing.ModelPrice(m.ID)
+			m.Price = &price
+		}
+
+		models = append(models, m)
+	}
+
+	return utils.ResponseWithData(c, fiber.StatusOK, "list of models", fiber.Map{
+		"models": models,
+	})
+}
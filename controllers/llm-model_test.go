@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/pricing"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestGetModels(t *testing.T) {
+	tablePrice := pricing.DefaultPriceTable().ModelPrice("gpt-4o-mini")
+	customPrice := pricing.ModelPrice{InputPerMillion: 1, OutputPerMillion: 2}
+
+	tests := []struct {
+		name    string
+		catalog *llm.Catalog // nil mean no catalog set
+		want    []llm.Model
+	}{
+		{
+			name:    "model without value filled from the tables",
+			catalog: &llm.Catalog{Models: []llm.Model{{ID: "gpt-4o-mini", Provider: llm.ProviderOpenAI, DisplayName: "GPT-4o mini", Enabled: true}}},
+			want:    []llm.Model{{ID: "gpt-4o-mini", Provider: llm.ProviderOpenAI, ContextWindow: llm.ContextWindow("gpt-4o-mini"), Price: &tablePrice}},
+		},
+		{
+			name:    "catalog value kept",
+			catalog: &llm.Catalog{Models: []llm.Model{{ID: "claude-custom", Provider: llm.ProviderClaude, ContextWindow: 50000, Price: &customPrice, Enabled: true}}},
+			want:    []llm.Model{{ID: "claude-custom", Provider: llm.ProviderClaude, ContextWindow: 50000, Price: &customPrice}},
+		},
+		{
+			name: "disabled model not listed",
+			catalog: &llm.Catalog{Models: []llm.Model{
+				{ID: "gpt-4o", Provider: llm.ProviderOpenAI, ContextWindow: 128000, Price: &customPrice},
+				{ID: "claude-3-5-haiku-20241022", Provider: llm.ProviderClaude, ContextWindow: 200000, Price: &customPrice, Enabled: true},
+			}},
+			want: []llm.Model{{ID: "claude-3-5-haiku-20241022", Provider: llm.ProviderClaude, ContextWindow: 200000, Price: &customPrice}},
+		},
+		{
+			name: "no catalog",
+			want: []llm.Model{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, 100)
+			if tt.catalog != nil {
+				if err := env.registry.SetCatalog(tt.catalog); err != nil {
+					t.Fatal(err)
+				}
+			}
+			controller := NewModelController(env.registry, pricing.DefaultPriceTable())
+
+			app := newTestApp(fiber.MethodGet, "/models", 100, controller.GetModels)
+			status, resp := sendTestJSONRequest(t, app, httptest.NewRequest(fiber.MethodGet, "/models", nil))
+			if status != fiber.StatusOK {
+				t.Fatalf("status = %d, want 200 (%s)", status, resp.Message)
+			}
+
+			var data struct {
+				Models []llm.Model `json:"models"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Models == nil || len(data.Models) != len(tt.want) {
+				t.Fatalf("models = %+v, want %d models", data.Models, len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				got := data.Models[i]
+				if got.ID != want.ID || got.Provider != want.Provider || got.ContextWindow != want.ContextWindow || got.Price == nil || *got.Price != *want.Price || !got.Enabled {
+					t.Errorf("model %d = %+v, want %+v", i, got, want)
+				}
+			}
+
+			// listing the model is free and not sent to any provider
+			if requests := env.srv.Requests(); len(requests) != 0 {
+				t.Errorf("requests = %d, want 0", len(requests))
+			}
+			if got := env.fake.creditToken(testUserId); got != 100 {
+				t.Errorf("credit token = %d, want 100", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"scrapper-test/utils/llm"
+	"time"
+)
+
+// max re-ask after the first structured answer failed to parse, each re-ask is a new billed request
+const structuredMaxReask = 2
+
+// structuredReaskPrompt is sent after the invalid answer so the model fix it, %s is the parse error
+const structuredReaskPrompt = `Jawaban sebelumnya tidak bisa digunakan karena tidak sesuai format JSON yang diminta: %s
+
+Berikan ulang jawaban lengkap hanya dalam format JSON yang sesuai dengan schema, tanpa teks lain.`
+
+// generateStructured send the structured output request and decode the answer to target with llm.ParseStructured.
+// When the answer still can't be parsed after repaired, the invalid answer and the parse error is sent back to the model
+// up to structuredMaxReask times. Every request is recorded on usage, the caller charge the credit only after this return nil.
+//
+// The returned response content is the repaired JSON, so it can be used as the assistant message on the next turn
+func generateStructured(ctx context.Context, provider llm.Provider, req llm.Request, usage *usageRecorder, target interface{}) (*llm.Response, error) {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		llmResp, err := provider.Generate(ctx, req)
+		usage.recordLLM(provider.Name(), llmResp, start, err)
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := llm.ParseStructured(llmResp.Content, req.Schema, target)
+		if err == nil {
+			llmResp.Content = content
+			return llmResp, nil
+		}
+
+		if attempt >= structuredMaxReask {
+			return nil, err
+		}
+
+		// copied so the caller messages is not changed
+		req.Messages = append(req.Messages[:len(req.Messages):len(req.Messages)],
+			llm.Message{
+				Role:    "assistant",
+				Content: llmResp.Content,
+			},
+			llm.Message{
+				Role:    "user",
+				Content: fmt.Sprintf(structuredReaskPrompt, err.Error()),
+			},
+		)
+	}
+}
@@ -0,0 +1,227 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"scrapper-test/models"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils"
+	"scrapper-test/utils/jsonschema"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/llm/llmtest"
+	"scrapper-test/utils/pricing"
+	"strings"
+	"testing"
+)
+
+type structuredTestAnswer struct {
+	Title string `json:"title"`
+	Mood  string `json:"mood" enum:"happy,sad"`
+}
+
+func TestGenerateStructured(t *testing.T) {
+	valid := llmtest.JSON(structuredTestAnswer{Title: "Judul", Mood: "happy"})
+
+	tests := []struct {
+		name         string
+		replies      []llmtest.Reply
+		wantErr      bool
+		wantParseErr bool
+		wantRequests int
+		wantUsages   int
+	}{
+		{
+			name:         "valid first answer",
+			replies:      []llmtest.Reply{valid},
+			wantRequests: 1,
+			wantUsages:   1,
+		},
+		{
+			name:         "repaired answer is not re-asked",
+			replies:      []llmtest.Reply{llmtest.JSON("```json\n{“title”: “Judul”, “mood”: “happy”,}\n```")},
+			wantRequests: 1,
+			wantUsages:   1,
+		},
+		{
+			name:         "missing field re-asked",
+			replies:      []llmtest.Reply{llmtest.JSON(`{"title": "Judul"}`), valid},
+			wantRequests: 2,
+			wantUsages:   2,
+		},
+		{
+			name:         "invalid enum and json re-asked",
+			replies:      []llmtest.Reply{llmtest.JSON(`{"title": "Judul", "mood": "angry"}`), llmtest.JSON(`{"title": `), valid},
+			wantRequests: 3,
+			wantUsages:   3,
+		},
+		{
+			name:         "still invalid after max re-ask",
+			replies:      []llmtest.Reply{llmtest.JSON(`{}`), llmtest.JSON(`{}`), llmtest.JSON(`{}`), valid},
+			wantErr:      true,
+			wantParseErr: true,
+			wantRequests: structuredMaxReask + 1,
+			wantUsages:   structuredMaxReask + 1,
+		},
+		{
+			name:         "provider error is not re-asked",
+			replies:      []llmtest.Reply{llmtest.InsufficientQuota(), valid},
+			wantErr:      true,
+			wantRequests: 1,
+			wantUsages:   1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := llmtest.New()
+			defer srv.Close()
+			srv.Enqueue(llmtest.EndpointOpenAIChat, tt.replies...)
+
+			registry, err := srv.Registry(llm.ProviderOpenAI)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			db, fake := newFakeDB(t)
+			usage := newUsageRecorder(db, *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), testUserId, utils.FEATURE_STORY_GENERATOR)
+
+			messages := []llm.Message{{Role: "user", Content: "buat judul cerita"}}
+			req := llm.Request{
+				Messages: messages,
+				Schema:   llm.MustSchema("answer", structuredTestAnswer{}),
+			}
+
+			var answer structuredTestAnswer
+			resp, err := generateStructured(context.Background(), registry.Get(llm.ProviderOpenAI), req, usage, &answer)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("generateStructured() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var parseErr *llm.ParseError
+			if errors.As(err, &parseErr) != tt.wantParseErr {
+				t.Errorf("generateStructured() error = %v, want parse error %v", err, tt.wantParseErr)
+			}
+
+			if err == nil {
+				if answer != (structuredTestAnswer{Title: "Judul", Mood: "happy"}) {
+					t.Errorf("answer = %+v", answer)
+				}
+				// the content is the repaired JSON, ready for the next turn
+				if !json.Valid([]byte(resp.Content)) {
+					t.Errorf("content = %q, want valid JSON", resp.Content)
+				}
+			}
+
+			requests := srv.RequestsTo(llmtest.EndpointOpenAIChat)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("chat requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+			if got := len(fake.llmUsages()); got != tt.wantUsages {
+				t.Errorf("recorded usages = %d, want %d", got, tt.wantUsages)
+			}
+
+			// the caller messages is not changed by the re-ask
+			if len(req.Messages) != len(messages) {
+				t.Errorf("caller messages = %d, want %d", len(req.Messages), len(messages))
+			}
+
+			// every re-ask send the previous invalid answer and its error back
+			for i := 1; i < len(requests); i++ {
+				body := string(requests[i].Body)
+				if !strings.Contains(body, "tidak sesuai format JSON") {
+					t.Errorf("request %d has no re-ask prompt: %s", i, body)
+				}
+				if got := strings.Count(body, `"role":"assistant"`); got != i {
+					t.Errorf("request %d assistant messages = %d, want %d", i, got, i)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateStructuredReaskError(t *testing.T) {
+	srv := llmtest.New()
+	defer srv.Close()
+	srv.Enqueue(llmtest.EndpointOpenAIChat, llmtest.JSON(`{"title": "Judul", "mood": "angry"}`), llmtest.JSON(structuredTestAnswer{Title: "Judul", Mood: "sad"}))
+
+	registry, err := srv.Registry(llm.ProviderOpenAI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, fake := newFakeDB(t)
+	usage := newUsageRecorder(db, *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), testUserId, utils.FEATURE_STORY_GENERATOR)
+
+	var answer structuredTestAnswer
+	_, err = generateStructured(context.Background(), registry.Get(llm.ProviderOpenAI), llm.Request{
+		Messages: []llm.Message{{Role: "user", Content: "buat judul cerita"}},
+		Schema:   llm.MustSchema("answer", structuredTestAnswer{}),
+	}, usage, &answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the validation error tell the model which field is wrong
+	var body struct {
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+	}
+	if err := json.Unmarshal(srv.RequestsTo(llmtest.EndpointOpenAIChat)[1].Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	reask := body.Messages[len(body.Messages)-1]
+	validationErr := &jsonschema.ValidationError{Errors: []string{"$.mood: must be one of happy, sad"}}
+	if reask.Role != "user" || !strings.Contains(reask.Content, validationErr.Error()) {
+		t.Errorf("re-ask message = %+v, want the validation error %q", reask, validationErr.Error())
+	}
+
+	// both answer is billed, the invalid one included
+	for _, usage := range fake.llmUsages() {
+		if usage.Status != models.LLM_USAGE_STATUS_SUCCESS || usage.Cost <= 0 {
+			t.Errorf("usage = %+v, want success with cost", usage)
+		}
+	}
+}
+
+func TestGenerateStructuredTruncatedRetryError(t *testing.T) {
+	truncated := llmtest.JSON(`{"title": "Jud`).WithUsage(1000, 500)
+	truncated.StopReason = "length"
+
+	srv := llmtest.New()
+	defer srv.Close()
+	srv.Enqueue(llmtest.EndpointOpenAIChat, truncated, llmtest.InsufficientQuota())
+
+	registry, err := srv.Registry(llm.ProviderOpenAI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, fake := newFakeDB(t)
+	usage := newUsageRecorder(db, *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), testUserId, utils.FEATURE_STORY_GENERATOR)
+
+	var answer structuredTestAnswer
+	_, err = generateStructured(context.Background(), registry.Get(llm.ProviderOpenAI), llm.Request{
+		Messages: []llm.Message{{Role: "user", Content: "buat judul cerita"}},
+		Schema:   llm.MustSchema("answer", structuredTestAnswer{}),
+	}, usage, &answer)
+	if err == nil {
+		t.Fatal("generateStructured() error = nil, want the retry error")
+	}
+
+	if got := len(srv.RequestsTo(llmtest.EndpointOpenAIChat)); got != 2 {
+		t.Fatalf("chat requests = %d, want 2", got)
+	}
+
+	// the cut answer is billed even when the retry with bigger limit failed
+	usages := fake.llmUsages()
+	if len(usages) != 1 || usages[0].Status != models.LLM_USAGE_STATUS_ERROR || usages[0].PromptTokens != 1000 || usages[0].CompletionTokens != 500 || usages[0].Cost <= 0 {
+		t.Errorf("recorded usages = %+v, want one error usage with the cut answer cost", usages)
+	}
+	if usage.credits() <= 0 {
+		t.Errorf("credits = %d, want the cut answer charged", usage.credits())
+	}
+}
@@ -0,0 +1,149 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net"
+	"scrapper-test/database"
+	"scrapper-test/models"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/pricing"
+	"time"
+)
+
+// usageRecorder record every LLM call made on one feature request to llm_usages table,
+// and sum the cost of the success call so the feature can charge the user based on the real usage
+type usageRecorder struct {
+	db           *sql.DB
+	usageRepo    llmusage.LLMUsageRepo
+	pricing      *pricing.PriceTable
+	userId       int
+	feature      string
+	totalCost    float64
+	lastProvider string // provider of the last success llm call
+}
+
+func newUsageRecorder(db *sql.DB, usageRepo llmusage.LLMUsageRepo, pricing *pricing.PriceTable, userId int, feature string) *usageRecorder {
+	return &usageRecorder{
+		db:        db,
+		usageRepo: usageRepo,
+		pricing:   pricing,
+		userId:    userId,
+		feature:   feature,
+	}
+}
+
+// credits return the credit to charge for all success call recorded
+func (r *usageRecorder) credits() int {
+	return r.pricing.Credits(r.totalCost)
+}
+
+// creditLeft return the user credit left after charged for the success call already recorded,
+// so the next call of the same feature is guarded with the credit the user still have
+func (r *usageRecorder) creditLeft(creditToken int) int {
+	if r.totalCost == 0 {
+		return creditToken
+	}
+
+	return creditToken - r.credits()
+}
+
+// servedBy return the provider that served the last success llm call, it differ from the selected provider
+// when the request is failed over
+func (r *usageRecorder) servedBy() string {
+	return r.lastProvider
+}
+
+// record save the LLM call on its own transaction, so failed call is also recorded even when the handler transaction is rolled back.
+// failing to record only logged because it should not fail the feature
+func (r *usageRecorder) record(provider string, model string, promptTokens int, completionTokens int, cost float64, start time.Time, callErr error) {
+	// failed call is not charged to the user
+	if callErr != nil {
+		cost = 0
+	}
+
+	r.recordUsage(models.LLMUsage{
+		Provider:         provider,
+		Model:            model,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Cost:             cost,
+	}, start, callErr)
+}
+
+// recordUsage same as record with the cache tokens, the user, feature, latency, and status is filled by the recorder.
+// The cost is kept on the failed call, so the caller only set it when the provider billed the call
+func (r *usageRecorder) recordUsage(usage models.LLMUsage, start time.Time, callErr error) {
+	r.totalCost += usage.Cost
+
+	usage.UserId = r.userId
+	usage.Feature = r.feature
+	usage.LatencyMs = int(time.Since(start).Milliseconds())
+	usage.Status = llmUsageStatus(callErr)
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		log.Println("Failed to record llm usage: ", err)
+		return
+	}
+	defer func() {
+		database.CommitOrRollback(tx, nil, err)
+	}()
+
+	if err = r.usageRepo.Create(tx, &usage); err != nil {
+		log.Println("Failed to record llm usage: ", err)
+	}
+}
+
+// recordLLM record the call made with llm.Provider, resp is nil when the call failed.
+// The response returned with the error only has the usage the provider billed before it failed, the cost is charged
+func (r *usageRecorder) recordLLM(providerName string, resp *llm.Response, start time.Time, callErr error) {
+	if resp == nil {
+		r.record(providerName, "", 0, 0, 0, start, callErr)
+		return
+	}
+
+	// the selected provider failed before the fallback served the request, recorded as its own failed call
+	if resp.FailoverFrom != "" {
+		r.record(resp.FailoverFrom, "", 0, 0, 0, start, resp.FailoverErr)
+	}
+
+	if callErr == nil {
+		r.lastProvider = resp.Provider
+	}
+
+	// the audio and the cache tokens is part of the input and output tokens, priced separately
+	textInput := resp.Usage.InputTokens - resp.Usage.AudioInputTokens - resp.Usage.CacheReadTokens - resp.Usage.CacheWriteTokens
+	textOutput := resp.Usage.OutputTokens - resp.Usage.AudioOutputTokens
+	cost := r.pricing.TokenCost(resp.Model, textInput, textOutput) +
+		r.pricing.AudioTokenCost(resp.Model, resp.Usage.AudioInputTokens, resp.Usage.AudioOutputTokens) +
+		r.pricing.CacheTokenCost(resp.Model, resp.Usage.CacheReadTokens, resp.Usage.CacheWriteTokens)
+
+	r.recordUsage(models.LLMUsage{
+		Provider:         resp.Provider,
+		Model:            resp.Model,
+		PromptTokens:     resp.Usage.InputTokens,
+		CompletionTokens: resp.Usage.OutputTokens,
+		CacheReadTokens:  resp.Usage.CacheReadTokens,
+		CacheWriteTokens: resp.Usage.CacheWriteTokens,
+		Cost:             cost,
+	}, start, callErr)
+}
+
+func llmUsageStatus(err error) string {
+	var netErr net.Error
+
+	switch {
+	case err == nil:
+		return models.LLM_USAGE_STATUS_SUCCESS
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return models.LLM_USAGE_STATUS_TIMEOUT
+	case errors.Is(err, context.Canceled):
+		return models.LLM_USAGE_STATUS_CANCELED
+	}
+
+	return models.LLM_USAGE_STATUS_ERROR
+}
@@ -1,30 +1,76 @@
 package controllers
 
 import (
+	"bufio"
+	"database/sql"
+	"errors"
 	"log"
 	"scrapper-test/database"
+	"scrapper-test/repository/llmusage"
 	"scrapper-test/utils"
-	"scrapper-test/utils/claude"
-	"scrapper-test/utils/openai"
+	"scrapper-test/utils/breaker"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/moderation"
+	"scrapper-test/utils/pricing"
+	"time"
 
 	sso_models "github.com/momokii/go-sso-web/pkg/models"
 	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
 	sso_utils "github.com/momokii/go-sso-web/pkg/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
 )
 
+// mediumRoastPrompt create roasting prompt from scrapped medium profile data
+func mediumRoastPrompt(promptData string) string {
+	return `
+	Berikan roasting playful untuk konten Medium user berikut dengan kriteria:
+	- Gaya bahasa: Santai/gaul Jakarta (lo-gue)
+	- Tone: Playful tapi savage 
+	- Panjang: 2-3 paragraf max
+	- Focus roasting pada:
+	* Topic/niche yang dipilih author
+	* Writing style & clickbait level
+	* Konsistensi posting
+	* Engagement & kualitas konten
+	* Fun fact atau pattern menarik
+
+	Note: Data post diambil max 10 tulisan terakhir per user. Tidak perlu mention jumlah post jika tepat 10.
+
+	Data Medium:
+	` + promptData
+}
+
+// mediumPromptTrimmer trim the end of the scrapped medium data when the profile is too large for the model
+func mediumPromptTrimmer(promptData string) promptTrimmer {
+	return func(req *llm.Request, excessTokens int) bool {
+		var ok bool
+		promptData, ok = trimTextTokens(promptData, excessTokens)
+		req.Messages[0].Content = mediumRoastPrompt(promptData)
+		return ok
+	}
+}
+
 type mediumController struct {
-	claude   claude.ClaudeAPI
-	openai   openai.OpenAI
-	userRepo sso_user.UserRepo
+	db          *sql.DB
+	llm         *llm.Registry
+	userRepo    sso_user.UserRepo
+	usageRepo   llmusage.LLMUsageRepo
+	pricing     *pricing.PriceTable
+	moderator   *moderation.Moderator
+	generations *GenerationController
 }
 
-func NewMediumController(claude claude.ClaudeAPI, openai openai.OpenAI, userRepo sso_user.UserRepo) *mediumController {
+func NewMediumController(db *sql.DB, llm *llm.Registry, userRepo sso_user.UserRepo, usageRepo llmusage.LLMUsageRepo, pricing *pricing.PriceTable, moderator *moderation.Moderator, generations *GenerationController) *mediumController {
 	return &mediumController{
-		claude:   claude,
-		openai:   openai,
-		userRepo: userRepo,
+		db:          db,
+		llm:         llm,
+		userRepo:    userRepo,
+		usageRepo:   usageRepo,
+		pricing:     pricing,
+		moderator:   moderator,
+		generations: generations,
 	}
 }
 
@@ -39,7 +85,7 @@ func (h *mediumController) PostMedium(c *fiber.Ctx) error {
 	// check if user is exist
 	user_session := c.Locals("user").(sso_models.UserSession)
 
-	tx, err := database.DB.Begin()
+	tx, err := h.db.BeginTx(c.UserContext(), nil)
 	if err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
@@ -63,69 +109,182 @@ func (h *mediumController) PostMedium(c *fiber.Ctx) error {
 	}
 
 	// start process and using the FEATURE
-
-	var content string
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user.Id, utils.FEATURE_MEDIUM)
 
 	username := c.FormValue("username")
 	llm_type := c.FormValue("model")
 
-	mediumData := utils.MediumProfileScrapper(username)
-
-	prompt := `
-	Berikan roasting playful untuk konten Medium user berikut dengan kriteria:
-	- Gaya bahasa: Santai/gaul Jakarta (lo-gue)
-	- Tone: Playful tapi savage 
-	- Panjang: 2-3 paragraf max
-	- Focus roasting pada:
-	* Topic/niche yang dipilih author
-	* Writing style & clickbait level
-	* Konsistensi posting
-	* Engagement & kualitas konten
-	* Fun fact atau pattern menarik
-
-	Note: Data post diambil max 10 tulisan terakhir per user. Tidak perlu mention jumlah post jika tepat 10.
+	provider, err := h.llm.Select(llm_type)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-	Data Medium:
-	` + mediumData.PromptData
+	mediumData, err := utils.MediumProfileScrapperWithContext(c.UserContext(), username)
+	if errors.Is(err, breaker.ErrOpen) {
+		return llmErrorResponse(c, err)
+	}
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusGatewayTimeout, "Failed to scrap medium profile: "+err.Error())
+	}
 
-	if llm_type == "claude" {
-		prompt_input := []claude.ClaudeMessageReq{
+	llmReq := llm.Request{
+		Messages: []llm.Message{
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: mediumRoastPrompt(mediumData.PromptData),
 			},
-		}
+		},
+		MaxTokens:   256 * 10,
+		MaxContinue: 1, // the roasting cut in the middle of the sentence is continued once
+	}
 
-		claudeResp, err := h.claude.ClaudeGetFirstContentDataResp(&prompt_input, 256*10, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-		}
+	estimate, err := guardPrompt(c.UserContext(), provider, &llmReq, h.pricing, usage.creditLeft(user.CreditToken), mediumPromptTrimmer(mediumData.PromptData))
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	start := time.Now()
+	llmResp, err := provider.Generate(c.UserContext(), llmReq)
+	usage.recordLLM(provider.Name(), llmResp, start, err)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	// blocked roasting is not returned and not charged
+	if err := h.moderator.CheckOutput(c.UserContext(), llmResp.Content); err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	// feature success executed, reduce user credit token
+	if err := sso_utils.UpdateUserCredit(tx, h.userRepo, user, usage.credits()); err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	h.generations.save(user.Id, utils.FEATURE_MEDIUM, username, llmResp.Content)
+
+	return utils.ResponseWithData(c, fiber.StatusOK, "medium data roasting", fiber.Map{
+		"profile":   mediumData.MediumProfileUser,
+		"content":   llmResp.Content,
+		"estimate":  estimate,
+		"served_by": usage.servedBy(),
+	})
+}
+
+// PostMediumStream same as PostMedium but send the roasting token by token using server-sent events.
+//
+// events sent: "profile" (scrapped profile), "estimate" (prompt estimate), "delta" ({"text"}), and "done" ({"profile", "content", "served_by"}) or "error" ({"message"}).
+// the credit only reduced after the stream finished successfully
+func (h *mediumController) PostMediumStream(c *fiber.Ctx) error {
+
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	tx, err := h.db.BeginTx(c.UserContext(), nil)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+	defer func() {
+		database.CommitOrRollback(tx, c, err)
+	}()
+
+	user, err := h.userRepo.FindByID(tx, user_session.Id)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if user.Id == 0 {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "user not found")
+	}
+
+	// check if user have enough credit token
+	if user.CreditToken < utils.FEATURE_MEDIUM_COST {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
+	}
+
+	// start process and using the FEATURE
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user.Id, utils.FEATURE_MEDIUM)
+
+	username := c.FormValue("username")
+	llm_type := c.FormValue("model")
+
+	provider, err := h.llm.Select(llm_type)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		content = claudeResp.Text
+	mediumData, err := utils.MediumProfileScrapperWithContext(c.UserContext(), username)
+	if errors.Is(err, breaker.ErrOpen) {
+		return llmErrorResponse(c, err)
+	}
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusGatewayTimeout, "Failed to scrap medium profile: "+err.Error())
+	}
 
-	} else {
-		prompt_input := []openai.OAMessageReq{
+	llmReq := llm.Request{
+		Messages: []llm.Message{
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: mediumRoastPrompt(mediumData.PromptData),
 			},
+		},
+		MaxTokens: 256 * 10,
+	}
+	userId := user.Id
+
+	// checked before the stream started, so the refused prompt get normal error response
+	estimate, err := guardPrompt(c.UserContext(), provider, &llmReq, h.pricing, usage.creditLeft(user.CreditToken), mediumPromptTrimmer(mediumData.PromptData))
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	// fiber ctx can't be used inside the stream writer because the handler already returned
+	deadline := streamDeadline(c, utils.FEATURE_MEDIUM_TIMEOUT)
+	utils.SetSSEHeaders(c)
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		// the request context is already done here, the stream context keep its deadline and canceled when the client left
+		stream := newSSEStream(w, deadline)
+		defer stream.close()
+
+		if err := stream.send("profile", mediumData.MediumProfileUser); err != nil {
+			return
+		}
+
+		if err := stream.send("estimate", estimate); err != nil {
+			return
 		}
 
-		openaiResp, err := h.openai.OpenAIGetFirstContentDataResp(&prompt_input, false, nil, false, nil)
+		// the generated text is checked per chunk before sent, the blocked chunk stop the generation and not charged
+		output := h.moderator.Stream(stream.ctx, func(text string) error {
+			return stream.send("delta", fiber.Map{
+				"text": text,
+			})
+		})
+
+		start := time.Now()
+		llmResp, err := provider.GenerateStream(stream.ctx, llmReq, output.Write)
+		usage.recordLLM(provider.Name(), llmResp, start, err)
+		if err == nil {
+			err = output.Flush()
+		}
 		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+			_, message := llmErrorStatus(err)
+			stream.sendError(message)
+			return
 		}
 
-		content = openaiResp.Content
-	}
+		// feature success executed, reduce user credit token
+		if err := chargeUserCredit(h.db, h.userRepo, userId, usage.credits()); err != nil {
+			stream.sendError(err.Error())
+			return
+		}
 
-	// feature success executed, reduce user credit token
-	if err := sso_utils.UpdateUserCredit(tx, h.userRepo, user, utils.FEATURE_MEDIUM_COST); err != nil {
-		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-	}
+		h.generations.save(userId, utils.FEATURE_MEDIUM, username, llmResp.Content)
 
-	return utils.ResponseWithData(c, fiber.StatusOK, "medium data roasting", fiber.Map{
-		"profile": mediumData.MediumProfileUser,
-		"content": content,
-	})
+		stream.send("done", fiber.Map{
+			"profile":   mediumData.MediumProfileUser,
+			"content":   llmResp.Content,
+			"served_by": usage.servedBy(),
+		})
+	}))
+
+	return nil
 }
@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"scrapper-test/models"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils"
+	"scrapper-test/utils/llm/llmtest"
+	"scrapper-test/utils/pricing"
+	"strings"
+	"testing"
+
+	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// mediumTestProfile is the medium profile page served to the scrapper, using the selector of the real page
+const mediumTestProfile = `<html><body>
+<div class="l ae">
+	<h2 class="pw-author-name">Kelana</h2>
+	<span class="pw-follower-count">12 Followers</span>
+	<p class="bf">Nulis tiap hari</p>
+	<img src="https://miro.medium.com/kelana.png">
+</div>
+<div class="ab cn"><h2>Belajar Go dalam 5 Menit</h2><h3>Pasti bisa</h3><div class="h">Jan 2</div></div>
+<div class="ab cn"><h2>10 Tips Produktif</h2><h3>Nomor 7 bikin kaget</h3><div class="h">Jan 9</div></div>
+</body></html>`
+
+// mediumTestTransport answer every scrapper request with mediumTestProfile
+type mediumTestTransport struct{}
+
+func (mediumTestTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(mediumTestProfile)),
+		Request:    req,
+	}, nil
+}
+
+// newTestMediumController create medium controller on the test env, the medium profile is scrapped from mediumTestProfile
+func newTestMediumController(t *testing.T, creditToken int) (*mediumController, *testEnv) {
+	t.Helper()
+
+	utils.SetScrapperTransport(mediumTestTransport{})
+	t.Cleanup(func() {
+		utils.SetScrapperTransport(http.DefaultTransport)
+	})
+
+	env := newTestEnv(t, creditToken)
+
+	return NewMediumController(env.db, env.registry, *sso_user.NewUserRepo(), *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), env.moderator, env.generations), env
+}
+
+func newMediumRequest(path string, username string, model string) *http.Request {
+	form := url.Values{"username": {username}, "model": {model}}
+
+	req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", fiber.MIMEApplicationForm)
+	return req
+}
+
+func TestPostMedium(t *testing.T) {
+	tests := []struct {
+		name         string
+		creditToken  int
+		model        string
+		endpoint     string
+		reply        llmtest.Reply
+		wantStatus   int
+		wantServedBy string
+		wantRequests int
+		wantUsage    string // status of the recorded usage, empty when no usage recorded
+	}{
+		{
+			name:         "roasted by claude",
+			creditToken:  100,
+			endpoint:     llmtest.EndpointClaudeMessages,
+			reply:        llmtest.Text("Lo nulis clickbait mulu").WithUsage(1500, 300),
+			wantStatus:   fiber.StatusOK,
+			wantServedBy: "claude",
+			wantRequests: 1,
+			wantUsage:    models.LLM_USAGE_STATUS_SUCCESS,
+		},
+		{
+			name:         "roasted by openai",
+			creditToken:  100,
+			model:        "openai",
+			endpoint:     llmtest.EndpointOpenAIChat,
+			reply:        llmtest.Text("Lo nulis clickbait mulu").WithUsage(1500, 300),
+			wantStatus:   fiber.StatusOK,
+			wantServedBy: "openai",
+			wantRequests: 1,
+			wantUsage:    models.LLM_USAGE_STATUS_SUCCESS,
+		},
+		{
+			name:         "blocked roast not charged",
+			creditToken:  100,
+			endpoint:     llmtest.EndpointClaudeMessages,
+			reply:        llmtest.Text("BAD roast"),
+			wantStatus:   fiber.StatusUnprocessableEntity,
+			wantRequests: 1,
+			wantUsage:    models.LLM_USAGE_STATUS_SUCCESS,
+		},
+		{
+			name:         "provider error not charged",
+			creditToken:  100,
+			endpoint:     llmtest.EndpointClaudeMessages,
+			reply:        llmtest.Overloaded(),
+			wantStatus:   fiber.StatusServiceUnavailable,
+			wantRequests: 1,
+			wantUsage:    models.LLM_USAGE_STATUS_ERROR,
+		},
+		{
+			name:        "not enough credit",
+			creditToken: 0,
+			endpoint:    llmtest.EndpointClaudeMessages,
+			wantStatus:  fiber.StatusUnauthorized,
+		},
+		{
+			name:        "model not allowed",
+			creditToken: 100,
+			model:       "gpt-unknown",
+			endpoint:    llmtest.EndpointClaudeMessages,
+			wantStatus:  fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestMediumController(t, tt.creditToken)
+			if tt.wantRequests > 0 {
+				env.srv.Enqueue(tt.endpoint, tt.reply)
+			}
+
+			app := newTestApp(fiber.MethodPost, "/medium", tt.creditToken, controller.PostMedium)
+			status, resp := sendTestJSONRequest(t, app, newMediumRequest("/medium", "kelana", tt.model))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			requests := env.srv.RequestsTo(tt.endpoint)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("%s requests = %d, want %d", tt.endpoint, len(requests), tt.wantRequests)
+			}
+
+			usages := env.fake.llmUsages()
+			if tt.wantUsage == "" && len(usages) != 0 {
+				t.Errorf("recorded usages = %d, want 0", len(usages))
+			}
+			if tt.wantUsage != "" && (len(usages) != 1 || usages[0].Status != tt.wantUsage || usages[0].Feature != utils.FEATURE_MEDIUM) {
+				t.Errorf("recorded usages = %+v, want one %s medium usage", usages, tt.wantUsage)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != tt.creditToken {
+					t.Errorf("credit token = %d, want %d not charged", got, tt.creditToken)
+				}
+				return
+			}
+
+			// the scrapped profile is sent on the prompt
+			if body := string(requests[0].Body); !strings.Contains(body, "Belajar Go dalam 5 Menit") || !strings.Contains(body, "Name: Kelana") {
+				t.Errorf("prompt not contain the scrapped profile: %s", body)
+			}
+
+			var data struct {
+				Profile  models.MediumProfileUser `json:"profile"`
+				Content  string                   `json:"content"`
+				ServedBy string                   `json:"served_by"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Content != "Lo nulis clickbait mulu" || data.Profile.Name != "Kelana" || data.ServedBy != tt.wantServedBy {
+				t.Errorf("data = %+v", data)
+			}
+
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != tt.creditToken-charged {
+				t.Errorf("credit token = %d, want %d charged from %d", got, charged, tt.creditToken)
+			}
+
+			generations := env.fake.waitGenerations(t, 1)
+			if generations[0].Feature != utils.FEATURE_MEDIUM || generations[0].Title != "kelana" || generations[0].Content != data.Content {
+				t.Errorf("saved generation = %+v", generations[0])
+			}
+		})
+	}
+}
+
+func TestPostMediumStream(t *testing.T) {
+	tests := []struct {
+		name        string
+		reply       llmtest.Reply
+		wantEvents  []string
+		wantCharged bool
+	}{
+		{
+			name:        "streamed",
+			reply:       llmtest.Text("Lo nulis clickbait mulu, judulnya heboh isinya biasa aja").WithUsage(1500, 300),
+			wantEvents:  []string{"profile", "estimate", "delta", "done"},
+			wantCharged: true,
+		},
+		{
+			name:       "blocked chunk stop the stream",
+			reply:      llmtest.Text("BAD roast"),
+			wantEvents: []string{"profile", "estimate", "error"},
+		},
+		{
+			name:       "stream error",
+			reply:      llmtest.StreamError("Lo nulis clickbait mulu, judulnya heboh", "overloaded_error", "Overloaded"),
+			wantEvents: []string{"profile", "estimate", "error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestMediumController(t, 100)
+			env.srv.Enqueue(llmtest.EndpointClaudeMessages, tt.reply)
+
+			app := newTestApp(fiber.MethodPost, "/medium/stream", 100, controller.PostMediumStream)
+			status, body := sendTestRequest(t, app, newMediumRequest("/medium/stream", "kelana", ""))
+			if status != fiber.StatusOK {
+				t.Fatalf("status = %d, want 200 (%s)", status, body)
+			}
+
+			// every event is sent in order, the delta may be sent more than once
+			var events []string
+			for _, line := range strings.Split(string(body), "\n") {
+				event, ok := strings.CutPrefix(line, "event: ")
+				if ok && (len(events) == 0 || events[len(events)-1] != event) {
+					events = append(events, event)
+				}
+			}
+			if strings.Join(events, ",") != strings.Join(tt.wantEvents, ",") {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+
+			charged := 0
+			if tt.wantCharged {
+				charged = env.chargedCredits()
+				if charged <= 0 {
+					t.Errorf("charged credits = %d, want more than 0", charged)
+				}
+				env.fake.waitGenerations(t, 1)
+			}
+			if got := env.fake.creditToken(testUserId); got != 100-charged {
+				t.Errorf("credit token = %d, want %d", got, 100-charged)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"scrapper-test/models"
+	"scrapper-test/utils/llm"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// narrated story mode use the openai audio output, so the paragraph come back already narrated in one request
+const (
+	storiesNarrationVoice  = "alloy"
+	storiesNarrationFormat = "mp3"
+)
+
+// storiesChoiceNumber match the choice number like "1." or "2)" on the narration transcript
+var storiesChoiceNumber = regexp.MustCompile(`(?:^|\s)[1-9][.)]\s+`)
+
+// storiesNarrationPrompt replace the JSON output instruction on the paragraph prompt, the audio output can't use
+// structured output so the choices is spoken after the paragraph and read back from the transcript
+func storiesNarrationPrompt(withChoices bool) string {
+	if !withChoices {
+		return `
+	Jawaban ini akan langsung dibacakan oleh narator, jadi abaikan instruksi format data dan tag HTML di atas. Bacakan hanya paragraf penutup cerita dengan penuh penghayatan tanpa pilihan keputusan.
+	`
+	}
+
+	return `
+	Jawaban ini akan langsung dibacakan oleh narator, jadi abaikan instruksi format data dan tag HTML di atas. Bacakan paragraf cerita dengan penuh penghayatan, kemudian bacakan 4 pilihan keputusan dengan masing-masing pilihan diawali nomornya seperti "1.", "2.", "3.", dan "4.". Jangan gunakan nomor seperti itu di dalam paragraf cerita.
+	`
+}
+
+// parseStoriesNarration split the narration transcript into the paragraph and the numbered choices
+func parseStoriesNarration(transcript string, withChoices bool) (models.StoriesCreateParagraph, error) {
+	transcript = strings.TrimSpace(transcript)
+
+	if !withChoices {
+		return models.StoriesCreateParagraph{
+			Paragraph: transcript,
+			Choices:   []string{},
+		}, nil
+	}
+
+	numbers := storiesChoiceNumber.FindAllStringIndex(transcript, -1)
+	if len(numbers) == 0 {
+		return models.StoriesCreateParagraph{}, errors.New("Failed to read the choices from the narrated story, please try again")
+	}
+
+	parsed := models.StoriesCreateParagraph{
+		Paragraph: strings.TrimSuffix(strings.TrimSpace(transcript[:numbers[0][0]]), ":"),
+		Choices:   make([]string, 0, len(numbers)),
+	}
+
+	for i, number := range numbers {
+		end := len(transcript)
+		if i+1 < len(numbers) {
+			end = numbers[i+1][0]
+		}
+
+		if choice := strings.TrimSpace(transcript[number[1]:end]); choice != "" {
+			parsed.Choices = append(parsed.Choices, choice)
+		}
+	}
+
+	return parsed, nil
+}
+
+// generateStoriesParagraph generate the paragraph and the choices with the model from type_llm, usage is recorded on usage.
+// system and parts is the prompt, the part marked with Cache is reused from the prompt cache on the next turn.
+// The prompt is guarded before it sent, the oldest paragraph part is removed when the story is too long for the model.
+// When narrate is true the paragraph is generated by openai with audio output whatever the type_llm,
+// and the narration is returned together with the paragraph parsed from its transcript
+func (h *StoriesController) generateStoriesParagraph(ctx context.Context, type_llm string, system []llm.Part, parts []llm.Part, narrate bool, withChoices bool, creditToken int, usage *usageRecorder) (*models.StoriesCreateParagraph, *models.StoriesNarration, *promptEstimate, error) {
+	var parsedResponse models.StoriesCreateParagraph
+
+	llmReq := llm.Request{
+		System: system,
+		Messages: []llm.Message{
+			{
+				Role:  "user",
+				Parts: parts,
+			},
+		},
+		MaxTokens: 512 * 10,
+		Schema:    storiesParagraphSchema,
+	}
+
+	// the first part (story data), the last paragraph, and the choice instruction after it is never trimmed
+	keep := 2
+
+	var provider llm.Provider
+	var err error
+	if !narrate {
+		provider, err = h.llm.Select(type_llm)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	} else {
+		keep++
+		provider = h.llm.Get(llm.ProviderOpenAI)
+		llmReq.Messages[0].Parts = append(parts[:len(parts):len(parts)], llm.Part{Text: storiesNarrationPrompt(withChoices)})
+		llmReq.Schema = nil
+		llmReq.Audio = &llm.AudioRequest{
+			Voice:  storiesNarrationVoice,
+			Format: storiesNarrationFormat,
+		}
+	}
+
+	estimate, err := guardPrompt(ctx, provider, &llmReq, h.pricing, usage.creditLeft(creditToken), func(req *llm.Request, excessTokens int) bool {
+		var trimmed bool
+		req.Messages[0].Parts, trimmed = trimOldestParts(req.Messages[0].Parts, keep, excessTokens)
+		return trimmed
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if !narrate {
+		// the invalid answer is asked again
+		if _, err := generateStructured(ctx, provider, llmReq, usage, &parsedResponse); err != nil {
+			return nil, nil, nil, err
+		}
+
+		return &parsedResponse, nil, estimate, nil
+	}
+
+	start := time.Now()
+	llmResp, err := provider.Generate(ctx, llmReq)
+	usage.recordLLM(provider.Name(), llmResp, start, err)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	parsedResponse, err = parseStoriesNarration(llmResp.Audio.Transcript, withChoices)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &parsedResponse, &models.StoriesNarration{
+		Format:     llmResp.Audio.Format,
+		B64JSON:    llmResp.Audio.Data,
+		Transcript: llmResp.Audio.Transcript,
+	}, estimate, nil
+}
+
+// withNarration add the narration to the paragraph response data when the paragraph is narrated
+func withNarration(data fiber.Map, narration *models.StoriesNarration) fiber.Map {
+	if narration != nil {
+		data["narration"] = narration
+	}
+
+	return data
+}
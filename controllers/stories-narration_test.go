@@ -0,0 +1,170 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"scrapper-test/models"
+	"scrapper-test/utils/llm/llmtest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestParseStoriesNarration(t *testing.T) {
+	tests := []struct {
+		name        string
+		transcript  string
+		withChoices bool
+		want        models.StoriesCreateParagraph
+		wantErr     bool
+	}{
+		{
+			name:        "numbered with dot",
+			transcript:  "Pintu terbuka sendiri. Apa yang kamu lakukan? 1. Masuk ke dalam 2. Lari pulang",
+			withChoices: true,
+			want:        models.StoriesCreateParagraph{Paragraph: "Pintu terbuka sendiri. Apa yang kamu lakukan?", Choices: []string{"Masuk ke dalam", "Lari pulang"}},
+		},
+		{
+			name:        "numbered with parenthesis on new line",
+			transcript:  "Pintu terbuka sendiri. Pilihanmu:\n1) Masuk ke dalam\n2) Memanggil pemilik rumah\n3) Lari pulang",
+			withChoices: true,
+			want:        models.StoriesCreateParagraph{Paragraph: "Pintu terbuka sendiri. Pilihanmu", Choices: []string{"Masuk ke dalam", "Memanggil pemilik rumah", "Lari pulang"}},
+		},
+		{
+			name:        "number inside the word is not a choice",
+			transcript:  "Jam menunjukkan pukul 12.30 malam. 1. Menunggu 2. Tidur",
+			withChoices: true,
+			want:        models.StoriesCreateParagraph{Paragraph: "Jam menunjukkan pukul 12.30 malam.", Choices: []string{"Menunggu", "Tidur"}},
+		},
+		{
+			name:        "no numbered choice",
+			transcript:  "Pintu terbuka sendiri dan kamu masuk ke dalam.",
+			withChoices: true,
+			wantErr:     true,
+		},
+		{
+			name:       "closing paragraph has no choice",
+			transcript: "  Sejak malam itu lampu rumah tidak pernah menyala lagi. 1. bukan pilihan  ",
+			want:       models.StoriesCreateParagraph{Paragraph: "Sejak malam itu lampu rumah tidak pernah menyala lagi. 1. bukan pilihan", Choices: []string{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStoriesNarration(tt.transcript, tt.withChoices)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStoriesNarration() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.Paragraph != tt.want.Paragraph || strings.Join(got.Choices, "|") != strings.Join(tt.want.Choices, "|") || got.Choices == nil {
+				t.Errorf("parseStoriesNarration() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateStoriesParagraphNarrate(t *testing.T) {
+	audio := []byte("ID3 narasi cerita")
+	input := models.StoriesCreateParagraphContinueInput{
+		StoriesCreateFirstPartInput: models.StoriesCreateFirstPartInput{
+			StoriesCreateInput: models.StoriesCreateInput{Theme: "horor", Language: "indonesia"},
+			Title:              "Rumah di Ujung Jalan",
+			Description:        "Rumah tua yang selalu menyala tengah malam.",
+		},
+		Paragraph: "Lampu rumah itu menyala lagi tepat tengah malam.",
+		Choice:    "Mendekati rumah",
+	}
+
+	tests := []struct {
+		name          string
+		data          string
+		transcript    string
+		wantStatus    int
+		wantParagraph string
+		wantChoices   []string
+	}{
+		{
+			name:          "next paragraph narrated with choices",
+			data:          "next",
+			transcript:    "Pintu rumah terbuka sendiri saat kamu mendekat. 1. Masuk ke dalam 2. Memanggil pemilik rumah 3. Mengintip dari jendela 4. Lari pulang",
+			wantStatus:    fiber.StatusOK,
+			wantParagraph: "Pintu rumah terbuka sendiri saat kamu mendekat.",
+			wantChoices:   []string{"Masuk ke dalam", "Memanggil pemilik rumah", "Mengintip dari jendela", "Lari pulang"},
+		},
+		{
+			name:          "end paragraph narrated without choices",
+			data:          "end",
+			transcript:    "Sejak malam itu lampu rumah tidak pernah menyala lagi.",
+			wantStatus:    fiber.StatusOK,
+			wantParagraph: "Sejak malam itu lampu rumah tidak pernah menyala lagi.",
+			wantChoices:   []string{},
+		},
+		{
+			name:       "choices not spoken not charged",
+			data:       "next",
+			transcript: "Pintu rumah terbuka sendiri saat kamu mendekat.",
+			wantStatus: fiber.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestStoriesController(t, 100)
+			env.srv.Enqueue(llmtest.EndpointOpenAIChat, llmtest.Narration(tt.transcript, audio).WithUsage(1500, 800))
+
+			app := newTestApp(fiber.MethodPost, "/stories/:data", 100, controller.CreateStoriesParagraph)
+			status, resp := sendTestJSONRequest(t, app, newStoriesRequest(t, "/stories/"+tt.data+"?narrate=true", input))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			// narrated by openai audio output whatever the default provider
+			if got := len(env.srv.RequestsTo(llmtest.EndpointClaudeMessages)); got != 0 {
+				t.Errorf("claude requests = %d, want 0", got)
+			}
+			requests := env.srv.RequestsTo(llmtest.EndpointOpenAIChat)
+			if len(requests) != 1 {
+				t.Fatalf("chat requests = %d, want 1", len(requests))
+			}
+			if body := string(requests[0].Body); !strings.Contains(body, `"modalities":["text","audio"]`) || strings.Contains(body, "response_format") {
+				t.Errorf("request is not audio output without schema: %s", body)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != 100 {
+					t.Errorf("credit token = %d, want 100 not charged", got)
+				}
+				return
+			}
+
+			var data struct {
+				Paragraph string                  `json:"paragraph"`
+				Choices   []string                `json:"choices"`
+				Narration models.StoriesNarration `json:"narration"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Paragraph != tt.wantParagraph || strings.Join(data.Choices, "|") != strings.Join(tt.wantChoices, "|") {
+				t.Errorf("data = %+v", data)
+			}
+			if data.Narration.Format != storiesNarrationFormat || data.Narration.B64JSON != base64.StdEncoding.EncodeToString(audio) || data.Narration.Transcript != tt.transcript {
+				t.Errorf("narration = %+v", data.Narration)
+			}
+
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != 100-charged {
+				t.Errorf("credit token = %d, want %d charged from 100", got, charged)
+			}
+
+			generations := env.fake.waitGenerations(t, 1)
+			if generations[0].Content != tt.wantParagraph {
+				t.Errorf("saved generation = %+v", generations[0])
+			}
+		})
+	}
+}
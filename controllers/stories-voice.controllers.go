@@ -0,0 +1,153 @@
+package controllers
+
+import (
+	"encoding/json"
+	"io"
+	"scrapper-test/models"
+	"scrapper-test/utils"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/openai"
+	"strings"
+	"time"
+
+	sso_models "github.com/momokii/go-sso-web/pkg/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// story language on the UI to ISO-639-1 code used by whisper, unknown language is auto detected
+var whisperLanguage = map[string]string{
+	"indonesia": "id",
+	"english":   "en",
+}
+
+// withExtraData add the extra field to the response data
+func withExtraData(data fiber.Map, extra fiber.Map) fiber.Map {
+	for key, value := range extra {
+		data[key] = value
+	}
+
+	return data
+}
+
+// CreateStoriesVoiceChoice let the user speak the theme or a custom choice instead of selecting it.
+//
+// The request is multipart form with "audio" file and "payload" that contain the same JSON body as the target route,
+// the "target" query is "theme" for CreateStoriesTitle, "next" or "end" for CreateStoriesParagraph.
+// The transcribed text replace the theme or the choice, and returned on "transcript" together with the target response.
+// The transcription is charged together with the story part, so nothing charged if the story part failed
+func (h *StoriesController) CreateStoriesVoiceChoice(c *fiber.Ctx) error {
+
+	target := c.Query("target")
+	if target != "theme" && target != "next" && target != "end" {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "target must be theme, next, or end")
+	}
+
+	fileHeader, err := c.FormFile("audio")
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, "audio file is required")
+	}
+
+	if fileHeader.Size > openai.OAMaxTranscriptionFileSize {
+		return utils.ErrorResponse(c, fiber.StatusRequestEntityTooLarge, "audio file must be less than 25 MB")
+	}
+
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	user, err := findUser(c.UserContext(), h.db, h.userRepo, user_session.Id)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if user.Id == 0 {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "user not found")
+	}
+
+	minCost := utils.FEATURE_STORY_PARAGRAPH_COST
+	if target == "theme" {
+		minCost = utils.FEATURE_STORY_GENERATOR_COST
+	}
+	if user.CreditToken < minCost {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
+	}
+
+	// payload decoded before the transcription, so invalid input not wasting the transcription
+	titleInput := new(models.StoriesCreateInput)
+	paragraphInput := new(models.StoriesCreateParagraphContinueInput)
+	var language string
+
+	payload := []byte(c.FormValue("payload"))
+	if target == "theme" {
+		if err := json.Unmarshal(payload, titleInput); err != nil {
+			return utils.ErrorResponse(c, fiber.StatusBadRequest, "invalid payload: "+err.Error())
+		}
+		language = titleInput.Language
+	} else {
+		if err := json.Unmarshal(payload, paragraphInput); err != nil {
+			return utils.ErrorResponse(c, fiber.StatusBadRequest, "invalid payload: "+err.Error())
+		}
+		language = paragraphInput.Language
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+	defer file.Close()
+
+	audio, err := io.ReadAll(file)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user_session.Id, utils.FEATURE_STORY_GENERATOR)
+
+	transcribeReq := openai.OAReqTranscription{
+		File:     audio,
+		FileName: fileHeader.Filename,
+		Model:    "whisper-1",
+		Language: whisperLanguage[language],
+	}
+
+	start := time.Now()
+	transcription, err := h.openai.OpenAITranscribeWithContext(c.UserContext(), &transcribeReq)
+	var transcriptionCost float64
+	if transcription != nil {
+		transcriptionCost = h.pricing.TranscriptionCost(transcribeReq.Model, transcription.Duration)
+	}
+	usage.record(llm.ProviderOpenAI, transcribeReq.Model, 0, 0, transcriptionCost, start, err)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	text := strings.TrimSpace(transcription.Text)
+	if text == "" {
+		return utils.ErrorResponse(c, fiber.StatusUnprocessableEntity, "Could not understand the audio, please try again")
+	}
+
+	transcript := models.StoriesVoiceTranscript{
+		Text:     text,
+		Language: transcription.Language,
+		Duration: transcription.Duration,
+		Segments: make([]models.StoriesVoiceTranscriptSegment, 0, len(transcription.Segments)),
+	}
+	for _, segment := range transcription.Segments {
+		transcript.Segments = append(transcript.Segments, models.StoriesVoiceTranscriptSegment{
+			Start: segment.Start,
+			End:   segment.End,
+			Text:  strings.TrimSpace(segment.Text),
+		})
+	}
+
+	extra := fiber.Map{
+		"transcript": transcript,
+	}
+
+	if target == "theme" {
+		titleInput.Theme = text
+		return h.createStoriesTitle(c, titleInput, usage, extra)
+	}
+
+	paragraphInput.Choice = text
+	return h.createStoriesParagraph(c, target, paragraphInput, usage, extra)
+}
@@ -0,0 +1,183 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"scrapper-test/models"
+	"scrapper-test/utils/llm/llmtest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func newVoiceChoiceRequest(t *testing.T, target string, payload string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("payload", payload)
+
+	file, err := form.CreateFormFile("audio", "pilihan.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte("ID3 fake audio"))
+
+	if err := form.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, "/stories/voice?target="+target, &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return req
+}
+
+func TestCreateStoriesVoiceChoice(t *testing.T) {
+	titles := models.StoriesCreateTitleFormat{Titles: []models.StoriesCreateTitle{
+		{Title: "Rumah di Ujung Jalan", Description: "Rumah tua yang selalu menyala tengah malam."},
+	}}
+	next := models.StoriesCreateParagraph{
+		Paragraph: "Kamu mengetuk pintu tiga kali dan lampu langsung padam.",
+		Choices:   []string{"Masuk ke dalam", "Lari pulang"},
+	}
+	paragraph := `{"theme": "horor", "language": "indonesia", "title": "Rumah di Ujung Jalan", "description": "Rumah tua", "paragraph": "Lampu rumah itu menyala lagi.", "choice": ""}`
+
+	tests := []struct {
+		name          string
+		target        string
+		payload       string
+		transcription llmtest.Reply
+		reply         llmtest.Reply
+		wantStatus    int
+		wantRequests  int    // message requests after the transcription
+		wantPrompt    string // the transcript replace the theme or the choice on the prompt
+	}{
+		{
+			name:          "spoken theme create titles",
+			target:        "theme",
+			payload:       `{"language": "indonesia"}`,
+			transcription: llmtest.Text("rumah hantu di desa"),
+			reply:         llmtest.JSON(titles).WithUsage(800, 400),
+			wantStatus:    fiber.StatusOK,
+			wantRequests:  1,
+			wantPrompt:    "rumah hantu di desa",
+		},
+		{
+			name:          "spoken choice continue the story",
+			target:        "next",
+			payload:       paragraph,
+			transcription: llmtest.Text("mengetuk pintu tiga kali"),
+			reply:         llmtest.JSON(next).WithUsage(1500, 500),
+			wantStatus:    fiber.StatusOK,
+			wantRequests:  1,
+			wantPrompt:    "Pilihan yang diambil: 'mengetuk pintu tiga kali'",
+		},
+		{
+			name:          "silent audio not sent to the story",
+			target:        "next",
+			payload:       paragraph,
+			transcription: llmtest.Text("  "),
+			wantStatus:    fiber.StatusUnprocessableEntity,
+		},
+		{
+			name:          "story error not charged with the transcription",
+			target:        "end",
+			payload:       paragraph,
+			transcription: llmtest.Text("masuk ke dalam"),
+			reply:         llmtest.Overloaded(),
+			wantStatus:    fiber.StatusServiceUnavailable,
+			wantRequests:  1,
+		},
+		{
+			name:       "invalid payload not transcribed",
+			target:     "next",
+			payload:    `{"theme": `,
+			wantStatus: fiber.StatusBadRequest,
+		},
+		{
+			name:       "invalid target",
+			target:     "title",
+			payload:    paragraph,
+			wantStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestStoriesController(t, 100)
+			if tt.transcription.Text != "" {
+				env.srv.Enqueue(llmtest.EndpointOpenAITranscriptions, tt.transcription)
+			}
+			if tt.wantRequests > 0 {
+				env.srv.Enqueue(llmtest.EndpointClaudeMessages, tt.reply)
+			}
+
+			app := newTestApp(fiber.MethodPost, "/stories/voice", 100, controller.CreateStoriesVoiceChoice)
+			status, resp := sendTestJSONRequest(t, app, newVoiceChoiceRequest(t, tt.target, tt.payload))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			// the invalid request is refused before the audio is transcribed
+			wantTranscriptions := 0
+			if tt.transcription.Text != "" {
+				wantTranscriptions = 1
+			}
+			transcriptions := env.srv.RequestsTo(llmtest.EndpointOpenAITranscriptions)
+			if len(transcriptions) != wantTranscriptions {
+				t.Fatalf("transcription requests = %d, want %d", len(transcriptions), wantTranscriptions)
+			}
+			if wantTranscriptions > 0 && !strings.Contains(string(transcriptions[0].Body), "whisper-1") {
+				t.Errorf("transcription request not use whisper-1: %s", transcriptions[0].Body)
+			}
+
+			requests := env.srv.RequestsTo(llmtest.EndpointClaudeMessages)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("message requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != 100 {
+					t.Errorf("credit token = %d, want 100 not charged", got)
+				}
+				return
+			}
+
+			if body := string(requests[0].Body); !strings.Contains(body, tt.wantPrompt) {
+				t.Errorf("prompt not contain %q: %s", tt.wantPrompt, body)
+			}
+
+			var data struct {
+				Transcript models.StoriesVoiceTranscript `json:"transcript"`
+				Titles     []models.StoriesCreateTitle   `json:"titles"`
+				Paragraph  string                        `json:"paragraph"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Transcript.Text != tt.transcription.Text || len(data.Transcript.Segments) != 1 || data.Transcript.Duration <= 0 {
+				t.Errorf("transcript = %+v", data.Transcript)
+			}
+			if (tt.target == "theme" && len(data.Titles) != len(titles.Titles)) || (tt.target != "theme" && data.Paragraph != next.Paragraph) {
+				t.Errorf("data = %+v", data)
+			}
+
+			// the transcription is charged together with the story part
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != 100-charged {
+				t.Errorf("credit token = %d, want %d charged from 100", got, charged)
+			}
+			if usages := env.fake.llmUsages(); len(usages) != 2 || usages[0].Model != "whisper-1" || usages[0].Cost <= 0 {
+				t.Errorf("recorded usages = %+v, want the transcription and the story part", usages)
+			}
+
+			if tt.target != "theme" {
+				env.fake.waitGenerations(t, 1)
+			}
+		})
+	}
+}
@@ -1,36 +1,112 @@
 package controllers
 
 import (
-	"encoding/json"
+	"bufio"
+	"database/sql"
 	"fmt"
 	"scrapper-test/database"
 	"scrapper-test/models"
+	"scrapper-test/repository/llmusage"
 	"scrapper-test/utils"
-	"scrapper-test/utils/claude"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/moderation"
 	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
 	"strings"
+	"time"
 
 	sso_models "github.com/momokii/go-sso-web/pkg/models"
 	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
 	sso_utils "github.com/momokii/go-sso-web/pkg/utils"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
 )
 
+// json schema for the stories response, generated from the struct the response is decoded to
+var (
+	storiesTitleSchema     = llm.MustSchema("titles_choices", models.StoriesCreateTitleFormat{})
+	storiesParagraphSchema = llm.MustSchema("paragraph_choices", models.StoriesCreateParagraph{}) // used by first part and next paragraph
+)
+
+// storiesFirstPartPrompt create prompt for the opening paragraph of the story based on the chosen title
+func storiesFirstPartPrompt(inputUser *models.StoriesCreateFirstPartInput) string {
+	return fmt.Sprintf(`Berdasarkan judul yang dipilih ['%s'] dengan tema ['%s'] dan deskripsi ['%s'], hasilkan awal cerita pendek yang menarik dalam bahasa ['%s'] berikan dalam 3-4 kalimat diakhiri dengan keadaan yang membutuhkan keputusan.
+
+	Return pada paragraf hanya berisi paragraf baru saja tanpa pilihan keputusan baru yang akan digunakan dan juga tanpa seperti '\n' dan sejenisnya. Jika diperlukan berikan input tersebut dalam tag HTML
+
+	Kemudian berikan 4 pilihan keputusan yang bisa diambil oleh karakter utama untuk dapat melanjutkan cerita.
+
+	Berikan format keputusan dalam array dengan ["keputusan 1", "keputusan -n"] tanpa "a. KEPUTUSAN" atau "1. KEPUTUSAN"
+
+	`, inputUser.Title, inputUser.Theme, inputUser.Description, inputUser.Language)
+}
+
+// storiesParagraphSystem is the static instruction for the next and the last paragraph. It is the same on every turn,
+// so together with the story so far it is sent as the cached prompt prefix and only the choice is new on each turn
+const storiesParagraphSystem = `Kamu sedang membuat cerita pendek bersambung yang interaktif, pembaca menentukan jalan cerita dengan memilih keputusan yang diambil oleh karakter utama.
+
+Pada setiap giliran kamu akan diberikan data cerita (judul, deskripsi, tema, dan bahasa penulisan), seluruh paragraf cerita sampai saat ini, dan pilihan yang diambil. Tulis lanjutan cerita dalam bahasa penulisan yang diminta dan tetap konsisten dengan cerita sebelumnya.
+
+Return pada data "paragraph" hanya berisi paragraf baru saja tanpa pilihan keputusan dan tanpa inputan paragraph cerita sampai saat ini, juga tanpa seperti '\n' dan sejenisnya. Jika diperlukan berikan paragraf tersebut dalam tag HTML.
+
+Keputusan baru diberikan pada data "choices" dengan format array ["keputusan 1", "keputusan -n"] tanpa "a. KEPUTUSAN" atau "1. KEPUTUSAN".`
+
+// storiesParagraphParts split the story data and the paragraph so far into prompt parts, one part for each paragraph.
+// the last paragraph is the cache breakpoint, so the next turn reuse the cached prefix until its previous last paragraph
+func storiesParagraphParts(inputUser *models.StoriesCreateParagraphContinueInput) []llm.Part {
+	parts := []llm.Part{
+		{
+			Text: fmt.Sprintf(`Judul: '%s'
+Deskripsi: '%s'
+Tema: '%s'
+Bahasa penulisan: '%s'
+
+Paragraph sampai saat ini:`, inputUser.Title, inputUser.Description, inputUser.Theme, inputUser.Language),
+		},
+	}
+
+	// the client join the paragraph with <br><br>
+	for _, paragraph := range strings.Split(inputUser.Paragraph, "<br><br>") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			parts = append(parts, llm.Part{Text: paragraph})
+		}
+	}
+
+	parts[len(parts)-1].Cache = true
+
+	return parts
+}
+
 type StoriesController struct {
-	claude   claude.ClaudeAPI
-	openai   openai.OpenAI
-	userRepo sso_user.UserRepo
+	db          *sql.DB
+	llm         *llm.Registry
+	openai      openai.OpenAI
+	userRepo    sso_user.UserRepo
+	usageRepo   llmusage.LLMUsageRepo
+	pricing     *pricing.PriceTable
+	moderator   *moderation.Moderator
+	generations *GenerationController
 }
 
-func NewStoriesController(claude claude.ClaudeAPI, openai openai.OpenAI, userRepo sso_user.UserRepo) *StoriesController {
+func NewStoriesController(db *sql.DB, llm *llm.Registry, openai openai.OpenAI, userRepo sso_user.UserRepo, usageRepo llmusage.LLMUsageRepo, pricing *pricing.PriceTable, moderator *moderation.Moderator, generations *GenerationController) *StoriesController {
 	return &StoriesController{
-		claude:   claude,
-		openai:   openai,
-		userRepo: userRepo,
+		db:          db,
+		llm:         llm,
+		openai:      openai,
+		userRepo:    userRepo,
+		usageRepo:   usageRepo,
+		pricing:     pricing,
+		moderator:   moderator,
+		generations: generations,
 	}
 }
 
+// storiesParagraphText return the generated paragraph and choices text for the moderation
+func storiesParagraphText(paragraph models.StoriesCreateParagraph) []string {
+	return append([]string{paragraph.Paragraph}, paragraph.Choices...)
+}
+
 func (h *StoriesController) ViewStories(c *fiber.Ctx) error {
 	return c.Render("stories", fiber.Map{
 		"Title": "Create Your Own Stories",
@@ -38,22 +114,31 @@ func (h *StoriesController) ViewStories(c *fiber.Ctx) error {
 }
 
 func (h *StoriesController) CreateStoriesTitle(c *fiber.Ctx) error {
-	// for now, just subtract user credit token from when the request is made whisch is when the user create the stories
+	// the title charged based on its token usage, next paragraph is charged separately
 
 	user_session := c.Locals("user").(sso_models.UserSession)
 
-	var parsedResponse models.StoriesCreateTitleFormat
-	var jsonResp string
-
-	// get model query to determine which model to use
-	type_llm := c.Query("model")
-
 	inputUser := new(models.StoriesCreateInput)
 	if err := c.BodyParser(inputUser); err != nil {
 		return utils.ErrorResponse(c, fiber.StatusBadRequest, err.Error())
 	}
 
-	tx, err := database.DB.Begin()
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user_session.Id, utils.FEATURE_STORY_GENERATOR)
+
+	return h.createStoriesTitle(c, inputUser, usage, nil)
+}
+
+// createStoriesTitle generate the title choices from the input and charge all the usage recorded on usage,
+// extra is added to the response data
+func (h *StoriesController) createStoriesTitle(c *fiber.Ctx, inputUser *models.StoriesCreateInput, usage *usageRecorder, extra fiber.Map) error {
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	var parsedResponse models.StoriesCreateTitleFormat
+
+	// get model query to determine which model to use
+	type_llm := c.Query("model")
+
+	tx, err := h.db.BeginTx(c.UserContext(), nil)
 	if err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
@@ -76,311 +161,324 @@ func (h *StoriesController) CreateStoriesTitle(c *fiber.Ctx) error {
 		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
 	}
 
-	// start process and using the FEATURE
+	if err := h.moderator.CheckInput(c.UserContext(), inputUser.Theme, inputUser.Language); err != nil {
+		return llmErrorResponse(c, err)
+	}
 
+	// start process and using the FEATURE
 	prompt := fmt.Sprintf(`Berdasarkan tema ['%s'], hasilkan 4 judul cerita pendek yang menarik dan dalam bahasa ['%s'] juga cerita terkait cerita yang ada di ['%s']. Berikan deskripsi sederhana dengan 1-2 kalimat.
 	
 	Berikan format judul dengan "NAMA JUDUL" tanpa "a. NAMA JUDUL" atau "1. NAMA JUDUL"
 
 	`, inputUser.Theme, inputUser.Language, inputUser.Language)
 
-	if type_llm == "claude" {
-		// if using claude add json response format in prompt
-		prompt += `
-			Berikan jawaban dalam struktur response API JSON penuh dan berikan jawaban hanya struktur JSON saja dengan struktur
-
-			{"titles": [{"title", "description"}]}
-		`
-
-		prompt_input := []claude.ClaudeMessageReq{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		}
-
-		claudeRes, err := h.claude.ClaudeGetFirstContentDataResp(&prompt_input, 10*512, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-		}
-
-		jsonResp = claudeRes.Text
-
-	} else {
-		prompt_gpt := []openai.OAMessageReq{
+	provider, err := h.llm.Select(type_llm)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+	llmReq := llm.Request{
+		Messages: []llm.Message{
 			{
 				Role:    "user",
 				Content: prompt,
 			},
-		}
-
-		// response format for openai
-		format_response := openai.OACreateResponseFormat(
-			"titles_choices",
-			map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"titles": map[string]interface{}{
-						"type": "array",
-						"items": map[string]interface{}{
-							"type": "object",
-							"properties": map[string]interface{}{
-								"title": map[string]string{
-									"type": "string",
-								},
-								"description": map[string]string{
-									"type": "string",
-								},
-							},
-						},
-					},
-				},
-			},
-		)
+		},
+		MaxTokens: 10 * 512,
+		Schema:    storiesTitleSchema,
+	}
 
-		openairesp, err := h.openai.OpenAIGetFirstContentDataResp(&prompt_gpt, true, &format_response, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, "openai: "+err.Error())
-		}
+	estimate, err := guardPrompt(c.UserContext(), provider, &llmReq, h.pricing, usage.creditLeft(user.CreditToken), nil)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		jsonResp = openairesp.Content
+	// decode response from llm, the invalid answer is asked again
+	if _, err := generateStructured(c.UserContext(), provider, llmReq, usage, &parsedResponse); err != nil {
+		return llmErrorResponse(c, err)
 	}
 
-	// decode response from openai
-	if err := json.NewDecoder(strings.NewReader(jsonResp)).Decode(&parsedResponse); err != nil {
-		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	titlesText := make([]string, 0, len(parsedResponse.Titles)*2)
+	for _, title := range parsedResponse.Titles {
+		titlesText = append(titlesText, title.Title, title.Description)
+	}
+	if err := h.moderator.CheckOutput(c.UserContext(), titlesText...); err != nil {
+		return llmErrorResponse(c, err)
 	}
 
 	// update user credit token for success request
-	if err := sso_utils.UpdateUserCredit(tx, h.userRepo, user, utils.FEATURE_STORY_GENERATOR_COST); err != nil {
+	if err := sso_utils.UpdateUserCredit(tx, h.userRepo, user, usage.credits()); err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
 
-	return utils.ResponseWithData(c, fiber.StatusOK, "create stories title", fiber.Map{
-		"titles": parsedResponse.Titles,
-	})
+	return utils.ResponseWithData(c, fiber.StatusOK, "create stories title", withExtraData(fiber.Map{
+		"titles":    parsedResponse.Titles,
+		"estimate":  estimate,
+		"served_by": usage.servedBy(),
+	}, extra))
 }
 
+// CreateFirstStoriesPart generate the opening paragraph, with "narrate" query the paragraph is also narrated in the same request
 func (h *StoriesController) CreateFirstStoriesPart(c *fiber.Ctx) error {
 
-	var parsedResponse models.StoriesCreateParagraph
-	var jsonResp string
-
 	type_llm := c.Query("model")
+	narrate := c.QueryBool("narrate")
 
 	inputUser := new(models.StoriesCreateFirstPartInput)
 	if err := c.BodyParser(inputUser); err != nil {
 		return utils.ErrorResponse(c, fiber.StatusBadRequest, err.Error())
 	}
 
-	prompt := fmt.Sprintf(`Berdasarkan judul yang dipilih ['%s'] dengan tema ['%s'] dan deskripsi ['%s'], hasilkan awal cerita pendek yang menarik dalam bahasa ['%s'] berikan dalam 3-4 kalimat diakhiri dengan keadaan yang membutuhkan keputusan.
-
-	Return pada paragraf hanya berisi paragraf baru saja tanpa pilihan keputusan baru yang akan digunakan dan juga tanpa seperti '\n' dan sejenisnya. Jika diperlukan berikan input tersebut dalam tag HTML
-
-	Kemudian berikan 4 pilihan keputusan yang bisa diambil oleh karakter utama untuk dapat melanjutkan cerita.
-
-	Berikan format keputusan dalam array dengan ["keputusan 1", "keputusan -n"] tanpa "a. KEPUTUSAN" atau "1. KEPUTUSAN"
-
-	`, inputUser.Title, inputUser.Theme, inputUser.Description, inputUser.Language)
-
-	if type_llm == "claude" {
-		prompt += `
-		berikan format jawaban hanya struktur JSON saja dengan struktur diberikan
-
-		{ "paragraph", "choices" : ["choice"]}
-		`
+	user_session := c.Locals("user").(sso_models.UserSession)
 
-		prompt_input := []claude.ClaudeMessageReq{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		}
+	user, err := findUser(c.UserContext(), h.db, h.userRepo, user_session.Id)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
 
-		claudeRes, err := h.claude.ClaudeGetFirstContentDataResp(&prompt_input, 512*10, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-		}
+	if user.Id == 0 {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "user not found")
+	}
 
-		jsonResp = claudeRes.Text
-	} else {
+	if user.CreditToken < utils.FEATURE_STORY_PARAGRAPH_COST {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
+	}
 
-		prompt_input := []openai.OAMessageReq{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
-		}
+	if err := h.moderator.CheckInput(c.UserContext(), inputUser.Title, inputUser.Description, inputUser.Theme, inputUser.Language); err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		response_format := openai.OACreateResponseFormat(
-			"paragraph_choices",
-			map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"paragraph": map[string]string{
-						"type": "string",
-					},
-					"choices": map[string]interface{}{
-						"type": "array",
-						"items": map[string]string{
-							"type": "string",
-						},
-					},
-				},
-			},
-		)
+	prompt := storiesFirstPartPrompt(inputUser)
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user_session.Id, utils.FEATURE_STORY_GENERATOR)
 
-		gptRes, err := h.openai.OpenAIGetFirstContentDataResp(&prompt_input, true, &response_format, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-		}
+	parsedResponse, narration, estimate, err := h.generateStoriesParagraph(c.UserContext(), type_llm, nil, []llm.Part{{Text: prompt}}, narrate, true, user.CreditToken, usage)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		jsonResp = gptRes.Content
+	if err := h.moderator.CheckOutput(c.UserContext(), storiesParagraphText(*parsedResponse)...); err != nil {
+		return llmErrorResponse(c, err)
 	}
 
-	if err := json.NewDecoder(strings.NewReader(jsonResp)).Decode(&parsedResponse); err != nil {
+	// every paragraph charged based on its token usage, so longer story cost more
+	if err := chargeUserCredit(h.db, h.userRepo, user_session.Id, usage.credits()); err != nil {
 		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
 
-	return utils.ResponseWithData(c, fiber.StatusOK, "create stories first part", fiber.Map{
+	h.generations.save(user_session.Id, utils.FEATURE_STORY_GENERATOR, inputUser.Title, parsedResponse.Paragraph)
+
+	return utils.ResponseWithData(c, fiber.StatusOK, "create stories first part", withNarration(fiber.Map{
 		"paragraph": parsedResponse.Paragraph,
 		"choices":   parsedResponse.Choices,
-	})
+		"estimate":  estimate,
+		"served_by": usage.servedBy(),
+	}, narration))
 }
 
 func (h *StoriesController) CreateStoriesParagraph(c *fiber.Ctx) error {
 
-	var parsedResponse models.StoriesCreateParagraph
-	var prompt, jsonResp string
+	inputUser := new(models.StoriesCreateParagraphContinueInput)
+	if err := c.BodyParser(inputUser); err != nil {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	user_session := c.Locals("user").(sso_models.UserSession)
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user_session.Id, utils.FEATURE_STORY_GENERATOR)
+
+	return h.createStoriesParagraph(c, c.Params("data"), inputUser, usage, nil)
+}
+
+// createStoriesParagraph generate the next ("next") or the last (other value) paragraph from the chosen choice
+// and charge all the usage recorded on usage, extra is added to the response data.
+// with "narrate" query the paragraph is also narrated in the same request
+func (h *StoriesController) createStoriesParagraph(c *fiber.Ctx, data string, inputUser *models.StoriesCreateParagraphContinueInput, usage *usageRecorder, extra fiber.Map) error {
+
+	var prompt string
 
 	type_llm := c.Query("model")
+	narrate := c.QueryBool("narrate")
 
-	data := c.Params("data")
 	if data != "next" {
 		data = "end"
 	}
 
-	inputUser := new(models.StoriesCreateParagraphContinueInput)
-	if err := c.BodyParser(inputUser); err != nil {
-		return utils.ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	user, err := findUser(c.UserContext(), h.db, h.userRepo, user_session.Id)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
 	}
 
-	if data == "next" {
-		prompt = fmt.Sprintf(`Berdasarkan cerita pendek bersambung yang sedang dibuat dengan data sebelumnya yang sudah didapat. Lanjutkan cerita berikut dengan mempertimbangkan pilihan yang diambil. 
+	if user.Id == 0 {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "user not found")
+	}
 
-		Judul: '%s'
-		Deskripsi: '%s'
-		Tema:'%s'
-		Bahasa penulisan: '%s'
-		Paragraph sampai saat ini:
-		'%s'
+	if user.CreditToken < utils.FEATURE_STORY_PARAGRAPH_COST {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
+	}
 
-		Pilihan yang diambil:'%s'
+	// the paragraph so far is sent back by the client, so it is checked too
+	if err := h.moderator.CheckInput(c.UserContext(), inputUser.Title, inputUser.Description, inputUser.Theme, inputUser.Language, inputUser.Paragraph, inputUser.Choice); err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		Buatlah paragraf lanjutan (3-4 kalimat) yang menggambarkan konsekuensi dari pilihan tersebut diakhiri dengan situasi baru yang membutuhkan keputusan.
-		
-		Kemudian berikan 4 pilihan keputusan baru yang dapat diambil oleh karakter utama.
+	// only the choice and the instruction after it is new on each turn, the part before it is cached
+	if data == "next" {
+		prompt = fmt.Sprintf(`Pilihan yang diambil: '%s'
 
-		Berikan format keputusan dalam array dengan ["keputusan 1", "keputusan -n"] tanpa "a. KEPUTUSAN" atau "1. KEPUTUSAN"
+		Lanjutkan cerita di atas dengan mempertimbangkan pilihan yang diambil. Buatlah paragraf lanjutan (3-4 kalimat) yang menggambarkan konsekuensi dari pilihan tersebut diakhiri dengan situasi baru yang membutuhkan keputusan.
 
-		Return pada data "paragraf" hanya berisi paragraf baru saja tanpa pilihan keputusan baru yang akan digunakan, keputusan baru diberikan pada data "choices".
+		Kemudian berikan 4 pilihan keputusan baru yang dapat diambil oleh karakter utama.
 
-		`, inputUser.Title, inputUser.Description, inputUser.Theme, inputUser.Language, inputUser.Paragraph, inputUser.Choice)
+		`, inputUser.Choice)
 	} else {
-		prompt = fmt.Sprintf(`Berdasarkan cerita pendek bersambung yang sedang dibuat dengan data sebelumnya yang sudah didapat. Ini merupakan bagian akhir cerita. Berdasarkan seluruh cerita dan pilihan terakhir yang diambil, buatlah paragraf penutup yang memberikan kesimpulan yang memuaskan.
-
-		Judul: '%s'
-		Deskripsi: '%s'
-		Tema:'%s'
-		Bahasa penulisan: '%s'
-		Paragraph sampai saat ini:
-		'%s'
+		prompt = fmt.Sprintf(`Pilihan yang diambil: '%s'
 
-		Pilihan yang diambil:'%s'
+		Ini merupakan bagian akhir cerita. Berdasarkan seluruh cerita dan pilihan terakhir yang diambil, buatlah paragraf penutup yang memberikan kesimpulan yang memuaskan.
 
 		Buatlah paragraf akhir(3-4 kalimat per paragraf) menggambarkan konsekuensi dari pilihan yang dipilih. Jika merasa hasil kurang baik untuk penutup yang memuaskan bisa tambahkan lebih dari satu (1) paragraf.
 
 		Jika lebih dari 1 paragraf, jeda paragraf tandai dengan <br> tag
 
-		Return pada paragraf hanya berisi paragraf baru saja tanpa pilihan keputusan baru yang akan digunakan.
+		Tetap berikan jawaban "choices" namun berikan dengan nilai list kosong []
 
-		`, inputUser.Title, inputUser.Description, inputUser.Theme, inputUser.Language, inputUser.Paragraph, inputUser.Choice)
+		`, inputUser.Choice)
 	}
 
-	if type_llm == "claude" {
-		if data == "next" {
-			prompt += `
-			Berikan format jawaban hanya struktur JSON saja dengan struktur seperti di bawah dan pada paragraph hanya berisi ceritan penutup.
+	system := []llm.Part{{Text: storiesParagraphSystem, Cache: true}}
+	parts := append(storiesParagraphParts(inputUser), llm.Part{Text: prompt})
 
-			Berikan format jawaban hanya struktur JSON saja dengan struktur seperti di bawah dan pada paragraph hanya berisi ceritan lanjutan barunya tanpa inputan paragraph yang diberikan di atas.
+	parsedResponse, narration, estimate, err := h.generateStoriesParagraph(c.UserContext(), type_llm, system, parts, narrate, data == "next", user.CreditToken, usage)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-			{"paragraph", "choices" : ["choice"]}
-			`
+	if err := h.moderator.CheckOutput(c.UserContext(), storiesParagraphText(*parsedResponse)...); err != nil {
+		return llmErrorResponse(c, err)
+	}
 
-		} else {
-			prompt += `
-			Berikan format jawaban hanya struktur JSON saja dengan struktur seperti di bawah dan pada paragraph hanya berisi ceritan penutup.
+	// every paragraph charged based on its token usage, so longer story cost more
+	if err := chargeUserCredit(h.db, h.userRepo, user_session.Id, usage.credits()); err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
 
-			Tetap berikan jawaban "choices" namun berikan dengan nilai list kosong []
+	h.generations.save(user_session.Id, utils.FEATURE_STORY_GENERATOR, inputUser.Title, parsedResponse.Paragraph)
 
-			{"paragraph", "choices" : []}
-			`
-		}
+	return utils.ResponseWithData(c, fiber.StatusOK, "create stories paragraph", withExtraData(withNarration(fiber.Map{
+		"paragraph": parsedResponse.Paragraph,
+		"choices":   parsedResponse.Choices,
+		"estimate":  estimate,
+		"served_by": usage.servedBy(),
+	}, narration), extra))
+}
 
-		prompt_input := []claude.ClaudeMessageReq{
+// CreateFirstStoriesPartStream same as CreateFirstStoriesPart but send the generated token using server-sent events.
+//
+// events sent: "delta" ({"text"}) with the raw JSON token, and "done" ({"paragraph", "choices", "served_by"}) or "error" ({"message"}).
+// the credit only reduced after the stream finished successfully
+func (h *StoriesController) CreateFirstStoriesPartStream(c *fiber.Ctx) error {
+
+	type_llm := c.Query("model")
+
+	inputUser := new(models.StoriesCreateFirstPartInput)
+	if err := c.BodyParser(inputUser); err != nil {
+		return utils.ErrorResponse(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	user_session := c.Locals("user").(sso_models.UserSession)
+
+	user, err := findUser(c.UserContext(), h.db, h.userRepo, user_session.Id)
+	if err != nil {
+		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if user.Id == 0 {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "user not found")
+	}
+
+	if user.CreditToken < utils.FEATURE_STORY_PARAGRAPH_COST {
+		return utils.ErrorResponse(c, fiber.StatusUnauthorized, "Not enough credit token to use this feature")
+	}
+
+	if err := h.moderator.CheckInput(c.UserContext(), inputUser.Title, inputUser.Description, inputUser.Theme, inputUser.Language); err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	usage := newUsageRecorder(h.db, h.usageRepo, h.pricing, user_session.Id, utils.FEATURE_STORY_GENERATOR)
+	userId := user_session.Id
+	provider, err := h.llm.Select(type_llm)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+	llmReq := llm.Request{
+		Messages: []llm.Message{
 			{
 				Role:    "user",
-				Content: prompt,
+				Content: storiesFirstPartPrompt(inputUser),
 			},
+		},
+		MaxTokens: 512 * 10,
+		Schema:    storiesParagraphSchema,
+	}
+
+	// checked before the stream started, so the refused prompt get normal error response
+	estimate, err := guardPrompt(c.UserContext(), provider, &llmReq, h.pricing, usage.creditLeft(user.CreditToken), nil)
+	if err != nil {
+		return llmErrorResponse(c, err)
+	}
+
+	// fiber ctx can't be used inside the stream writer because the handler already returned
+	deadline := streamDeadline(c, utils.FEATURE_STORY_GENERATOR_TIMEOUT)
+	utils.SetSSEHeaders(c)
+	c.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		// the request context is already done here, the stream context keep its deadline and canceled when the client left
+		stream := newSSEStream(w, deadline)
+		defer stream.close()
+
+		var parsedResponse models.StoriesCreateParagraph
+
+		if err := stream.send("estimate", estimate); err != nil {
+			return
 		}
 
-		claudeRes, err := h.claude.ClaudeGetFirstContentDataResp(&prompt_input, 512*10, false, nil)
+		// the generated text is checked per chunk before sent, the blocked chunk stop the generation and not charged
+		output := h.moderator.Stream(stream.ctx, func(text string) error {
+			return stream.send("delta", fiber.Map{
+				"text": text,
+			})
+		})
+
+		start := time.Now()
+		llmResp, err := provider.GenerateStream(stream.ctx, llmReq, output.Write)
+		usage.recordLLM(provider.Name(), llmResp, start, err)
+		if err == nil {
+			err = output.Flush()
+		}
 		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+			_, message := llmErrorStatus(err)
+			stream.sendError(message)
+			return
 		}
 
-		jsonResp = claudeRes.Text
-	} else {
-
-		prompt_input := []openai.OAMessageReq{
-			{
-				Role:    "user",
-				Content: prompt,
-			},
+		// the streamed answer can't be asked again, only repaired
+		if _, err := llm.ParseStructured(llmResp.Content, llmReq.Schema, &parsedResponse); err != nil {
+			_, message := llmErrorStatus(err)
+			stream.sendError(message)
+			return
 		}
 
-		response_format := openai.OACreateResponseFormat(
-			"paragraph_choices",
-			map[string]interface{}{
-				"type": "object",
-				"properties": map[string]interface{}{
-					"paragraph": map[string]string{
-						"type": "string",
-					},
-					"choices": map[string]interface{}{
-						"type": "array",
-						"items": map[string]string{
-							"type": "string",
-						},
-					},
-				},
-			},
-		)
-
-		gptRes, err := h.openai.OpenAIGetFirstContentDataResp(&prompt_input, true, &response_format, false, nil)
-		if err != nil {
-			return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
+		if err := chargeUserCredit(h.db, h.userRepo, userId, usage.credits()); err != nil {
+			stream.sendError(err.Error())
+			return
 		}
 
-		jsonResp = gptRes.Content
-	}
+		h.generations.save(userId, utils.FEATURE_STORY_GENERATOR, inputUser.Title, parsedResponse.Paragraph)
 
-	if err := json.NewDecoder(strings.NewReader(jsonResp)).Decode(&parsedResponse); err != nil {
-		return utils.ErrorResponse(c, fiber.StatusInternalServerError, err.Error())
-	}
+		stream.send("done", fiber.Map{
+			"paragraph": parsedResponse.Paragraph,
+			"choices":   parsedResponse.Choices,
+			"served_by": usage.servedBy(),
+		})
+	}))
 
-	return utils.ResponseWithData(c, fiber.StatusOK, "create stories paragraph", fiber.Map{
-		"paragraph": parsedResponse.Paragraph,
-		"choices":   parsedResponse.Choices,
-	})
+	return nil
 }
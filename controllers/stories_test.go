@@ -0,0 +1,469 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"scrapper-test/models"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils"
+	"scrapper-test/utils/llm/llmtest"
+	"scrapper-test/utils/pricing"
+	"strings"
+	"testing"
+
+	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// newTestStoriesController create stories controller on the test env
+func newTestStoriesController(t *testing.T, creditToken int) (*StoriesController, *testEnv) {
+	t.Helper()
+
+	env := newTestEnv(t, creditToken)
+
+	return NewStoriesController(env.db, env.registry, env.openai, *sso_user.NewUserRepo(), *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), env.moderator, env.generations), env
+}
+
+func newStoriesRequest(t *testing.T, path string, input interface{}) *http.Request {
+	t.Helper()
+
+	body, err := json.Marshal(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(string(body)))
+	req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+	return req
+}
+
+func TestCreateStoriesTitle(t *testing.T) {
+	titles := models.StoriesCreateTitleFormat{Titles: []models.StoriesCreateTitle{
+		{Title: "Rumah di Ujung Jalan", Description: "Rumah tua yang selalu menyala tengah malam."},
+		{Title: "Kereta Terakhir", Description: "Penumpang yang tidak pernah turun."},
+	}}
+
+	tests := []struct {
+		name         string
+		creditToken  int
+		theme        string
+		replies      []llmtest.Reply
+		wantStatus   int
+		wantRequests int
+	}{
+		{
+			name:         "titles created",
+			creditToken:  100,
+			theme:        "horor",
+			replies:      []llmtest.Reply{llmtest.JSON(titles).WithUsage(800, 400)},
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 1,
+		},
+		{
+			name:         "invalid answer re-asked and both charged",
+			creditToken:  100,
+			theme:        "horor",
+			replies:      []llmtest.Reply{llmtest.JSON(`{"titles": [{"title": "Rumah di Ujung Jalan"}]}`).WithUsage(800, 400), llmtest.JSON(titles).WithUsage(900, 400)},
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 2,
+		},
+		{
+			name:         "blocked input not sent",
+			creditToken:  100,
+			theme:        "BAD",
+			wantStatus:   fiber.StatusUnprocessableEntity,
+			wantRequests: 0,
+		},
+		{
+			name:         "not enough credit",
+			creditToken:  utils.FEATURE_STORY_GENERATOR_COST - 1,
+			theme:        "horor",
+			wantStatus:   fiber.StatusUnauthorized,
+			wantRequests: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestStoriesController(t, tt.creditToken)
+			env.srv.Enqueue(llmtest.EndpointClaudeMessages, tt.replies...)
+
+			app := newTestApp(fiber.MethodPost, "/stories/title", tt.creditToken, controller.CreateStoriesTitle)
+			status, resp := sendTestJSONRequest(t, app, newStoriesRequest(t, "/stories/title", models.StoriesCreateInput{Theme: tt.theme, Language: "indonesia"}))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			if got := len(env.srv.RequestsTo(llmtest.EndpointClaudeMessages)); got != tt.wantRequests {
+				t.Fatalf("message requests = %d, want %d", got, tt.wantRequests)
+			}
+			if got := len(env.fake.llmUsages()); got != tt.wantRequests {
+				t.Errorf("recorded usages = %d, want %d", got, tt.wantRequests)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != tt.creditToken {
+					t.Errorf("credit token = %d, want %d not charged", got, tt.creditToken)
+				}
+				return
+			}
+
+			var data struct {
+				Titles   []models.StoriesCreateTitle `json:"titles"`
+				ServedBy string                      `json:"served_by"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if len(data.Titles) != len(titles.Titles) || data.Titles[0] != titles.Titles[0] || data.ServedBy != "claude" {
+				t.Errorf("data = %+v", data)
+			}
+
+			// the invalid answer is also charged because its tokens is used
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != tt.creditToken-charged {
+				t.Errorf("credit token = %d, want %d charged from %d", got, charged, tt.creditToken)
+			}
+		})
+	}
+}
+
+func TestCreateFirstStoriesPart(t *testing.T) {
+	paragraph := models.StoriesCreateParagraph{
+		Paragraph: "Lampu rumah itu menyala lagi tepat tengah malam.",
+		Choices:   []string{"Mendekati rumah", "Pulang dan melupakannya"},
+	}
+	input := models.StoriesCreateFirstPartInput{
+		StoriesCreateInput: models.StoriesCreateInput{Theme: "horor", Language: "indonesia"},
+		Title:              "Rumah di Ujung Jalan",
+		Description:        "Rumah tua yang selalu menyala tengah malam.",
+	}
+
+	tests := []struct {
+		name         string
+		creditToken  int
+		reply        llmtest.Reply
+		wantStatus   int
+		wantRequests int
+	}{
+		{
+			name:         "first part created",
+			creditToken:  100,
+			reply:        llmtest.JSON(paragraph).WithUsage(1200, 500),
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 1,
+		},
+		{
+			name:         "blocked paragraph not charged",
+			creditToken:  100,
+			reply:        llmtest.JSON(models.StoriesCreateParagraph{Paragraph: "BAD paragraph", Choices: paragraph.Choices}),
+			wantStatus:   fiber.StatusUnprocessableEntity,
+			wantRequests: 1,
+		},
+		{
+			name:         "provider error not charged",
+			creditToken:  100,
+			reply:        llmtest.Overloaded(),
+			wantStatus:   fiber.StatusServiceUnavailable,
+			wantRequests: 1,
+		},
+		{
+			name:        "not enough credit on session",
+			creditToken: 0,
+			wantStatus:  fiber.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestStoriesController(t, tt.creditToken)
+			if tt.wantRequests > 0 {
+				env.srv.Enqueue(llmtest.EndpointClaudeMessages, tt.reply)
+			}
+
+			app := newTestApp(fiber.MethodPost, "/stories/first", tt.creditToken, controller.CreateFirstStoriesPart)
+			status, resp := sendTestJSONRequest(t, app, newStoriesRequest(t, "/stories/first", input))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			requests := env.srv.RequestsTo(llmtest.EndpointClaudeMessages)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("message requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != tt.creditToken {
+					t.Errorf("credit token = %d, want %d not charged", got, tt.creditToken)
+				}
+				return
+			}
+
+			if body := string(requests[0].Body); !strings.Contains(body, input.Title) {
+				t.Errorf("prompt not contain the title: %s", body)
+			}
+
+			var data struct {
+				Paragraph string   `json:"paragraph"`
+				Choices   []string `json:"choices"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Paragraph != paragraph.Paragraph || len(data.Choices) != len(paragraph.Choices) {
+				t.Errorf("data = %+v", data)
+			}
+
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != tt.creditToken-charged {
+				t.Errorf("credit token = %d, want %d charged from %d", got, charged, tt.creditToken)
+			}
+
+			generations := env.fake.waitGenerations(t, 1)
+			if generations[0].Feature != utils.FEATURE_STORY_GENERATOR || generations[0].Title != input.Title || generations[0].Content != paragraph.Paragraph {
+				t.Errorf("saved generation = %+v", generations[0])
+			}
+		})
+	}
+}
+
+func TestCreateStoriesParagraph(t *testing.T) {
+	next := models.StoriesCreateParagraph{
+		Paragraph: "Pintu rumah terbuka sendiri saat kamu mendekat.",
+		Choices:   []string{"Masuk ke dalam", "Memanggil pemilik rumah", "Mengintip dari jendela", "Lari pulang"},
+	}
+	end := models.StoriesCreateParagraph{
+		Paragraph: "Sejak malam itu lampu rumah tidak pernah menyala lagi.",
+		Choices:   []string{},
+	}
+	input := models.StoriesCreateParagraphContinueInput{
+		StoriesCreateFirstPartInput: models.StoriesCreateFirstPartInput{
+			StoriesCreateInput: models.StoriesCreateInput{Theme: "horor", Language: "indonesia"},
+			Title:              "Rumah di Ujung Jalan",
+			Description:        "Rumah tua yang selalu menyala tengah malam.",
+		},
+		Paragraph: "Lampu rumah itu menyala lagi tepat tengah malam.",
+		Choice:    "Mendekati rumah",
+	}
+
+	tests := []struct {
+		name         string
+		creditToken  int
+		data         string
+		reply        llmtest.Reply
+		wantStatus   int
+		wantRequests int
+		wantPrompt   string // instruction of the data sent after the choice
+		want         models.StoriesCreateParagraph
+	}{
+		{
+			name:         "next paragraph created",
+			creditToken:  100,
+			data:         "next",
+			reply:        llmtest.JSON(next).WithUsage(1500, 500),
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 1,
+			wantPrompt:   "Lanjutkan cerita di atas",
+			want:         next,
+		},
+		{
+			name:         "end paragraph created",
+			creditToken:  100,
+			data:         "end",
+			reply:        llmtest.JSON(end).WithUsage(1500, 500),
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 1,
+			wantPrompt:   "bagian akhir cerita",
+			want:         end,
+		},
+		{
+			name:         "unknown data end the story",
+			creditToken:  100,
+			data:         "lanjut",
+			reply:        llmtest.JSON(end),
+			wantStatus:   fiber.StatusOK,
+			wantRequests: 1,
+			wantPrompt:   "bagian akhir cerita",
+			want:         end,
+		},
+		{
+			name:         "blocked paragraph not charged",
+			creditToken:  100,
+			data:         "next",
+			reply:        llmtest.JSON(models.StoriesCreateParagraph{Paragraph: "BAD paragraph", Choices: next.Choices}),
+			wantStatus:   fiber.StatusUnprocessableEntity,
+			wantRequests: 1,
+		},
+		{
+			name:         "provider error not charged",
+			creditToken:  100,
+			data:         "end",
+			reply:        llmtest.Overloaded(),
+			wantStatus:   fiber.StatusServiceUnavailable,
+			wantRequests: 1,
+		},
+		{
+			name:        "not enough credit",
+			creditToken: utils.FEATURE_STORY_PARAGRAPH_COST - 1,
+			data:        "next",
+			wantStatus:  fiber.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestStoriesController(t, tt.creditToken)
+			if tt.wantRequests > 0 {
+				env.srv.Enqueue(llmtest.EndpointClaudeMessages, tt.reply)
+			}
+
+			app := newTestApp(fiber.MethodPost, "/stories/:data", tt.creditToken, controller.CreateStoriesParagraph)
+			status, resp := sendTestJSONRequest(t, app, newStoriesRequest(t, "/stories/"+tt.data, input))
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (%s)", status, tt.wantStatus, resp.Message)
+			}
+
+			requests := env.srv.RequestsTo(llmtest.EndpointClaudeMessages)
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("message requests = %d, want %d", len(requests), tt.wantRequests)
+			}
+
+			if status != fiber.StatusOK {
+				if got := env.fake.creditToken(testUserId); got != tt.creditToken {
+					t.Errorf("credit token = %d, want %d not charged", got, tt.creditToken)
+				}
+				return
+			}
+
+			// the story so far and the choice is sent with the instruction of the data
+			body := string(requests[0].Body)
+			if !strings.Contains(body, input.Paragraph) || !strings.Contains(body, input.Choice) || !strings.Contains(body, tt.wantPrompt) {
+				t.Errorf("prompt not contain the story, the choice, and %q: %s", tt.wantPrompt, body)
+			}
+
+			var data struct {
+				Paragraph string          `json:"paragraph"`
+				Choices   []string        `json:"choices"`
+				Estimate  json.RawMessage `json:"estimate"`
+				Narration json.RawMessage `json:"narration"`
+			}
+			if err := json.Unmarshal(resp.Data, &data); err != nil {
+				t.Fatal(err)
+			}
+			if data.Paragraph != tt.want.Paragraph || strings.Join(data.Choices, ",") != strings.Join(tt.want.Choices, ",") || data.Estimate == nil || data.Narration != nil {
+				t.Errorf("data = %+v", data)
+			}
+
+			charged := env.chargedCredits()
+			if got := env.fake.creditToken(testUserId); charged <= 0 || got != tt.creditToken-charged {
+				t.Errorf("credit token = %d, want %d charged from %d", got, charged, tt.creditToken)
+			}
+
+			generations := env.fake.waitGenerations(t, 1)
+			if generations[0].Feature != utils.FEATURE_STORY_GENERATOR || generations[0].Title != input.Title || generations[0].Content != tt.want.Paragraph {
+				t.Errorf("saved generation = %+v", generations[0])
+			}
+		})
+	}
+}
+
+func TestCreateFirstStoriesPartStream(t *testing.T) {
+	paragraph := models.StoriesCreateParagraph{
+		Paragraph: "Lampu rumah itu menyala lagi tepat tengah malam.",
+		Choices:   []string{"Mendekati rumah", "Pulang dan melupakannya"},
+	}
+	input := models.StoriesCreateFirstPartInput{
+		StoriesCreateInput: models.StoriesCreateInput{Theme: "horor", Language: "indonesia"},
+		Title:              "Rumah di Ujung Jalan",
+		Description:        "Rumah tua yang selalu menyala tengah malam.",
+	}
+
+	tests := []struct {
+		name        string
+		reply       llmtest.Reply
+		wantEvents  []string
+		wantCharged bool
+	}{
+		{
+			name:        "streamed",
+			reply:       llmtest.JSON(paragraph).WithUsage(1200, 500),
+			wantEvents:  []string{"estimate", "delta", "done"},
+			wantCharged: true,
+		},
+		{
+			name:       "blocked chunk stop the stream",
+			reply:      llmtest.JSON(models.StoriesCreateParagraph{Paragraph: "BAD paragraph", Choices: paragraph.Choices}),
+			wantEvents: []string{"estimate", "error"},
+		},
+		{
+			name:       "invalid answer not charged",
+			reply:      llmtest.JSON(`{"choices": []}`),
+			wantEvents: []string{"estimate", "delta", "error"},
+		},
+		{
+			// the chunk waiting for the moderation is not sent
+			name:       "stream error",
+			reply:      llmtest.StreamError(`{"paragraph": "Lampu rumah itu`, "overloaded_error", "Overloaded"),
+			wantEvents: []string{"estimate", "error"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			controller, env := newTestStoriesController(t, 100)
+			env.srv.Enqueue(llmtest.EndpointClaudeMessages, tt.reply)
+
+			app := newTestApp(fiber.MethodPost, "/stories/first/stream", 100, controller.CreateFirstStoriesPartStream)
+			status, body := sendTestRequest(t, app, newStoriesRequest(t, "/stories/first/stream", input))
+			if status != fiber.StatusOK {
+				t.Fatalf("status = %d, want 200 (%s)", status, body)
+			}
+
+			// every event is sent in order, the delta may be sent more than once
+			var events []string
+			var done string
+			for _, line := range strings.Split(string(body), "\n") {
+				event, ok := strings.CutPrefix(line, "event: ")
+				if ok && (len(events) == 0 || events[len(events)-1] != event) {
+					events = append(events, event)
+				}
+				if data, ok := strings.CutPrefix(line, "data: "); ok && len(events) > 0 && events[len(events)-1] == "done" {
+					done = data
+				}
+			}
+			if strings.Join(events, ",") != strings.Join(tt.wantEvents, ",") {
+				t.Errorf("events = %v, want %v", events, tt.wantEvents)
+			}
+
+			charged := 0
+			if tt.wantCharged {
+				var data struct {
+					Paragraph string   `json:"paragraph"`
+					Choices   []string `json:"choices"`
+					ServedBy  string   `json:"served_by"`
+				}
+				if err := json.Unmarshal([]byte(done), &data); err != nil {
+					t.Fatalf("done event %q: %v", done, err)
+				}
+				if data.Paragraph != paragraph.Paragraph || len(data.Choices) != len(paragraph.Choices) || data.ServedBy != "claude" {
+					t.Errorf("done = %+v", data)
+				}
+
+				charged = env.chargedCredits()
+				if charged <= 0 {
+					t.Errorf("charged credits = %d, want more than 0", charged)
+				}
+
+				generations := env.fake.waitGenerations(t, 1)
+				if generations[0].Title != input.Title || generations[0].Content != paragraph.Paragraph {
+					t.Errorf("saved generation = %+v", generations[0])
+				}
+			}
+			if got := env.fake.creditToken(testUserId); got != 100-charged {
+				t.Errorf("credit token = %d, want %d", got, 100-charged)
+			}
+		})
+	}
+}
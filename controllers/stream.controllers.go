@@ -0,0 +1,111 @@
+package controllers
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"scrapper-test/database"
+	"scrapper-test/utils"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	sso_models "github.com/momokii/go-sso-web/pkg/models"
+	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
+	sso_utils "github.com/momokii/go-sso-web/pkg/utils"
+)
+
+// chargeUserCredit reduce user credit on its own transaction, used by streaming handler
+// because the credit only charged after the stream finished and the handler transaction is already closed at that time,
+// also used by handler that not open transaction before calling the LLM. The full cost is charged, the request that the user
+// can't pay is refused before the LLM call by guardPrompt
+func chargeUserCredit(db *sql.DB, userRepo sso_user.UserRepo, userId int, cost int) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		database.CommitOrRollback(tx, nil, err)
+	}()
+
+	user, err := userRepo.FindByID(tx, userId)
+	if err != nil {
+		return err
+	}
+
+	if user.Id == 0 {
+		err = errors.New("user not found")
+		return err
+	}
+
+	err = sso_utils.UpdateUserCredit(tx, userRepo, user, cost)
+	return err
+}
+
+// findUser read the user on its own transaction, used by handler that charge with chargeUserCredit so the credit is
+// checked with the same value the charge is made from instead of the session credit that can be stale.
+// The returned user has zero Id when the user not found
+func findUser(ctx context.Context, db *sql.DB, userRepo sso_user.UserRepo, userId int) (user *sso_models.User, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		database.CommitOrRollback(tx, nil, err)
+	}()
+
+	user, err = userRepo.FindByID(tx, userId)
+	return user, err
+}
+
+// sseStream send the server-sent events of the streaming handler. The stream context has the same deadline as the
+// handler request context and is canceled as soon as one write failed, so the LLM call is stopped right after
+// the client closed the connection instead of running until the deadline
+type sseStream struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	w      *bufio.Writer
+}
+
+// streamDeadline return the deadline set by the Deadline middleware, it must be read before the handler returned
+// because the request context is canceled and the fiber ctx is reused after that
+func streamDeadline(c *fiber.Ctx, timeout time.Duration) time.Time {
+	if deadline, ok := c.UserContext().Deadline(); ok {
+		return deadline
+	}
+
+	return time.Now().Add(timeout)
+}
+
+// newSSEStream create the stream on the body stream writer, close must be called when the stream writer returned
+func newSSEStream(w *bufio.Writer, deadline time.Time) *sseStream {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+
+	return &sseStream{
+		ctx:    ctx,
+		cancel: cancel,
+		w:      w,
+	}
+}
+
+// send write one event, the stream context is canceled when the client connection is already closed
+func (s *sseStream) send(event string, data interface{}) error {
+	if err := utils.WriteSSEEvent(s.w, event, data); err != nil {
+		s.cancel()
+		return err
+	}
+
+	return nil
+}
+
+// sendError write the "error" event with the message
+func (s *sseStream) sendError(message string) {
+	s.send("error", fiber.Map{
+		"message": message,
+	})
+}
+
+func (s *sseStream) close() {
+	s.cancel()
+}
@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils/pricing"
+	"strings"
+	"testing"
+
+	sso_user "github.com/momokii/go-sso-web/pkg/repository/user"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestSessionCreditNotUsed check the handler charged with chargeUserCredit read the credit from the database,
+// the session still show the old credit after the user spent it on other tab
+func TestSessionCreditNotUsed(t *testing.T) {
+	paragraph := `{"theme": "horor", "language": "indonesia", "title": "Rumah", "description": "Rumah tua", "paragraph": "Lampu menyala.", "choice": "Masuk"}`
+
+	tests := []struct {
+		name    string
+		route   string // path of the handler, the request path is used when empty
+		handler func(stories *StoriesController, creative *CreativeContentController) fiber.Handler
+		req     func(t *testing.T) *http.Request
+	}{
+		{
+			name: "first stories part",
+			handler: func(stories *StoriesController, creative *CreativeContentController) fiber.Handler {
+				return stories.CreateFirstStoriesPart
+			},
+			req: func(t *testing.T) *http.Request {
+				return newStoriesRequest(t, "/stories/first", map[string]string{"theme": "horor", "language": "indonesia", "title": "Rumah"})
+			},
+		},
+		{
+			name: "first stories part stream",
+			handler: func(stories *StoriesController, creative *CreativeContentController) fiber.Handler {
+				return stories.CreateFirstStoriesPartStream
+			},
+			req: func(t *testing.T) *http.Request {
+				return newStoriesRequest(t, "/stories/first/stream", map[string]string{"theme": "horor", "language": "indonesia", "title": "Rumah"})
+			},
+		},
+		{
+			name:  "next stories paragraph",
+			route: "/stories/:data",
+			handler: func(stories *StoriesController, creative *CreativeContentController) fiber.Handler {
+				return stories.CreateStoriesParagraph
+			},
+			req: func(t *testing.T) *http.Request {
+				req := httptest.NewRequest(fiber.MethodPost, "/stories/next", strings.NewReader(paragraph))
+				req.Header.Set("Content-Type", fiber.MIMEApplicationJSON)
+				return req
+			},
+		},
+		{
+			name: "voice choice",
+			handler: func(stories *StoriesController, creative *CreativeContentController) fiber.Handler {
+				return stories.CreateStoriesVoiceChoice
+			},
+			req: func(t *testing.T) *http.Request {
+				return newVoiceChoiceRequest(t, "next", paragraph)
+			},
+		},
+		{
+			name: "image generator",
+			handler: func(stories *StoriesController, creative *CreativeContentController) fiber.Handler {
+				return creative.CreateImageDallE
+			},
+			req: func(t *testing.T) *http.Request {
+				return newStoriesRequest(t, "/image", map[string]string{"prompt": "kucing oranye"})
+			},
+		},
+		{
+			name: "text to speech",
+			handler: func(stories *StoriesController, creative *CreativeContentController) fiber.Handler {
+				return creative.CreateTTS
+			},
+			req: func(t *testing.T) *http.Request {
+				return newStoriesRequest(t, "/tts", map[string]string{"prompt": "halo semua", "language": "indonesia"})
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t, 0)
+			stories := NewStoriesController(env.db, env.registry, env.openai, *sso_user.NewUserRepo(), *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), env.moderator, env.generations)
+			creative := NewCreativeContentController(env.db, env.registry, env.openai, *sso_user.NewUserRepo(), *llmusage.NewLLMUsageRepo(), pricing.DefaultPriceTable(), env.moderator, env.generations)
+
+			req := tt.req(t)
+			route := tt.route
+			if route == "" {
+				route = req.URL.Path
+			}
+
+			app := newTestApp(req.Method, route, 100, tt.handler(stories, creative))
+			status, resp := sendTestJSONRequest(t, app, req)
+			if status != fiber.StatusUnauthorized {
+				t.Fatalf("status = %d, want 401 (%s)", status, resp.Message)
+			}
+
+			// refused before any paid request is sent
+			if requests := env.srv.Requests(); len(requests) != 0 {
+				t.Errorf("requests = %d, want 0", len(requests))
+			}
+		})
+	}
+}
@@ -4,4 +4,51 @@ CREATE TABLE users (
     password VARCHAR(255) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-);
\ No newline at end of file
+);
+
+CREATE TABLE llm_usages (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    feature VARCHAR(50) NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    model VARCHAR(100) NOT NULL DEFAULT '',
+    prompt_tokens INT NOT NULL DEFAULT 0,
+    completion_tokens INT NOT NULL DEFAULT 0,
+    cache_read_tokens INT NOT NULL DEFAULT 0,
+    cache_write_tokens INT NOT NULL DEFAULT 0,
+    cost NUMERIC(12, 6) NOT NULL DEFAULT 0,
+    latency_ms INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_llm_usages_user_id ON llm_usages (user_id);
+CREATE INDEX idx_llm_usages_created_at ON llm_usages (created_at);
+
+-- usd cost for the existing llm_usages table
+-- ALTER TABLE llm_usages ADD COLUMN cost NUMERIC(12, 6) NOT NULL DEFAULT 0;
+
+-- prompt cache tokens for the existing llm_usages table
+-- ALTER TABLE llm_usages ADD COLUMN cache_read_tokens INT NOT NULL DEFAULT 0, ADD COLUMN cache_write_tokens INT NOT NULL DEFAULT 0;
+
+-- generated output (roast, story paragraph, creative content) with its embedding for the semantic search
+CREATE TABLE generations (
+    id SERIAL PRIMARY KEY,
+    user_id INT NOT NULL,
+    feature VARCHAR(50) NOT NULL,
+    title TEXT NOT NULL DEFAULT '', -- the story title typed by the user has no length limit
+    content TEXT NOT NULL,
+    embedding_model VARCHAR(100) NOT NULL DEFAULT '',
+    embedding DOUBLE PRECISION[],
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_generations_user_id ON generations (user_id);
+
+-- unlimited title for the existing generations table
+-- ALTER TABLE generations ALTER COLUMN title TYPE TEXT;
+
+-- optional, when pgvector is installed the search is done by the database instead of the app.
+-- the dimension must be the same as the embedding model (1536 for text-embedding-3-small)
+-- CREATE EXTENSION IF NOT EXISTS vector;
+-- CREATE INDEX idx_generations_embedding ON generations USING hnsw ((embedding::vector(1536)) vector_cosine_ops);
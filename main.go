@@ -6,8 +6,15 @@ import (
 	"scrapper-test/controllers"
 	"scrapper-test/database"
 	"scrapper-test/middlewares"
+	"scrapper-test/repository/generation"
+	"scrapper-test/repository/llmusage"
+	"scrapper-test/utils"
+	"scrapper-test/utils/breaker"
 	"scrapper-test/utils/claude"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/moderation"
 	"scrapper-test/utils/openai"
+	"scrapper-test/utils/pricing"
 	"time"
 
 	sso_models "github.com/momokii/go-sso-web/pkg/models"
@@ -25,32 +32,126 @@ import (
 
 func main() {
 	engine := html.New("./public", ".html")
-	httpClient := &http.Client{
-		Timeout: 60 * time.Second,
+
+	// circuit breaker per upstream, use the default policy when the policy file not set.
+	// the request to the failing upstream fail fast instead of waiting for the http client timeout
+	breakerPolicy := breaker.DefaultPolicy()
+	if path := os.Getenv("CIRCUIT_BREAKER_POLICY_PATH"); path != "" {
+		var err error
+		breakerPolicy, err = breaker.LoadPolicy(path)
+		if err != nil {
+			panic(err)
+		}
 	}
+	breakers := breaker.NewSet(breakerPolicy)
+	utils.SetScrapperTransport(breakers.Transport(http.DefaultTransport, func(req *http.Request) string {
+		if req.URL.Hostname() == "medium.com" {
+			return utils.UPSTREAM_MEDIUM
+		}
+		return ""
+	}))
+
 	claude, err := claude.New(
 		os.Getenv("CLAUDE_API_KEY"),
-		claude.WithHTTPClient(httpClient),
+		claude.WithHTTPClient(&http.Client{
+			Timeout: 60 * time.Second,
+		}),
+		// the breaker get one outcome per request after the retry, not every rate limited attempt
+		claude.WithRequestGate(breakers.Gate(breaker.RouteAll(utils.UPSTREAM_CLAUDE))),
 		claude.WithBaseUrl(os.Getenv("CLAUDE_BASE_URL")),
 		claude.WithModel(os.Getenv("CLAUDE_MODEL")),
 		claude.WithAnthropicVersion(os.Getenv("CLAUDE_ANTHROPIC_VERSION")),
+		claude.WithRetryPolicy(claude.DefaultRetryPolicy()),
 	)
 	if err != nil {
 		panic(err)
 	}
 
+	// gpt-4o is the model used before OA_MODEL can be configured, so the deployment without OA_MODEL keep using it
+	openaiModel := os.Getenv("OA_MODEL")
+	if openaiModel == "" {
+		openaiModel = "gpt-4o"
+	}
+
 	openai, err := openai.New(
 		os.Getenv("OA_APIKEY"),
 		os.Getenv("OA_ORGANIZATIONID"),
 		os.Getenv("OA_PROJECTID"),
-		openai.WithHTTPClient(httpClient),
-		openai.WithModel("gpt-4o"),
-		openai.WithBaseUrl("https://api.openai.com/v1/chat/completions"),
+		// moderations, embeddings, and transcriptions is sent without breaker
+		openai.WithHTTPClient(&http.Client{
+			Timeout: 60 * time.Second,
+		}),
+		openai.WithRequestGate(breakers.Gate(breaker.RoutePath(map[string]string{
+			"/chat/completions":   utils.UPSTREAM_OPENAI_CHAT,
+			"/images/generations": utils.UPSTREAM_DALLE,
+			"/audio/speech":       utils.UPSTREAM_TTS,
+		}))),
+		openai.WithModel(openaiModel),
+		openai.WithRootUrl(os.Getenv("OA_BASE_URL")),
+		openai.WithBaseUrl(os.Getenv("OA_CHAT_COMPLETIONS_URL")),
+		openai.WithImageGenerationsUrl(os.Getenv("OA_IMAGE_GENERATIONS_URL")),
+		openai.WithTextToSpeechUrl(os.Getenv("OA_TEXT_TO_SPEECH_URL")),
+		openai.WithTranscriptionsUrl(os.Getenv("OA_TRANSCRIPTIONS_URL")),
+		openai.WithModerationsUrl(os.Getenv("OA_MODERATIONS_URL")),
+		openai.WithEmbeddingsUrl(os.Getenv("OA_EMBEDDINGS_URL")),
+		openai.WithRetryPolicy(openai.DefaultRetryPolicy()),
+	)
+	if err != nil {
+		panic(err)
+	}
+
+	// credit pricing, use the default price table when the price table file not set
+	priceTable := pricing.DefaultPriceTable()
+	if path := os.Getenv("PRICE_TABLE_PATH"); path != "" {
+		priceTable, err = pricing.LoadPriceTable(path)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	// moderation for user input and generated text, use the default policy when the policy file not set
+	moderationPolicy := moderation.DefaultPolicy()
+	if path := os.Getenv("MODERATION_POLICY_PATH"); path != "" {
+		moderationPolicy, err = moderation.LoadPolicy(path)
+		if err != nil {
+			panic(err)
+		}
+	}
+	moderator := moderation.New(openai, moderationPolicy)
+
+	// llm provider registry, default to openai when model not set
+	llmRegistry, err := llm.NewRegistry(
+		llm.ProviderOpenAI,
+		llm.NewClaudeProvider(claude),
+		llm.NewOpenAIProvider(openai),
 	)
 	if err != nil {
 		panic(err)
 	}
 
+	// opt-in failover, the failed request on claude or openai outage is sent again to the other provider
+	if os.Getenv("LLM_FAILOVER") == "true" {
+		llmRegistry.SetFailover(llm.DefaultFailoverPolicy())
+	}
+
+	// model that can be selected per request, use the default catalog when the catalog file not set.
+	// the catalog price override the price table so the selected model is charged with the listed price
+	modelCatalog := llm.DefaultCatalog()
+	if path := os.Getenv("MODEL_CATALOG_PATH"); path != "" {
+		modelCatalog, err = llm.LoadCatalog(path)
+		if err != nil {
+			panic(err)
+		}
+	}
+	if err := llmRegistry.SetCatalog(modelCatalog); err != nil {
+		panic(err)
+	}
+	for _, m := range modelCatalog.Models {
+		if m.Price != nil {
+			priceTable.Models[m.ID] = *m.Price
+		}
+	}
+
 	// db and session storage init
 	database.InitDB()
 	middlewares.InitSession()
@@ -58,13 +159,17 @@ func main() {
 	// repo init
 	userRepo := sso_user.NewUserRepo()
 	sessionRepo := sso_session.NewSessionRepo()
+	llmUsageRepo := llmusage.NewLLMUsageRepo()
+	generationRepo := generation.NewGenerationRepo()
 
 	// controller
-	mediumController := controllers.NewMediumController(claude, openai, *userRepo)
-	// bakuHantamController := controllers.NewBakuHantamController(claude, openai)
-	storiesController := controllers.NewStoriesController(claude, openai, *userRepo)
-	creativecontentController := controllers.NewCreativeContentController(openai, *userRepo)
-	authHandler := controllers.NewAuthHandler(*userRepo, *sessionRepo)
+	generationController := controllers.NewGenerationController(database.DB, openai, *generationRepo, *llmUsageRepo, priceTable)
+	mediumController := controllers.NewMediumController(database.DB, llmRegistry, *userRepo, *llmUsageRepo, priceTable, moderator, generationController)
+	// bakuHantamController := controllers.NewBakuHantamController(database.DB, llmRegistry, *llmUsageRepo, priceTable, moderator, generationController)
+	storiesController := controllers.NewStoriesController(database.DB, llmRegistry, openai, *userRepo, *llmUsageRepo, priceTable, moderator, generationController)
+	creativecontentController := controllers.NewCreativeContentController(database.DB, llmRegistry, openai, *userRepo, *llmUsageRepo, priceTable, moderator, generationController)
+	modelController := controllers.NewModelController(llmRegistry, priceTable)
+	authHandler := controllers.NewAuthHandler(database.DB, *userRepo, *sessionRepo)
 
 	app := fiber.New(fiber.Config{
 		Views: engine,
@@ -105,8 +210,9 @@ func main() {
 		user := c.Locals("user").(sso_models.UserSession)
 
 		return c.Render("index", fiber.Map{
-			"Title": "Hello, LLM!",
-			"User":  user,
+			"Title":    "Hello, LLM!",
+			"User":     user,
+			"Degraded": utils.DegradedUpstreams(breakers.NotClosed()),
 		})
 	})
 
@@ -114,23 +220,33 @@ func main() {
 	app.Get("/auth/sso", middlewares.IsNotAuth, authHandler.SSOAuthLogin)
 	app.Post("/api/logout", middlewares.IsAuth, authHandler.Logout)
 
+	// semantic search over the user past roast, story, and creative content
+	app.Get("/api/search", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_SEARCH_TIMEOUT), generationController.SearchGenerations)
+
+	// model catalog for the model dropdown
+	app.Get("/api/models", middlewares.IsAuth, modelController.GetModels)
+
 	app.Get("/medium", middlewares.IsAuth, mediumController.ViewMedium)
-	app.Post("/api/medium", middlewares.IsAuth, mediumController.PostMedium)
+	app.Post("/api/medium", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_MEDIUM_TIMEOUT), mediumController.PostMedium)
+	app.Post("/api/medium/stream", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_MEDIUM_TIMEOUT), mediumController.PostMediumStream)
 
 	// shutdown this feature beceause of the website that we crawl is down
 	// app.Get("/baku-hantam", middlewares.IsAuth, bakuHantamController.ViewBakuHantam)
-	// app.Post("/api/baku-hantam", middlewares.IsAuth, bakuHantamController.PostBakuHantam)
-	// app.Get("/api/baku-hantam/topics", middlewares.IsAuth, bakuHantamController.GetBakuHantamTopic)
+	// app.Post("/api/baku-hantam", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_BAKU_HANTAM_TIMEOUT), bakuHantamController.PostBakuHantam)
+	// app.Get("/api/baku-hantam/topics", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_BAKU_HANTAM_TIMEOUT), bakuHantamController.GetBakuHantamTopic)
 
 	app.Get("/stories", middlewares.IsAuth, storiesController.ViewStories)
-	app.Post("/api/stories/titles", middlewares.IsAuth, storiesController.CreateStoriesTitle)
-	app.Post("/api/stories/paragraphs", middlewares.IsAuth, storiesController.CreateFirstStoriesPart)
-	app.Post("/api/stories/paragraphs/:data", middlewares.IsAuth, storiesController.CreateStoriesParagraph)
+	app.Post("/api/stories/titles", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_STORY_GENERATOR_TIMEOUT), storiesController.CreateStoriesTitle)
+	app.Post("/api/stories/paragraphs", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_STORY_GENERATOR_TIMEOUT), storiesController.CreateFirstStoriesPart)
+	// stream route must be registered before the :data route
+	app.Post("/api/stories/paragraphs/stream", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_STORY_GENERATOR_TIMEOUT), storiesController.CreateFirstStoriesPartStream)
+	app.Post("/api/stories/voice-choice", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_VOICE_CHOICE_TIMEOUT), storiesController.CreateStoriesVoiceChoice)
+	app.Post("/api/stories/paragraphs/:data", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_STORY_GENERATOR_TIMEOUT), storiesController.CreateStoriesParagraph)
 
 	app.Get("/creative-content", middlewares.IsAuth, creativecontentController.ViewCreativeContent)
-	app.Post("/api/creative-content/images/analysis", middlewares.IsAuth, creativecontentController.GetImageAnalysis)
-	app.Post("/api/creative-content/images/generations", middlewares.IsAuth, creativecontentController.CreateImageDallE)
-	app.Post("/api/creative-content/audio/speech", middlewares.IsAuth, creativecontentController.CreateTTS)
+	app.Post("/api/creative-content/images/analysis", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_CONTENT_GENERATOR_TIMEOUT), creativecontentController.GetImageAnalysis)
+	app.Post("/api/creative-content/images/generations", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_IMAGE_GENERATOR_TIMEOUT), creativecontentController.CreateImageDallE)
+	app.Post("/api/creative-content/audio/speech", middlewares.IsAuth, middlewares.Deadline(utils.FEATURE_TTS_TIMEOUT), creativecontentController.CreateTTS)
 
 	app.Listen(":3002")
 }
@@ -0,0 +1,21 @@
+package middlewares
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Deadline set the request user context with the given timeout, so the LLM call, scrapping and
+// db transaction inside the handler that use c.UserContext() is canceled when the deadline exceeded
+func Deadline(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+
+		c.SetUserContext(ctx)
+
+		return c.Next()
+	}
+}
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"context"
 	"log"
 	"os"
 	"time"
@@ -105,48 +106,50 @@ func IsAuth(c *fiber.Ctx) error {
 		return c.Redirect(SSO_URL)
 	}
 
-	tx, err := database.DB.Begin()
-	if err != nil {
+	// db check is done on separate function, so the transaction is closed before the next handler
+	// and not held open during the long running LLM request
+	userData, err := findSessionUser(c.UserContext(), session_id.(string), userid.(int))
+	if err != nil || userData == nil {
 		DeleteSession(c)
 		return c.Redirect(SSO_URL)
 	}
+
+	userSession := sso_models.UserSession{
+		Id:               userData.Id,
+		Username:         userData.Username,
+		CreditToken:      userData.CreditToken,
+		LastFirstLLMUsed: userData.LastFirstLLMUsed,
+	}
+
+	// store information for next data
+	c.Locals("user", userSession)
+
+	return c.Next()
+}
+
+// findSessionUser check the session on database and return the user data, nil user returned when the session is not found
+func findSessionUser(ctx context.Context, session_id string, userid int) (user *sso_models.User, err error) {
+	tx, err := database.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
 	defer func() {
-		database.CommitOrRollback(tx, c, err)
+		database.CommitOrRollback(tx, nil, err)
 	}()
 
-	// check if session is valid
 	userRepo := sso_user.NewUserRepo()
 	session_repo := sessionRepo.NewSessionRepo()
 
 	// first check if session is valid on database
-	sessData, err := session_repo.FindSession(tx, session_id.(string), userid.(int))
-	// if session not found or error happen, redirect to login and delete the session local data
+	sessData, err := session_repo.FindSession(tx, session_id, userid)
 	if err != nil {
-		DeleteSession(c)
-		return c.Redirect(SSO_URL)
+		return nil, err
 	}
 
 	// if session is deleted/ not found
 	if sessData.Id == 0 && sessData.UserId == 0 && sessData.SessionId == "" {
-		DeleteSession(c)
-		return c.Redirect(SSO_URL)
-	}
-
-	userData, err := userRepo.FindByID(tx, userid.(int))
-	if err != nil {
-		DeleteSession(c)
-		return c.Redirect(SSO_URL)
+		return nil, nil
 	}
 
-	userSession := sso_models.UserSession{
-		Id:               userData.Id,
-		Username:         userData.Username,
-		CreditToken:      userData.CreditToken,
-		LastFirstLLMUsed: userData.LastFirstLLMUsed,
-	}
-
-	// store information for next data
-	c.Locals("user", userSession)
-
-	return c.Next()
+	return userRepo.FindByID(tx, userid)
 }
@@ -1,20 +1,23 @@
 package models
 
+// the struct is also the structured output schema of the LLM response (see llm.NewSchema),
+// the description tag is sent to the model as the instruction of the field
+
 type ImageAnalysisRes struct {
-	HaveEmotion      bool     `json:"have_emotion"`
+	HaveEmotion      bool     `json:"have_emotion" description:"false jika gambar tidak menarik untuk dijadikan content creative"`
 	ImageDescription string   `json:"image_description"`
 	EmotionDetection string   `json:"emotion_detection"`
-	ObjectDetection  []string `json:"object_detection"`
-	VisualElement    []string `json:"visual_element"`
+	ObjectDetection  []string `json:"object_detection" description:"maksimal 10 objek paling mencolok"`
+	VisualElement    []string `json:"visual_element" description:"maksimal 5 elemen visual paling mencolok"`
 }
 
 type CreativeContentData struct {
-	ContentType string `json:"content_type"`
-	Content     string `json:"content"`
+	ContentType string `json:"content_type" description:"jenis content creative, seperti cerita pendek, puisi, sajak, monolog, atau narasi singkat"`
+	Content     string `json:"content" description:"isi content creative, maksimal 4096 karakter"`
 }
 
 type CreativeContentRecommendationRes struct {
-	CreativeContent []CreativeContentData `json:"creative_content"`
+	CreativeContent []CreativeContentData `json:"creative_content" description:"maksimal 5 content creative paling menarik"`
 }
 
 type CreateImageGenerator struct {
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Generation is the generated output saved for the semantic search
+type Generation struct {
+	Id             int       `json:"id"`
+	UserId         int       `json:"user_id"`
+	Feature        string    `json:"feature"`
+	Title          string    `json:"title"`
+	Content        string    `json:"content"`
+	EmbeddingModel string    `json:"-"`
+	Embedding      []float64 `json:"-"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// GenerationSearchResult is the generation with its cosine similarity to the search query, 1 is the most similar
+type GenerationSearchResult struct {
+	Generation
+	Similarity float64 `json:"similarity"`
+}
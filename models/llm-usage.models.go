@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// status of the recorded LLM call
+const (
+	LLM_USAGE_STATUS_SUCCESS  = "success"
+	LLM_USAGE_STATUS_ERROR    = "error"
+	LLM_USAGE_STATUS_TIMEOUT  = "timeout"
+	LLM_USAGE_STATUS_CANCELED = "canceled"
+)
+
+type LLMUsage struct {
+	Id               int       `json:"id"`
+	UserId           int       `json:"user_id"`
+	Feature          string    `json:"feature"`
+	Provider         string    `json:"provider"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CacheReadTokens  int       `json:"cache_read_tokens"`  // part of the prompt tokens reused from the prompt cache
+	CacheWriteTokens int       `json:"cache_write_tokens"` // part of the prompt tokens written to the prompt cache
+	Cost             float64   `json:"cost"`               // in USD, 0 for failed call
+	LatencyMs        int       `json:"latency_ms"`
+	Status           string    `json:"status"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// LLMUsageSummary is the aggregated usage per feature, provider and model
+type LLMUsageSummary struct {
+	Feature          string  `json:"feature"`
+	Provider         string  `json:"provider"`
+	Model            string  `json:"model"`
+	TotalCalls       int     `json:"total_calls"`
+	ErrorCalls       int     `json:"error_calls"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	CacheReadTokens  int     `json:"cache_read_tokens"`
+	CacheWriteTokens int     `json:"cache_write_tokens"`
+	Cost             float64 `json:"cost"`
+	AvgLatencyMs     float64 `json:"avg_latency_ms"`
+}
@@ -10,6 +10,7 @@ type StoriesCreateTitle struct {
 	Description string `json:"description"`
 }
 
+// structured output of the title generation, the struct is also the schema sent to the LLM (see llm.NewSchema)
 type StoriesCreateTitleFormat struct {
 	Titles []StoriesCreateTitle `json:"titles"`
 }
@@ -26,7 +27,29 @@ type StoriesCreateParagraphContinueInput struct {
 	Choice    string `json:"choice"`
 }
 
+// structured output of the story paragraph, the struct is also the schema sent to the LLM (see llm.NewSchema)
 type StoriesCreateParagraph struct {
-	Paragraph string   `json:"paragraph"`
-	Choices   []string `json:"choices"`
+	Paragraph string   `json:"paragraph" description:"paragraf baru saja, tanpa pilihan keputusan"`
+	Choices   []string `json:"choices" description:"pilihan keputusan tanpa penomoran, list kosong pada bagian akhir cerita"`
+}
+
+// transcription of the voice input, returned together with the story response
+type StoriesVoiceTranscript struct {
+	Text     string                          `json:"text"`
+	Language string                          `json:"language"`
+	Duration float64                         `json:"duration"`
+	Segments []StoriesVoiceTranscriptSegment `json:"segments"`
+}
+
+type StoriesVoiceTranscriptSegment struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Text  string  `json:"text"`
+}
+
+// narration audio of the paragraph on the narrated story mode
+type StoriesNarration struct {
+	Format     string `json:"format"`
+	B64JSON    string `json:"b64_json"`
+	Transcript string `json:"transcript"`
 }
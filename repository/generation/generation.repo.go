@@ -0,0 +1,102 @@
+package generation
+
+import (
+	"database/sql"
+	"fmt"
+	"scrapper-test/models"
+
+	"github.com/lib/pq"
+)
+
+type GenerationRepo struct{}
+
+func NewGenerationRepo() *GenerationRepo {
+	return &GenerationRepo{}
+}
+
+func (r *GenerationRepo) Create(tx *sql.Tx, generation *models.Generation) error {
+	query := `
+		INSERT INTO generations (user_id, feature, title, content, embedding_model, embedding)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	if _, err := tx.Exec(query, generation.UserId, generation.Feature, generation.Title, generation.Content, generation.EmbeddingModel, pq.Array(generation.Embedding)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// HasVectorExtension return true when pgvector is installed, so the search can be done by the database
+func (r *GenerationRepo) HasVectorExtension(tx *sql.Tx) (bool, error) {
+	var exists bool
+
+	query := `SELECT EXISTS (SELECT 1 FROM pg_extension WHERE extname = 'vector')`
+
+	if err := tx.QueryRow(query).Scan(&exists); err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// SearchByVector return the user generations most similar to the embedding using pgvector cosine distance,
+// only generation embedded with the same model is compared
+func (r *GenerationRepo) SearchByVector(tx *sql.Tx, userId int, embeddingModel string, embedding []float64, limit int) ([]models.GenerationSearchResult, error) {
+	var results []models.GenerationSearchResult
+
+	// the dimension is part of the cast so the query can use the expression index on embedding::vector(dimension)
+	distance := fmt.Sprintf("(embedding::vector(%d) <=> $3::float8[]::vector(%d))", len(embedding), len(embedding))
+	query := `
+		SELECT id, user_id, feature, title, content, created_at, 1 - ` + distance + `
+		FROM generations
+		WHERE user_id = $1 AND embedding_model = $2 AND array_length(embedding, 1) = $4
+		ORDER BY ` + distance + ` LIMIT $5
+	`
+
+	rows, err := tx.Query(query, userId, embeddingModel, pq.Array(embedding), len(embedding), limit)
+	if err != nil {
+		return results, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result models.GenerationSearchResult
+		if err := rows.Scan(&result.Id, &result.UserId, &result.Feature, &result.Title, &result.Content, &result.CreatedAt, &result.Similarity); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+// FindEmbeddedByUserID return the latest user generations embedded with the model, including the embedding,
+// used to compute the similarity on the app when pgvector is not installed
+func (r *GenerationRepo) FindEmbeddedByUserID(tx *sql.Tx, userId int, embeddingModel string, limit int) ([]models.Generation, error) {
+	var generations []models.Generation
+
+	query := `
+		SELECT id, user_id, feature, title, content, embedding_model, embedding, created_at
+		FROM generations WHERE user_id = $1 AND embedding_model = $2 AND embedding IS NOT NULL
+		ORDER BY created_at DESC LIMIT $3
+	`
+
+	rows, err := tx.Query(query, userId, embeddingModel, limit)
+	if err != nil {
+		return generations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var generation models.Generation
+		var embedding pq.Float64Array
+		if err := rows.Scan(&generation.Id, &generation.UserId, &generation.Feature, &generation.Title, &generation.Content, &generation.EmbeddingModel, &embedding, &generation.CreatedAt); err != nil {
+			return generations, err
+		}
+		generation.Embedding = embedding
+		generations = append(generations, generation)
+	}
+
+	return generations, rows.Err()
+}
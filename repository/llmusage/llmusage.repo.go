@@ -0,0 +1,85 @@
+package llmusage
+
+import (
+	"database/sql"
+	"scrapper-test/models"
+	"time"
+)
+
+type LLMUsageRepo struct{}
+
+func NewLLMUsageRepo() *LLMUsageRepo {
+	return &LLMUsageRepo{}
+}
+
+func (r *LLMUsageRepo) Create(tx *sql.Tx, usage *models.LLMUsage) error {
+	query := `
+		INSERT INTO llm_usages (user_id, feature, provider, model, prompt_tokens, completion_tokens, cache_read_tokens, cache_write_tokens, cost, latency_ms, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+
+	if _, err := tx.Exec(query, usage.UserId, usage.Feature, usage.Provider, usage.Model, usage.PromptTokens, usage.CompletionTokens, usage.CacheReadTokens, usage.CacheWriteTokens, usage.Cost, usage.LatencyMs, usage.Status); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// FindByUserID return the latest LLM calls of the user
+func (r *LLMUsageRepo) FindByUserID(tx *sql.Tx, userId int, limit int) ([]models.LLMUsage, error) {
+	var usages []models.LLMUsage
+
+	query := `
+		SELECT id, user_id, feature, provider, model, prompt_tokens, completion_tokens, cache_read_tokens, cache_write_tokens, cost, latency_ms, status, created_at
+		FROM llm_usages WHERE user_id = $1
+		ORDER BY created_at DESC LIMIT $2
+	`
+
+	rows, err := tx.Query(query, userId, limit)
+	if err != nil {
+		return usages, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var usage models.LLMUsage
+		if err := rows.Scan(&usage.Id, &usage.UserId, &usage.Feature, &usage.Provider, &usage.Model, &usage.PromptTokens, &usage.CompletionTokens, &usage.CacheReadTokens, &usage.CacheWriteTokens, &usage.Cost, &usage.LatencyMs, &usage.Status, &usage.CreatedAt); err != nil {
+			return usages, err
+		}
+		usages = append(usages, usage)
+	}
+
+	return usages, rows.Err()
+}
+
+// SummaryByFeature aggregate the LLM calls between from and to, grouped by feature, provider and model
+func (r *LLMUsageRepo) SummaryByFeature(tx *sql.Tx, from time.Time, to time.Time) ([]models.LLMUsageSummary, error) {
+	var summaries []models.LLMUsageSummary
+
+	query := `
+		SELECT feature, provider, model, COUNT(*),
+		COUNT(*) FILTER (WHERE status != 'success'),
+		COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+		COALESCE(SUM(cache_read_tokens), 0), COALESCE(SUM(cache_write_tokens), 0),
+		COALESCE(SUM(cost), 0), COALESCE(AVG(latency_ms), 0)
+		FROM llm_usages WHERE created_at >= $1 AND created_at < $2
+		GROUP BY feature, provider, model
+		ORDER BY SUM(cost) DESC
+	`
+
+	rows, err := tx.Query(query, from, to)
+	if err != nil {
+		return summaries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var summary models.LLMUsageSummary
+		if err := rows.Scan(&summary.Feature, &summary.Provider, &summary.Model, &summary.TotalCalls, &summary.ErrorCalls, &summary.PromptTokens, &summary.CompletionTokens, &summary.CacheReadTokens, &summary.CacheWriteTokens, &summary.Cost, &summary.AvgLatencyMs); err != nil {
+			return summaries, err
+		}
+		summaries = append(summaries, summary)
+	}
+
+	return summaries, rows.Err()
+}
@@ -0,0 +1,299 @@
+package breaker
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// circuit state
+const (
+	StateClosed   = "closed"    // the request is sent, the consecutive failure is counted
+	StateOpen     = "open"      // the request fail fast with *OpenError until the open duration passed
+	StateHalfOpen = "half-open" // a few trial request is sent, the success close the circuit and the failure open it again
+)
+
+// result of one request sent through the breaker
+const (
+	OutcomeSuccess = iota
+	OutcomeFailure
+	OutcomeIgnored // the result say nothing about the upstream, like the request canceled by the caller
+)
+
+// ErrOpen is wrapped by OpenError, so the caller can check the open circuit with errors.Is
+var ErrOpen = errors.New("circuit breaker is open")
+
+// OpenError is returned instead of sending the request when the circuit of the upstream is open
+type OpenError struct {
+	Name       string        // upstream name
+	RetryAfter time.Duration // time left until the trial request is allowed
+}
+
+func (e *OpenError) Error() string {
+	return "Circuit breaker " + e.Name + " is open, retry after " + e.RetryAfter.Round(time.Second).String()
+}
+
+func (e *OpenError) Unwrap() error {
+	return ErrOpen
+}
+
+// Config holds the thresholds of one circuit breaker
+type Config struct {
+	FailureThreshold    int `json:"failure_threshold"`      // consecutive failure that open the circuit
+	OpenSeconds         int `json:"open_seconds"`           // how long the circuit stay open before the trial request
+	HalfOpenMaxRequests int `json:"half_open_max_requests"` // trial request allowed at the same time when half-open, all must success to close
+}
+
+// Policy holds the config for every upstream
+type Policy struct {
+	Default   Config            `json:"default"`   // used by the upstream not in Upstreams
+	Upstreams map[string]Config `json:"upstreams"` // the key is the upstream name
+}
+
+// DefaultPolicy return the policy that open the circuit after 5 consecutive failure for 30 seconds,
+// then send 1 trial request
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Default: Config{
+			FailureThreshold:    5,
+			OpenSeconds:         30,
+			HalfOpenMaxRequests: 1,
+		},
+		Upstreams: map[string]Config{},
+	}
+}
+
+// LoadPolicy read policy from json file, the value in the file override the default policy
+// so the file only need to contain the changed value
+func LoadPolicy(path string) (*Policy, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Failed to read circuit breaker policy: " + err.Error())
+	}
+
+	policy := DefaultPolicy()
+	if err := json.Unmarshal(file, policy); err != nil {
+		return nil, errors.New("Failed to decode circuit breaker policy: " + err.Error())
+	}
+
+	if err := policy.Default.validate(); err != nil {
+		return nil, errors.New("Failed to load circuit breaker policy: default: " + err.Error())
+	}
+	for name, config := range policy.Upstreams {
+		if err := config.validate(); err != nil {
+			return nil, errors.New("Failed to load circuit breaker policy: " + name + ": " + err.Error())
+		}
+	}
+
+	return policy, nil
+}
+
+func (c Config) validate() error {
+	if c.FailureThreshold <= 0 || c.OpenSeconds <= 0 || c.HalfOpenMaxRequests <= 0 {
+		return errors.New("failure_threshold, open_seconds, and half_open_max_requests must be greater than 0")
+	}
+
+	return nil
+}
+
+// Breaker is the circuit breaker of one upstream, safe to be used by many goroutine
+type Breaker struct {
+	name   string
+	config Config
+
+	mu       sync.Mutex
+	state    string
+	failures int       // consecutive failure when closed
+	openedAt time.Time // when the circuit is opened
+	trials   int       // trial request in flight when half-open
+}
+
+// New create circuit breaker in closed state
+func New(name string, config Config) *Breaker {
+	return &Breaker{
+		name:   name,
+		config: config,
+		state:  StateClosed,
+	}
+}
+
+// Name return the upstream name
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State return the current state, the open circuit past its open duration is reported as half-open
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh()
+	return b.state
+}
+
+// Allow check the request can be sent. When allowed, done must be called once with the outcome of the request,
+// when the circuit is open *OpenError is returned.
+//
+// Example usage:
+//
+//	done, err := b.Allow()
+//	if err != nil {
+//	    return err
+//	}
+//
+//	resp, err := send()
+//	if err != nil {
+//	    done(breaker.OutcomeFailure)
+//	    return err
+//	}
+//	done(breaker.OutcomeSuccess)
+func (b *Breaker) Allow() (done func(outcome int), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.refresh()
+
+	switch b.state {
+	case StateOpen:
+		return nil, &OpenError{
+			Name:       b.name,
+			RetryAfter: time.Until(b.openedAt.Add(b.openDuration())),
+		}
+
+	case StateHalfOpen:
+		if b.trials >= b.config.HalfOpenMaxRequests {
+			// the trial request is still running, the other request wait like the circuit is open
+			return nil, &OpenError{Name: b.name}
+		}
+		b.trials++
+	}
+
+	trial := b.state == StateHalfOpen
+	var once sync.Once
+
+	return func(outcome int) {
+		once.Do(func() {
+			b.done(trial, outcome)
+		})
+	}, nil
+}
+
+// done update the state with the request outcome
+func (b *Breaker) done(trial bool, outcome int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if trial {
+		b.trials--
+	}
+
+	switch outcome {
+	case OutcomeSuccess:
+		if b.state == StateHalfOpen && trial {
+			log.Printf("circuit breaker %s: closed", b.name)
+			b.state = StateClosed
+		}
+		if b.state == StateClosed {
+			b.failures = 0
+		}
+
+	case OutcomeFailure:
+		switch {
+		case b.state == StateHalfOpen && trial:
+			b.open()
+		case b.state == StateClosed:
+			b.failures++
+			if b.failures >= b.config.FailureThreshold {
+				b.open()
+			}
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	log.Printf("circuit breaker %s: open for %s", b.name, b.openDuration())
+
+	b.state = StateOpen
+	b.openedAt = time.Now()
+	b.failures = 0
+}
+
+// refresh move the open circuit to half-open after the open duration
+func (b *Breaker) refresh() {
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.openDuration() {
+		b.state = StateHalfOpen
+		b.trials = 0
+	}
+}
+
+func (b *Breaker) openDuration() time.Duration {
+	return time.Duration(b.config.OpenSeconds) * time.Second
+}
+
+// Set holds the circuit breaker of every upstream, the breaker is created on the first Get with the policy config
+type Set struct {
+	policy *Policy
+
+	mu       sync.Mutex
+	breakers map[string]*Breaker
+}
+
+// NewSet create the breaker set using the policy
+//
+// Example usage:
+//
+//	breakers := breaker.NewSet(breaker.DefaultPolicy())
+//
+//	httpClient := &http.Client{
+//	    Timeout:   60 * time.Second,
+//	    Transport: breakers.Transport(http.DefaultTransport, breaker.RouteAll("claude")),
+//	}
+func NewSet(policy *Policy) *Set {
+	return &Set{
+		policy:   policy,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Get return the breaker of the upstream
+func (s *Set) Get(name string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[name]
+	if !ok {
+		config, ok := s.policy.Upstreams[name]
+		if !ok {
+			config = s.policy.Default
+		}
+
+		b = New(name, config)
+		s.breakers[name] = b
+	}
+
+	return b
+}
+
+// NotClosed return the name of the upstream with open or half-open circuit, sorted by name
+func (s *Set) NotClosed() []string {
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+
+	names := []string{}
+	for _, b := range breakers {
+		if b.State() != StateClosed {
+			names = append(names, b.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,204 @@
+package breaker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// allow call Allow and fail the test when the request is rejected
+func allow(t *testing.T, b *Breaker) func(outcome int) {
+	t.Helper()
+
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() error = %v, want allowed", err)
+	}
+
+	return done
+}
+
+// passOpenDuration move the open circuit to the end of its open duration
+func passOpenDuration(b *Breaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.openedAt = time.Now().Add(-b.openDuration())
+}
+
+func TestBreakerThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		outcomes  []int
+		wantState string
+	}{
+		{name: "below threshold", outcomes: []int{OutcomeFailure, OutcomeFailure}, wantState: StateClosed},
+		{name: "reach threshold", outcomes: []int{OutcomeFailure, OutcomeFailure, OutcomeFailure}, wantState: StateOpen},
+		{name: "success reset the failures", outcomes: []int{OutcomeFailure, OutcomeFailure, OutcomeSuccess, OutcomeFailure, OutcomeFailure}, wantState: StateClosed},
+		{name: "ignored not reset the failures", outcomes: []int{OutcomeFailure, OutcomeFailure, OutcomeIgnored, OutcomeFailure}, wantState: StateOpen},
+		{name: "ignored only", outcomes: []int{OutcomeIgnored, OutcomeIgnored, OutcomeIgnored}, wantState: StateClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", Config{FailureThreshold: 3, OpenSeconds: 30, HalfOpenMaxRequests: 1})
+
+			for _, outcome := range tt.outcomes {
+				allow(t, b)(outcome)
+			}
+
+			if got := b.State(); got != tt.wantState {
+				t.Errorf("State() = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestBreakerOpen(t *testing.T) {
+	b := New("test", Config{FailureThreshold: 1, OpenSeconds: 30, HalfOpenMaxRequests: 1})
+	allow(t, b)(OutcomeFailure)
+
+	_, err := b.Allow()
+
+	var openErr *OpenError
+	if !errors.As(err, &openErr) {
+		t.Fatalf("Allow() error = %v, want *OpenError", err)
+	}
+	if !errors.Is(err, ErrOpen) {
+		t.Error("errors.Is(err, ErrOpen) = false")
+	}
+	if openErr.Name != "test" {
+		t.Errorf("Name = %s, want test", openErr.Name)
+	}
+	if openErr.RetryAfter <= 0 || openErr.RetryAfter > 30*time.Second {
+		t.Errorf("RetryAfter = %s, want between 0 and 30s", openErr.RetryAfter)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxTrials    int
+		trialOutcome []int // outcome of each trial request, in order
+		wantState    string
+	}{
+		{name: "trial success close", maxTrials: 1, trialOutcome: []int{OutcomeSuccess}, wantState: StateClosed},
+		{name: "trial failure reopen", maxTrials: 1, trialOutcome: []int{OutcomeFailure}, wantState: StateOpen},
+		{name: "trial ignored stay half-open", maxTrials: 1, trialOutcome: []int{OutcomeIgnored}, wantState: StateHalfOpen},
+		{name: "one failed trial reopen", maxTrials: 2, trialOutcome: []int{OutcomeSuccess, OutcomeFailure}, wantState: StateOpen},
+		{name: "first failed trial reopen", maxTrials: 2, trialOutcome: []int{OutcomeFailure, OutcomeSuccess}, wantState: StateOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := New("test", Config{FailureThreshold: 1, OpenSeconds: 30, HalfOpenMaxRequests: tt.maxTrials})
+			allow(t, b)(OutcomeFailure)
+			passOpenDuration(b)
+
+			if got := b.State(); got != StateHalfOpen {
+				t.Fatalf("State() = %s, want %s", got, StateHalfOpen)
+			}
+
+			// every trial allowed at the same time, the next request wait until one of them is done
+			dones := make([]func(outcome int), 0, tt.maxTrials)
+			for i := 0; i < tt.maxTrials; i++ {
+				dones = append(dones, allow(t, b))
+			}
+			if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+				t.Fatalf("Allow() past the trial limit error = %v, want ErrOpen", err)
+			}
+
+			for i, outcome := range tt.trialOutcome {
+				dones[i](outcome)
+			}
+
+			if got := b.State(); got != tt.wantState {
+				t.Errorf("State() = %s, want %s", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestBreakerReopenFailFast(t *testing.T) {
+	b := New("test", Config{FailureThreshold: 1, OpenSeconds: 30, HalfOpenMaxRequests: 1})
+	allow(t, b)(OutcomeFailure)
+	passOpenDuration(b)
+
+	allow(t, b)(OutcomeFailure)
+
+	// the reopened circuit wait the full open duration again
+	_, err := b.Allow()
+	var openErr *OpenError
+	if !errors.As(err, &openErr) || openErr.RetryAfter < 29*time.Second {
+		t.Errorf("Allow() after failed trial error = %v, want open for 30s", err)
+	}
+}
+
+func TestBreakerDoneOnce(t *testing.T) {
+	b := New("test", Config{FailureThreshold: 2, OpenSeconds: 30, HalfOpenMaxRequests: 1})
+
+	done := allow(t, b)
+	done(OutcomeFailure)
+	done(OutcomeFailure)
+
+	if got := b.State(); got != StateClosed {
+		t.Errorf("State() = %s, want %s, the second done must be ignored", got, StateClosed)
+	}
+}
+
+func TestSet(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Upstreams["claude"] = Config{FailureThreshold: 1, OpenSeconds: 30, HalfOpenMaxRequests: 1}
+	set := NewSet(policy)
+
+	if set.Get("claude") != set.Get("claude") {
+		t.Error("Get() return different breaker for the same upstream")
+	}
+	if got := set.Get("openai").config; got != policy.Default {
+		t.Errorf("upstream not in policy config = %+v, want default %+v", got, policy.Default)
+	}
+
+	allow(t, set.Get("claude"))(OutcomeFailure)
+	if got := set.NotClosed(); len(got) != 1 || got[0] != "claude" {
+		t.Errorf("NotClosed() = %v, want [claude]", got)
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "override", content: `{"upstreams": {"claude": {"failure_threshold": 2, "open_seconds": 10, "half_open_max_requests": 1}}}`},
+		{name: "invalid json", content: `{`, wantErr: true},
+		{name: "zero threshold", content: `{"default": {"failure_threshold": 0, "open_seconds": 10, "half_open_max_requests": 1}}`, wantErr: true},
+		{name: "zero upstream open seconds", content: `{"upstreams": {"claude": {"failure_threshold": 2, "half_open_max_requests": 1}}}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "breaker.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			policy, err := LoadPolicy(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if policy.Default != DefaultPolicy().Default {
+				t.Errorf("Default = %+v, want the default policy", policy.Default)
+			}
+			if got := policy.Upstreams["claude"]; got.FailureThreshold != 2 || got.OpenSeconds != 10 {
+				t.Errorf("claude config = %+v", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package breaker
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Route return the upstream name of the request, empty name mean the request is sent without breaker
+type Route func(req *http.Request) string
+
+// RouteAll send every request through the same upstream breaker
+func RouteAll(name string) Route {
+	return func(req *http.Request) string {
+		return name
+	}
+}
+
+// RoutePath choose the upstream by the url path suffix, like "/chat/completions", the request not matched is sent without breaker
+func RoutePath(suffixes map[string]string) Route {
+	return func(req *http.Request) string {
+		for suffix, name := range suffixes {
+			if strings.HasSuffix(strings.TrimSuffix(req.URL.Path, "/"), suffix) {
+				return name
+			}
+		}
+
+		return ""
+	}
+}
+
+// transport send the request through the circuit breaker of its upstream
+type transport struct {
+	set   *Set
+	base  http.RoundTripper
+	route Route
+}
+
+// Transport wrap the base transport, so every request routed to an upstream fail fast with *OpenError when its circuit is open.
+// The connection error, client timeout, 429, and 5xx response count as failure, the request canceled or past the deadline
+// of its context is ignored. Every attempt is one outcome, use Gate for the client that retry the request
+func (s *Set) Transport(base http.RoundTripper, route Route) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &transport{
+		set:   s,
+		base:  base,
+		route: route,
+	}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	done, err := t.set.Gate(t.route)(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	done(resp, err)
+
+	return resp, err
+}
+
+// Gate return the function that check the request routed to an upstream can be sent, and record the outcome given to done
+// with the same rule as Transport. It is used as the request gate of the claude and openai client, so the retried attempts
+// of one request is recorded once with the final response instead of every rate limited attempt count as failure.
+//
+// Example usage:
+//
+//	claude.New(apiKey,
+//	    claude.WithRetryPolicy(claude.DefaultRetryPolicy()),
+//	    claude.WithRequestGate(breakers.Gate(breaker.RouteAll("claude"))),
+//	)
+func (s *Set) Gate(route Route) func(req *http.Request) (done func(resp *http.Response, err error), err error) {
+	return func(req *http.Request) (func(resp *http.Response, err error), error) {
+		name := route(req)
+		if name == "" {
+			return func(resp *http.Response, err error) {}, nil
+		}
+
+		done, err := s.Get(name).Allow()
+		if err != nil {
+			return nil, err
+		}
+
+		return func(resp *http.Response, err error) {
+			done(outcome(req, resp, err))
+		}, nil
+	}
+}
+
+// outcome decide the request result say the upstream is failing or not
+func outcome(req *http.Request, resp *http.Response, err error) int {
+	if err != nil {
+		// the request canceled by the caller or stopped by the feature deadline say nothing about the upstream,
+		// the http client timeout is not on the request context so the slow upstream still count as failure
+		if req.Context().Err() != nil {
+			return OutcomeIgnored
+		}
+		return OutcomeFailure
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return OutcomeFailure
+	}
+
+	return OutcomeSuccess
+}
@@ -0,0 +1,118 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOutcome(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status int
+		err    error
+		want   int
+	}{
+		{name: "success", ctx: context.Background(), status: http.StatusOK, want: OutcomeSuccess},
+		{name: "client error", ctx: context.Background(), status: http.StatusBadRequest, want: OutcomeSuccess},
+		{name: "rate limited", ctx: context.Background(), status: http.StatusTooManyRequests, want: OutcomeFailure},
+		{name: "server error", ctx: context.Background(), status: http.StatusInternalServerError, want: OutcomeFailure},
+		{name: "overloaded", ctx: context.Background(), status: 529, want: OutcomeFailure},
+		{name: "connection error", ctx: context.Background(), err: errors.New("connection refused"), want: OutcomeFailure},
+		{name: "canceled by caller", ctx: canceled, err: context.Canceled, want: OutcomeIgnored},
+		{name: "feature deadline exceeded", ctx: expired, err: context.DeadlineExceeded, want: OutcomeIgnored},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/messages", nil).WithContext(tt.ctx)
+
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status}
+			}
+
+			if got := outcome(req, resp, tt.err); got != tt.want {
+				t.Errorf("outcome() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGate(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Default = Config{FailureThreshold: 2, OpenSeconds: 30, HalfOpenMaxRequests: 1}
+	set := NewSet(policy)
+
+	gate := set.Gate(RoutePath(map[string]string{"/chat/completions": "openai-chat"}))
+	chat := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	// the deadline exceeded request is ignored, it must not open the circuit
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		done, err := gate(chat.WithContext(expired))
+		if err != nil {
+			t.Fatalf("gate() error = %v", err)
+		}
+		done(nil, context.DeadlineExceeded)
+	}
+	if got := set.Get("openai-chat").State(); got != StateClosed {
+		t.Fatalf("State() after deadline exceeded = %s, want %s", got, StateClosed)
+	}
+
+	for i := 0; i < 2; i++ {
+		done, err := gate(chat)
+		if err != nil {
+			t.Fatalf("gate() error = %v", err)
+		}
+		done(&http.Response{StatusCode: http.StatusServiceUnavailable}, nil)
+	}
+
+	if _, err := gate(chat); !errors.Is(err, ErrOpen) {
+		t.Errorf("gate() error = %v, want ErrOpen", err)
+	}
+
+	// the request not routed is always allowed
+	done, err := gate(httptest.NewRequest(http.MethodPost, "/v1/embeddings", nil))
+	if err != nil {
+		t.Fatalf("gate() not routed error = %v", err)
+	}
+	done(nil, errors.New("connection refused"))
+}
+
+func TestTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	policy := DefaultPolicy()
+	policy.Default = Config{FailureThreshold: 2, OpenSeconds: 30, HalfOpenMaxRequests: 1}
+	set := NewSet(policy)
+
+	client := &http.Client{Transport: set.Transport(nil, RouteAll("medium"))}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// every attempt is one outcome, the third request fail fast without being sent
+	if _, err := client.Get(srv.URL); !errors.Is(err, ErrOpen) {
+		t.Errorf("Get() error = %v, want ErrOpen", err)
+	}
+}
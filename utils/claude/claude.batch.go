@@ -0,0 +1,279 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// message batch processing status and result type
+const (
+	ClaudeBatchStatusInProgress = "in_progress"
+	ClaudeBatchStatusCanceling  = "canceling"
+	ClaudeBatchStatusEnded      = "ended"
+
+	ClaudeBatchResultSucceeded = "succeeded"
+	ClaudeBatchResultErrored   = "errored"
+	ClaudeBatchResultCanceled  = "canceled"
+	ClaudeBatchResultExpired   = "expired"
+
+	// used by ClaudeWaitMessageBatchResults when poll_interval is 0
+	ClaudeBatchDefaultPollInterval = 30 * time.Second
+)
+
+// Err return nil for succeeded result, *APIError for errored result, and error for canceled or expired result
+func (r ClaudeBatchResult) Err() error {
+	switch r.Result.Type {
+	case ClaudeBatchResultSucceeded:
+		return nil
+	case ClaudeBatchResultErrored:
+		if r.Result.Error != nil && r.Result.Error.Error.Type != "" {
+			return newStreamAPIError(r.Result.Error.Error.Type, r.Result.Error.Error.Message, "")
+		}
+		return newStreamAPIError("api_error", "Claude batch request errored", "")
+	}
+
+	return errors.New("request failed: batch request " + r.CustomID + " " + r.Result.Type)
+}
+
+// ClaudeCreateMessageBatch sends many message requests as one batch, processed asynchronously at a lower price than ClaudeSendMessage.
+//
+// Each request has a `CustomID` used to match the result later, and `Params` that is the same request body used by
+// ClaudeSendMessage (so ClaudeCreateResponseFormat can be used for structured output). If the params model is empty
+// the client configured model is used. The batch is processed within 24 hours, most batch finish much faster,
+// use ClaudeGetMessageBatch to poll the status or ClaudeWaitMessageBatchResults to wait and get the results.
+//
+// Returns:
+//   - A pointer to `ClaudeMessageBatch` with the batch id and `in_progress` status.
+//   - An error if the requests is invalid (empty, duplicated custom id, or using stream) or the request fails.
+//
+// Example usage:
+//
+//	batch, err := claudeAPI.ClaudeCreateMessageBatch([]claude.ClaudeBatchRequest{
+//	    {CustomID: "title-1", Params: claude.ClaudeReqBody{MaxTokens: 1024, Messages: messages1}},
+//	    {CustomID: "title-2", Params: claude.ClaudeReqBody{MaxTokens: 1024, Messages: messages2}},
+//	})
+//	if err != nil {
+//	    log.Fatalf("Failed to create message batch: %v", err)
+//	}
+//
+//	results, err := claudeAPI.ClaudeWaitMessageBatchResults(ctx, batch.ID, time.Minute)
+//	if err != nil {
+//	    log.Fatalf("Failed to wait message batch: %v", err)
+//	}
+//
+//	if err := results["title-1"].Err(); err == nil {
+//	    fmt.Println(results["title-1"].Result.Message.Content[0].Text)
+//	}
+//
+// References:
+//   - Official Claude Message Batches documentation: https://docs.anthropic.com/en/docs/build-with-claude/message-batches
+func (c *claudeAPI) ClaudeCreateMessageBatch(requests []ClaudeBatchRequest) (*ClaudeMessageBatch, error) {
+	return c.ClaudeCreateMessageBatchWithContext(context.Background(), requests)
+}
+
+// ClaudeCreateMessageBatchWithContext is the context-aware version of ClaudeCreateMessageBatch, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeCreateMessageBatchWithContext(ctx context.Context, requests []ClaudeBatchRequest) (*ClaudeMessageBatch, error) {
+	if c.apiKey == "" {
+		return nil, errors.New("API Key is empty")
+	}
+
+	if len(requests) == 0 {
+		return nil, errors.New("request failed: batch requests is empty")
+	}
+
+	reqBody := ClaudeBatchCreateReq{
+		Requests: make([]ClaudeBatchRequest, 0, len(requests)),
+	}
+	customIDs := make(map[string]bool, len(requests))
+
+	for _, r := range requests {
+		if r.CustomID == "" {
+			return nil, errors.New("request failed: batch request custom id is empty")
+		}
+		if customIDs[r.CustomID] {
+			return nil, errors.New("request failed: batch request custom id " + r.CustomID + " is duplicated")
+		}
+		customIDs[r.CustomID] = true
+
+		if r.Params.Stream {
+			return nil, errors.New("request failed: batch request " + r.CustomID + " can not use stream")
+		}
+
+		// copy so the caller request not changed
+		if r.Params.Model == "" {
+			r.Params.Model = c.config.claudeModel
+		}
+		reqBody.Requests = append(reqBody.Requests, r)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, c.batchesUrl(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.doBatchRequest(req)
+}
+
+// ClaudeGetMessageBatch return the batch status, the batch is finished when `ProcessingStatus` is `ended`
+func (c *claudeAPI) ClaudeGetMessageBatch(batch_id string) (*ClaudeMessageBatch, error) {
+	return c.ClaudeGetMessageBatchWithContext(context.Background(), batch_id)
+}
+
+// ClaudeGetMessageBatchWithContext is the context-aware version of ClaudeGetMessageBatch, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeGetMessageBatchWithContext(ctx context.Context, batch_id string) (*ClaudeMessageBatch, error) {
+	if batch_id == "" {
+		return nil, errors.New("request failed: batch id is empty")
+	}
+
+	req, err := c.newRequest(ctx, http.MethodGet, c.batchesUrl()+"/"+url.PathEscape(batch_id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.doBatchRequest(req)
+}
+
+// ClaudeCancelMessageBatch cancel the batch, the status become `canceling` until the request being processed finished.
+// The request that already finished keep its result, the rest has `canceled` result
+func (c *claudeAPI) ClaudeCancelMessageBatch(batch_id string) (*ClaudeMessageBatch, error) {
+	return c.ClaudeCancelMessageBatchWithContext(context.Background(), batch_id)
+}
+
+// ClaudeCancelMessageBatchWithContext is the context-aware version of ClaudeCancelMessageBatch, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeCancelMessageBatchWithContext(ctx context.Context, batch_id string) (*ClaudeMessageBatch, error) {
+	if batch_id == "" {
+		return nil, errors.New("request failed: batch id is empty")
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, c.batchesUrl()+"/"+url.PathEscape(batch_id)+"/cancel", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.doBatchRequest(req)
+}
+
+// ClaudeGetMessageBatchResults return the result of every request on the ended batch.
+//
+// The result order is not the same as the request order, use the `CustomID` to match it or use
+// ClaudeWaitMessageBatchResults that return the results mapped by custom id. Each result can be checked with `Err()`.
+func (c *claudeAPI) ClaudeGetMessageBatchResults(batch_id string) ([]ClaudeBatchResult, error) {
+	return c.ClaudeGetMessageBatchResultsWithContext(context.Background(), batch_id)
+}
+
+// ClaudeGetMessageBatchResultsWithContext is the context-aware version of ClaudeGetMessageBatchResults, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeGetMessageBatchResultsWithContext(ctx context.Context, batch_id string) ([]ClaudeBatchResult, error) {
+	if batch_id == "" {
+		return nil, errors.New("request failed: batch id is empty")
+	}
+
+	// the batch results_url is the same as this url, derived here so custom base url (proxy or test server) keep working
+	req, err := c.newRequest(ctx, http.MethodGet, c.batchesUrl()+"/"+url.PathEscape(batch_id)+"/results", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	// the results is .jsonl file, one result for each line
+	var results []ClaudeBatchResult
+	decoder := json.NewDecoder(resp.Body)
+	for decoder.More() {
+		var result ClaudeBatchResult
+		if err := decoder.Decode(&result); err != nil {
+			return nil, errors.New("request failed: failed to decode batch result: " + err.Error())
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// ClaudeWaitMessageBatchResults poll the batch status every poll_interval until it ended, then return the results mapped by custom id.
+//
+// The wait is stopped when ctx is done, the batch keep processing on Claude side so it can be waited again later.
+// If poll_interval is 0 ClaudeBatchDefaultPollInterval is used.
+func (c *claudeAPI) ClaudeWaitMessageBatchResults(ctx context.Context, batch_id string, poll_interval time.Duration) (map[string]ClaudeBatchResult, error) {
+	if poll_interval <= 0 {
+		poll_interval = ClaudeBatchDefaultPollInterval
+	}
+
+	for {
+		batch, err := c.ClaudeGetMessageBatchWithContext(ctx, batch_id)
+		if err != nil {
+			return nil, err
+		}
+
+		if batch.ProcessingStatus == ClaudeBatchStatusEnded {
+			break
+		}
+
+		if err := sleepWithContext(ctx, poll_interval); err != nil {
+			return nil, fmt.Errorf("request failed: %w", err)
+		}
+	}
+
+	results, err := c.ClaudeGetMessageBatchResultsWithContext(ctx, batch_id)
+	if err != nil {
+		return nil, err
+	}
+
+	resultsByID := make(map[string]ClaudeBatchResult, len(results))
+	for _, result := range results {
+		resultsByID[result.CustomID] = result
+	}
+
+	return resultsByID, nil
+}
+
+// batchesUrl return the message batches endpoint, derived from the messages endpoint if not configured
+func (c *claudeAPI) batchesUrl() string {
+	if c.config.claudeBatchesUrl != "" {
+		return c.config.claudeBatchesUrl
+	}
+
+	return strings.TrimSuffix(c.config.claudeBaseUrl, "/") + "/batches"
+}
+
+// doBatchRequest send the request and decode the message batch response
+func (c *claudeAPI) doBatchRequest(req *http.Request) (*ClaudeMessageBatch, error) {
+	resp, err := c.doRequest(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var batch ClaudeMessageBatch
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, errors.New("request failed: failed to decode message batch: " + err.Error())
+	}
+
+	return &batch, nil
+}
@@ -0,0 +1,129 @@
+package claude
+
+import (
+	"context"
+	"strings"
+	"unicode"
+)
+
+// ClaudeSendMessageContinue sends a message to the Claude API like ClaudeSendMessage, and when the answer is cut
+// because it reach the max tokens, ask Claude to continue it up to max_continue times.
+//
+// The continuation is done by sending the same request again with the answer so far as the last assistant message
+// (prefill), so Claude continue the text from where it stopped. The text of every response is joined to one text block,
+// and the usage is the sum of all the requests because every request is billed.
+//
+// Parameters:
+//   - content, maxToken, with_custom_reqbody, req_body_custom: the same as ClaudeSendMessage.
+//     When the last message is an assistant message with string content, it is used as the prefix of the answer.
+//   - max_continue: the max number of continue request after the first request, 0 is the same as ClaudeSendMessage.
+//
+// Returns:
+//   - A pointer to `ClaudeResp` with the joined answer, the stop reason is from the last request, so it is still
+//     "max_tokens" (check with `IsTruncated`) when the answer is not finished after max_continue.
+//   - An error if any of the request fails, the answer so far is not returned.
+//
+// Considerations:
+//   - Only plain text answer is continued. The request with tools (structured output) or extended thinking is sent
+//     once, because the cut tool_use input and thinking block can not be continued with prefill.
+//   - The trailing whitespace of the answer so far is removed before sent as prefill (Claude reject it), so the
+//     joined answer follow the text Claude see.
+//   - Each continue request send the whole prompt again, the cost of the input tokens is paid every time.
+//
+// Example usage:
+//
+//	messages := []ClaudeMessageReq{
+//	    {Role: "user", Content: "Write a long story about a cat"},
+//	}
+//
+//	resp, err := claudeAPI.ClaudeSendMessageContinue(&messages, 1024, false, nil, 2)
+//	if err != nil {
+//	    log.Fatalf("Failed to send message to Claude: %v", err)
+//	}
+//	fmt.Println(resp.Text(), resp.IsTruncated())
+//
+// References:
+//   - Prefill Claude response: https://docs.anthropic.com/en/docs/build-with-claude/prompt-engineering/prefill-claudes-response
+func (c *claudeAPI) ClaudeSendMessageContinue(content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, max_continue int) (*ClaudeResp, error) {
+	return c.ClaudeSendMessageContinueWithContext(context.Background(), content, maxToken, with_custom_reqbody, req_body_custom, max_continue)
+}
+
+// ClaudeSendMessageContinueWithContext is the context-aware version of ClaudeSendMessageContinue, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeSendMessageContinueWithContext(ctx context.Context, content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, max_continue int) (*ClaudeResp, error) {
+	reqBody, err := c.createReqBody(content, maxToken, with_custom_reqbody, req_body_custom)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := reqBody.Messages
+	prefill := ""
+	canContinue := len(reqBody.Tools) == 0 && reqBody.Thinking == nil
+
+	// the assistant prefill from the caller is the start of the answer, the continuation is added after it
+	if last := len(messages) - 1; last >= 0 && messages[last].Role == "assistant" {
+		text, ok := messages[last].Content.(string)
+		if ok {
+			prefill = text
+			messages = messages[:last]
+		} else {
+			canContinue = false
+		}
+	}
+
+	var result *ClaudeResp
+	answer := ""
+
+	for attempt := 0; ; attempt++ {
+		claudeResp, err := c.ClaudeSendMessageWithContext(ctx, nil, 0, true, reqBody)
+		if err != nil {
+			return nil, err
+		}
+
+		if result == nil {
+			result = claudeResp
+		} else {
+			result.StopReason = claudeResp.StopReason
+			result.StopSequence = claudeResp.StopSequence
+			result.Usage.InputTokens += claudeResp.Usage.InputTokens
+			result.Usage.OutputTokens += claudeResp.Usage.OutputTokens
+			result.Usage.CacheCreationInputTokens += claudeResp.Usage.CacheCreationInputTokens
+			result.Usage.CacheReadInputTokens += claudeResp.Usage.CacheReadInputTokens
+		}
+		answer += claudeResp.Text()
+
+		if !canContinue || !claudeResp.IsTruncated() || attempt >= max_continue || !isTextOnly(claudeResp) {
+			break
+		}
+
+		// Claude reject the prefill that end with whitespace, the continuation usually start with it again
+		answer = strings.TrimRightFunc(answer, unicode.IsSpace)
+		if prefill+answer == "" {
+			break
+		}
+
+		nextBody := *reqBody
+		nextBody.Messages = append(append([]ClaudeMessageReq{}, messages...), ClaudeMessageReq{
+			Role:    "assistant",
+			Content: prefill + answer,
+		})
+		reqBody = &nextBody
+	}
+
+	// only replaced when continued, so the single response keep its blocks as is
+	if result.Text() != answer {
+		result.Content = []ClaudeContentResp{{Type: ClaudeContentTypeText, Text: answer}}
+	}
+
+	return result, nil
+}
+
+// isTextOnly return true when the response only has text block, so it can be continued with prefill
+func isTextOnly(claudeResp *ClaudeResp) bool {
+	for _, content := range claudeResp.Content {
+		if content.Type != ClaudeContentTypeText {
+			return false
+		}
+	}
+
+	return true
+}
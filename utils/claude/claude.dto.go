@@ -1,5 +1,7 @@
 package claude
 
+import "encoding/json"
+
 // message bidy content structure
 type ClaudeMessageReq struct {
 	Role    string      `json:"role"`
@@ -16,9 +18,23 @@ type ClaudeVisionSource struct {
 
 // Struct untuk data tipe image dan text
 type ClaudeVisionContentBase struct {
-	Type   string              `json:"type"`
-	Source *ClaudeVisionSource `json:"source,omitempty"` // Using pointer to allow nil value
-	Text   *string             `json:"text,omitempty"`   // using pointer to allow nil value
+	Type         string              `json:"type"`
+	Source       *ClaudeVisionSource `json:"source,omitempty"`        // Using pointer to allow nil value
+	Text         *string             `json:"text,omitempty"`          // using pointer to allow nil value
+	CacheControl *ClaudeCacheControl `json:"cache_control,omitempty"` // cache breakpoint, the prompt until this block is cached
+}
+
+// prompt caching marker, only "ephemeral" type is supported
+// https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching
+type ClaudeCacheControl struct {
+	Type string `json:"type"`
+}
+
+// system prompt block, used instead of plain string system prompt when the system prompt need cache_control
+type ClaudeSystemBlock struct {
+	Type         string              `json:"type"` // text
+	Text         string              `json:"text"`
+	CacheControl *ClaudeCacheControl `json:"cache_control,omitempty"`
 }
 
 // claude full request body structure with all possible fields
@@ -29,12 +45,20 @@ type ClaudeReqBody struct {
 	Metadata      map[string]interface{}   `json:"metadata,omitempty"`
 	StopSequences []string                 `json:"stop_sequences,omitempty"`
 	Stream        bool                     `json:"stream,omitempty"`
-	System        string                   `json:"system,omitempty"`
+	System        interface{}              `json:"system,omitempty"`      // string or []ClaudeSystemBlock
 	Temperature   float64                  `json:"temperature,omitempty"` // default 1.0
+	Thinking      *ClaudeThinkingConfig    `json:"thinking,omitempty"`    // extended thinking, the response then start with thinking block
 	ToolChoice    map[string]interface{}   `json:"tool_choice,omitempty"`
 	Tools         []map[string]interface{} `json:"tools,omitempty"`
 }
 
+// extended thinking config, budget_tokens must be at least 1024 and less than max_tokens
+// https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking
+type ClaudeThinkingConfig struct {
+	Type         string `json:"type"` // enabled
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
 // Claude 4xx error response structure
 type ClaudeRespError struct {
 	Type  string `json:"type"`
@@ -44,9 +68,16 @@ type ClaudeRespError struct {
 	} `json:"error"`
 }
 
+// one content block of the response, the field used depend on the type (see ClaudeContentType constants)
 type ClaudeContentResp struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type      string          `json:"type"` // text, tool_use, thinking, or redacted_thinking
+	Text      string          `json:"text"`
+	ID        string          `json:"id,omitempty"`        // tool_use id
+	Name      string          `json:"name,omitempty"`      // tool_use name
+	Input     json.RawMessage `json:"input,omitempty"`     // tool_use input, json object follow the tool input_schema
+	Thinking  string          `json:"thinking,omitempty"`  // thinking text, only when extended thinking is enabled
+	Signature string          `json:"signature,omitempty"` // thinking signature, must be sent back unchanged on the next turn
+	Data      string          `json:"data,omitempty"`      // redacted_thinking encrypted data, must be sent back unchanged
 }
 
 // claude full response structure on chat completions
@@ -56,10 +87,103 @@ type ClaudeResp struct {
 	Role         string              `json:"role"`
 	Content      []ClaudeContentResp `json:"content"`
 	Model        string              `json:"model"`
-	StopReason   string              `json:"stop_reason"`
+	StopReason   string              `json:"stop_reason"` // see ClaudeStopReason constants
 	StopSequence string              `json:"stop_sequence"`
-	Usage        struct {
-		InputTokens  int `json:"input_tokens"`
-		OutputTokens int `json:"output_tokens"`
-	} `json:"usage"`
+	Usage        ClaudeUsage         `json:"usage"`
+}
+
+type ClaudeUsage struct {
+	InputTokens  int `json:"input_tokens"` // input tokens after the last cache breakpoint, not including the cache tokens below
+	OutputTokens int `json:"output_tokens"`
+	// prompt caching tokens, written is priced higher than the input tokens and read is much cheaper
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// claude streaming event structure, each "data:" line on the server-sent events decoded to this struct
+// https://docs.anthropic.com/en/api/messages-streaming
+type ClaudeStreamEvent struct {
+	Type         string             `json:"type"` // message_start, content_block_start, content_block_delta, content_block_stop, message_delta, message_stop, ping, error
+	Index        int                `json:"index"`
+	Message      *ClaudeResp        `json:"message,omitempty"`       // on message_start
+	ContentBlock *ClaudeContentResp `json:"content_block,omitempty"` // on content_block_start
+	Delta        *ClaudeStreamDelta `json:"delta,omitempty"`         // on content_block_delta and message_delta
+	Usage        *ClaudeUsage       `json:"usage,omitempty"`         // on message_delta, contain the final output tokens
+	Error        *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"` // on error event
+}
+
+type ClaudeStreamDelta struct {
+	Type         string `json:"type"` // text_delta, input_json_delta, thinking_delta, or signature_delta on content_block_delta
+	Text         string `json:"text"`
+	PartialJson  string `json:"partial_json"` // tool_use input chunk on input_json_delta
+	Thinking     string `json:"thinking"`     // on thinking_delta
+	Signature    string `json:"signature"`    // on signature_delta
+	StopReason   string `json:"stop_reason"`  // on message_delta
+	StopSequence string `json:"stop_sequence"`
+}
+
+// ----------------- COUNT TOKENS ------ Reference for Count Message Tokens
+//   - Claude Docs: https://docs.anthropic.com/en/api/messages-count-tokens
+
+// same as the /messages request body without the generation field (max_tokens, stream, temperature, ...)
+type ClaudeCountTokensReq struct {
+	Model      string                   `json:"model"`    // required
+	Messages   []ClaudeMessageReq       `json:"messages"` // required
+	System     interface{}              `json:"system,omitempty"`
+	ToolChoice map[string]interface{}   `json:"tool_choice,omitempty"`
+	Tools      []map[string]interface{} `json:"tools,omitempty"`
+}
+
+type ClaudeCountTokensResp struct {
+	InputTokens int    `json:"input_tokens"` // the prompt tokens including the system prompt, tools, and image
+	Model       string `json:"-"`            // the model used to count, filled by the client
+}
+
+// ----------------- MESSAGE BATCHES ------ Reference for Message Batches
+//   - Claude Docs: https://docs.anthropic.com/en/api/creating-message-batches
+
+// one request on the batch, custom_id is used to match the result because the result order is not guaranteed
+type ClaudeBatchRequest struct {
+	CustomID string        `json:"custom_id"` // required, unique on the batch, must match regex ^[a-zA-Z0-9_-]{1,64}$
+	Params   ClaudeReqBody `json:"params"`    // required, same as the /messages request body, stream is not supported
+}
+
+type ClaudeBatchCreateReq struct {
+	Requests []ClaudeBatchRequest `json:"requests"`
+}
+
+// message batch status, returned on create, get, and cancel
+type ClaudeMessageBatch struct {
+	ID                string                   `json:"id"`
+	Type              string                   `json:"type"`              // message_batch
+	ProcessingStatus  string                   `json:"processing_status"` // in_progress, canceling, or ended
+	RequestCounts     ClaudeBatchRequestCounts `json:"request_counts"`
+	EndedAt           *string                  `json:"ended_at"`
+	CreatedAt         string                   `json:"created_at"`
+	ExpiresAt         string                   `json:"expires_at"`
+	CancelInitiatedAt *string                  `json:"cancel_initiated_at"`
+	ResultsUrl        *string                  `json:"results_url"` // available after the batch ended
+}
+
+type ClaudeBatchRequestCounts struct {
+	Processing int `json:"processing"`
+	Succeeded  int `json:"succeeded"`
+	Errored    int `json:"errored"`
+	Canceled   int `json:"canceled"`
+	Expired    int `json:"expired"`
+}
+
+// one line of the batch results .jsonl file
+type ClaudeBatchResult struct {
+	CustomID string                `json:"custom_id"`
+	Result   ClaudeBatchResultData `json:"result"`
+}
+
+type ClaudeBatchResultData struct {
+	Type    string           `json:"type"`              // succeeded, errored, canceled, or expired
+	Message *ClaudeResp      `json:"message,omitempty"` // on succeeded
+	Error   *ClaudeRespError `json:"error,omitempty"`   // on errored
 }
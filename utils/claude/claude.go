@@ -2,24 +2,50 @@ package claude
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type ClaudeAPI interface {
 	ClaudeSendMessage(content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeResp, error)
+	ClaudeSendMessageWithContext(ctx context.Context, content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeResp, error)
 	ClaudeGetFirstContentDataResp(prompt *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeContentResp, error)
+	ClaudeGetFirstContentDataRespWithContext(ctx context.Context, prompt *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeContentResp, error)
+	ClaudeSendMessageContinue(content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, max_continue int) (*ClaudeResp, error)
+	ClaudeSendMessageContinueWithContext(ctx context.Context, content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, max_continue int) (*ClaudeResp, error)
+	ClaudeSendMessageStream(content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, on_delta func(text string) error) (*ClaudeResp, error)
+	ClaudeSendMessageStreamWithContext(ctx context.Context, content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, on_delta func(text string) error) (*ClaudeResp, error)
+	ClaudeGetStructuredDataResp(prompt *[]ClaudeMessageReq, maxToken int, json_name string, json_schema map[string]interface{}) (json.RawMessage, error)
+	ClaudeGetStructuredDataRespWithContext(ctx context.Context, prompt *[]ClaudeMessageReq, maxToken int, json_name string, json_schema map[string]interface{}) (json.RawMessage, error)
+	ClaudeCreateMessageBatch(requests []ClaudeBatchRequest) (*ClaudeMessageBatch, error)
+	ClaudeCreateMessageBatchWithContext(ctx context.Context, requests []ClaudeBatchRequest) (*ClaudeMessageBatch, error)
+	ClaudeGetMessageBatch(batch_id string) (*ClaudeMessageBatch, error)
+	ClaudeGetMessageBatchWithContext(ctx context.Context, batch_id string) (*ClaudeMessageBatch, error)
+	ClaudeGetMessageBatchResults(batch_id string) ([]ClaudeBatchResult, error)
+	ClaudeGetMessageBatchResultsWithContext(ctx context.Context, batch_id string) ([]ClaudeBatchResult, error)
+	ClaudeCancelMessageBatch(batch_id string) (*ClaudeMessageBatch, error)
+	ClaudeCancelMessageBatchWithContext(ctx context.Context, batch_id string) (*ClaudeMessageBatch, error)
+	ClaudeWaitMessageBatchResults(ctx context.Context, batch_id string, poll_interval time.Duration) (map[string]ClaudeBatchResult, error)
+	ClaudeCountTokens(content *[]ClaudeMessageReq, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeCountTokensResp, error)
+	ClaudeCountTokensWithContext(ctx context.Context, content *[]ClaudeMessageReq, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeCountTokensResp, error)
 }
 
 // Config holds the configuration for Claude API client
 type Config struct {
 	httpClient             *http.Client
 	claudeBaseUrl          string
+	claudeBatchesUrl       string // if empty derived from claudeBaseUrl + "/batches"
+	claudeCountTokensUrl   string // if empty derived from claudeBaseUrl + "/count_tokens"
 	claudeModel            string
 	claudeAnthropicVersion string
+	retryPolicy            RetryPolicy
+	requestGate            RequestGate // nil mean every request is sent
 }
 
 // default configuration for Claude API client
@@ -67,9 +93,15 @@ type ClientOption func(*Config)
 //   - claudeBaseUrl: The default base URL for the Claude API is set to the `/messages` endpoint,
 //     as this is currently the primary endpoint available for both text and vision requests.
 //     The default value is `"https://api.anthropic.com/v1/messages"`.
+//   - claudeBatchesUrl: The Message Batches endpoint, by default empty so the URL is derived from the base URL
+//     (`"https://api.anthropic.com/v1/messages/batches"`). Use `WithBatchesUrl` to override it.
+//   - claudeCountTokensUrl: The token counting endpoint, by default empty so the URL is derived from the base URL
+//     (`"https://api.anthropic.com/v1/messages/count_tokens"`). Use `WithCountTokensUrl` to override it.
 //   - claudeModel: The default model for message processing is `"claude-3-5-sonnet-20240620"`, which specifies
 //     the Claude model version that will be used to generate responses.
 //   - claudeAnthropicVersion: The API version used for interacting with Claude. The default value is `"2021-06-01"`.
+//   - retryPolicy: Failed request is not retried by default, use `WithRetryPolicy(DefaultRetryPolicy())` to retry
+//     rate limited and overloaded response.
 //
 // Example usage:
 //
@@ -143,6 +175,22 @@ func WithBaseUrl(baseUrl string) ClientOption {
 	}
 }
 
+// custom options for configuring the Claude API client, use it on New function initiate.
+// By default the message batches url is the base url + "/batches", set this if the base url is not the /messages endpoint
+func WithBatchesUrl(batchesUrl string) ClientOption {
+	return func(c *Config) {
+		c.claudeBatchesUrl = batchesUrl
+	}
+}
+
+// custom options for configuring the Claude API client, use it on New function initiate.
+// By default the count tokens url is the base url + "/count_tokens", set this if the base url is not the /messages endpoint
+func WithCountTokensUrl(countTokensUrl string) ClientOption {
+	return func(c *Config) {
+		c.claudeCountTokensUrl = countTokensUrl
+	}
+}
+
 // custom options for configuring the Claude API client, use it on New function initiate
 func WithModel(model string) ClientOption {
 	return func(c *Config) {
@@ -260,6 +308,190 @@ func ClaudeCreateOneContentImageVisionBase64(media_type string, encode_file_base
 	return content, nil
 }
 
+// the only cache_control type, the cache live for 5 minutes since it is last used
+const ClaudeCacheTypeEphemeral = "ephemeral"
+
+// ClaudeCreateTextContent creates one text content block, with cache breakpoint when cache is true.
+//
+// Prompt caching let Claude reuse the processed prompt prefix from the previous request, so the long repeated
+// part of the prompt (instruction, document, conversation so far) is cheaper and faster on the next request.
+// The cached prefix is everything from the tools, the system prompt, and the messages until the block with the
+// cache breakpoint, so the static part should be placed first and the changing part after the breakpoint.
+//
+// Parameters:
+//   - text: A string representing the text content.
+//   - cache: A boolean, if true the block is marked with `cache_control` of type "ephemeral".
+//
+// Returns:
+//   - ClaudeVisionContentBase: text content block that can be used on `ClaudeMessageReq.Content` as []ClaudeVisionContentBase.
+//
+// Considerations:
+//   - Max 4 cache breakpoint on one request, the cache is also looked up on the earlier block boundary (up to 20 block)
+//     so one breakpoint at the end of growing conversation still hit the cache written by the previous request.
+//   - Prefix shorter than the model minimum (1024 tokens, 2048 tokens on Haiku) is not cached without any error.
+//   - The cache live for 5 minutes and refreshed every time it is used. Writing the cache cost 25% more than the
+//     input token and reading it cost 10% of the input token, see ClaudeUsage for the cache tokens.
+//
+// Example usage:
+//
+//	content := []ClaudeVisionContentBase{
+//	    ClaudeCreateTextContent(storySoFar, true),
+//	    ClaudeCreateTextContent("Continue the story", false),
+//	}
+//
+//	reqBody := ClaudeReqBody{
+//	    MaxTokens: 1024,
+//	    System:    []ClaudeSystemBlock{ClaudeCreateSystemContent(instruction, true)},
+//	    Messages:  []ClaudeMessageReq{{Role: "user", Content: content}},
+//	}
+//
+// References:
+//   - Claude prompt caching: https://docs.anthropic.com/en/docs/build-with-claude/prompt-caching
+func ClaudeCreateTextContent(text string, cache bool) ClaudeVisionContentBase {
+	content := ClaudeVisionContentBase{
+		Type: "text",
+		Text: &text,
+	}
+
+	if cache {
+		content.CacheControl = &ClaudeCacheControl{Type: ClaudeCacheTypeEphemeral}
+	}
+
+	return content
+}
+
+// ClaudeCreateSystemContent creates one system prompt block, with cache breakpoint when cache is true.
+// See ClaudeCreateTextContent for how the prompt caching work
+func ClaudeCreateSystemContent(text string, cache bool) ClaudeSystemBlock {
+	block := ClaudeSystemBlock{
+		Type: "text",
+		Text: text,
+	}
+
+	if cache {
+		block.CacheControl = &ClaudeCacheControl{Type: ClaudeCacheTypeEphemeral}
+	}
+
+	return block
+}
+
+// ClaudeCreateResponseFormat creates the tools and tool_choice data to force Claude answer with structured JSON output.
+//
+// Claude don't have response format like OpenAI (see openai.OACreateResponseFormat), so the structured output is done
+// by using tool use. One tool is created with the given JSON schema as the `input_schema` and the `tool_choice` is set to
+// force Claude to call that tool, so the answer will be a `tool_use` content block with `input` that follow the schema.
+//
+// Parameters:
+//   - jsonName: A string representing the name of the tool (and the JSON schema), must match regex ^[a-zA-Z0-9_-]{1,64}$.
+//   - jsonSchema: A map of string to interface, representing the JSON schema data of the output, the root type must be "object".
+//
+// Returns:
+//   - tools: the value for `ClaudeReqBody.Tools`
+//   - toolChoice: the value for `ClaudeReqBody.ToolChoice`
+//
+// Example usage:
+//
+//	tools, toolChoice := ClaudeCreateResponseFormat("titles_choices", map[string]interface{}{
+//	    "type": "object",
+//	    "properties": map[string]interface{}{
+//	        "title": map[string]interface{}{"type": "string"},
+//	    },
+//	})
+//
+//	reqBody := ClaudeReqBody{
+//	    MaxTokens:  1024,
+//	    Messages:   messages,
+//	    Tools:      tools,
+//	    ToolChoice: toolChoice,
+//	}
+//
+//	resp, err := claudeAPI.ClaudeSendMessage(nil, 0, true, &reqBody)
+//	if err != nil {
+//	    log.Fatalf("Failed to send message to Claude: %v", err)
+//	}
+//
+//	input, err := ClaudeGetToolUseInput(resp, "titles_choices")
+//
+// References:
+//   - Claude tool use JSON mode: https://docs.anthropic.com/en/docs/build-with-claude/tool-use#json-mode
+func ClaudeCreateResponseFormat(jsonName string, jsonSchema map[string]interface{}) ([]map[string]interface{}, map[string]interface{}) {
+	tools := []map[string]interface{}{
+		{
+			"name":         jsonName,
+			"description":  "Return the answer using this tool with the input follow the given JSON schema",
+			"input_schema": jsonSchema,
+		},
+	}
+
+	toolChoice := map[string]interface{}{
+		"type": "tool",
+		"name": jsonName,
+	}
+
+	return tools, toolChoice
+}
+
+// content block type on the response
+const (
+	ClaudeContentTypeText             = "text"
+	ClaudeContentTypeToolUse          = "tool_use"
+	ClaudeContentTypeThinking         = "thinking"
+	ClaudeContentTypeRedactedThinking = "redacted_thinking"
+)
+
+// why Claude stopped generating, the value of `stop_reason` on the response
+const (
+	ClaudeStopReasonEndTurn      = "end_turn"
+	ClaudeStopReasonMaxTokens    = "max_tokens" // the answer is cut, see TruncatedError
+	ClaudeStopReasonStopSequence = "stop_sequence"
+	ClaudeStopReasonToolUse      = "tool_use"
+)
+
+// Text return all the text content blocks concatenated, the thinking and tool_use blocks are skipped.
+//
+// Claude can answer with more than one text block, for example the text before and after a tool_use block or
+// the answer after the thinking block, so use this instead of reading the first content block.
+// Empty string is returned when the response has no text block.
+func (r *ClaudeResp) Text() string {
+	var text strings.Builder
+
+	for _, content := range r.Content {
+		if content.Type == ClaudeContentTypeText {
+			text.WriteString(content.Text)
+		}
+	}
+
+	return text.String()
+}
+
+// IsTruncated return true when the answer is cut because it reach the max tokens
+func (r *ClaudeResp) IsTruncated() bool {
+	return r.StopReason == ClaudeStopReasonMaxTokens
+}
+
+// ClaudeGetToolUseInput get the `input` of the first `tool_use` content block with the given tool name from Claude response.
+//
+// The returned value is the raw JSON object, so caller can decode it directly to the target struct.
+// An error is returned when Claude not calling the tool, it is *TruncatedError when the tool input is cut because of max tokens
+// (the input would be incomplete JSON) and ErrEmptyContent when the response has no content at all.
+func ClaudeGetToolUseInput(claudeResp *ClaudeResp, toolName string) (json.RawMessage, error) {
+	if claudeResp.IsTruncated() {
+		return nil, &TruncatedError{Resp: claudeResp}
+	}
+
+	for _, content := range claudeResp.Content {
+		if content.Type == ClaudeContentTypeToolUse && content.Name == toolName {
+			return content.Input, nil
+		}
+	}
+
+	if len(claudeResp.Content) == 0 {
+		return nil, ErrEmptyContent
+	}
+
+	return nil, errors.New("Claude response not contain tool_use " + toolName + " with stop reason: " + claudeResp.StopReason)
+}
+
 // ClaudeSendMessage sends a message to the Claude API and returns the response.
 //
 // This function constructs and sends a request to Claude, either using a custom request body or
@@ -325,54 +557,26 @@ func ClaudeCreateOneContentImageVisionBase64(media_type string, encode_file_base
 // References:
 //   - Official Claude API documentation: https://docs.anthropic.com/en/api/messages
 func (c *claudeAPI) ClaudeSendMessage(content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeResp, error) {
+	return c.ClaudeSendMessageWithContext(context.Background(), content, maxToken, with_custom_reqbody, req_body_custom)
+}
 
-	var reqBody interface{}
-
-	apiKey := c.apiKey
-	if apiKey == "" {
-		return nil, errors.New("API Key is empty")
-	}
-
-	if with_custom_reqbody && req_body_custom == nil {
-		return nil, errors.New("request failed: custom request body is empty")
-	}
-
-	if !with_custom_reqbody && content == nil {
-		return nil, errors.New("request failed: content is empty")
-	}
-
-	if with_custom_reqbody {
-		reqBody = req_body_custom
-
-	} else {
-		reqBody = ClaudeReqBody{
-			Model:       c.config.claudeModel,
-			MaxTokens:   maxToken,
-			Messages:    *content,
-			Temperature: 1.0, // default value from docs
-		}
-	}
+// ClaudeSendMessageWithContext is the context-aware version of ClaudeSendMessage, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeSendMessageWithContext(ctx context.Context, content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeResp, error) {
 
-	reqBodyJson, err := json.Marshal(reqBody)
+	reqBody, err := c.createReqBody(content, maxToken, with_custom_reqbody, req_body_custom)
 	if err != nil {
-		return nil, errors.New("request failed: " + err.Error())
+		return nil, err
 	}
 
-	// send request to Claude
-	req, err := http.NewRequest(http.MethodPost, c.config.claudeBaseUrl, bytes.NewBuffer(reqBodyJson))
+	req, err := c.createRequest(ctx, reqBody)
 	if err != nil {
-		return nil, errors.New("request failed: " + err.Error())
+		return nil, err
 	}
 
-	req.Header.Set("x-api-key", apiKey)
-	req.Header.Set("anthropic-version", c.config.claudeAnthropicVersion)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := c.config.httpClient
-
-	resp, err := client.Do(req)
+	resp, err := c.doRequest(req)
 	if err != nil {
-		return nil, errors.New("request failed: " + err.Error())
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer func() {
 		if resp.StatusCode != http.StatusOK {
@@ -383,12 +587,7 @@ func (c *claudeAPI) ClaudeSendMessage(content *[]ClaudeMessageReq, maxToken int,
 
 	// error handling status
 	if resp.StatusCode != http.StatusOK {
-		var errClaude ClaudeRespError
-		if err := json.NewDecoder(resp.Body).Decode(&errClaude); err != nil {
-			return nil, errors.New("request failed with status code: " + resp.Status)
-		}
-
-		return nil, errors.New("Claude API response error: " + resp.Status + " with message: " + errClaude.Error.Message + " type: " + errClaude.Error.Type)
+		return nil, newAPIError(resp)
 	}
 
 	// decode response from Claude to map
@@ -400,7 +599,7 @@ func (c *claudeAPI) ClaudeSendMessage(content *[]ClaudeMessageReq, maxToken int,
 	return &result, nil
 }
 
-// ClaudeGetFirstContentDataResp sends a prompt to the Claude API and returns the first content response.
+// ClaudeGetFirstContentDataResp sends a prompt to the Claude API and returns the first text content response.
 //
 // Notes: --
 // This function is designed to send a message to the Claude API using the provided prompt,
@@ -415,8 +614,9 @@ func (c *claudeAPI) ClaudeSendMessage(content *[]ClaudeMessageReq, maxToken int,
 //     If this value is nil when `with_custom_reqbody` is true, an error is returned.
 //
 // Returns:
-//   - A pointer to `ClaudeContentResp`, representing the first content element returned by Claude in the response.
-//   - An error if the request fails or if there is an issue extracting the content.
+//   - A pointer to `ClaudeContentResp`, representing the first text content element returned by Claude in the response.
+//   - An error if the request fails or if there is an issue extracting the content, ErrEmptyContent when the response
+//     has no text content, and *TruncatedError when the answer is cut because of max tokens (the partial response is on the error).
 //
 // Example usage:
 //
@@ -437,34 +637,151 @@ func (c *claudeAPI) ClaudeSendMessage(content *[]ClaudeMessageReq, maxToken int,
 //  1. **ClaudeSendMessage Call**: This function internally calls `ClaudeSendMessage` to send the provided prompt to Claude.
 //     It uses the default request body (without custom modifications) and a specified maximum token limit.
 //  2. **Response Parsing**: Once the response is returned by `ClaudeSendMessage`, the function extracts the `Content` field from the response.
-//  3. **First Content Extraction**: The function retrieves the first element from the `Content` array of `ClaudeResp`.
-//     If successful, this content is returned as `ClaudeContentResp`.
+//  3. **First Content Extraction**: The function retrieves the first `text` element from the `Content` array of `ClaudeResp`,
+//     the thinking block that come before the answer is skipped. If successful, this content is returned as `ClaudeContentResp`.
 //  4. **Error Handling**: If there is any error in sending the request or parsing the response, the error is returned directly.
 //
 // Notes:
 //   - This function simplifies the process of retrieving the first content element from a Claude API response.
-//   - The `Content` field in the Claude response is an array, and this function assumes at least one element is present.
-//     If the array is empty, this would result in an index error.
+//   - The `Content` field in the Claude response is an array that can be empty, in that case ErrEmptyContent is returned.
+//   - Claude can split the answer into more than one text block, use ClaudeSendMessage and `ClaudeResp.Text()` to get all of them.
 //   - The `ClaudeContentResp` structure contains the `Type` and `Text` fields representing the response content.
 //
 // Considerations:
-//   - You may need to check for errors in the returned content, such as if the array is empty or the first element is invalid.
+//   - Use errors.As with *TruncatedError to still read the cut answer, or ClaudeSendMessageContinue to continue it automatically.
 //   - This function is designed to handle textual responses, though the Claude API can also support other content types (e.g., vision data) that still you can pass image data here on base64 encoding with structure that Claude needs.
 //
 // References:
 //   - Official Claude API documentation: https://docs.anthropic.com/en/api/messages
 func (c *claudeAPI) ClaudeGetFirstContentDataResp(prompt *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeContentResp, error) {
+	return c.ClaudeGetFirstContentDataRespWithContext(context.Background(), prompt, maxToken, with_custom_reqbody, req_body_custom)
+}
+
+// ClaudeGetFirstContentDataRespWithContext is the context-aware version of ClaudeGetFirstContentDataResp, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeGetFirstContentDataRespWithContext(ctx context.Context, prompt *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeContentResp, error) {
+
 	// send request to Claude
-	claudeResp, err := c.ClaudeSendMessage(prompt, maxToken, with_custom_reqbody, req_body_custom)
+	claudeResp, err := c.ClaudeSendMessageWithContext(ctx, prompt, maxToken, with_custom_reqbody, req_body_custom)
 	if err != nil {
 		return nil, err
 	}
 
-	// with response example above
-	// get content key from map and type assert as array of interface
-	// get first element from array of interface and type assert as map
-	content := claudeResp.Content[0]
+	if claudeResp.IsTruncated() {
+		return nil, &TruncatedError{Resp: claudeResp}
+	}
+
+	// the answer is the first text block, thinking block is placed before it
+	for _, content := range claudeResp.Content {
+		if content.Type == ClaudeContentTypeText {
+			return &content, nil
+		}
+	}
+
+	return nil, ErrEmptyContent
+}
+
+// createReqBody validate the input and create the request body used by ClaudeSendMessage and ClaudeSendMessageStream
+func (c *claudeAPI) createReqBody(content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeReqBody, error) {
+	if c.apiKey == "" {
+		return nil, errors.New("API Key is empty")
+	}
+
+	if with_custom_reqbody && req_body_custom == nil {
+		return nil, errors.New("request failed: custom request body is empty")
+	}
+
+	if !with_custom_reqbody && content == nil {
+		return nil, errors.New("request failed: content is empty")
+	}
+
+	if with_custom_reqbody {
+		// copy so the caller custom body not changed when the stream flag is set
+		reqBody := *req_body_custom
+		if reqBody.Model == "" {
+			reqBody.Model = c.config.claudeModel
+		}
+		return &reqBody, nil
+	}
+
+	return &ClaudeReqBody{
+		Model:       c.config.claudeModel,
+		MaxTokens:   maxToken,
+		Messages:    *content,
+		Temperature: 1.0, // default value from docs
+	}, nil
+}
+
+// createRequest create the http request to Claude messages endpoint with all the needed headers
+func (c *claudeAPI) createRequest(ctx context.Context, reqBody *ClaudeReqBody) (*http.Request, error) {
+	return c.newRequest(ctx, http.MethodPost, c.config.claudeBaseUrl, reqBody)
+}
+
+// newRequest create the http request to any Claude endpoint with all the needed headers, nil body send no body
+func (c *claudeAPI) newRequest(ctx context.Context, method string, url string, body interface{}) (*http.Request, error) {
+	var reqBody io.Reader
+	if body != nil {
+		reqBodyJson, err := json.Marshal(body)
+		if err != nil {
+			return nil, errors.New("request failed: " + err.Error())
+		}
+		reqBody = bytes.NewBuffer(reqBodyJson)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return nil, errors.New("request failed: " + err.Error())
+	}
+
+	req.Header.Set("x-api-key", c.apiKey)
+	req.Header.Set("anthropic-version", c.config.claudeAnthropicVersion)
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// ClaudeGetStructuredDataResp sends a prompt to Claude and returns the structured JSON data that follow the given JSON schema.
+//
+// This function is the structured output version of ClaudeGetFirstContentDataResp, it use ClaudeCreateResponseFormat to
+// force Claude to answer with tool use and return the tool `input` (raw JSON object) that can be decoded straight away.
+//
+// Example usage:
+//
+//	var parsed models.StoriesCreateTitleFormat
+//
+//	data, err := claudeAPI.ClaudeGetStructuredDataResp(&messages, 1024, "titles_choices", jsonSchema)
+//	if err != nil {
+//	    log.Fatalf("Failed to get structured data: %v", err)
+//	}
+//
+//	if err := json.Unmarshal(data, &parsed); err != nil {
+//	    log.Fatalf("Failed to decode structured data: %v", err)
+//	}
+func (c *claudeAPI) ClaudeGetStructuredDataResp(prompt *[]ClaudeMessageReq, maxToken int, json_name string, json_schema map[string]interface{}) (json.RawMessage, error) {
+	return c.ClaudeGetStructuredDataRespWithContext(context.Background(), prompt, maxToken, json_name, json_schema)
+}
+
+// ClaudeGetStructuredDataRespWithContext is the context-aware version of ClaudeGetStructuredDataResp, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeGetStructuredDataRespWithContext(ctx context.Context, prompt *[]ClaudeMessageReq, maxToken int, json_name string, json_schema map[string]interface{}) (json.RawMessage, error) {
+
+	if prompt == nil {
+		return nil, errors.New("request failed: content is empty")
+	}
+
+	tools, toolChoice := ClaudeCreateResponseFormat(json_name, json_schema)
+
+	reqBody := ClaudeReqBody{
+		Model:       c.config.claudeModel,
+		MaxTokens:   maxToken,
+		Messages:    *prompt,
+		Temperature: 1.0,
+		Tools:       tools,
+		ToolChoice:  toolChoice,
+	}
+
+	claudeResp, err := c.ClaudeSendMessageWithContext(ctx, nil, 0, true, &reqBody)
+	if err != nil {
+		return nil, err
+	}
 
-	// return the message content as interface
-	return &content, nil
+	return ClaudeGetToolUseInput(claudeResp, json_name)
 }
@@ -0,0 +1,187 @@
+package claude
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ClaudeSendMessageStream sends a message to the Claude API with streaming enabled and forward each text token to on_delta.
+//
+// The parameters is the same as ClaudeSendMessage, with additional `on_delta` callback that called for every
+// `content_block_delta` event with `text_delta` or `input_json_delta` (tool_use input when using ClaudeCreateResponseFormat) type. If `on_delta` return error, the stream is stopped and the error returned,
+// this can be used to stop the upstream request when the client is gone. The `thinking_delta` is not forwarded, it is only kept on the thinking block.
+//
+// Returns:
+//   - A pointer to `ClaudeResp` build from the stream events, each content block contain the full text (or tool_use input)
+//     concatenated from all the deltas, and the usage is taken from `message_start` (input tokens) and `message_delta` (output tokens).
+//   - An error if the request fails, Claude send `error` event, or the stream ended before `message_stop`.
+//
+// Example usage:
+//
+//	messages := []ClaudeMessageReq{
+//	    {Role: "user", Content: "Tell me a story"},
+//	}
+//
+//	resp, err := claudeAPI.ClaudeSendMessageStream(&messages, 1024, false, nil, func(text string) error {
+//	    fmt.Print(text)
+//	    return nil
+//	})
+//	if err != nil {
+//	    log.Fatalf("Failed to stream message from Claude: %v", err)
+//	}
+//
+// References:
+//   - Official Claude streaming documentation: https://docs.anthropic.com/en/api/messages-streaming
+func (c *claudeAPI) ClaudeSendMessageStream(content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, on_delta func(text string) error) (*ClaudeResp, error) {
+	return c.ClaudeSendMessageStreamWithContext(context.Background(), content, maxToken, with_custom_reqbody, req_body_custom, on_delta)
+}
+
+// ClaudeSendMessageStreamWithContext is the context-aware version of ClaudeSendMessageStream, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeSendMessageStreamWithContext(ctx context.Context, content *[]ClaudeMessageReq, maxToken int, with_custom_reqbody bool, req_body_custom *ClaudeReqBody, on_delta func(text string) error) (*ClaudeResp, error) {
+
+	if on_delta == nil {
+		return nil, errors.New("request failed: on_delta callback is empty")
+	}
+
+	reqBody, err := c.createReqBody(content, maxToken, with_custom_reqbody, req_body_custom)
+	if err != nil {
+		return nil, err
+	}
+	reqBody.Stream = true
+
+	req, err := c.createRequest(ctx, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	// not drain the body on close, so when on_delta stop the stream the upstream connection also stopped
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var result ClaudeResp
+	// content blocks by index, text and tool_use input is accumulated from the deltas
+	var blocks []ClaudeContentResp
+	var blocksData []*strings.Builder
+	isStopped := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// only need the data line, the event name is also available inside the data as "type"
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var event ClaudeStreamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &event); err != nil {
+			return nil, errors.New("request failed: failed to decode stream event: " + err.Error())
+		}
+
+		switch event.Type {
+		case "message_start":
+			if event.Message != nil {
+				result = *event.Message
+			}
+
+		case "content_block_start":
+			if event.ContentBlock != nil && event.Index == len(blocks) {
+				blocks = append(blocks, *event.ContentBlock)
+				blocksData = append(blocksData, &strings.Builder{})
+			}
+
+		case "content_block_delta":
+			if event.Delta == nil || event.Index >= len(blocks) {
+				continue
+			}
+
+			// both text and tool input json forwarded, so structured output can be streamed too
+			var delta string
+			switch event.Delta.Type {
+			case "text_delta":
+				delta = event.Delta.Text
+			case "input_json_delta":
+				delta = event.Delta.PartialJson
+			case "thinking_delta":
+				// thinking is kept on the block but not forwarded, it is not part of the answer
+				blocksData[event.Index].WriteString(event.Delta.Thinking)
+				continue
+			case "signature_delta":
+				blocks[event.Index].Signature += event.Delta.Signature
+				continue
+			default:
+				continue
+			}
+
+			blocksData[event.Index].WriteString(delta)
+
+			if delta != "" {
+				if err := on_delta(delta); err != nil {
+					return nil, err
+				}
+			}
+
+		case "message_delta":
+			if event.Delta != nil {
+				result.StopReason = event.Delta.StopReason
+				result.StopSequence = event.Delta.StopSequence
+			}
+			if event.Usage != nil {
+				result.Usage.OutputTokens = event.Usage.OutputTokens
+			}
+
+		case "message_stop":
+			isStopped = true
+
+		case "error":
+			if event.Error != nil {
+				return nil, newStreamAPIError(event.Error.Type, event.Error.Message, resp.Header.Get("request-id"))
+			}
+			return nil, newStreamAPIError("api_error", "Claude API stream error", resp.Header.Get("request-id"))
+		}
+
+		if isStopped {
+			break
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("request failed: " + err.Error())
+	}
+
+	if !isStopped {
+		return nil, errors.New("request failed: stream ended before message_stop")
+	}
+
+	for i := range blocks {
+		switch blocks[i].Type {
+		case ClaudeContentTypeText:
+			blocks[i].Text = blocksData[i].String()
+		case ClaudeContentTypeToolUse:
+			if blocksData[i].Len() > 0 {
+				blocks[i].Input = json.RawMessage(blocksData[i].String())
+			}
+		case ClaudeContentTypeThinking:
+			blocks[i].Thinking = blocksData[i].String()
+		}
+	}
+	result.Content = blocks
+
+	return &result, nil
+}
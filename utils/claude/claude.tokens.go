@@ -0,0 +1,97 @@
+package claude
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ClaudeCountTokens count the input tokens of the message request before sending it, without generating any response.
+//
+// The parameter is the same as ClaudeSendMessage (the content is used when with_custom_reqbody is false), so the counted
+// request is exactly the request that would be sent, including the system prompt, the tools used for structured
+// output (see ClaudeCreateResponseFormat), and the image. Use it to check the prompt fit the model context window
+// or to estimate the cost before the request is sent.
+//
+// Returns:
+//   - A pointer to `ClaudeCountTokensResp` with the input tokens and the model used to count.
+//   - An error if the request body is invalid or the request fails.
+//
+// Considerations:
+//   - Counting is free but rate limited separately from the message request.
+//   - The count is an estimate and can differ by a small number of tokens from the billed input tokens.
+//   - The request is not retried and not checked by the request gate (WithRequestGate), fallback to the local estimate when it failed.
+//
+// Example usage:
+//
+//	count, err := claudeAPI.ClaudeCountTokens(nil, true, &reqBody)
+//	if err != nil {
+//	    log.Fatalf("Failed to count tokens: %v", err)
+//	}
+//
+//	if count.InputTokens+reqBody.MaxTokens > 200000 {
+//	    log.Println("prompt is too large")
+//	}
+//
+// References:
+//   - Official Claude token counting documentation: https://docs.anthropic.com/en/docs/build-with-claude/token-counting
+func (c *claudeAPI) ClaudeCountTokens(content *[]ClaudeMessageReq, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeCountTokensResp, error) {
+	return c.ClaudeCountTokensWithContext(context.Background(), content, with_custom_reqbody, req_body_custom)
+}
+
+// ClaudeCountTokensWithContext is the context-aware version of ClaudeCountTokens, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *claudeAPI) ClaudeCountTokensWithContext(ctx context.Context, content *[]ClaudeMessageReq, with_custom_reqbody bool, req_body_custom *ClaudeReqBody) (*ClaudeCountTokensResp, error) {
+	reqBody, err := c.createReqBody(content, 0, with_custom_reqbody, req_body_custom)
+	if err != nil {
+		return nil, err
+	}
+
+	countReq := ClaudeCountTokensReq{
+		Model:      reqBody.Model,
+		Messages:   reqBody.Messages,
+		System:     reqBody.System,
+		ToolChoice: reqBody.ToolChoice,
+		Tools:      reqBody.Tools,
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPost, c.countTokensUrl(), countReq)
+	if err != nil {
+		return nil, err
+	}
+
+	// sent once without the retry policy and the request gate, the count is only an estimate that the caller can do locally,
+	// so the failed count should not wait for the retry or count as failure on the circuit of the message request
+	resp, err := c.config.httpClient.Do(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer func() {
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var count ClaudeCountTokensResp
+	if err := json.NewDecoder(resp.Body).Decode(&count); err != nil {
+		return nil, errors.New("request failed: failed to decode count tokens: " + err.Error())
+	}
+	count.Model = countReq.Model
+
+	return &count, nil
+}
+
+func (c *claudeAPI) countTokensUrl() string {
+	if c.config.claudeCountTokensUrl != "" {
+		return c.config.claudeCountTokensUrl
+	}
+
+	return strings.TrimSuffix(c.config.claudeBaseUrl, "/") + "/count_tokens"
+}
@@ -0,0 +1,107 @@
+package claude
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// ErrEmptyContent is returned when Claude response has no content block to read the answer from
+var ErrEmptyContent = errors.New("Claude response content is empty")
+
+// TruncatedError is returned when the answer is cut because it reach the max tokens (stop_reason "max_tokens"),
+// the partial response is kept so the caller can still use the cut text or continue it.
+//
+// Example usage:
+//
+//	content, err := claudeClient.ClaudeGetFirstContentDataResp(&messages, 1024, false, nil)
+//	var truncatedErr *claude.TruncatedError
+//	if errors.As(err, &truncatedErr) {
+//	    log.Printf("answer cut after %d tokens: %s", truncatedErr.Resp.Usage.OutputTokens, truncatedErr.Resp.Text())
+//	}
+type TruncatedError struct {
+	Resp *ClaudeResp
+}
+
+func (e *TruncatedError) Error() string {
+	return "Claude response is truncated: the answer reach the max tokens after " + strconv.Itoa(e.Resp.Usage.OutputTokens) + " output tokens"
+}
+
+// APIError is returned when Claude API respond with error, use errors.As to inspect it.
+//
+// Example usage:
+//
+//	resp, err := claudeClient.ClaudeSendMessage(&content, 1024, false, nil)
+//	var apiErr *claude.APIError
+//	if errors.As(err, &apiErr) && apiErr.Type == "overloaded_error" {
+//	    log.Printf("Claude is overloaded, request id: %s", apiErr.RequestID)
+//	}
+//
+// References:
+//   - Claude errors: https://docs.anthropic.com/en/api/errors
+type APIError struct {
+	StatusCode int    // http status code, on error event inside the stream the status is taken from the error type
+	Type       string // error type like invalid_request_error, authentication_error, rate_limit_error, overloaded_error
+	Message    string
+	RequestID  string // value of request-id header, used when contacting Anthropic support
+	Retryable  bool   // true if the same request can be sent again later
+}
+
+func (e *APIError) Error() string {
+	return "Claude API response error: " + strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode) + " with message: " + e.Message + " type: " + e.Type
+}
+
+// error type and the status code returned by Claude, used for error event inside the stream that have no status code
+var errorTypeStatus = map[string]int{
+	"invalid_request_error": http.StatusBadRequest,
+	"authentication_error":  http.StatusUnauthorized,
+	"permission_error":      http.StatusForbidden,
+	"not_found_error":       http.StatusNotFound,
+	"request_too_large":     http.StatusRequestEntityTooLarge,
+	"rate_limit_error":      http.StatusTooManyRequests,
+	"api_error":             http.StatusInternalServerError,
+	"overloaded_error":      529,
+}
+
+// newAPIError create APIError from non 200 response, the body is read but not closed
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Type:       "api_error",
+		Message:    resp.Status,
+		RequestID:  resp.Header.Get("request-id"),
+		Retryable:  shouldRetry(resp, nil),
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+
+	var errClaude ClaudeRespError
+	if err := json.Unmarshal(body, &errClaude); err == nil && errClaude.Error.Type != "" {
+		apiErr.Type = errClaude.Error.Type
+		apiErr.Message = errClaude.Error.Message
+	}
+
+	return apiErr
+}
+
+// newStreamAPIError create APIError from error that has no http status, like error event received in the middle of the stream
+// or errored message batch result
+func newStreamAPIError(errType string, message string, requestID string) *APIError {
+	status, ok := errorTypeStatus[errType]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	return &APIError{
+		StatusCode: status,
+		Type:       errType,
+		Message:    message,
+		RequestID:  requestID,
+		Retryable:  isRetryableStatus(status),
+	}
+}
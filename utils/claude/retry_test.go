@@ -0,0 +1,318 @@
+package claude
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header map[string]string
+		err    error
+		want   bool
+	}{
+		{name: "ok", status: http.StatusOK, want: false},
+		{name: "bad request", status: http.StatusBadRequest, want: false},
+		{name: "request timeout", status: http.StatusRequestTimeout, want: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, want: true},
+		{name: "internal server error", status: http.StatusInternalServerError, want: false},
+		{name: "bad gateway", status: http.StatusBadGateway, want: true},
+		{name: "service unavailable", status: http.StatusServiceUnavailable, want: true},
+		{name: "gateway timeout", status: http.StatusGatewayTimeout, want: true},
+		{name: "overloaded", status: 529, want: true},
+		{name: "x-should-retry true", status: http.StatusInternalServerError, header: map[string]string{"x-should-retry": "true"}, want: true},
+		{name: "x-should-retry false", status: http.StatusTooManyRequests, header: map[string]string{"x-should-retry": "false"}, want: false},
+		{name: "dial error", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "read error", err: &net.OpError{Op: "read", Err: errors.New("connection reset")}, want: false},
+		{name: "other error", err: errors.New("unexpected EOF"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status, Header: http.Header{}}
+				for k, v := range tt.header {
+					resp.Header.Set(k, v)
+				}
+			}
+
+			if got := shouldRetry(resp, tt.err); got != tt.want {
+				t.Errorf("shouldRetry() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "empty", value: "", wantOk: false},
+		{name: "seconds", value: "3", want: 3 * time.Second, wantOk: true},
+		{name: "fraction seconds", value: "1.5", want: 1500 * time.Millisecond, wantOk: true},
+		{name: "invalid", value: "soon", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("retry-after", tt.value)
+			}
+
+			got, ok := retryAfter(header)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+
+	t.Run("http date", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("retry-after", time.Now().Add(10*time.Second).UTC().Format(http.TimeFormat))
+
+		got, ok := retryAfter(header)
+		if !ok || got <= 8*time.Second || got > 10*time.Second {
+			t.Errorf("retryAfter() = %v, %v, want about 10s", got, ok)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	t.Run("retry-after header", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("retry-after", "2")
+
+		if got := retryDelay(policy, 0, resp); got != 2*time.Second {
+			t.Errorf("retryDelay() = %v, want 2s", got)
+		}
+	})
+
+	t.Run("retry-after longer than max delay is returned as it is", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("retry-after", "60")
+
+		if got := retryDelay(policy, 0, resp); got != time.Minute {
+			t.Errorf("retryDelay() = %v, want 1m", got)
+		}
+	})
+
+	t.Run("rate limit reset", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("anthropic-ratelimit-tokens-remaining", "0")
+		resp.Header.Set("anthropic-ratelimit-tokens-reset", time.Now().Add(4*time.Second).UTC().Format(time.RFC3339))
+
+		if got := retryDelay(policy, 0, resp); got <= 2*time.Second || got > 4*time.Second {
+			t.Errorf("retryDelay() = %v, want about 4s", got)
+		}
+	})
+
+	t.Run("rate limit not reached use backoff", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("anthropic-ratelimit-tokens-remaining", "10")
+		resp.Header.Set("anthropic-ratelimit-tokens-reset", time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+
+		if got := retryDelay(policy, 0, resp); got < 500*time.Millisecond || got > time.Second {
+			t.Errorf("retryDelay() = %v, want between 500ms and 1s", got)
+		}
+	})
+
+	backoff := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 0, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 1, min: time.Second, max: 2 * time.Second},
+		{attempt: 2, min: 2 * time.Second, max: 4 * time.Second},
+		{attempt: 3, min: 2500 * time.Millisecond, max: 5 * time.Second},  // capped to max delay
+		{attempt: 70, min: 2500 * time.Millisecond, max: 5 * time.Second}, // overflow
+	}
+	for _, tt := range backoff {
+		for i := 0; i < 20; i++ {
+			if got := retryDelay(policy, tt.attempt, nil); got < tt.min || got > tt.max {
+				t.Fatalf("retryDelay(attempt %d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestDoRequest(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		policy       RetryPolicy
+		timeout      time.Duration
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{
+			name:         "success after retry",
+			statuses:     []int{529, http.StatusTooManyRequests, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "last response returned when all retry failed",
+			statuses:     []int{529, 529, 529},
+			policy:       RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+			wantStatus:   529,
+			wantAttempts: 3,
+		},
+		{
+			name:         "no retry policy",
+			statuses:     []int{529, http.StatusOK},
+			wantStatus:   529,
+			wantAttempts: 1,
+		},
+		{
+			name:         "not retryable",
+			statuses:     []int{http.StatusBadRequest, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+			wantStatus:   http.StatusBadRequest,
+			wantAttempts: 1,
+		},
+		{
+			name:         "wait past half of the deadline is not retried",
+			statuses:     []int{529, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: 800 * time.Millisecond, MaxDelay: time.Second},
+			timeout:      500 * time.Millisecond,
+			wantStatus:   529,
+			wantAttempts: 1,
+		},
+		{
+			name:         "wait within half of the deadline is retried",
+			statuses:     []int{529, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second},
+			timeout:      5 * time.Second,
+			wantStatus:   http.StatusOK,
+			wantAttempts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&attempts, 1) - 1
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer srv.Close()
+
+			client := &claudeAPI{config: &Config{httpClient: srv.Client(), retryPolicy: tt.policy}}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(`{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := client.doRequest(req)
+			if err != nil {
+				t.Fatalf("doRequest() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestDoRequestGate(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(529)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var gateCalls, doneCalls int
+	var doneStatus int
+	gate := func(req *http.Request) (func(resp *http.Response, err error), error) {
+		gateCalls++
+		return func(resp *http.Response, err error) {
+			doneCalls++
+			if resp != nil {
+				doneStatus = resp.StatusCode
+			}
+		}, nil
+	}
+
+	client := &claudeAPI{config: &Config{
+		httpClient:  srv.Client(),
+		retryPolicy: RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+		requestGate: gate,
+	}}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.doRequest(req)
+	if err != nil {
+		t.Fatalf("doRequest() error = %v", err)
+	}
+	resp.Body.Close()
+
+	// the retried attempts is one request for the gate, done with the final response
+	if gateCalls != 1 || doneCalls != 1 {
+		t.Errorf("gate calls = %d, done calls = %d, want 1 and 1", gateCalls, doneCalls)
+	}
+	if doneStatus != http.StatusOK {
+		t.Errorf("done status = %d, want %d", doneStatus, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+
+	t.Run("gate error", func(t *testing.T) {
+		gateErr := errors.New("circuit open")
+		client.config.requestGate = func(req *http.Request) (func(resp *http.Response, err error), error) {
+			return nil, gateErr
+		}
+		atomic.StoreInt32(&attempts, 0)
+
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.doRequest(req); !errors.Is(err, gateErr) {
+			t.Errorf("doRequest() error = %v, want %v", err, gateErr)
+		}
+		if got := atomic.LoadInt32(&attempts); got != 0 {
+			t.Errorf("attempts = %d, want 0", got)
+		}
+	})
+}
@@ -1,8 +1,24 @@
 package utils
 
+// minimum credit token the user must have before the feature started,
+// the charged credit is computed from the real usage by the pricing.PriceTable
 const (
 	FEATURE_MEDIUM_COST            = 1
 	FEATURE_BAKU_HANTAM_COST       = 1
 	FEATURE_STORY_GENERATOR_COST   = 3
+	FEATURE_STORY_PARAGRAPH_COST   = 1
 	FEATURE_CONTENT_GENERATOR_COST = 3
+	FEATURE_IMAGE_GENERATOR_COST   = 1
+	FEATURE_TTS_COST               = 1
+)
+
+// feature name, used to record the LLM usage per feature
+const (
+	FEATURE_MEDIUM            = "medium"
+	FEATURE_BAKU_HANTAM       = "baku_hantam"
+	FEATURE_STORY_GENERATOR   = "story_generator"
+	FEATURE_CONTENT_GENERATOR = "content_generator"
+	FEATURE_IMAGE_GENERATOR   = "image_generator"
+	FEATURE_TTS               = "tts"
+	FEATURE_SEARCH            = "search"
 )
@@ -0,0 +1,15 @@
+package utils
+
+import "time"
+
+// deadline for each feature request, include scrapping and all the LLM call inside the feature
+const (
+	FEATURE_MEDIUM_TIMEOUT            = 60 * time.Second
+	FEATURE_BAKU_HANTAM_TIMEOUT       = 60 * time.Second
+	FEATURE_STORY_GENERATOR_TIMEOUT   = 60 * time.Second
+	FEATURE_CONTENT_GENERATOR_TIMEOUT = 120 * time.Second // image analysis and content recommendation is 2 chained request
+	FEATURE_IMAGE_GENERATOR_TIMEOUT   = 90 * time.Second
+	FEATURE_TTS_TIMEOUT               = 60 * time.Second
+	FEATURE_VOICE_CHOICE_TIMEOUT      = 90 * time.Second // transcription then the story generator
+	FEATURE_SEARCH_TIMEOUT            = 30 * time.Second
+)
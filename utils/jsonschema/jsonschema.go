@@ -0,0 +1,231 @@
+package jsonschema
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// Reflect build the JSON schema of the given Go value, so the structured output schema is generated from the same struct
+// the answer is decoded to and the two can't drift.
+//
+// The struct field name is taken from the `json` tag (field with `json:"-"` or unexported is skipped, embedded struct
+// is flattened like encoding/json do), and two optional tag is read:
+//   - `description:"..."`: the field description, sent to the model as the instruction of that field.
+//   - `enum:"a,b,c"`: the allowed value, only for string field or string slice item.
+//
+// Every struct become object with all of its field on `properties`, the field without `omitempty` listed on
+// `required`, and `additionalProperties: false`, so the schema can be used on OpenAI strict mode and Claude tool input.
+// Slice and array become array with the element schema on `items`, pointer follow the pointed type.
+//
+// Returns an error for type that has no JSON schema (map with non string key, channel, function) and for recursive
+// struct, because the structured output schema can't reference itself.
+//
+// Example usage:
+//
+//	type Answer struct {
+//	    Title string   `json:"title" description:"short title of the story"`
+//	    Mood  string   `json:"mood" enum:"happy,sad"`
+//	    Tags  []string `json:"tags,omitempty"`
+//	}
+//
+//	schema, err := jsonschema.Reflect(Answer{})
+//	if err != nil {
+//	    log.Fatalf("Failed to create schema: %v", err)
+//	}
+//
+// References:
+//   - JSON Schema: https://json-schema.org/understanding-json-schema
+func Reflect(v interface{}) (map[string]interface{}, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, errors.New("Failed to create schema: value is nil")
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil, errors.New("Failed to create schema: the root type must be struct, got " + t.Kind().String())
+	}
+
+	return reflectType(t, map[reflect.Type]bool{})
+}
+
+// MustReflect same as Reflect but panic on error, used for package level schema of the known struct
+func MustReflect(v interface{}) map[string]interface{} {
+	schema, err := Reflect(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return schema
+}
+
+// reflectType build the schema of one type, visiting hold the struct on the current path to detect recursive struct
+func reflectType(t reflect.Type, visiting map[reflect.Type]bool) (map[string]interface{}, error) {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return reflectType(t.Elem(), visiting)
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+
+	case reflect.Interface:
+		// any JSON value
+		return map[string]interface{}{}, nil
+
+	case reflect.Slice, reflect.Array:
+		items, err := reflectType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.New("Failed to create schema: map key of " + t.String() + " must be string")
+		}
+		values, err := reflectType(t.Elem(), visiting)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+
+	case reflect.Struct:
+		return reflectStruct(t, visiting)
+	}
+
+	return nil, errors.New("Failed to create schema: type " + t.String() + " is not supported")
+}
+
+// reflectStruct build the object schema of the struct fields
+func reflectStruct(t reflect.Type, visiting map[reflect.Type]bool) (map[string]interface{}, error) {
+	if visiting[t] {
+		return nil, errors.New("Failed to create schema: struct " + t.String() + " is recursive")
+	}
+	visiting[t] = true
+	defer delete(visiting, t)
+
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	if err := reflectFields(t, visiting, properties, &required); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+// reflectFields add the struct fields to properties, the embedded struct without json name is flattened
+func reflectFields(t reflect.Type, visiting map[reflect.Type]bool, properties map[string]interface{}, required *[]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		name, omitempty, skip := fieldName(field)
+		if skip {
+			continue
+		}
+
+		if name == "" {
+			// embedded struct without json name, its fields is part of this object
+			embedded := field.Type
+			for embedded.Kind() == reflect.Pointer {
+				embedded = embedded.Elem()
+			}
+			if err := reflectFields(embedded, visiting, properties, required); err != nil {
+				return err
+			}
+			continue
+		}
+
+		schema, err := reflectType(field.Type, visiting)
+		if err != nil {
+			return err
+		}
+
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+
+		if enum := field.Tag.Get("enum"); enum != "" {
+			if err := addEnum(schema, strings.Split(enum, ",")); err != nil {
+				return errors.New("Failed to create schema: field " + t.String() + "." + field.Name + ": " + err.Error())
+			}
+		}
+
+		properties[name] = schema
+		if !omitempty {
+			*required = append(*required, name)
+		}
+	}
+
+	return nil
+}
+
+// fieldName return the JSON name of the field like encoding/json, empty name is embedded struct that should be flattened
+func fieldName(field reflect.StructField) (name string, omitempty bool, skip bool) {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false, true
+	}
+
+	name, options, _ := strings.Cut(tag, ",")
+	omitempty = strings.Contains(","+options+",", ",omitempty,")
+
+	if field.Anonymous && name == "" {
+		embedded := field.Type
+		for embedded.Kind() == reflect.Pointer {
+			embedded = embedded.Elem()
+		}
+		if embedded.Kind() == reflect.Struct {
+			return "", omitempty, false
+		}
+	}
+
+	if !field.IsExported() {
+		return "", false, true
+	}
+
+	if name == "" {
+		name = field.Name
+	}
+
+	return name, omitempty, false
+}
+
+// addEnum set the enum on the string schema, or on the items of the string array schema
+func addEnum(schema map[string]interface{}, values []string) error {
+	target := schema
+	if items, ok := schema["items"].(map[string]interface{}); ok && schema["type"] == "array" {
+		target = items
+	}
+
+	if target["type"] != "string" {
+		return errors.New("enum is only supported on string field")
+	}
+
+	enum := make([]string, 0, len(values))
+	for _, value := range values {
+		enum = append(enum, strings.TrimSpace(value))
+	}
+	target["enum"] = enum
+
+	return nil
+}
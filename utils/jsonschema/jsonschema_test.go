@@ -0,0 +1,160 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type reflectItem struct {
+	Title string   `json:"title" description:"short title"`
+	Mood  string   `json:"mood" enum:"happy, sad"`
+	Tags  []string `json:"tags,omitempty" enum:"a,b"`
+}
+
+type reflectBase struct {
+	Id int `json:"id"`
+}
+
+type reflectRoot struct {
+	reflectBase
+	Name     string            `json:"name"`
+	Score    float64           `json:"score"`
+	Done     bool              `json:"done,omitempty"`
+	Item     *reflectItem      `json:"item"`
+	Items    []reflectItem     `json:"items"`
+	Extra    map[string]int    `json:"extra"`
+	Any      interface{}       `json:"any"`
+	Skipped  string            `json:"-"`
+	private  string            // unexported, skipped
+	NoTag    uint8             // no json tag, the Go name is used
+	Matrix   [2][]int          `json:"matrix"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Nullable *string           `json:"nullable"`
+}
+
+type reflectRecursive struct {
+	Children []reflectRecursive `json:"children"`
+}
+
+type reflectBadEnum struct {
+	Count int `json:"count" enum:"1,2"`
+}
+
+type reflectBadMap struct {
+	Values map[int]string `json:"values"`
+}
+
+type reflectFunc struct {
+	Fn func() `json:"fn"`
+}
+
+// compactJSON marshal the value to JSON so the schema can be compared with the expected JSON text, the map key is sorted
+func compactJSON(t *testing.T, value interface{}) string {
+	t.Helper()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(data)
+}
+
+func TestReflect(t *testing.T) {
+	item := `{"additionalProperties":false,"properties":{` +
+		`"mood":{"enum":["happy","sad"],"type":"string"},` +
+		`"tags":{"items":{"enum":["a","b"],"type":"string"},"type":"array"},` +
+		`"title":{"description":"short title","type":"string"}},` +
+		`"required":["title","mood"],"type":"object"}`
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{
+			name:  "nested struct, slice, and enum",
+			value: reflectItem{},
+			want:  item,
+		},
+		{
+			name:  "pointer to struct",
+			value: &reflectItem{},
+			want:  item,
+		},
+		{
+			name:  "empty struct",
+			value: struct{}{},
+			want:  `{"additionalProperties":false,"properties":{},"required":[],"type":"object"}`,
+		},
+		{
+			name:  "every kind",
+			value: reflectRoot{},
+			want: `{"additionalProperties":false,"properties":{` +
+				`"NoTag":{"type":"integer"},` +
+				`"any":{},` +
+				`"done":{"type":"boolean"},` +
+				`"extra":{"additionalProperties":{"type":"integer"},"type":"object"},` +
+				`"id":{"type":"integer"},` +
+				`"item":` + item + `,` +
+				`"items":{"items":` + item + `,"type":"array"},` +
+				`"labels":{"additionalProperties":{"type":"string"},"type":"object"},` +
+				`"matrix":{"items":{"items":{"type":"integer"},"type":"array"},"type":"array"},` +
+				`"name":{"type":"string"},` +
+				`"nullable":{"type":"string"},` +
+				`"score":{"type":"number"}},` +
+				`"required":["id","name","score","item","items","extra","any","NoTag","matrix","nullable"],"type":"object"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := Reflect(tt.value)
+			if err != nil {
+				t.Fatalf("Reflect() error = %v", err)
+			}
+
+			if got := compactJSON(t, schema); got != tt.want {
+				t.Errorf("Reflect() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReflectError(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr string
+	}{
+		{name: "nil", value: nil, wantErr: "value is nil"},
+		{name: "not struct", value: []string{}, wantErr: "root type must be struct"},
+		{name: "recursive struct", value: reflectRecursive{}, wantErr: "is recursive"},
+		{name: "enum on non string", value: reflectBadEnum{}, wantErr: "enum is only supported on string field"},
+		{name: "map with non string key", value: reflectBadMap{}, wantErr: "must be string"},
+		{name: "function", value: reflectFunc{}, wantErr: "is not supported"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Reflect(tt.value)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Reflect() error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMustReflect(t *testing.T) {
+	if schema := MustReflect(reflectItem{}); schema["type"] != "object" {
+		t.Errorf("MustReflect() type = %v, want object", schema["type"])
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("MustReflect() with recursive struct not panic")
+		}
+	}()
+	MustReflect(reflectRecursive{})
+}
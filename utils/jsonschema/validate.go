@@ -0,0 +1,240 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// max error listed on ValidationError, so the message sent back to the model stay short
+const maxValidationErrors = 10
+
+// ValidationError is returned by Validate with every part of the value that not follow the schema
+type ValidationError struct {
+	Errors []string // like "$.titles[0].title: is required"
+}
+
+func (e *ValidationError) Error() string {
+	return "JSON not follow the schema: " + strings.Join(e.Errors, "; ")
+}
+
+// Validate check the JSON value follow the schema, the value is the result of json.Unmarshal to interface{}
+// (with or without json.Decoder.UseNumber).
+//
+// Only the keyword used by Reflect and the structured output schema is checked: `type`, `properties`, `required`,
+// `additionalProperties`, `items`, and `enum`, the other keyword is ignored. The schema can use map[string]string
+// for the leaf schema like the hand written schema.
+//
+// Returns nil when valid, or *ValidationError with the path of every invalid part.
+//
+// Example usage:
+//
+//	var value interface{}
+//	if err := json.Unmarshal(data, &value); err != nil {
+//	    return err
+//	}
+//
+//	if err := jsonschema.Validate(schema, value); err != nil {
+//	    log.Printf("invalid answer: %v", err)
+//	}
+func Validate(schema map[string]interface{}, value interface{}) error {
+	v := &validator{}
+	v.validate(schema, value, "$")
+
+	if len(v.errors) == 0 {
+		return nil
+	}
+
+	return &ValidationError{Errors: v.errors}
+}
+
+type validator struct {
+	errors []string
+}
+
+func (v *validator) fail(path string, message string) {
+	if len(v.errors) < maxValidationErrors {
+		v.errors = append(v.errors, path+": "+message)
+	}
+}
+
+func (v *validator) validate(schema map[string]interface{}, value interface{}, path string) {
+	if types := schemaTypes(schema["type"]); len(types) > 0 && !matchAnyType(types, value) {
+		v.fail(path, "must be "+strings.Join(types, " or ")+", got "+valueType(value))
+		return
+	}
+
+	if enum := stringList(schema["enum"]); len(enum) > 0 {
+		if s, ok := value.(string); !ok || !contains(enum, s) {
+			v.fail(path, "must be one of "+strings.Join(enum, ", "))
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(schema, value, path)
+
+	case []interface{}:
+		if items := asSchema(schema["items"]); items != nil {
+			for i, item := range value {
+				v.validate(items, item, path+"["+strconv.Itoa(i)+"]")
+			}
+		}
+	}
+}
+
+func (v *validator) validateObject(schema map[string]interface{}, value map[string]interface{}, path string) {
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	for _, name := range stringList(schema["required"]) {
+		if _, ok := value[name]; !ok {
+			v.fail(path+"."+name, "is required")
+		}
+	}
+
+	// sorted so the error message is the same every time
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if property := asSchema(properties[name]); property != nil {
+			v.validate(property, value[name], path+"."+name)
+			continue
+		}
+
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(path+"."+name, "is not allowed")
+			}
+		default:
+			if additionalSchema := asSchema(additional); additionalSchema != nil {
+				v.validate(additionalSchema, value[name], path+"."+name)
+			}
+		}
+	}
+}
+
+// asSchema return the sub schema as map, the leaf schema can be map[string]string
+func asSchema(schema interface{}) map[string]interface{} {
+	switch schema := schema.(type) {
+	case map[string]interface{}:
+		return schema
+	case map[string]string:
+		converted := make(map[string]interface{}, len(schema))
+		for key, value := range schema {
+			converted[key] = value
+		}
+		return converted
+	}
+
+	return nil
+}
+
+// schemaTypes return the `type` keyword that can be one type or list of type
+func schemaTypes(schemaType interface{}) []string {
+	if t, ok := schemaType.(string); ok {
+		return []string{t}
+	}
+
+	return stringList(schemaType)
+}
+
+// stringList return []string or []interface{} of string keyword value
+func stringList(value interface{}) []string {
+	switch value := value.(type) {
+	case []string:
+		return value
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+
+	return nil
+}
+
+func matchAnyType(types []string, value interface{}) bool {
+	for _, t := range types {
+		if matchType(t, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchType(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	case "number":
+		switch value.(type) {
+		case float64, json.Number:
+			return true
+		}
+		return false
+	case "integer":
+		switch n := value.(type) {
+		case float64:
+			return n == float64(int64(n))
+		case json.Number:
+			_, err := n.Int64()
+			return err == nil
+		}
+		return false
+	}
+
+	// unknown type is not checked
+	return true
+}
+
+// valueType return the JSON type name of the decoded value for the error message
+func valueType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	case nil:
+		return "null"
+	}
+
+	return "unknown"
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
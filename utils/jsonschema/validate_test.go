@@ -0,0 +1,161 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type validateStory struct {
+	Title string   `json:"title"`
+	Mood  string   `json:"mood" enum:"happy,sad"`
+	Pages int      `json:"pages"`
+	Tags  []string `json:"tags,omitempty"`
+	Parts []struct {
+		Text string `json:"text"`
+	} `json:"parts"`
+}
+
+func TestValidate(t *testing.T) {
+	schema := MustReflect(validateStory{})
+
+	tests := []struct {
+		name       string
+		value      string
+		wantErrors []string
+	}{
+		{
+			name:  "valid",
+			value: `{"title": "a", "mood": "happy", "pages": 2, "tags": ["x"], "parts": [{"text": "p"}]}`,
+		},
+		{
+			name:  "optional field missing",
+			value: `{"title": "a", "mood": "sad", "pages": 2, "parts": []}`,
+		},
+		{
+			name:       "required field missing",
+			value:      `{"title": "a", "pages": 2, "parts": []}`,
+			wantErrors: []string{"$.mood: is required"},
+		},
+		{
+			name:       "additional property",
+			value:      `{"title": "a", "mood": "sad", "pages": 2, "parts": [], "extra": 1}`,
+			wantErrors: []string{"$.extra: is not allowed"},
+		},
+		{
+			name:       "not in enum",
+			value:      `{"title": "a", "mood": "angry", "pages": 2, "parts": []}`,
+			wantErrors: []string{"$.mood: must be one of happy, sad"},
+		},
+		{
+			name:       "wrong type",
+			value:      `{"title": 1, "mood": "sad", "pages": "2", "parts": {}}`,
+			wantErrors: []string{"$.pages: must be integer, got string", "$.parts: must be array, got object", "$.title: must be string, got number"},
+		},
+		{
+			name:       "number is not integer",
+			value:      `{"title": "a", "mood": "sad", "pages": 2.5, "parts": []}`,
+			wantErrors: []string{"$.pages: must be integer, got number"},
+		},
+		{
+			name:       "nested item invalid",
+			value:      `{"title": "a", "mood": "sad", "pages": 2, "parts": [{"text": "p"}, {"body": "p"}]}`,
+			wantErrors: []string{"$.parts[1].text: is required", "$.parts[1].body: is not allowed"},
+		},
+		{
+			name:       "root not object",
+			value:      `["title"]`,
+			wantErrors: []string{"$: must be object, got array"},
+		},
+		{
+			name:       "null value",
+			value:      `null`,
+			wantErrors: []string{"$: must be object, got null"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the validator accept the value decoded with or without UseNumber
+			for _, useNumber := range []bool{false, true} {
+				decoder := json.NewDecoder(strings.NewReader(tt.value))
+				if useNumber {
+					decoder.UseNumber()
+				}
+
+				var value interface{}
+				if err := decoder.Decode(&value); err != nil {
+					t.Fatal(err)
+				}
+
+				err := Validate(schema, value)
+				if len(tt.wantErrors) == 0 {
+					if err != nil {
+						t.Errorf("Validate() useNumber=%v error = %v", useNumber, err)
+					}
+					continue
+				}
+
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Fatalf("Validate() useNumber=%v error = %v, want *ValidationError", useNumber, err)
+				}
+				if !reflect.DeepEqual(validationErr.Errors, tt.wantErrors) {
+					t.Errorf("Validate() useNumber=%v errors = %q, want %q", useNumber, validationErr.Errors, tt.wantErrors)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateHandWrittenSchema(t *testing.T) {
+	// the hand written schema use map[string]string for the leaf and []interface{} for the list keyword
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":  map[string]string{"type": "string"},
+			"value": map[string]interface{}{"type": []interface{}{"number", "null"}},
+		},
+		"required":             []interface{}{"name"},
+		"additionalProperties": map[string]string{"type": "boolean"},
+	}
+
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr bool
+	}{
+		{name: "valid", value: map[string]interface{}{"name": "a", "value": 1.5, "flag": true}},
+		{name: "nullable", value: map[string]interface{}{"name": "a", "value": nil}},
+		{name: "leaf type", value: map[string]interface{}{"name": 1}, wantErr: true},
+		{name: "additional property schema", value: map[string]interface{}{"name": "a", "flag": "yes"}, wantErr: true},
+		{name: "required list", value: map[string]interface{}{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(schema, tt.value); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMaxErrors(t *testing.T) {
+	value := map[string]interface{}{}
+	for i := 0; i < maxValidationErrors+5; i++ {
+		value[strings.Repeat("x", i+1)] = i
+	}
+
+	err := Validate(MustReflect(struct{}{}), value)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Validate() error = %v, want *ValidationError", err)
+	}
+	if len(validationErr.Errors) != maxValidationErrors {
+		t.Errorf("errors = %d, want %d", len(validationErr.Errors), maxValidationErrors)
+	}
+}
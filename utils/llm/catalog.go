@@ -0,0 +1,185 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"scrapper-test/utils/pricing"
+)
+
+// model capability, the request that need the capability is refused on the model without it
+const (
+	CapabilityVision     = "vision"      // image on the message
+	CapabilityJSONSchema = "json_schema" // structured output with Schema
+	CapabilityAudio      = "audio"       // audio output with Audio
+)
+
+var (
+	// ErrModelNotAllowed is returned when the requested model is not a provider name or an enabled model on the catalog
+	ErrModelNotAllowed = errors.New("request failed: model is not allowed")
+	// ErrModelNotSupported is returned when the selected model has no capability needed by the request
+	ErrModelNotSupported = errors.New("request failed: model not support the request")
+)
+
+// Model is one chat model that can be selected per request
+type Model struct {
+	ID            string              `json:"id"`             // model name sent to the provider, like "gpt-4o-mini"
+	Provider      string              `json:"provider"`       // registered provider name, like "openai"
+	DisplayName   string              `json:"display_name"`   // name shown on the UI
+	ContextWindow int                 `json:"context_window"` // 0 use the ContextWindow table
+	Price         *pricing.ModelPrice `json:"price"`          // nil use the price table
+	Capabilities  []string            `json:"capabilities"`   // CapabilityVision, CapabilityJSONSchema, CapabilityAudio
+	Enabled       bool                `json:"enabled"`        // disabled model is not listed and can't be selected
+}
+
+// Supports return true when the model has the capability
+func (m Model) Supports(capability string) bool {
+	for _, c := range m.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Catalog is the allowlist of the model that can be selected per request
+type Catalog struct {
+	Models []Model `json:"models"`
+}
+
+// DefaultCatalog return the catalog of the Claude and OpenAI chat models used by the features,
+// the context window and the price is taken from the ContextWindow and the price table
+func DefaultCatalog() *Catalog {
+	return &Catalog{
+		Models: []Model{
+			{ID: "claude-3-5-sonnet-20240620", Provider: ProviderClaude, DisplayName: "Claude 3.5 Sonnet", Capabilities: []string{CapabilityVision, CapabilityJSONSchema}, Enabled: true},
+			{ID: "claude-3-5-haiku-20241022", Provider: ProviderClaude, DisplayName: "Claude 3.5 Haiku", Capabilities: []string{CapabilityJSONSchema}, Enabled: true},
+			{ID: "gpt-4o", Provider: ProviderOpenAI, DisplayName: "GPT-4o", Capabilities: []string{CapabilityVision, CapabilityJSONSchema}, Enabled: true},
+			{ID: "gpt-4o-mini", Provider: ProviderOpenAI, DisplayName: "GPT-4o mini", Capabilities: []string{CapabilityVision, CapabilityJSONSchema}, Enabled: true},
+		},
+	}
+}
+
+// LoadCatalog read the catalog from json file, the models on the file replace all the default models
+// so the file must list every model that can be selected
+func LoadCatalog(path string) (*Catalog, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Failed to read model catalog: " + err.Error())
+	}
+
+	catalog := &Catalog{}
+	if err := json.Unmarshal(file, catalog); err != nil {
+		return nil, errors.New("Failed to decode model catalog: " + err.Error())
+	}
+
+	seen := make(map[string]bool, len(catalog.Models))
+	for _, m := range catalog.Models {
+		if m.ID == "" || m.Provider == "" {
+			return nil, errors.New("Failed to load model catalog: every model must have id and provider")
+		}
+		if seen[m.ID] {
+			return nil, errors.New("Failed to load model catalog: model " + m.ID + " is listed more than once")
+		}
+		seen[m.ID] = true
+	}
+
+	return catalog, nil
+}
+
+// Get return the model by id, the disabled model is also returned
+func (c *Catalog) Get(id string) (Model, bool) {
+	for _, m := range c.Models {
+		if m.ID == id {
+			return m, true
+		}
+	}
+
+	return Model{}, false
+}
+
+// Enabled return the models that can be selected, in the catalog order
+func (c *Catalog) Enabled() []Model {
+	models := make([]Model, 0, len(c.Models))
+	for _, m := range c.Models {
+		if m.Enabled {
+			models = append(models, m)
+		}
+	}
+
+	return models
+}
+
+// modelProvider send every request of the provider with the selected model instead of the configured model,
+// the request that need the capability the model doesn't have is refused before it sent
+type modelProvider struct {
+	provider Provider
+	model    Model
+}
+
+func (p *modelProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *modelProvider) Generate(ctx context.Context, req Request) (*Response, error) {
+	if err := p.prepare(&req); err != nil {
+		return nil, err
+	}
+
+	return p.provider.Generate(ctx, req)
+}
+
+func (p *modelProvider) GenerateStream(ctx context.Context, req Request, onDelta func(text string) error) (*Response, error) {
+	if err := p.prepare(&req); err != nil {
+		return nil, err
+	}
+
+	return p.provider.GenerateStream(ctx, req, onDelta)
+}
+
+// CountTokens count with the selected model, the catalog context window is returned when it is set
+func (p *modelProvider) CountTokens(ctx context.Context, req Request) (*TokenCount, error) {
+	if err := p.prepare(&req); err != nil {
+		return nil, err
+	}
+
+	count, err := p.provider.CountTokens(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.model.ContextWindow > 0 {
+		count.ContextWindow = p.model.ContextWindow
+	}
+
+	return count, nil
+}
+
+// prepare set the selected model on the request and check the model has the capability needed by the request
+func (p *modelProvider) prepare(req *Request) error {
+	needs := []string{}
+	for _, m := range req.Messages {
+		if m.Image != nil {
+			needs = append(needs, CapabilityVision)
+			break
+		}
+	}
+	if req.Schema != nil {
+		needs = append(needs, CapabilityJSONSchema)
+	}
+	if req.Audio != nil {
+		needs = append(needs, CapabilityAudio)
+	}
+
+	for _, capability := range needs {
+		if !p.model.Supports(capability) {
+			return fmt.Errorf("%w: %s has no %s capability", ErrModelNotSupported, p.model.ID, capability)
+		}
+	}
+
+	req.Model = p.model.ID
+	return nil
+}
@@ -0,0 +1,183 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"scrapper-test/utils/claude"
+)
+
+// adapter for claude.ClaudeAPI
+type claudeProvider struct {
+	client claude.ClaudeAPI
+}
+
+func NewClaudeProvider(client claude.ClaudeAPI) Provider {
+	return &claudeProvider{
+		client: client,
+	}
+}
+
+func (p *claudeProvider) Name() string {
+	return ProviderClaude
+}
+
+func (p *claudeProvider) Generate(ctx context.Context, req Request) (*Response, error) {
+	reqBody, err := p.createReqBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var claudeResp *claude.ClaudeResp
+	if req.MaxContinue > 0 && req.Schema == nil {
+		claudeResp, err = p.client.ClaudeSendMessageContinueWithContext(ctx, nil, 0, true, reqBody, req.MaxContinue)
+	} else {
+		claudeResp, err = p.client.ClaudeSendMessageWithContext(ctx, nil, 0, true, reqBody)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p.createResponse(req, claudeResp)
+}
+
+func (p *claudeProvider) GenerateStream(ctx context.Context, req Request, onDelta func(text string) error) (*Response, error) {
+	reqBody, err := p.createReqBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	claudeResp, err := p.client.ClaudeSendMessageStreamWithContext(ctx, nil, 0, true, reqBody, onDelta)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.createResponse(req, claudeResp)
+}
+
+// CountTokens count the tokens with the claude count tokens endpoint, the count include the system prompt, image, and schema tool
+func (p *claudeProvider) CountTokens(ctx context.Context, req Request) (*TokenCount, error) {
+	reqBody, err := p.createReqBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	count, err := p.client.ClaudeCountTokensWithContext(ctx, nil, true, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenCount{
+		Model:       count.Model,
+		InputTokens: count.InputTokens,
+	}, nil
+}
+
+// createReqBody convert the provider agnostic request to claude request body,
+// the model is left empty when the request has no model so the client fill it with the configured model
+func (p *claudeProvider) createReqBody(req Request) (*claude.ClaudeReqBody, error) {
+	if len(req.Messages) == 0 {
+		return nil, errors.New("request failed: messages is empty")
+	}
+
+	if req.Audio != nil {
+		return nil, ErrAudioNotSupported
+	}
+
+	messages := make([]claude.ClaudeMessageReq, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		var content interface{} = m.Content
+
+		if m.Image != nil {
+			vision, err := claude.ClaudeCreateOneContentImageVisionBase64(m.Image.MediaType, m.Image.Data, m.Content)
+			if err != nil {
+				return nil, err
+			}
+
+			// parts sent after the image, one block for each part so the cache breakpoint is kept
+			for _, part := range m.Parts {
+				vision = append(vision, claude.ClaudeCreateTextContent(part.Text, part.Cache))
+			}
+			content = vision
+		} else if len(m.Parts) > 0 {
+			blocks := make([]claude.ClaudeVisionContentBase, 0, len(m.Parts))
+			for _, part := range m.Parts {
+				blocks = append(blocks, claude.ClaudeCreateTextContent(part.Text, part.Cache))
+			}
+			content = blocks
+		}
+
+		messages = append(messages, claude.ClaudeMessageReq{
+			Role:    m.Role,
+			Content: content,
+		})
+	}
+
+	maxTokens := req.MaxTokens
+	if maxTokens == 0 {
+		maxTokens = DefaultMaxTokens
+	}
+
+	reqBody := &claude.ClaudeReqBody{
+		Model:       req.Model,
+		MaxTokens:   maxTokens,
+		Messages:    messages,
+		Temperature: 1.0,
+	}
+
+	if len(req.System) > 0 {
+		system := make([]claude.ClaudeSystemBlock, 0, len(req.System))
+		for _, part := range req.System {
+			system = append(system, claude.ClaudeCreateSystemContent(part.Text, part.Cache))
+		}
+		reqBody.System = system
+	}
+
+	// structured output on claude is done with forced tool use
+	if req.Schema != nil {
+		reqBody.Tools, reqBody.ToolChoice = claude.ClaudeCreateResponseFormat(req.Schema.Name, req.Schema.Schema)
+	}
+
+	return reqBody, nil
+}
+
+func (p *claudeProvider) createResponse(req Request, claudeResp *claude.ClaudeResp) (*Response, error) {
+	var content string
+
+	if req.Schema != nil {
+		input, err := claude.ClaudeGetToolUseInput(claudeResp, req.Schema.Name)
+		if err != nil {
+			var truncatedErr *claude.TruncatedError
+			switch {
+			case errors.As(err, &truncatedErr):
+				// both wrapped, so the caller can check the provider agnostic error or read the claude response
+				return nil, fmt.Errorf("%w: %w", ErrTruncated, err)
+			case errors.Is(err, claude.ErrEmptyContent):
+				return nil, fmt.Errorf("%w: %w", ErrEmptyContent, err)
+			}
+			return nil, err
+		}
+
+		content = string(input)
+	} else {
+		// the answer can be split into more than one text block, the thinking block is skipped
+		content = claudeResp.Text()
+		if content == "" && !claudeResp.IsTruncated() {
+			return nil, ErrEmptyContent
+		}
+	}
+
+	return &Response{
+		Provider:   ProviderClaude,
+		Model:      claudeResp.Model,
+		Content:    content,
+		StopReason: claudeResp.StopReason,
+		// claude input tokens not include the cache tokens, they are added so the input tokens is the whole prompt like openai
+		Usage: Usage{
+			InputTokens:      claudeResp.Usage.InputTokens + claudeResp.Usage.CacheReadInputTokens + claudeResp.Usage.CacheCreationInputTokens,
+			OutputTokens:     claudeResp.Usage.OutputTokens,
+			CacheReadTokens:  claudeResp.Usage.CacheReadInputTokens,
+			CacheWriteTokens: claudeResp.Usage.CacheCreationInputTokens,
+		},
+	}, nil
+}
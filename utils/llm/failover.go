@@ -0,0 +1,128 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"scrapper-test/utils/breaker"
+	"scrapper-test/utils/claude"
+	"scrapper-test/utils/openai"
+)
+
+// FailoverPolicy holds the configuration for sending the failed request again to the other provider
+type FailoverPolicy struct {
+	Fallback map[string]string // provider name to the fallback provider name, provider without fallback never fail over
+	// ShouldFailover check if the error from the selected provider should be sent to the fallback, nil use IsFailoverError
+	ShouldFailover func(err error) bool
+}
+
+// DefaultFailoverPolicy return the recommended failover policy, claude and openai is the fallback of each other
+// and only the outage error from IsFailoverError is sent to the fallback
+func DefaultFailoverPolicy() FailoverPolicy {
+	return FailoverPolicy{
+		Fallback: map[string]string{
+			ProviderClaude: ProviderOpenAI,
+			ProviderOpenAI: ProviderClaude,
+		},
+		ShouldFailover: IsFailoverError,
+	}
+}
+
+// IsFailoverError return true when the error mean the provider can't serve any request right now (rate limit, overloaded,
+// server error, out of quota, open circuit breaker, or the connection failed), so the same request may success on the other provider.
+// The error caused by the request itself (bad request, content policy, refusal, truncated or invalid answer) is not failed over
+func IsFailoverError(err error) bool {
+	var claudeErr *claude.APIError
+	var openaiErr *openai.APIError
+	var netErr net.Error
+
+	switch {
+	case err == nil || errors.Is(err, context.Canceled):
+		return false
+
+	case errors.Is(err, breaker.ErrOpen):
+		return true
+
+	case errors.As(err, &claudeErr):
+		return claudeErr.Retryable || claudeErr.StatusCode >= 500
+
+	case errors.As(err, &openaiErr):
+		return openaiErr.Retryable || openaiErr.StatusCode >= 500 ||
+			openaiErr.Code == "insufficient_quota" || openaiErr.Type == "insufficient_quota"
+
+	case errors.As(err, &netErr):
+		return true
+	}
+
+	return false
+}
+
+// failoverProvider send the request to the fallback provider when the primary failed with the failover error.
+// The response Provider is the provider that actually served the request, and the failed primary is on FailoverFrom
+type failoverProvider struct {
+	primary        Provider
+	fallback       Provider
+	shouldFailover func(err error) bool
+}
+
+// Name return the primary name, the request is sent to the primary first
+func (p *failoverProvider) Name() string {
+	return p.primary.Name()
+}
+
+func (p *failoverProvider) Generate(ctx context.Context, req Request) (*Response, error) {
+	resp, err := p.primary.Generate(ctx, req)
+	// the billed primary call is returned so its usage is recorded, instead of lost on the fallback response
+	if resp != nil || !p.canFailover(ctx, err) {
+		return resp, err
+	}
+
+	fallbackResp, fallbackErr := p.fallback.Generate(ctx, req)
+	return p.failoverResult(err, fallbackResp, fallbackErr)
+}
+
+// GenerateStream only fail over when no text is sent yet, the text already sent to onDelta can't be taken back
+func (p *failoverProvider) GenerateStream(ctx context.Context, req Request, onDelta func(text string) error) (*Response, error) {
+	started := false
+	resp, err := p.primary.GenerateStream(ctx, req, func(text string) error {
+		started = true
+		return onDelta(text)
+	})
+	if started || !p.canFailover(ctx, err) {
+		return resp, err
+	}
+
+	fallbackResp, fallbackErr := p.fallback.GenerateStream(ctx, req, onDelta)
+	return p.failoverResult(err, fallbackResp, fallbackErr)
+}
+
+// CountTokens count with the primary model, the fallback only used when the primary can't count at all
+func (p *failoverProvider) CountTokens(ctx context.Context, req Request) (*TokenCount, error) {
+	count, err := p.primary.CountTokens(ctx, req)
+	if !p.canFailover(ctx, err) {
+		return count, err
+	}
+
+	return p.fallback.CountTokens(ctx, req)
+}
+
+// canFailover check the primary error should be sent to the fallback, the request canceled or past its deadline is not sent again
+func (p *failoverProvider) canFailover(ctx context.Context, err error) bool {
+	return err != nil && ctx.Err() == nil && p.shouldFailover(err)
+}
+
+// failoverResult mark the fallback response with the failed primary. When the fallback also failed the primary error
+// is returned, because it is the provider the user selected
+func (p *failoverProvider) failoverResult(primaryErr error, resp *Response, err error) (*Response, error) {
+	if err != nil {
+		log.Printf("llm: %s failed (%v), failover to %s also failed: %v", p.primary.Name(), primaryErr, p.fallback.Name(), err)
+		return nil, primaryErr
+	}
+
+	log.Printf("llm: %s failed (%v), request served by %s", p.primary.Name(), primaryErr, p.fallback.Name())
+
+	resp.FailoverFrom = p.primary.Name()
+	resp.FailoverErr = primaryErr
+	return resp, nil
+}
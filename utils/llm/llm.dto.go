@@ -0,0 +1,106 @@
+package llm
+
+// provider agnostic message, role is "user" or "assistant"
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	Image   *Image `json:"image,omitempty"` // optional image for vision, sent before the text content
+	// optional text parts used instead of Content, so part of the message can be cached
+	Parts []Part `json:"parts,omitempty"`
+}
+
+// Text return the message text, the parts is joined with blank line
+func (m Message) Text() string {
+	if len(m.Parts) == 0 {
+		return m.Content
+	}
+
+	return joinParts(m.Parts)
+}
+
+// text part of the message or the system prompt. Cache mark the end of the prompt prefix that can be
+// reused by the next request with the same prefix, claude cache it explicitly (max 4 cache part on one request)
+// and openai cache the long prefix automatically, so the static part should be placed first
+type Part struct {
+	Text  string `json:"text"`
+	Cache bool   `json:"cache,omitempty"`
+}
+
+// base64 encoded image for vision request
+type Image struct {
+	MediaType string `json:"media_type"` // image/jpeg, image/png, image/gif, or image/webp
+	Data      string `json:"data"`       // base64 encoded image without the data url prefix
+}
+
+// json schema for structured output, name is used as the schema/tool name on provider side
+type Schema struct {
+	Name   string                 `json:"name"`
+	Schema map[string]interface{} `json:"schema"`
+	// openai strict mode, every object property become required so every object on the schema must have properties.
+	// claude has no strict mode, the flag is ignored
+	Strict bool `json:"strict,omitempty"`
+}
+
+// audio output request, the response content is the transcript of the generated audio
+type AudioRequest struct {
+	Voice  string `json:"voice"`  // provider voice name, like "alloy" on openai
+	Format string `json:"format"` // audio format, like "mp3" or "wav"
+}
+
+// generated audio on the response
+type Audio struct {
+	Format     string `json:"format"`
+	Data       string `json:"data"` // base64 encoded audio
+	Transcript string `json:"transcript"`
+}
+
+// provider agnostic request body
+type Request struct {
+	Model     string        `json:"model,omitempty"`      // if empty will use the provider configured model
+	System    []Part        `json:"system,omitempty"`     // optional system prompt, placed before the messages
+	Messages  []Message     `json:"messages"`             // required
+	MaxTokens int           `json:"max_tokens,omitempty"` // if 0 will use DefaultMaxTokens
+	Schema    *Schema       `json:"schema,omitempty"`     // if not nil, the response content will be JSON string follow the schema
+	Audio     *AudioRequest `json:"audio,omitempty"`      // if not nil, the response also contain the spoken audio, can't be used with Schema or stream
+	// max number of continue request when the text answer is cut by MaxTokens, claude only and not used on Schema or stream
+	MaxContinue int `json:"max_continue,omitempty"`
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	// audio part of the input and output tokens, priced differently from the text token
+	AudioInputTokens  int `json:"audio_input_tokens,omitempty"`
+	AudioOutputTokens int `json:"audio_output_tokens,omitempty"`
+	// prompt cache part of the input tokens, read is the reused prefix and write is the newly cached prefix
+	CacheReadTokens  int `json:"cache_read_tokens,omitempty"`
+	CacheWriteTokens int `json:"cache_write_tokens,omitempty"`
+}
+
+// input tokens of the request counted before it sent
+type TokenCount struct {
+	Model       string `json:"model"` // the model the request would be sent to
+	InputTokens int    `json:"input_tokens"`
+	Estimated   bool   `json:"estimated"` // true when counted by the local tokenizer instead of the provider
+	// context window of the model from the catalog, 0 mean not known and ContextWindow should be used
+	ContextWindow int `json:"context_window,omitempty"`
+}
+
+// provider agnostic response
+type Response struct {
+	Provider   string `json:"provider"`
+	Model      string `json:"model"`
+	Content    string `json:"content"`
+	StopReason string `json:"stop_reason"`
+	Usage      Usage  `json:"usage"`
+	Audio      *Audio `json:"audio,omitempty"` // only when the request has Audio
+	// the selected provider that failed before the request is served by Provider, empty when the request is not failed over.
+	// FailoverErr is the error of that provider, so the failed call can also be recorded
+	FailoverFrom string `json:"failover_from,omitempty"`
+	FailoverErr  error  `json:"-"`
+}
+
+// Truncated return true when the answer is cut because it reach the max tokens
+func (r *Response) Truncated() bool {
+	return r.StopReason == "max_tokens" || r.StopReason == "length"
+}
@@ -0,0 +1,236 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"scrapper-test/utils/jsonschema"
+	"strings"
+)
+
+// provider name, also used as the "model" value sent from the UI
+const (
+	ProviderClaude = "claude"
+	ProviderOpenAI = "openai"
+
+	DefaultMaxTokens = 256 * 10
+)
+
+var (
+	// ErrAudioNotSupported is returned when the request ask for audio output but the provider or the call not support it
+	ErrAudioNotSupported = errors.New("request failed: audio output is not supported")
+	// ErrTruncated is returned when the structured output is cut by the max tokens, so the content would be incomplete JSON.
+	// The cut text answer is not an error, check Response.Truncated instead
+	ErrTruncated = errors.New("request failed: response is truncated by the max tokens")
+	// ErrEmptyContent is returned when the provider response has no answer at all
+	ErrEmptyContent = errors.New("request failed: response content is empty")
+	// ErrRefused is returned when the model refuse to answer, the provider error with the refusal message is wrapped with it
+	ErrRefused = errors.New("request failed: model refused the request")
+)
+
+// joinParts join the text parts for provider that only accept one text content
+func joinParts(parts []Part) string {
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		texts = append(texts, part.Text)
+	}
+
+	return strings.Join(texts, "\n\n")
+}
+
+// NewSchema create the structured output schema from the struct the answer is decoded to, see jsonschema.Reflect
+// for the supported tag. The reflected schema has properties on every object, so the openai strict mode is enabled.
+//
+// Example usage:
+//
+//	schema, err := llm.NewSchema("titles_choices", models.StoriesCreateTitleFormat{})
+//	if err != nil {
+//	    log.Fatalf("Failed to create schema: %v", err)
+//	}
+//
+//	resp, err := provider.Generate(ctx, llm.Request{Messages: messages, Schema: schema})
+func NewSchema(name string, v interface{}) (*Schema, error) {
+	schema, err := jsonschema.Reflect(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Schema{
+		Name:   name,
+		Schema: schema,
+		Strict: true,
+	}, nil
+}
+
+// MustSchema same as NewSchema but panic on error, used for package level schema of the known struct
+func MustSchema(name string, v interface{}) *Schema {
+	schema, err := NewSchema(name, v)
+	if err != nil {
+		panic(err)
+	}
+
+	return schema
+}
+
+// Provider is the common contract for every LLM backend used by the controllers.
+//
+// Each provider is an adapter wrapping the provider specific client (claude.ClaudeAPI, openai.OpenAI, ...),
+// so adding new provider only need to create new adapter and register it on the Registry.
+type Provider interface {
+	Name() string
+	// Generate send the request and return the full answer. On error the response is nil, except when the provider
+	// already billed part of the call (like the cut answer before the retry failed), then only the Usage is set
+	Generate(ctx context.Context, req Request) (*Response, error)
+	// GenerateStream same as Generate but call onDelta for every text token received,
+	// the returned Response contain the full content after the stream finished
+	GenerateStream(ctx context.Context, req Request, onDelta func(text string) error) (*Response, error)
+	// CountTokens return the input tokens of the request before it sent, so the prompt can be checked
+	// against the model context window and the cost can be estimated
+	CountTokens(ctx context.Context, req Request) (*TokenCount, error)
+}
+
+// Registry holds all available providers and select the provider by name
+type Registry struct {
+	providers       map[string]Provider
+	defaultProvider string
+	failover        *FailoverPolicy // nil mean the request is never failed over
+	catalog         *Catalog        // nil mean only the provider name can be selected
+}
+
+// NewRegistry creates new provider registry.
+//
+// The defaultProvider is used when the requested name is empty or not registered, so it must be one of the given providers.
+//
+// Example usage:
+//
+//	registry, err := llm.NewRegistry(llm.ProviderOpenAI, llm.NewClaudeProvider(claudeClient), llm.NewOpenAIProvider(openaiClient))
+//	if err != nil {
+//	    log.Fatalf("Failed to create llm registry: %v", err)
+//	}
+//
+//	resp, err := registry.Get("claude").Generate(ctx, llm.Request{
+//	    Messages: []llm.Message{{Role: "user", Content: "Hello"}},
+//	})
+func NewRegistry(defaultProvider string, providers ...Provider) (*Registry, error) {
+	r := &Registry{
+		providers:       make(map[string]Provider),
+		defaultProvider: defaultProvider,
+	}
+
+	for _, p := range providers {
+		r.Register(p)
+	}
+
+	if _, ok := r.providers[defaultProvider]; !ok {
+		return nil, errors.New("default provider " + defaultProvider + " is not registered")
+	}
+
+	return r, nil
+}
+
+// Register add new provider or replace the provider with the same name
+func (r *Registry) Register(p Provider) {
+	r.providers[p.Name()] = p
+}
+
+// SetFailover enable the failover, after this the provider from Get send the failed request again to its fallback provider.
+// By default the registry not fail over any request, the request only fail over to the registered fallback
+//
+// Example usage:
+//
+//	registry.SetFailover(llm.DefaultFailoverPolicy())
+//
+//	resp, err := registry.Get(llm.ProviderClaude).Generate(ctx, req)
+//	if err == nil && resp.FailoverFrom != "" {
+//	    log.Printf("claude is down, served by %s", resp.Provider)
+//	}
+func (r *Registry) SetFailover(policy FailoverPolicy) {
+	if policy.ShouldFailover == nil {
+		policy.ShouldFailover = IsFailoverError
+	}
+
+	r.failover = &policy
+}
+
+// SetCatalog set the allowlist of the model that can be selected with Select, every model provider must be registered
+func (r *Registry) SetCatalog(catalog *Catalog) error {
+	for _, m := range catalog.Models {
+		if _, ok := r.providers[m.Provider]; !ok {
+			return errors.New("model " + m.ID + " provider " + m.Provider + " is not registered")
+		}
+	}
+
+	r.catalog = catalog
+	return nil
+}
+
+// Catalog return the model catalog, empty catalog when not set
+func (r *Registry) Catalog() *Catalog {
+	if r.catalog == nil {
+		return &Catalog{}
+	}
+
+	return r.catalog
+}
+
+// Get return provider by name, if the name not registered will return the default provider.
+// When the failover is enabled and the provider has registered fallback, the returned provider fail over to it
+func (r *Registry) Get(name string) Provider {
+	p, ok := r.providers[name]
+	if !ok {
+		p = r.providers[r.defaultProvider]
+	}
+
+	return r.withFailover(p)
+}
+
+// Select return the provider for the model value sent by the user. Empty value use the default provider and the provider
+// name use that provider, both with the configured model. The enabled catalog model id use its provider and every call
+// is sent with that model, the request that need the capability the model doesn't have return ErrModelNotSupported.
+// Other value return ErrModelNotAllowed.
+//
+// Example usage:
+//
+//	provider, err := registry.Select(c.FormValue("model"))
+//	if err != nil {
+//	    return err
+//	}
+//
+//	resp, err := provider.Generate(ctx, req)
+func (r *Registry) Select(model string) (Provider, error) {
+	if model == "" {
+		return r.Get(r.defaultProvider), nil
+	}
+
+	if _, ok := r.providers[model]; ok {
+		return r.Get(model), nil
+	}
+
+	m, ok := r.Catalog().Get(model)
+	if !ok || !m.Enabled {
+		return nil, fmt.Errorf("%w: %s", ErrModelNotAllowed, model)
+	}
+
+	return r.withFailover(&modelProvider{
+		provider: r.providers[m.Provider],
+		model:    m,
+	}), nil
+}
+
+// withFailover wrap the provider with its fallback when the failover is enabled, the fallback use its configured model
+func (r *Registry) withFailover(p Provider) Provider {
+	if r.failover == nil {
+		return p
+	}
+
+	fallback, ok := r.providers[r.failover.Fallback[p.Name()]]
+	if !ok || fallback.Name() == p.Name() {
+		return p
+	}
+
+	return &failoverProvider{
+		primary:        p,
+		fallback:       fallback,
+		shouldFailover: r.failover.ShouldFailover,
+	}
+}
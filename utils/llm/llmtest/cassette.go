@@ -0,0 +1,209 @@
+package llmtest
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// real api root url used when recording
+const (
+	upstreamClaude = "https://api.anthropic.com"
+	upstreamOpenAI = "https://api.openai.com"
+)
+
+// cassetteEntry is one recorded response saved as json file
+type cassetteEntry struct {
+	Method      string `json:"method"`
+	Path        string `json:"path"`
+	Status      int    `json:"status"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body,omitempty"`        // text body like json or server-sent events
+	BodyBase64  string `json:"body_base64,omitempty"` // binary body like audio
+}
+
+// cassette replay recorded response from dir, and record the missing one from the real api when record is true
+type cassette struct {
+	dir        string
+	record     bool
+	httpClient *http.Client
+}
+
+func newCassette(dir string, record bool) *cassette {
+	return &cassette{
+		dir:    dir,
+		record: record,
+		httpClient: &http.Client{
+			Timeout: 120 * time.Second,
+		},
+	}
+}
+
+func (c *cassette) serve(w http.ResponseWriter, req Request) {
+	path := c.filePath(req)
+
+	entry, err := c.load(path)
+	if err == nil {
+		entry.write(w)
+		return
+	}
+
+	if !errors.Is(err, os.ErrNotExist) {
+		writeError(w, req.Path, Error(http.StatusInternalServerError, "api_error", "llmtest: "+err.Error()))
+		return
+	}
+
+	if !c.record {
+		writeError(w, req.Path, Error(http.StatusInternalServerError, "api_error", "llmtest: no cassette "+path+", run with LLMTEST_RECORD=1 to record it"))
+		return
+	}
+
+	entry, err = c.fetch(req)
+	if err != nil {
+		writeError(w, req.Path, Error(http.StatusBadGateway, "api_error", "llmtest: "+err.Error()))
+		return
+	}
+
+	// only success response saved, so transient error like rate limit not replayed forever
+	if entry.Status >= 200 && entry.Status < 300 {
+		if err := c.save(path, entry); err != nil {
+			writeError(w, req.Path, Error(http.StatusInternalServerError, "api_error", "llmtest: "+err.Error()))
+			return
+		}
+	}
+
+	entry.write(w)
+}
+
+// filePath return the cassette file for the request, like "v1_messages-1a2b3c4d5e6f7a8b.json"
+func (c *cassette) filePath(req Request) string {
+	hash := sha256.New()
+	io.WriteString(hash, req.Method+" "+req.Path+"\n")
+	hash.Write(canonicalBody(req))
+
+	name := strings.ReplaceAll(strings.Trim(req.Path, "/"), "/", "_")
+	return filepath.Join(c.dir, name+"-"+hex.EncodeToString(hash.Sum(nil))[:16]+".json")
+}
+
+// canonicalBody return the body used for the cassette key, json is re-encoded so the key not depend on the field order
+// and the multipart boundary removed because it is random for each request
+func canonicalBody(req Request) []byte {
+	mediaType, params, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+
+	switch {
+	case mediaType == "application/json":
+		var value interface{}
+		if err := json.Unmarshal(req.Body, &value); err == nil {
+			if data, err := json.Marshal(value); err == nil {
+				return data
+			}
+		}
+
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+		return bytes.ReplaceAll(req.Body, []byte(params["boundary"]), nil)
+	}
+
+	return req.Body
+}
+
+func (c *cassette) load(path string) (*cassetteEntry, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entry cassetteEntry
+	if err := json.Unmarshal(file, &entry); err != nil {
+		return nil, errors.New("failed to decode cassette " + path + ": " + err.Error())
+	}
+
+	return &entry, nil
+}
+
+func (c *cassette) save(path string, entry *cassetteEntry) error {
+	if err := os.MkdirAll(c.dir, 0o755); err != nil {
+		return errors.New("failed to create cassette dir: " + err.Error())
+	}
+
+	file, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return errors.New("failed to encode cassette: " + err.Error())
+	}
+
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		return errors.New("failed to write cassette: " + err.Error())
+	}
+
+	return nil
+}
+
+// fetch send the request to the real api with the header from the client, so the api key is never saved
+func (c *cassette) fetch(req Request) (*cassetteEntry, error) {
+	upstream := upstreamOpenAI
+	if isClaudePath(req.Path) {
+		upstream = upstreamClaude
+	}
+
+	httpReq, err := http.NewRequest(req.Method, upstream+req.Path, bytes.NewReader(req.Body))
+	if err != nil {
+		return nil, errors.New("failed to create upstream request: " + err.Error())
+	}
+	httpReq.Header = req.Header.Clone()
+	// let the transport handle the compression so the saved body is plain text
+	httpReq.Header.Del("Accept-Encoding")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, errors.New("failed to send upstream request: " + err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.New("failed to read upstream response: " + err.Error())
+	}
+
+	entry := &cassetteEntry{
+		Method:      req.Method,
+		Path:        req.Path,
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+
+	if utf8.Valid(body) {
+		entry.Body = string(body)
+	} else {
+		entry.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+
+	return entry, nil
+}
+
+func (e *cassetteEntry) write(w http.ResponseWriter) {
+	body := []byte(e.Body)
+	if e.BodyBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(e.BodyBase64)
+		if err != nil {
+			http.Error(w, "llmtest: failed to decode cassette body: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body = data
+	}
+
+	if e.ContentType != "" {
+		w.Header().Set("Content-Type", e.ContentType)
+	}
+	w.WriteHeader(e.Status)
+	w.Write(body)
+}
@@ -0,0 +1,174 @@
+package llmtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"scrapper-test/utils/claude"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fakeBatch is message batch created on the fake server, every request is answered on create
+// and the batch is ended on the second poll
+type fakeBatch struct {
+	batch   claude.ClaudeMessageBatch
+	results []claude.ClaudeBatchResult
+	polls   int
+}
+
+// serveClaudeBatch handle the message batches endpoint, each batch request is answered with the reply queued or handled on
+// EndpointClaudeMessages, so the same script used for ClaudeSendMessage can be used for the batch
+func (s *Server) serveClaudeBatch(w http.ResponseWriter, req Request) {
+	path := strings.Trim(strings.TrimPrefix(req.Path, EndpointClaudeBatches), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case req.Method == http.MethodPost && path == "":
+		s.createClaudeBatch(w, req)
+
+	case req.Method == http.MethodGet && len(parts) == 1:
+		s.withClaudeBatch(w, req, parts[0], func(b *fakeBatch) {
+			// keep in progress on the first poll so the waiting code is exercised
+			b.polls++
+			if b.polls > 1 && b.batch.ProcessingStatus != claude.ClaudeBatchStatusEnded {
+				endClaudeBatch(b)
+			}
+			writeJSON(w, http.StatusOK, b.batch)
+		})
+
+	case req.Method == http.MethodGet && len(parts) == 2 && parts[1] == "results":
+		s.withClaudeBatch(w, req, parts[0], func(b *fakeBatch) {
+			if b.batch.ProcessingStatus != claude.ClaudeBatchStatusEnded {
+				writeError(w, req.Path, Error(http.StatusBadRequest, "invalid_request_error", "llmtest: batch "+parts[0]+" is still processing"))
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/binary")
+			w.WriteHeader(http.StatusOK)
+			encoder := json.NewEncoder(w)
+			for _, result := range b.results {
+				encoder.Encode(result)
+			}
+		})
+
+	case req.Method == http.MethodPost && len(parts) == 2 && parts[1] == "cancel":
+		s.withClaudeBatch(w, req, parts[0], func(b *fakeBatch) {
+			if b.batch.ProcessingStatus == claude.ClaudeBatchStatusInProgress {
+				now := time.Now().UTC().Format(time.RFC3339)
+				b.batch.CancelInitiatedAt = &now
+
+				// the fake batch has nothing running, so every request not finished yet is canceled
+				for i := range b.results {
+					b.results[i].Result = claude.ClaudeBatchResultData{Type: claude.ClaudeBatchResultCanceled}
+				}
+				b.batch.ProcessingStatus = claude.ClaudeBatchStatusCanceling
+			}
+			writeJSON(w, http.StatusOK, b.batch)
+		})
+
+	default:
+		writeError(w, req.Path, Error(http.StatusNotFound, "not_found_error", "llmtest: unknown endpoint "+req.Method+" "+req.Path))
+	}
+}
+
+func (s *Server) createClaudeBatch(w http.ResponseWriter, req Request) {
+	var body claude.ClaudeBatchCreateReq
+	if err := json.Unmarshal(req.Body, &body); err != nil {
+		writeError(w, req.Path, Error(http.StatusBadRequest, "invalid_request_error", "llmtest: invalid batch body: "+err.Error()))
+		return
+	}
+
+	id := "msgbatch_llmtest_" + strconv.Itoa(s.nextID())
+	now := time.Now().UTC()
+
+	b := &fakeBatch{
+		batch: claude.ClaudeMessageBatch{
+			ID:               id,
+			Type:             "message_batch",
+			ProcessingStatus: claude.ClaudeBatchStatusInProgress,
+			RequestCounts:    claude.ClaudeBatchRequestCounts{Processing: len(body.Requests)},
+			CreatedAt:        now.Format(time.RFC3339),
+			ExpiresAt:        now.Add(24 * time.Hour).Format(time.RFC3339),
+		},
+	}
+
+	for _, r := range body.Requests {
+		params, _ := json.Marshal(r.Params)
+		b.results = append(b.results, s.claudeBatchResult(r.CustomID, Request{
+			Method: http.MethodPost,
+			Path:   EndpointClaudeMessages,
+			Header: req.Header.Clone(),
+			Body:   params,
+		}))
+	}
+
+	s.mu.Lock()
+	s.batches[id] = b
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, b.batch)
+}
+
+// claudeBatchResult answer one batch request using the reply for EndpointClaudeMessages
+func (s *Server) claudeBatchResult(customID string, req Request) claude.ClaudeBatchResult {
+	result := claude.ClaudeBatchResult{CustomID: customID}
+
+	reply, ok := s.nextReply(req)
+	if !ok {
+		reply = Error(http.StatusInternalServerError, "api_error", "llmtest: no scripted reply for batch request "+customID)
+	}
+
+	if reply.Err != nil {
+		errBody := &claude.ClaudeRespError{Type: "error"}
+		errBody.Error.Type = reply.Err.Type
+		errBody.Error.Message = reply.Err.Message
+
+		result.Result = claude.ClaudeBatchResultData{Type: claude.ClaudeBatchResultErrored, Error: errBody}
+		return result
+	}
+
+	resp := s.claudeResp(req, req.meta(), reply)
+	result.Result = claude.ClaudeBatchResultData{Type: claude.ClaudeBatchResultSucceeded, Message: &resp}
+
+	return result
+}
+
+// withClaudeBatch call fn with the batch locked, or write not found error
+func (s *Server) withClaudeBatch(w http.ResponseWriter, req Request, id string, fn func(b *fakeBatch)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.batches[id]
+	if !ok {
+		writeError(w, req.Path, Error(http.StatusNotFound, "not_found_error", "llmtest: batch "+id+" not found"))
+		return
+	}
+
+	fn(b)
+}
+
+// endClaudeBatch mark the batch ended and count the result
+func endClaudeBatch(b *fakeBatch) {
+	now := time.Now().UTC().Format(time.RFC3339)
+	resultsUrl := "/v1/messages/batches/" + b.batch.ID + "/results"
+
+	counts := claude.ClaudeBatchRequestCounts{}
+	for _, result := range b.results {
+		switch result.Result.Type {
+		case claude.ClaudeBatchResultSucceeded:
+			counts.Succeeded++
+		case claude.ClaudeBatchResultErrored:
+			counts.Errored++
+		case claude.ClaudeBatchResultCanceled:
+			counts.Canceled++
+		case claude.ClaudeBatchResultExpired:
+			counts.Expired++
+		}
+	}
+
+	b.batch.ProcessingStatus = claude.ClaudeBatchStatusEnded
+	b.batch.RequestCounts = counts
+	b.batch.EndedAt = &now
+	b.batch.ResultsUrl = &resultsUrl
+}
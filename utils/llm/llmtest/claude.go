@@ -0,0 +1,133 @@
+package llmtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"scrapper-test/utils/claude"
+	"strconv"
+)
+
+// writeClaude render the reply as claude message response, or as claude stream events if the request use stream
+func (s *Server) writeClaude(w http.ResponseWriter, req Request, reply Reply) {
+	meta := req.meta()
+	resp := s.claudeResp(req, meta, reply)
+
+	if !meta.Stream {
+		w.Header().Set("request-id", resp.ID)
+		writeJSON(w, reply.status(), resp)
+		return
+	}
+
+	w.Header().Set("request-id", resp.ID)
+	sse := newSSEWriter(w)
+
+	block := resp.Content[0]
+	start := block
+	deltaType := "text_delta"
+	data := block.Text
+	if block.Type == "tool_use" {
+		start.Input = json.RawMessage(`{}`)
+		deltaType = "input_json_delta"
+		data = string(block.Input)
+	} else {
+		start.Text = ""
+	}
+
+	// message_start carry the input usage, the content is sent with the delta
+	message := resp
+	message.Content = []claude.ClaudeContentResp{}
+	message.StopReason = ""
+	message.Usage.OutputTokens = 1
+	sse.event("message_start", claude.ClaudeStreamEvent{Type: "message_start", Message: &message})
+	sse.event("content_block_start", claude.ClaudeStreamEvent{Type: "content_block_start", Index: 0, ContentBlock: &start})
+
+	for i, chunk := range chunks(data, 16) {
+		delta := &claude.ClaudeStreamDelta{Type: deltaType}
+		if deltaType == "text_delta" {
+			delta.Text = chunk
+		} else {
+			delta.PartialJson = chunk
+		}
+		sse.event("content_block_delta", claude.ClaudeStreamEvent{Type: "content_block_delta", Index: 0, Delta: delta})
+
+		if i == 0 && reply.StreamErr != nil {
+			sse.event("error", map[string]interface{}{
+				"type": "error",
+				"error": map[string]string{
+					"type":    reply.StreamErr.Type,
+					"message": reply.StreamErr.Message,
+				},
+			})
+			return
+		}
+	}
+
+	sse.event("content_block_stop", claude.ClaudeStreamEvent{Type: "content_block_stop", Index: 0})
+	sse.event("message_delta", claude.ClaudeStreamEvent{
+		Type:  "message_delta",
+		Delta: &claude.ClaudeStreamDelta{StopReason: resp.StopReason},
+		Usage: &claude.ClaudeUsage{OutputTokens: resp.Usage.OutputTokens},
+	})
+	sse.event("message_stop", claude.ClaudeStreamEvent{Type: "message_stop"})
+}
+
+// claudeResp build the full claude response, the structured output use the tool forced by the request tool_choice
+func (s *Server) claudeResp(req Request, meta requestMeta, reply Reply) claude.ClaudeResp {
+	id := strconv.Itoa(s.nextID())
+
+	model := meta.Model
+	if model == "" {
+		model = "claude-llmtest"
+	}
+
+	block := claude.ClaudeContentResp{Type: "text", Text: reply.Text + reply.Refusal}
+	stopReason := "end_turn"
+	output := block.Text
+
+	if reply.JSON != "" {
+		stopReason = "tool_use"
+		output = reply.JSON
+		block = claude.ClaudeContentResp{
+			Type:  "tool_use",
+			ID:    "toolu_llmtest_" + id,
+			Name:  meta.ToolChoice.Name,
+			Input: json.RawMessage(reply.JSON),
+		}
+	}
+
+	if reply.StopReason != "" {
+		stopReason = reply.StopReason
+	}
+
+	return claude.ClaudeResp{
+		ID:         "msg_llmtest_" + id,
+		Type:       "message",
+		Role:       "assistant",
+		Content:    []claude.ClaudeContentResp{block},
+		Model:      model,
+		StopReason: stopReason,
+		Usage: claude.ClaudeUsage{
+			InputTokens:              tokens(reply.InputTokens, string(req.Body)),
+			OutputTokens:             tokens(reply.OutputTokens, output),
+			CacheReadInputTokens:     reply.CacheReadTokens,
+			CacheCreationInputTokens: reply.CacheWriteTokens,
+		},
+	}
+}
+
+// tokens return the scripted token count, or estimate it using 4 character per token
+func tokens(scripted int, text string) int {
+	if scripted > 0 {
+		return scripted
+	}
+
+	return len([]rune(text))/4 + 1
+}
+
+// writeClaudeCountTokens render the reply input tokens as count tokens response
+func (s *Server) writeClaudeCountTokens(w http.ResponseWriter, req Request, reply Reply) {
+	w.Header().Set("request-id", "req_llmtest_"+strconv.Itoa(s.nextID()))
+	writeJSON(w, reply.status(), claude.ClaudeCountTokensResp{
+		InputTokens: tokens(reply.InputTokens, string(req.Body)),
+	})
+}
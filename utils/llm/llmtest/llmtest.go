@@ -0,0 +1,507 @@
+// Package llmtest provide fake LLM server for offline test.
+//
+// The server speak the same protocol as the Claude `/v1/messages` endpoint and the OpenAI chat completions,
+// image generations, text to speech, transcriptions, moderations, and embeddings endpoint, so the real claude and openai client can be pointed to it
+// and every code using the client (llm.Provider, controllers) run without network.
+//
+// The reply for each request is taken in this order:
+//  1. scripted reply queued with Enqueue, one reply used for one request
+//  2. reply function set with Handle
+//  3. cassette file when the server created using WithCassette
+//
+// If none of them give reply the server respond with 500 error, so missing script is visible on the test.
+//
+// The Claude message batches endpoint is served by fake batch, each batch request answered with the reply for
+// EndpointClaudeMessages, and the batch ended on the second poll. The Claude count tokens endpoint without scripted
+// reply is answered with the tokens estimated from the request body, script it with WithUsage to set the input tokens.
+//
+// OpenAI chat completions with "audio" modality get the reply text as the transcript of the audio output,
+// use Narration to script the audio bytes.
+//
+// Example usage:
+//
+//	srv := llmtest.New()
+//	defer srv.Close()
+//
+//	srv.Enqueue(llmtest.EndpointClaudeMessages, llmtest.RateLimited(), llmtest.Text("Hello from fake claude"))
+//
+//	registry, err := srv.Registry(llm.ProviderClaude)
+//	if err != nil {
+//	    t.Fatal(err)
+//	}
+//
+//	resp, err := registry.Get(llm.ProviderClaude).Generate(ctx, llm.Request{
+//	    Messages: []llm.Message{{Role: "user", Content: "Hello"}},
+//	})
+package llmtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"scrapper-test/utils/claude"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/openai"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpoint path served by the fake server
+const (
+	EndpointClaudeMessages       = "/v1/messages"
+	EndpointClaudeBatches        = "/v1/messages/batches"
+	EndpointClaudeCountTokens    = "/v1/messages/count_tokens"
+	EndpointOpenAIChat           = "/v1/chat/completions"
+	EndpointOpenAIImages         = "/v1/images/generations"
+	EndpointOpenAISpeech         = "/v1/audio/speech"
+	EndpointOpenAITranscriptions = "/v1/audio/transcriptions"
+	EndpointOpenAIModerations    = "/v1/moderations"
+	EndpointOpenAIEmbeddings     = "/v1/embeddings"
+)
+
+// Request is the request received by the fake server, can be used to assert what the client sent
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+// requestMeta is the common field on the claude and openai request body needed to render the reply
+type requestMeta struct {
+	Model          string          `json:"model"`
+	Stream         bool            `json:"stream"`
+	ResponseFormat json.RawMessage `json:"response_format"` // string on image generations, object on chat completions
+	Modalities     []string        `json:"modalities"`      // chat completions with "audio" get the audio output
+	Audio          struct {
+		Format string `json:"format"`
+	} `json:"audio"`
+	ToolChoice struct {
+		Name string `json:"name"`
+	} `json:"tool_choice"`
+}
+
+// meta decode the common request field, body that is not json object return empty meta
+func (r Request) meta() requestMeta {
+	var meta requestMeta
+	json.Unmarshal(r.Body, &meta)
+
+	return meta
+}
+
+// Model return the model sent on the request body
+func (r Request) Model() string {
+	return r.meta().Model
+}
+
+// Stream return true if the request ask for streaming response
+func (r Request) Stream() bool {
+	return r.meta().Stream
+}
+
+// ReplyFunc create reply for the request, used with Handle for reply depending on the request
+type ReplyFunc func(req Request) Reply
+
+// Server is the fake LLM server, create it with New and close it with Close
+type Server struct {
+	server   *httptest.Server
+	cassette *cassette
+
+	mu       sync.Mutex
+	replies  map[string][]Reply
+	handlers map[string]ReplyFunc
+	batches  map[string]*fakeBatch
+	requests []Request
+	counter  int
+}
+
+// server options for configuring the fake server
+type Option func(*Server)
+
+// WithCassette replay the response saved on dir for request that has no scripted reply.
+//
+// When the environment variable LLMTEST_RECORD=1 and the response file not exist yet, the request is sent to the real
+// api (https://api.anthropic.com or https://api.openai.com) with the api key from the client, and the success response
+// is saved to dir so the next run replay it without network. The response file name is derived from the request path
+// and body, so the same request always replay the same response.
+func WithCassette(dir string) Option {
+	return func(s *Server) {
+		s.cassette = newCassette(dir, os.Getenv("LLMTEST_RECORD") == "1")
+	}
+}
+
+// New start the fake server, the server must be closed with Close after the test
+func New(opts ...Option) *Server {
+	s := &Server{
+		replies:  make(map[string][]Reply),
+		handlers: make(map[string]ReplyFunc),
+		batches:  make(map[string]*fakeBatch),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	return s
+}
+
+// Close shut down the server
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// URL return the root url of the server, like "http://127.0.0.1:12345"
+func (s *Server) URL() string {
+	return s.server.URL
+}
+
+// ClaudeUrl return the url for claude.WithBaseUrl
+func (s *Server) ClaudeUrl() string {
+	return s.server.URL + EndpointClaudeMessages
+}
+
+// OpenAIUrl return the url for openai.WithRootUrl
+func (s *Server) OpenAIUrl() string {
+	return s.server.URL + "/v1"
+}
+
+// Enqueue add scripted reply for the endpoint, the replies are used in order one for each request
+func (s *Server) Enqueue(endpoint string, replies ...Reply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replies[endpoint] = append(s.replies[endpoint], replies...)
+}
+
+// Handle set reply function for the endpoint, used when the endpoint has no scripted reply left
+func (s *Server) Handle(endpoint string, fn ReplyFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.handlers[endpoint] = fn
+}
+
+// Requests return all request received by the server in order
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Request(nil), s.requests...)
+}
+
+// RequestsTo return the request received on the endpoint in order
+func (s *Server) RequestsTo(endpoint string) []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Request
+	for _, r := range s.requests {
+		if r.Path == endpoint {
+			result = append(result, r)
+		}
+	}
+
+	return result
+}
+
+// Pending return the scripted reply that not used yet for the endpoint, should be 0 at the end of the test
+func (s *Server) Pending(endpoint string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.replies[endpoint])
+}
+
+// ClaudeClient create claude client pointed to the server, the api key is taken from CLAUDE_API_KEY so the cassette
+// can record with the real key, or "test-key" if empty. The given opts applied after the default test option
+func (s *Server) ClaudeClient(opts ...claude.ClientOption) (claude.ClaudeAPI, error) {
+	apiKey := os.Getenv("CLAUDE_API_KEY")
+	if apiKey == "" {
+		apiKey = "test-key"
+	}
+
+	return claude.New(apiKey, append([]claude.ClientOption{
+		claude.WithBaseUrl(s.ClaudeUrl()),
+		claude.WithAnthropicVersion("2023-06-01"),
+	}, opts...)...)
+}
+
+// OpenAIClient create openai client pointed to the server, the api key is taken from OA_APIKEY so the cassette
+// can record with the real key, or "test-key" if empty. The given opts applied after the default test option
+func (s *Server) OpenAIClient(opts ...openai.ClientOption) (openai.OpenAI, error) {
+	apiKey := os.Getenv("OA_APIKEY")
+	if apiKey == "" {
+		apiKey = "test-key"
+	}
+
+	return openai.New(apiKey, "", "", append([]openai.ClientOption{
+		openai.WithRootUrl(s.OpenAIUrl()),
+	}, opts...)...)
+}
+
+// Registry create llm.Registry with claude and openai provider pointed to the server,
+// ready to be passed to the controllers constructor
+func (s *Server) Registry(defaultProvider string) (*llm.Registry, error) {
+	claudeClient, err := s.ClaudeClient()
+	if err != nil {
+		return nil, err
+	}
+
+	openaiClient, err := s.OpenAIClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return llm.NewRegistry(defaultProvider, llm.NewClaudeProvider(claudeClient), llm.NewOpenAIProvider(openaiClient))
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "llmtest: failed to read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+
+	reply, ok := s.nextReply(req)
+	if ok {
+		s.writeReply(w, r, req, reply)
+		return
+	}
+
+	// batch is stateful, so it is always served by the fake batch instead of the cassette
+	if strings.HasPrefix(req.Path, EndpointClaudeBatches) {
+		s.serveClaudeBatch(w, req)
+		return
+	}
+
+	// token counting is done before most message request, so it is estimated from the body unless scripted
+	if req.Path == EndpointClaudeCountTokens {
+		s.writeClaudeCountTokens(w, req, Reply{})
+		return
+	}
+
+	if s.cassette != nil {
+		s.cassette.serve(w, req)
+		return
+	}
+
+	writeError(w, req.Path, Error(http.StatusInternalServerError, "api_error", "llmtest: no scripted reply for "+req.Method+" "+req.Path))
+}
+
+// nextReply save the request and take the reply for it
+func (s *Server) nextReply(req Request) (Reply, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests = append(s.requests, req)
+
+	if queue := s.replies[req.Path]; len(queue) > 0 {
+		s.replies[req.Path] = queue[1:]
+		return queue[0], true
+	}
+
+	if fn, ok := s.handlers[req.Path]; ok {
+		// unlock while calling the reply function so it can call the server method
+		s.mu.Unlock()
+		reply := fn(req)
+		s.mu.Lock()
+		return reply, true
+	}
+
+	return Reply{}, false
+}
+
+// nextID return unique id for the response
+func (s *Server) nextID() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counter++
+	return s.counter
+}
+
+func (s *Server) writeReply(w http.ResponseWriter, r *http.Request, req Request, reply Reply) {
+	if reply.Delay > 0 {
+		select {
+		case <-time.After(reply.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	for key, value := range reply.Header {
+		w.Header().Set(key, value)
+	}
+
+	switch {
+	case reply.Err != nil:
+		writeError(w, req.Path, reply)
+
+	case reply.Body != nil:
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.WriteHeader(reply.status())
+		w.Write(reply.Body)
+
+	case req.Path == EndpointClaudeCountTokens:
+		s.writeClaudeCountTokens(w, req, reply)
+
+	case isClaudePath(req.Path):
+		s.writeClaude(w, req, reply)
+
+	case req.Path == EndpointOpenAIChat:
+		s.writeOpenAIChat(w, req, reply)
+
+	case req.Path == EndpointOpenAIImages:
+		s.writeOpenAIImages(w, req, reply)
+
+	case req.Path == EndpointOpenAISpeech:
+		s.writeOpenAISpeech(w, req, reply)
+
+	case req.Path == EndpointOpenAITranscriptions:
+		s.writeOpenAITranscription(w, req, reply)
+
+	case req.Path == EndpointOpenAIModerations:
+		s.writeOpenAIModeration(w, req, reply)
+
+	case req.Path == EndpointOpenAIEmbeddings:
+		s.writeOpenAIEmbeddings(w, req, reply)
+
+	default:
+		writeError(w, req.Path, Error(http.StatusNotFound, "not_found_error", "llmtest: unknown endpoint "+req.Path))
+	}
+}
+
+// isClaudePath return true for the claude endpoint, all claude endpoint is under /v1/messages
+func isClaudePath(path string) bool {
+	return strings.HasPrefix(path, EndpointClaudeMessages)
+}
+
+// writeJSON write the value as json response
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(value); err != nil {
+		http.Error(w, "llmtest: failed to encode response: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
+
+// writeError write the reply error using the error body of the provider that own the path
+func writeError(w http.ResponseWriter, path string, reply Reply) {
+	apiErr := reply.Err
+	if apiErr == nil {
+		apiErr = &APIError{Type: "api_error", Message: "llmtest: error"}
+	}
+
+	if isClaudePath(path) {
+		w.Header().Set("request-id", "req_llmtest")
+
+		var body claude.ClaudeRespError
+		body.Type = "error"
+		body.Error.Type = apiErr.Type
+		body.Error.Message = apiErr.Message
+		writeJSON(w, reply.status(), body)
+		return
+	}
+
+	w.Header().Set("x-request-id", "req_llmtest")
+
+	var code interface{}
+	if apiErr.Code != "" {
+		code = apiErr.Code
+	}
+	writeJSON(w, reply.status(), map[string]interface{}{
+		"error": map[string]interface{}{
+			"type":    apiErr.Type,
+			"code":    code,
+			"message": apiErr.Message,
+			"param":   nil,
+		},
+	})
+}
+
+// sseWriter write server-sent events and flush each event so the client receive it immediately
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	flusher, _ := w.(http.Flusher)
+	return &sseWriter{w: w, flusher: flusher}
+}
+
+// event write one event, empty name only write the data line like OpenAI stream
+func (s *sseWriter) event(name string, data interface{}) {
+	var payload []byte
+	switch d := data.(type) {
+	case string:
+		payload = []byte(d)
+	default:
+		payload, _ = json.Marshal(d)
+	}
+
+	if name != "" {
+		io.WriteString(s.w, "event: "+name+"\n")
+	}
+	io.WriteString(s.w, "data: ")
+	s.w.Write(payload)
+	io.WriteString(s.w, "\n\n")
+
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// chunks split the text to small chunk so the stream contain multiple delta, empty text return one empty chunk
+func chunks(text string, size int) []string {
+	runes := []rune(text)
+	if len(runes) == 0 {
+		return []string{""}
+	}
+
+	var result []string
+
+	for len(runes) > size {
+		result = append(result, string(runes[:size]))
+		runes = runes[size:]
+	}
+	if len(runes) > 0 {
+		result = append(result, string(runes))
+	}
+
+	return result
+}
+
+// hasAudioOutput report whether the chat completions request ask for the audio output
+func (m requestMeta) hasAudioOutput() bool {
+	for _, modality := range m.Modalities {
+		if modality == openai.OAModalityAudio {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,368 @@
+package llmtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"scrapper-test/utils/claude"
+	"scrapper-test/utils/llm"
+	"scrapper-test/utils/openai"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestProvider create the provider pointed to the server, retried up to maxRetries without waiting
+func newTestProvider(t *testing.T, srv *Server, name string, maxRetries int) llm.Provider {
+	t.Helper()
+
+	switch name {
+	case llm.ProviderClaude:
+		client, err := srv.ClaudeClient(claude.WithRetryPolicy(claude.RetryPolicy{MaxRetries: maxRetries, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return llm.NewClaudeProvider(client)
+
+	default:
+		client, err := srv.OpenAIClient(openai.WithRetryPolicy(openai.RetryPolicy{MaxRetries: maxRetries, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return llm.NewOpenAIProvider(client)
+	}
+}
+
+// apiErrorStatus return the status of the claude or openai api error, 0 if err is not api error
+func apiErrorStatus(err error) int {
+	var claudeErr *claude.APIError
+	var openaiErr *openai.APIError
+
+	switch {
+	case errors.As(err, &claudeErr):
+		return claudeErr.StatusCode
+	case errors.As(err, &openaiErr):
+		return openaiErr.StatusCode
+	}
+
+	return 0
+}
+
+func testRequest(content string) llm.Request {
+	return llm.Request{
+		Messages: []llm.Message{{Role: "user", Content: content}},
+	}
+}
+
+func TestServerScriptedReply(t *testing.T) {
+	for _, name := range []string{llm.ProviderClaude, llm.ProviderOpenAI} {
+		t.Run(name, func(t *testing.T) {
+			srv := New()
+			defer srv.Close()
+
+			endpoint := EndpointClaudeMessages
+			if name == llm.ProviderOpenAI {
+				endpoint = EndpointOpenAIChat
+			}
+
+			// the queue is used first in order, then the handler for every next request
+			srv.Enqueue(endpoint, Text("first").WithUsage(10, 5), Text("second"))
+			srv.Handle(endpoint, func(req Request) Reply {
+				return Text("handled " + req.Model())
+			})
+
+			provider := newTestProvider(t, srv, name, 0)
+
+			var contents []string
+			for i := 0; i < 3; i++ {
+				resp, err := provider.Generate(context.Background(), testRequest("halo"))
+				if err != nil {
+					t.Fatalf("Generate() %d error = %v", i, err)
+				}
+				contents = append(contents, resp.Content)
+
+				if i == 0 && (resp.Usage.InputTokens != 10 || resp.Usage.OutputTokens != 5) {
+					t.Errorf("usage = %+v, want 10 input and 5 output tokens", resp.Usage)
+				}
+			}
+
+			requests := srv.RequestsTo(endpoint)
+			if len(requests) != 3 {
+				t.Fatalf("requests = %d, want 3", len(requests))
+			}
+
+			want := []string{"first", "second", "handled " + requests[2].Model()}
+			for i := range want {
+				if contents[i] != want[i] {
+					t.Errorf("content %d = %q, want %q", i, contents[i], want[i])
+				}
+			}
+
+			if requests[0].Model() == "" || !strings.Contains(string(requests[0].Body), "halo") {
+				t.Errorf("request body = %s, want the model and the message", requests[0].Body)
+			}
+			if got := srv.Pending(endpoint); got != 0 {
+				t.Errorf("Pending() = %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestServerNoScriptedReply(t *testing.T) {
+	srv := New()
+	defer srv.Close()
+
+	_, err := newTestProvider(t, srv, llm.ProviderOpenAI, 0).Generate(context.Background(), testRequest("halo"))
+	if status := apiErrorStatus(err); status != http.StatusInternalServerError {
+		t.Fatalf("Generate() error = %v, want 500 api error", err)
+	}
+	if !strings.Contains(err.Error(), "no scripted reply") {
+		t.Errorf("Generate() error = %v, want the missing script message", err)
+	}
+}
+
+func TestServerErrorReply(t *testing.T) {
+	tests := []struct {
+		name         string
+		provider     string
+		replies      []Reply
+		maxRetries   int
+		wantErr      bool
+		wantStatus   int // status of the api error, 0 when the error is not api error
+		wantRequests int
+		wantPending  int
+	}{
+		{name: "claude rate limited retried", provider: llm.ProviderClaude, replies: []Reply{RateLimited(), Text("ok")}, maxRetries: 3, wantRequests: 2},
+		{name: "openai rate limited retried", provider: llm.ProviderOpenAI, replies: []Reply{RateLimited(), Text("ok")}, maxRetries: 3, wantRequests: 2},
+		{name: "claude overloaded retried", provider: llm.ProviderClaude, replies: []Reply{Overloaded(), Text("ok")}, maxRetries: 3, wantRequests: 2},
+		{name: "claude overloaded", provider: llm.ProviderClaude, replies: []Reply{Overloaded()}, wantErr: true, wantStatus: 529, wantRequests: 1},
+		{name: "openai rate limited", provider: llm.ProviderOpenAI, replies: []Reply{RateLimited()}, wantErr: true, wantStatus: http.StatusTooManyRequests, wantRequests: 1},
+		{
+			name:         "openai insufficient quota not retried",
+			provider:     llm.ProviderOpenAI,
+			replies:      []Reply{InsufficientQuota(), Text("ok")},
+			maxRetries:   3,
+			wantErr:      true,
+			wantStatus:   http.StatusTooManyRequests,
+			wantRequests: 1,
+			wantPending:  1,
+		},
+		{name: "claude error", provider: llm.ProviderClaude, replies: []Reply{Error(http.StatusBadRequest, "invalid_request_error", "bad")}, wantErr: true, wantStatus: http.StatusBadRequest, wantRequests: 1},
+		{name: "claude malformed", provider: llm.ProviderClaude, replies: []Reply{Malformed()}, wantErr: true, wantRequests: 1},
+		{name: "openai malformed", provider: llm.ProviderOpenAI, replies: []Reply{Malformed()}, wantErr: true, wantRequests: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New()
+			defer srv.Close()
+
+			endpoint := EndpointClaudeMessages
+			if tt.provider == llm.ProviderOpenAI {
+				endpoint = EndpointOpenAIChat
+			}
+			srv.Enqueue(endpoint, tt.replies...)
+
+			resp, err := newTestProvider(t, srv, tt.provider, tt.maxRetries).Generate(context.Background(), testRequest("halo"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && resp.Content != "ok" {
+				t.Errorf("content = %q, want ok", resp.Content)
+			}
+			if status := apiErrorStatus(err); status != tt.wantStatus {
+				t.Errorf("api error status = %d, want %d (error %v)", status, tt.wantStatus, err)
+			}
+
+			if got := len(srv.RequestsTo(endpoint)); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if got := srv.Pending(endpoint); got != tt.wantPending {
+				t.Errorf("Pending() = %d, want %d", got, tt.wantPending)
+			}
+		})
+	}
+}
+
+func TestServerStreamError(t *testing.T) {
+	for _, name := range []string{llm.ProviderClaude, llm.ProviderOpenAI} {
+		t.Run(name, func(t *testing.T) {
+			srv := New()
+			defer srv.Close()
+
+			endpoint := EndpointClaudeMessages
+			if name == llm.ProviderOpenAI {
+				endpoint = EndpointOpenAIChat
+			}
+
+			text := "roasting yang panjang dan terpotong di tengah jalan"
+			srv.Enqueue(endpoint, StreamError(text, "overloaded_error", "llmtest: overloaded"))
+
+			var received strings.Builder
+			_, err := newTestProvider(t, srv, name, 0).GenerateStream(context.Background(), testRequest("halo"), func(delta string) error {
+				received.WriteString(delta)
+				return nil
+			})
+			if err == nil {
+				t.Fatal("GenerateStream() error = nil, want stream error")
+			}
+
+			// the error is sent after the first chunk, so part of the text is received before it
+			if received.Len() == 0 || received.Len() == len(text) || !strings.HasPrefix(text, received.String()) {
+				t.Errorf("received = %q, want the start of %q", received.String(), text)
+			}
+
+			var claudeErr *claude.APIError
+			if name == llm.ProviderClaude && (!errors.As(err, &claudeErr) || claudeErr.Type != "overloaded_error") {
+				t.Errorf("GenerateStream() error = %v, want claude overloaded_error", err)
+			}
+		})
+	}
+}
+
+// rewriteTransport send every request to the target server instead of the real api
+type rewriteTransport struct {
+	target *url.URL
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = ""
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// newRecordingServer create server that record the cassette to dir from the upstream server
+func newRecordingServer(t *testing.T, dir string, upstream *Server) *Server {
+	t.Helper()
+	t.Setenv("LLMTEST_RECORD", "1")
+
+	target, err := url.Parse(upstream.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(WithCassette(dir))
+	srv.cassette.httpClient.Transport = &rewriteTransport{target: target}
+
+	return srv
+}
+
+func TestCassetteRecordReplay(t *testing.T) {
+	t.Setenv("OA_APIKEY", "")
+	dir := t.TempDir()
+
+	upstream := New()
+	defer upstream.Close()
+	upstream.Enqueue(EndpointOpenAIChat, Text("recorded answer"))
+
+	recorder := newRecordingServer(t, dir, upstream)
+	defer recorder.Close()
+
+	resp, err := newTestProvider(t, recorder, llm.ProviderOpenAI, 0).Generate(context.Background(), testRequest("halo"))
+	if err != nil {
+		t.Fatalf("record Generate() error = %v", err)
+	}
+	if resp.Content != "recorded answer" {
+		t.Errorf("record content = %q, want recorded answer", resp.Content)
+	}
+
+	// the request is forwarded with the client header, the api key is not saved on the cassette
+	upstreamRequests := upstream.RequestsTo(EndpointOpenAIChat)
+	if len(upstreamRequests) != 1 {
+		t.Fatalf("upstream requests = %d, want 1", len(upstreamRequests))
+	}
+	if got := upstreamRequests[0].Header.Get("Authorization"); got != "Bearer test-key" {
+		t.Errorf("upstream Authorization = %q, want the client api key", got)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || !strings.HasPrefix(files[0].Name(), "v1_chat_completions-") {
+		t.Fatalf("cassette files = %v, want one chat completions file", files)
+	}
+	file, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(file), "test-key") {
+		t.Error("cassette file contain the api key")
+	}
+
+	// the replay server has no network, the same request is answered from the cassette
+	t.Setenv("LLMTEST_RECORD", "")
+	replay := New(WithCassette(dir))
+	defer replay.Close()
+	provider := newTestProvider(t, replay, llm.ProviderOpenAI, 0)
+
+	resp, err = provider.Generate(context.Background(), testRequest("halo"))
+	if err != nil {
+		t.Fatalf("replay Generate() error = %v", err)
+	}
+	if resp.Content != "recorded answer" {
+		t.Errorf("replay content = %q, want recorded answer", resp.Content)
+	}
+	if got := len(upstream.RequestsTo(EndpointOpenAIChat)); got != 1 {
+		t.Errorf("upstream requests after replay = %d, want 1", got)
+	}
+
+	// the request not recorded yet is not sent without LLMTEST_RECORD
+	_, err = provider.Generate(context.Background(), testRequest("request lain"))
+	if err == nil || !strings.Contains(err.Error(), "no cassette") {
+		t.Errorf("replay not recorded request error = %v, want no cassette error", err)
+	}
+}
+
+func TestCassetteScriptedReplyFirst(t *testing.T) {
+	dir := t.TempDir()
+
+	upstream := New()
+	defer upstream.Close()
+
+	recorder := newRecordingServer(t, dir, upstream)
+	defer recorder.Close()
+	recorder.Enqueue(EndpointClaudeMessages, Text("scripted"))
+
+	resp, err := newTestProvider(t, recorder, llm.ProviderClaude, 0).Generate(context.Background(), testRequest("halo"))
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if resp.Content != "scripted" {
+		t.Errorf("content = %q, want scripted", resp.Content)
+	}
+
+	if got := len(upstream.Requests()); got != 0 {
+		t.Errorf("upstream requests = %d, want 0", got)
+	}
+}
+
+func TestCassetteRecordSkipError(t *testing.T) {
+	dir := t.TempDir()
+
+	upstream := New()
+	defer upstream.Close()
+	upstream.Enqueue(EndpointOpenAIChat, InsufficientQuota())
+
+	recorder := newRecordingServer(t, dir, upstream)
+	defer recorder.Close()
+
+	_, err := newTestProvider(t, recorder, llm.ProviderOpenAI, 0).Generate(context.Background(), testRequest("halo"))
+	if status := apiErrorStatus(err); status != http.StatusTooManyRequests {
+		t.Fatalf("Generate() error = %v, want the upstream 429 error", err)
+	}
+
+	// the failed response is returned but not saved, so the next run send the request again
+	files, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("cassette files = %v, want none", files)
+	}
+}
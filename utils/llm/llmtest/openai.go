@@ -0,0 +1,307 @@
+package llmtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+	"net/http"
+	"scrapper-test/utils/openai"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeOpenAIChat render the reply as chat completion response, or as chat completion chunks if the request use stream
+func (s *Server) writeOpenAIChat(w http.ResponseWriter, req Request, reply Reply) {
+	meta := req.meta()
+	id := "chatcmpl-llmtest-" + strconv.Itoa(s.nextID())
+	created := time.Now().Unix()
+
+	model := meta.Model
+	if model == "" {
+		model = "gpt-llmtest"
+	}
+
+	// structured output on openai is the json string on the message content
+	content := reply.Text
+	if reply.JSON != "" {
+		content = reply.JSON
+	}
+
+	finishReason := "stop"
+	if reply.StopReason != "" {
+		finishReason = reply.StopReason
+	}
+
+	usage := openai.OAUsage{
+		PromptTokens:     tokens(reply.InputTokens, string(req.Body)),
+		CompletionTokens: tokens(reply.OutputTokens, content),
+	}
+	usage.PromptTokensDetail.CachedTokens = reply.CacheReadTokens
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	w.Header().Set("x-request-id", "req_"+id)
+
+	if !meta.Stream {
+		message := openai.OAMessage{
+			Role:    "assistant",
+			Content: content,
+			Refusal: reply.Refusal,
+		}
+
+		// audio output put the text on the transcript, the audio token is part of the completion token
+		if meta.hasAudioOutput() {
+			message.Content = ""
+			message.Audio = &openai.OAAudioDataResponse{
+				Id:         "audio_llmtest_" + strconv.Itoa(s.nextID()),
+				ExpiresAt:  time.Now().Add(time.Hour).Unix(),
+				Data:       base64.StdEncoding.EncodeToString(narrationAudio(reply, meta.Audio.Format)),
+				Transcript: content,
+			}
+			usage.CompletionTokensDetail.AudioTokens = usage.CompletionTokens
+		}
+
+		writeJSON(w, reply.status(), openai.OAChatCompletionResp{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   model,
+			Choices: []openai.OAChoice{
+				{
+					Index:        0,
+					Message:      message,
+					FinishReason: finishReason,
+				},
+			},
+			Usage: usage,
+		})
+		return
+	}
+
+	sse := newSSEWriter(w)
+	chunk := func(delta openai.OADelta, finish *string) openai.OAChatCompletionChunk {
+		return openai.OAChatCompletionChunk{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []openai.OAChunkChoice{{Index: 0, Delta: delta, FinishReason: finish}},
+		}
+	}
+
+	sse.event("", chunk(openai.OADelta{Role: "assistant"}, nil))
+
+	if reply.Refusal != "" {
+		sse.event("", chunk(openai.OADelta{Refusal: reply.Refusal}, nil))
+	}
+
+	for _, text := range chunks(content, 16) {
+		sse.event("", chunk(openai.OADelta{Content: text}, nil))
+
+		// openai has no error event, the stream is cut with error data and without [DONE]
+		if reply.StreamErr != nil {
+			sse.event("", map[string]interface{}{
+				"error": map[string]string{
+					"type":    reply.StreamErr.Type,
+					"code":    reply.StreamErr.Code,
+					"message": reply.StreamErr.Message,
+				},
+			})
+			return
+		}
+	}
+
+	sse.event("", chunk(openai.OADelta{}, &finishReason))
+
+	// last chunk carry the usage because the client always set stream_options.include_usage
+	sse.event("", openai.OAChatCompletionChunk{
+		ID:      id,
+		Object:  "chat.completion.chunk",
+		Created: created,
+		Model:   model,
+		Choices: []openai.OAChunkChoice{},
+		Usage:   &usage,
+	})
+	sse.event("", "[DONE]")
+}
+
+// writeOpenAIImages render the reply as image generations response
+func (s *Server) writeOpenAIImages(w http.ResponseWriter, req Request, reply Reply) {
+	isBase64 := string(req.meta().ResponseFormat) == `"b64_json"`
+
+	resp := openai.OAImageGeneratorDallEResp{
+		Created: time.Now().Unix(),
+		Data:    []openai.OAImageGeneratorDallEData{},
+	}
+
+	for _, data := range reply.Images {
+		if isBase64 {
+			resp.Data = append(resp.Data, openai.OAImageGeneratorDallEData{B64JSON: data})
+		} else {
+			resp.Data = append(resp.Data, openai.OAImageGeneratorDallEData{Url: data})
+		}
+	}
+
+	w.Header().Set("x-request-id", "req_llmtest_"+strconv.Itoa(s.nextID()))
+	writeJSON(w, reply.status(), resp)
+}
+
+// narrationAudio return the scripted audio of the reply, or small placeholder bytes of the format
+func narrationAudio(reply Reply, format string) []byte {
+	if len(reply.Audio) > 0 {
+		return reply.Audio
+	}
+
+	return []byte("llmtest-audio-" + format)
+}
+
+// writeOpenAISpeech render the reply as text to speech audio response
+func (s *Server) writeOpenAISpeech(w http.ResponseWriter, req Request, reply Reply) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "audio/mpeg")
+	}
+	w.Header().Set("x-request-id", "req_llmtest_"+strconv.Itoa(s.nextID()))
+	w.WriteHeader(reply.status())
+	w.Write(reply.Audio)
+}
+
+// writeOpenAITranscription render the reply text as verbose_json transcription with one segment,
+// the duration is estimated 0.5 second per word
+func (s *Server) writeOpenAITranscription(w http.ResponseWriter, req Request, reply Reply) {
+	duration := float64(len(strings.Fields(reply.Text))) * 0.5
+
+	w.Header().Set("x-request-id", "req_llmtest_"+strconv.Itoa(s.nextID()))
+	writeJSON(w, reply.status(), openai.OATranscriptionResp{
+		Task:     "transcribe",
+		Language: "english",
+		Duration: duration,
+		Text:     reply.Text,
+		Segments: []openai.OATranscriptionSegment{
+			{Id: 0, Start: 0, End: duration, Text: reply.Text},
+		},
+	})
+}
+
+// writeOpenAIModeration render the reply moderation score as moderation response with one result for every input
+func (s *Server) writeOpenAIModeration(w http.ResponseWriter, req Request, reply Reply) {
+	var body struct {
+		Input interface{} `json:"input"`
+		Model string      `json:"model"`
+	}
+	json.Unmarshal(req.Body, &body)
+
+	inputs := 1
+	if list, ok := body.Input.([]interface{}); ok {
+		inputs = len(list)
+	}
+
+	model := body.Model
+	if model == "" {
+		model = "omni-moderation-llmtest"
+	}
+
+	resp := openai.OAModerationResp{
+		ID:    "modr-llmtest-" + strconv.Itoa(s.nextID()),
+		Model: model,
+	}
+
+	for i := 0; i < inputs; i++ {
+		result := openai.OAModerationResult{
+			Categories:     make(map[string]bool, len(reply.Moderation)),
+			CategoryScores: make(map[string]float64, len(reply.Moderation)),
+		}
+		for category, score := range reply.Moderation {
+			result.CategoryScores[category] = score
+			result.Categories[category] = score >= 0.5
+			result.Flagged = result.Flagged || score >= 0.5
+		}
+		resp.Results = append(resp.Results, result)
+	}
+
+	writeJSON(w, reply.status(), resp)
+}
+
+// writeOpenAIEmbeddings render the reply embedding as embeddings response with one embedding for every input
+func (s *Server) writeOpenAIEmbeddings(w http.ResponseWriter, req Request, reply Reply) {
+	var body struct {
+		Input interface{} `json:"input"`
+		Model string      `json:"model"`
+	}
+	json.Unmarshal(req.Body, &body)
+
+	var inputs []string
+	switch input := body.Input.(type) {
+	case string:
+		inputs = []string{input}
+	case []interface{}:
+		for _, item := range input {
+			text, _ := item.(string)
+			inputs = append(inputs, text)
+		}
+	}
+
+	resp := openai.OAEmbeddingsResp{
+		Object: "list",
+		Model:  body.Model,
+	}
+
+	for i, input := range inputs {
+		embedding := reply.Embedding
+		if len(embedding) == 0 {
+			embedding = wordsEmbedding(input)
+		}
+
+		resp.Data = append(resp.Data, openai.OAEmbeddingData{
+			Object:    "embedding",
+			Index:     i,
+			Embedding: embedding,
+		})
+		resp.Usage.PromptTokens += tokens(0, input)
+	}
+	resp.Usage.TotalTokens = resp.Usage.PromptTokens
+
+	writeJSON(w, reply.status(), resp)
+}
+
+// wordsEmbeddingDimensions is the dimension of the embedding derived from the input words
+const wordsEmbeddingDimensions = 64
+
+// wordsEmbedding return normalized bag of words vector, each lower case word is hashed to one dimension
+// so text sharing more words has higher cosine similarity
+func wordsEmbedding(text string) []float64 {
+	embedding := make([]float64, wordsEmbeddingDimensions)
+
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		hash := fnv.New32a()
+		hash.Write([]byte(strings.Trim(word, ".,!?;:'\"()")))
+		embedding[hash.Sum32()%wordsEmbeddingDimensions]++
+	}
+
+	var norm float64
+	for _, value := range embedding {
+		norm += value * value
+	}
+	if norm > 0 {
+		norm = math.Sqrt(norm)
+		for i := range embedding {
+			embedding[i] /= norm
+		}
+	}
+
+	return embedding
+}
+
+// base64Image is 1x1 transparent png, can be used as Image reply for b64_json request
+var base64Image = base64.StdEncoding.EncodeToString([]byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x48, 0x44, 0x52,
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x08, 0x06, 0x00, 0x00, 0x00, 0x1f, 0x15, 0xc4,
+	0x89, 0x00, 0x00, 0x00, 0x0d, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0x00, 0x01, 0x00, 0x00,
+	0x05, 0x00, 0x01, 0x0d, 0x0a, 0x2d, 0xb4, 0x00, 0x00, 0x00, 0x00, 0x49, 0x45, 0x4e, 0x44, 0xae,
+	0x42, 0x60, 0x82,
+})
+
+// PNG return 1x1 transparent png encoded in base64, for Image reply on b64_json request or vision request input
+func PNG() string {
+	return base64Image
+}
@@ -0,0 +1,197 @@
+package llmtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// APIError is the error body sent by the fake server, rendered with the claude or openai error format depending on the endpoint
+type APIError struct {
+	Type    string // claude error.type or openai error.type, like "rate_limit_error"
+	Code    string // only on openai error.code, like "insufficient_quota"
+	Message string
+}
+
+// Reply is the scripted response for one request.
+//
+// The same reply is rendered with the protocol of the endpoint it is queued to, so Text("hi") queued to claude
+// become claude message response and queued to openai chat become chat completion response. If the request ask for
+// streaming, the reply is sent as server-sent events split into multiple delta.
+type Reply struct {
+	Status int               // http status, default 200
+	Header map[string]string // extra response header, like "retry-after"
+	Delay  time.Duration     // wait before sending the reply, to test timeout and cancellation
+
+	// raw response body sent as is, the field below is ignored. used for malformed or custom response
+	Body []byte
+	// error response, the field below is ignored
+	Err *APIError
+	// error sent in the middle of the stream after the first delta, only for streaming request
+	StreamErr *APIError
+
+	Text    string   // assistant text on chat response, or the transcribed text on transcriptions
+	JSON    string   // structured output, claude tool_use input or openai message content
+	Refusal string   // openai refusal message, the content is empty. claude has no refusal field, it is sent as the text
+	Images  []string // image url, or base64 image when the request use response_format b64_json
+	Audio   []byte   // text to speech audio, or the chat audio output when the request use audio modality
+	// moderation category score for every input, the category is flagged when the score is 0.5 or more
+	Moderation map[string]float64
+	// embedding vector for every input, if empty the vector is derived from the input words so similar text has similar vector
+	Embedding  []float64
+	StopReason string // claude stop_reason or openai finish_reason, default "end_turn" / "tool_use" / "stop"
+
+	InputTokens  int // usage, if 0 estimated from the request body length
+	OutputTokens int // usage, if 0 estimated from the reply content length
+	// prompt cache usage, on claude it is reported besides the input tokens and on openai the read is part of the input tokens
+	CacheReadTokens  int
+	CacheWriteTokens int
+}
+
+func (r Reply) status() int {
+	if r.Status == 0 {
+		return http.StatusOK
+	}
+
+	return r.Status
+}
+
+// WithUsage set the usage reported on the reply
+func (r Reply) WithUsage(inputTokens int, outputTokens int) Reply {
+	r.InputTokens = inputTokens
+	r.OutputTokens = outputTokens
+	return r
+}
+
+// WithCache set the prompt cache usage reported on the reply, openai only report the read tokens
+func (r Reply) WithCache(readTokens int, writeTokens int) Reply {
+	r.CacheReadTokens = readTokens
+	r.CacheWriteTokens = writeTokens
+	return r
+}
+
+// WithHeader add response header to the reply
+func (r Reply) WithHeader(key string, value string) Reply {
+	header := make(map[string]string, len(r.Header)+1)
+	for k, v := range r.Header {
+		header[k] = v
+	}
+	header[key] = value
+
+	r.Header = header
+	return r
+}
+
+// WithDelay wait before sending the reply
+func (r Reply) WithDelay(delay time.Duration) Reply {
+	r.Delay = delay
+	return r
+}
+
+// Text reply with assistant text
+func Text(text string) Reply {
+	return Reply{Text: text}
+}
+
+// JSON reply with structured output, value can be string, []byte, or any value that can be marshaled to json
+func JSON(value interface{}) Reply {
+	switch v := value.(type) {
+	case string:
+		return Reply{JSON: v}
+	case []byte:
+		return Reply{JSON: string(v)}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic("llmtest: failed to marshal json reply: " + err.Error())
+	}
+
+	return Reply{JSON: string(data)}
+}
+
+// Refusal reply for chat with the model refusing the request
+func Refusal(message string) Reply {
+	return Reply{Refusal: message}
+}
+
+// Image reply for image generations, each data is url or base64 image depending on the request response_format
+func Image(data ...string) Reply {
+	return Reply{Images: data}
+}
+
+// Speech reply for text to speech with the audio bytes
+func Speech(audio []byte) Reply {
+	return Reply{
+		Audio:  audio,
+		Header: map[string]string{"Content-Type": "audio/mpeg"},
+	}
+}
+
+// Narration reply for chat completions with audio output, the text is the transcript of the audio.
+// text only request get the text as the message content
+func Narration(text string, audio []byte) Reply {
+	return Reply{
+		Text:  text,
+		Audio: audio,
+	}
+}
+
+// Moderation reply for moderations with the category score, empty scores mean nothing flagged
+func Moderation(scores map[string]float64) Reply {
+	if scores == nil {
+		scores = map[string]float64{}
+	}
+
+	return Reply{Moderation: scores}
+}
+
+// Embedding reply for embeddings with the vector, without vector the embedding is derived from the input words
+func Embedding(vector ...float64) Reply {
+	return Reply{Embedding: vector}
+}
+
+// Raw reply with the raw body and status
+func Raw(status int, body string) Reply {
+	return Reply{Status: status, Body: []byte(body)}
+}
+
+// Error reply with provider error response
+func Error(status int, errType string, message string) Reply {
+	return Reply{
+		Status: status,
+		Err:    &APIError{Type: errType, Message: message},
+	}
+}
+
+// RateLimited reply with 429 rate limit error and retry-after 0 second so the client retry immediately
+func RateLimited() Reply {
+	reply := Error(http.StatusTooManyRequests, "rate_limit_error", "llmtest: rate limited")
+	reply.Err.Code = "rate_limit_exceeded"
+	return reply.WithHeader("retry-after", "0")
+}
+
+// Overloaded reply with claude 529 overloaded error
+func Overloaded() Reply {
+	return Error(529, "overloaded_error", "llmtest: overloaded")
+}
+
+// InsufficientQuota reply with openai 429 insufficient quota error, this error is not retried by the client
+func InsufficientQuota() Reply {
+	reply := Error(http.StatusTooManyRequests, "insufficient_quota", "llmtest: insufficient quota")
+	reply.Err.Code = "insufficient_quota"
+	return reply
+}
+
+// Malformed reply with 200 status and invalid json body
+func Malformed() Reply {
+	return Raw(http.StatusOK, `{"id": "llmtest", "content": [`)
+}
+
+// StreamError reply with streaming response that send error in the middle of the stream
+func StreamError(text string, errType string, message string) Reply {
+	return Reply{
+		Text:      text,
+		StreamErr: &APIError{Type: errType, Message: message},
+	}
+}
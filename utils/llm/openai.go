@@ -0,0 +1,276 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"scrapper-test/utils/openai"
+)
+
+// max output tokens of gpt-4o and gpt-4o-mini, the structured output cut by the max tokens is retried once
+// with double max tokens up to this limit
+const openaiMaxOutputTokens = 16384
+
+// adapter for openai.OpenAI
+type openaiProvider struct {
+	client openai.OpenAI
+}
+
+func NewOpenAIProvider(client openai.OpenAI) Provider {
+	return &openaiProvider{
+		client: client,
+	}
+}
+
+func (p *openaiProvider) Name() string {
+	return ProviderOpenAI
+}
+
+func (p *openaiProvider) Generate(ctx context.Context, req Request) (*Response, error) {
+	messages, formatResponse, err := p.createMessages(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Audio != nil {
+		return p.generateAudio(ctx, req, messages)
+	}
+
+	reqBody := p.createReqBody(req, messages, formatResponse)
+
+	openaiResp, err := p.client.OpenAISendMessageWithContext(ctx, nil, false, nil, true, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	// the cut JSON can't be used, so it is sent again with bigger limit. The first answer is billed too
+	if req.Schema != nil && isLengthFinish(openaiResp) && reqBody.MaxCompletionTokens < openaiMaxOutputTokens {
+		retryBody := *reqBody
+		retryBody.MaxCompletionTokens = min(reqBody.MaxCompletionTokens*2, openaiMaxOutputTokens)
+
+		retryResp, err := p.client.OpenAISendMessageWithContext(ctx, nil, false, nil, true, &retryBody)
+		if err != nil {
+			// the cut answer is still billed, so its usage is returned with the error to be recorded
+			return &Response{
+				Provider:   ProviderOpenAI,
+				Model:      openaiResp.Model,
+				StopReason: openaiResp.Choices[0].FinishReason,
+				Usage:      openaiUsage(openaiResp),
+			}, err
+		}
+
+		retryResp.Usage.PromptTokens += openaiResp.Usage.PromptTokens
+		retryResp.Usage.CompletionTokens += openaiResp.Usage.CompletionTokens
+		retryResp.Usage.TotalTokens += openaiResp.Usage.TotalTokens
+		retryResp.Usage.PromptTokensDetail.CachedTokens += openaiResp.Usage.PromptTokensDetail.CachedTokens
+		openaiResp = retryResp
+	}
+
+	return p.createResponse(req, openaiResp)
+}
+
+// isLengthFinish return true when the first choice is cut by the max tokens
+func isLengthFinish(openaiResp *openai.OAChatCompletionResp) bool {
+	return len(openaiResp.Choices) > 0 && openaiResp.Choices[0].FinishReason == openai.OAFinishReasonLength
+}
+
+// createReqBody create the chat completions request body, the model is left empty when the request has no model
+// so the client fill it with the configured model
+func (p *openaiProvider) createReqBody(req Request, messages []openai.OAMessageReq, formatResponse *map[string]interface{}) *openai.OAReqBodyMessageCompletion {
+	reqBody := &openai.OAReqBodyMessageCompletion{
+		Model:               req.Model,
+		Messages:            messages,
+		MaxCompletionTokens: req.MaxTokens,
+	}
+
+	if reqBody.MaxCompletionTokens == 0 {
+		reqBody.MaxCompletionTokens = DefaultMaxTokens
+	}
+
+	if formatResponse != nil {
+		reqBody.ResponseFormat = *formatResponse
+	}
+
+	return reqBody
+}
+
+// generateAudio send the request with audio output
+func (p *openaiProvider) generateAudio(ctx context.Context, req Request, messages []openai.OAMessageReq) (*Response, error) {
+	if req.Schema != nil {
+		return nil, errors.New("request failed: schema is not supported with audio output")
+	}
+
+	reqBody := p.createAudioReqBody(req, messages)
+
+	openaiResp, err := p.client.OpenAISendMessageWithContext(ctx, nil, false, nil, true, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.createResponse(req, openaiResp)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Audio == nil {
+		return nil, errors.New("request failed: response audio is empty")
+	}
+	resp.Audio.Format = reqBody.Audio.Format
+
+	return resp, nil
+}
+
+// createAudioReqBody create the request body with audio output using the audio model when the request has no model,
+// the configured model may not support audio
+func (p *openaiProvider) createAudioReqBody(req Request, messages []openai.OAMessageReq) *openai.OAReqBodyMessageCompletion {
+	modalities, audio := openai.OACreateAudioOutput(req.Audio.Voice, req.Audio.Format)
+
+	reqBody := p.createReqBody(req, messages, nil)
+	if reqBody.Model == "" {
+		reqBody.Model = openai.OAModelAudio
+	}
+	reqBody.Modalities = modalities
+	reqBody.Audio = audio
+
+	return reqBody
+}
+
+// CountTokens estimate the tokens with the local tokenizer, openai has no endpoint to count the tokens
+func (p *openaiProvider) CountTokens(ctx context.Context, req Request) (*TokenCount, error) {
+	messages, formatResponse, err := p.createMessages(req)
+	if err != nil {
+		return nil, err
+	}
+
+	reqBody := p.createReqBody(req, messages, formatResponse)
+	if req.Audio != nil {
+		reqBody = p.createAudioReqBody(req, messages)
+	}
+
+	estimate, err := p.client.OpenAIEstimateTokens(nil, false, nil, true, reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenCount{
+		Model:       estimate.Model,
+		InputTokens: estimate.PromptTokens,
+		Estimated:   true,
+	}, nil
+}
+
+func (p *openaiProvider) GenerateStream(ctx context.Context, req Request, onDelta func(text string) error) (*Response, error) {
+	if req.Audio != nil {
+		return nil, ErrAudioNotSupported
+	}
+
+	messages, formatResponse, err := p.createMessages(req)
+	if err != nil {
+		return nil, err
+	}
+
+	openaiResp, err := p.client.OpenAISendMessageStreamWithContext(ctx, nil, false, nil, true, p.createReqBody(req, messages, formatResponse), onDelta)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.createResponse(req, openaiResp)
+}
+
+// createMessages convert the provider agnostic request to openai messages and response format
+func (p *openaiProvider) createMessages(req Request) ([]openai.OAMessageReq, *map[string]interface{}, error) {
+	if len(req.Messages) == 0 {
+		return nil, nil, errors.New("request failed: messages is empty")
+	}
+
+	messages := make([]openai.OAMessageReq, 0, len(req.Messages)+1)
+
+	// openai cache the long prompt prefix automatically, so the parts only need to be joined
+	if len(req.System) > 0 {
+		messages = append(messages, openai.OAMessageReq{
+			Role:    "system",
+			Content: joinParts(req.System),
+		})
+	}
+
+	for _, m := range req.Messages {
+		var content interface{} = m.Text()
+
+		if m.Image != nil {
+			vision, err := openai.OACreateOneContentVision(m.Image.MediaType, false, m.Image.Data, m.Text())
+			if err != nil {
+				return nil, nil, err
+			}
+			content = vision
+		}
+
+		messages = append(messages, openai.OAMessageReq{
+			Role:    m.Role,
+			Content: content,
+		})
+	}
+
+	var formatResponse *map[string]interface{}
+	if req.Schema != nil {
+		format := openai.OACreateResponseFormat(req.Schema.Name, req.Schema.Schema, req.Schema.Strict)
+		formatResponse = &format
+	}
+
+	return messages, formatResponse, nil
+}
+
+func (p *openaiProvider) createResponse(req Request, openaiResp *openai.OAChatCompletionResp) (*Response, error) {
+	if err := openai.OACheckFirstChoice(openaiResp); err != nil {
+		var refusalErr *openai.RefusalError
+		var truncatedErr *openai.TruncatedError
+
+		// both wrapped, so the caller can check the provider agnostic error or read the openai error
+		switch {
+		case errors.Is(err, openai.ErrEmptyChoices):
+			return nil, fmt.Errorf("%w: %w", ErrEmptyContent, err)
+		case errors.As(err, &refusalErr):
+			return nil, fmt.Errorf("%w: %w", ErrRefused, err)
+		case errors.As(err, &truncatedErr):
+			// the cut text answer is still usable, only the cut JSON is an error
+			if req.Schema != nil {
+				return nil, fmt.Errorf("%w: %w", ErrTruncated, err)
+			}
+		default:
+			return nil, err
+		}
+	}
+
+	choice := openaiResp.Choices[0]
+
+	resp := &Response{
+		Provider:   ProviderOpenAI,
+		Model:      openaiResp.Model,
+		Content:    choice.Message.Content,
+		StopReason: choice.FinishReason,
+		Usage:      openaiUsage(openaiResp),
+	}
+
+	// on audio output the text is only on the transcript
+	if choice.Message.Audio != nil {
+		resp.Audio = &Audio{
+			Data:       choice.Message.Audio.Data,
+			Transcript: choice.Message.Audio.Transcript,
+		}
+		if resp.Content == "" {
+			resp.Content = choice.Message.Audio.Transcript
+		}
+	}
+
+	return resp, nil
+}
+
+// openaiUsage convert the openai usage, the audio and cached tokens is part of the prompt and completion tokens
+func openaiUsage(openaiResp *openai.OAChatCompletionResp) Usage {
+	return Usage{
+		InputTokens:       openaiResp.Usage.PromptTokens,
+		OutputTokens:      openaiResp.Usage.CompletionTokens,
+		AudioInputTokens:  openaiResp.Usage.PromptTokensDetail.AudioTokens,
+		AudioOutputTokens: openaiResp.Usage.CompletionTokensDetail.AudioTokens,
+		CacheReadTokens:   openaiResp.Usage.PromptTokensDetail.CachedTokens,
+	}
+}
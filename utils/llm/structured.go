@@ -0,0 +1,176 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"scrapper-test/utils/jsonschema"
+	"strings"
+	"unicode/utf8"
+)
+
+// ParseError is returned by ParseStructured when the response content is not valid JSON or not follow the schema
+// even after repaired, the message can be sent back to the model so it fix the answer
+type ParseError struct {
+	Content string // the repaired content that failed to parse
+	Err     error  // the JSON syntax error or *jsonschema.ValidationError
+}
+
+func (e *ParseError) Error() string {
+	return "Failed to parse structured response: " + e.Err.Error()
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// ParseStructured decode the structured output content to target, after the content is extracted and repaired
+// with ExtractJSON and validated against the schema (nil schema skip the validation).
+//
+// Returns the repaired JSON that is decoded, so it can be sent back as the assistant message on the next turn,
+// or *ParseError when the content still can't be used.
+//
+// Example usage:
+//
+//	var parsed models.StoriesCreateTitleFormat
+//
+//	content, err := llm.ParseStructured(resp.Content, schema, &parsed)
+//	var parseErr *llm.ParseError
+//	if errors.As(err, &parseErr) {
+//	    log.Printf("invalid answer: %v", parseErr.Err)
+//	}
+func ParseStructured(content string, schema *Schema, target interface{}) (string, error) {
+	repaired := ExtractJSON(content)
+
+	decoder := json.NewDecoder(strings.NewReader(repaired))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return "", &ParseError{Content: repaired, Err: err}
+	}
+
+	if schema != nil {
+		if err := jsonschema.Validate(schema.Schema, value); err != nil {
+			return "", &ParseError{Content: repaired, Err: err}
+		}
+	}
+
+	if err := json.Unmarshal([]byte(repaired), target); err != nil {
+		return "", &ParseError{Content: repaired, Err: err}
+	}
+
+	return repaired, nil
+}
+
+// ExtractJSON return the JSON object or array from the model answer, repairing the common mistake of the model:
+//   - the JSON wrapped with markdown code fence or placed after and before prose, only the first JSON value is taken.
+//   - trailing comma before the closing bracket.
+//   - smart quote (“ ”) used as the string quote, the smart quote inside the string is kept as is.
+//   - raw new line and tab inside the string.
+//
+// The valid JSON is returned unchanged except the surrounding text, the content without JSON value is returned trimmed.
+func ExtractJSON(content string) string {
+	text := strings.TrimSpace(content)
+
+	// the code fence can have language like ```json, the value is searched inside the first fence
+	if start := strings.Index(text, "```"); start >= 0 {
+		inside := text[start+3:]
+		if newline := strings.IndexByte(inside, '\n'); newline >= 0 {
+			inside = inside[newline+1:]
+		}
+		if end := strings.Index(inside, "```"); end >= 0 {
+			inside = inside[:end]
+		}
+		text = inside
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return strings.TrimSpace(text)
+	}
+
+	return repairJSON(text[start:])
+}
+
+// smart double quote used by the model as the JSON string quote
+func isSmartQuote(r rune) bool {
+	return r == '“' || r == '”' || r == '„'
+}
+
+// repairJSON copy the first JSON value of the text with the repair of ExtractJSON, stop after the value is closed
+func repairJSON(text string) string {
+	var out bytes.Buffer
+	depth := 0 // open object and array
+
+	inString := false
+	smartString := false // the string is opened with smart quote, so it is closed with smart quote
+	escaped := false
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+				out.WriteString(text[i : i+size])
+			case r == '\\':
+				escaped = true
+				out.WriteByte('\\')
+			case smartString && isSmartQuote(r):
+				inString = false
+				out.WriteByte('"')
+			case smartString && r == '"':
+				out.WriteString(`\"`)
+			case !smartString && r == '"':
+				inString = false
+				out.WriteByte('"')
+			case r == '\n':
+				out.WriteString(`\n`)
+			case r == '\r':
+				out.WriteString(`\r`)
+			case r == '\t':
+				out.WriteString(`\t`)
+			default:
+				out.WriteString(text[i : i+size])
+			}
+
+			i += size
+			continue
+		}
+
+		switch {
+		case r == '"' || isSmartQuote(r):
+			inString = true
+			smartString = r != '"'
+			out.WriteByte('"')
+
+		case r == '{' || r == '[':
+			depth++
+			out.WriteRune(r)
+
+		case r == '}' || r == ']':
+			out.WriteRune(r)
+			depth--
+			if depth <= 0 {
+				// the first value is closed, the text after it is prose
+				return out.String()
+			}
+
+		case r == ',':
+			// trailing comma, the next non space character close the object or array
+			next := strings.TrimLeft(text[i+size:], " \t\r\n")
+			if next == "" || next[0] != '}' && next[0] != ']' {
+				out.WriteByte(',')
+			}
+
+		default:
+			out.WriteString(text[i : i+size])
+		}
+
+		i += size
+	}
+
+	// the value is not closed, returned as is so the decode error tell it is incomplete
+	return out.String()
+}
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"errors"
+	"scrapper-test/utils/jsonschema"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{name: "valid json unchanged", content: `{"a": 1, "b": [1, 2]}`, want: `{"a": 1, "b": [1, 2]}`},
+		{name: "surrounding space", content: "\n  {\"a\": 1}  \n", want: `{"a": 1}`},
+		{name: "code fence with language", content: "```json\n{\"a\": 1}\n```", want: `{"a": 1}`},
+		{name: "code fence without language", content: "```\n[1, 2]\n```", want: `[1, 2]`},
+		{name: "prose around code fence", content: "Berikut jawabannya:\n```json\n{\"a\": 1}\n```\nSemoga membantu", want: `{"a": 1}`},
+		{name: "prose around json", content: `Ini hasilnya {"a": "b"} terima kasih`, want: `{"a": "b"}`},
+		{name: "only first value", content: `{"a": 1} {"b": 2}`, want: `{"a": 1}`},
+		{name: "trailing comma on object", content: `{"a": 1, "b": 2,}`, want: `{"a": 1, "b": 2}`},
+		{name: "trailing comma on array", content: "{\"a\": [1, 2,\n]}", want: "{\"a\": [1, 2\n]}"},
+		{name: "comma inside string kept", content: `{"a": "x,}"}`, want: `{"a": "x,}"}`},
+		{name: "smart quote", content: `{“title”: “Judul”}`, want: `{"title": "Judul"}`},
+		{name: "quote inside smart quote string escaped", content: `{“title”: “kata "bagus"”}`, want: `{"title": "kata \"bagus\""}`},
+		{name: "smart quote inside string kept", content: `{"title": "kata “bagus”"}`, want: `{"title": "kata “bagus”"}`},
+		{name: "raw new line and tab in string", content: "{\"a\": \"x\ny\tz\"}", want: `{"a": "x\ny\tz"}`},
+		{name: "escaped quote kept", content: `{"a": "x\"}"}`, want: `{"a": "x\"}"}`},
+		{name: "bracket inside string", content: `{"a": "}]"}`, want: `{"a": "}]"}`},
+		{name: "no json", content: "  maaf, tidak bisa  ", want: "maaf, tidak bisa"},
+		{name: "not closed", content: `{"a": [1, 2`, want: `{"a": [1, 2`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExtractJSON(tt.content); got != tt.want {
+				t.Errorf("ExtractJSON() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type structuredAnswer struct {
+	Title string `json:"title"`
+	Mood  string `json:"mood" enum:"happy,sad"`
+}
+
+func TestParseStructured(t *testing.T) {
+	schema := MustSchema("answer", structuredAnswer{})
+
+	tests := []struct {
+		name           string
+		content        string
+		schema         *Schema
+		want           structuredAnswer
+		wantContent    string
+		wantValidation bool // *jsonschema.ValidationError
+		wantErr        bool
+	}{
+		{
+			name:        "valid",
+			content:     `{"title": "a", "mood": "happy"}`,
+			schema:      schema,
+			want:        structuredAnswer{Title: "a", Mood: "happy"},
+			wantContent: `{"title": "a", "mood": "happy"}`,
+		},
+		{
+			name:        "repaired",
+			content:     "```json\n{“title”: “a”, “mood”: “sad”,}\n```",
+			schema:      schema,
+			want:        structuredAnswer{Title: "a", Mood: "sad"},
+			wantContent: `{"title": "a", "mood": "sad"}`,
+		},
+		{
+			name:           "missing required field",
+			content:        `{"title": "a"}`,
+			schema:         schema,
+			wantValidation: true,
+			wantErr:        true,
+		},
+		{
+			name:           "not in enum",
+			content:        `{"title": "a", "mood": "angry"}`,
+			schema:         schema,
+			wantValidation: true,
+			wantErr:        true,
+		},
+		{
+			name:        "nil schema skip validation",
+			content:     `{"title": "a", "mood": "angry"}`,
+			want:        structuredAnswer{Title: "a", Mood: "angry"},
+			wantContent: `{"title": "a", "mood": "angry"}`,
+		},
+		{
+			name:    "invalid json",
+			content: `{"title": "a", "mood": `,
+			schema:  schema,
+			wantErr: true,
+		},
+		{
+			name:    "no json",
+			content: `maaf, tidak bisa`,
+			schema:  schema,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got structuredAnswer
+			content, err := ParseStructured(tt.content, tt.schema, &got)
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseStructured() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				var parseErr *ParseError
+				if !errors.As(err, &parseErr) {
+					t.Fatalf("ParseStructured() error = %v, want *ParseError", err)
+				}
+
+				var validationErr *jsonschema.ValidationError
+				if errors.As(err, &validationErr) != tt.wantValidation {
+					t.Errorf("validation error = %v, want %v", validationErr, tt.wantValidation)
+				}
+				return
+			}
+
+			if got != tt.want {
+				t.Errorf("decoded = %+v, want %+v", got, tt.want)
+			}
+			if content != tt.wantContent {
+				t.Errorf("content = %q, want %q", content, tt.wantContent)
+			}
+		})
+	}
+}
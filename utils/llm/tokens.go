@@ -0,0 +1,78 @@
+package llm
+
+import (
+	"encoding/json"
+	"scrapper-test/utils/openai"
+	"strings"
+)
+
+const (
+	// DefaultContextWindow is used for model not found in the context window table
+	DefaultContextWindow = 128_000
+
+	// estimated tokens of one image on vision request, about one 1024x1024 image on both provider
+	estimatedImageTokens = 1_000
+)
+
+// context window in tokens (prompt and the max output tokens), the key is matched as prefix of the model name
+// like the pricing table so "gpt-4o" match "gpt-4o-2024-08-06", the longest prefix is used
+var contextWindows = map[string]int{
+	"claude":        200_000,
+	"gpt-4o":        128_000,
+	"gpt-4-turbo":   128_000,
+	"gpt-4":         8_192,
+	"gpt-3.5-turbo": 16_385,
+}
+
+// ContextWindow return the context window of the model in tokens
+func ContextWindow(model string) int {
+	window := DefaultContextWindow
+	matched := ""
+
+	for name, w := range contextWindows {
+		if strings.HasPrefix(model, name) && len(name) > len(matched) {
+			window = w
+			matched = name
+		}
+	}
+
+	return window
+}
+
+// EstimateTokens return the approximate input tokens of the request using the local tokenizer,
+// used when the provider can't count the tokens. The image is counted as about one 1024x1024 image
+func EstimateTokens(req Request) int {
+	var messages []openai.OAMessageReq
+
+	if len(req.System) > 0 {
+		messages = append(messages, openai.OAMessageReq{
+			Role:    "system",
+			Content: joinParts(req.System),
+		})
+	}
+
+	tokens := 0
+	for _, m := range req.Messages {
+		messages = append(messages, openai.OAMessageReq{
+			Role:    m.Role,
+			Content: m.Text(),
+		})
+
+		if m.Image != nil {
+			tokens += estimatedImageTokens
+		}
+	}
+
+	// the messages only contain string content, so it can't fail
+	count, _ := openai.OAEstimateMessagesTokens(messages)
+	tokens += count
+
+	// the schema is added to the prompt by both provider
+	if req.Schema != nil {
+		if schemaJSON, err := json.Marshal(req.Schema.Schema); err == nil {
+			tokens += openai.OAEstimateTokens(string(schemaJSON))
+		}
+	}
+
+	return tokens
+}
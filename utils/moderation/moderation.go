@@ -0,0 +1,178 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"scrapper-test/utils/openai"
+	"sort"
+	"strings"
+)
+
+// where the moderated text come from
+const (
+	SourceInput  = "input"
+	SourceOutput = "output"
+)
+
+// Policy decide which moderation result is blocked
+type Policy struct {
+	// moderation is skipped when false
+	Enabled bool `json:"enabled"`
+	// OpenAI moderation model
+	Model string `json:"model"`
+	// score threshold per category, the category is blocked when its score is equal or more than the threshold.
+	// the key is the OpenAI category name like "violence" or "sexual/minors"
+	Thresholds map[string]float64 `json:"thresholds"`
+	// threshold for category not in Thresholds, 0 mean follow the OpenAI flag for that category
+	DefaultThreshold float64 `json:"default_threshold"`
+	// allow the request when the moderation api failed, by default the request is rejected
+	FailOpen bool `json:"fail_open"`
+}
+
+// DefaultPolicy follow the OpenAI flag, with stricter threshold for minors and self-harm instruction,
+// and looser threshold for violence and harassment because the stories (horror, thriller) and the medium roast
+// often contain mild violence and mockery
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Enabled: true,
+		Model:   "omni-moderation-latest",
+		Thresholds: map[string]float64{
+			"sexual/minors":          0.2,
+			"self-harm/instructions": 0.3,
+			"violence":               0.95,
+			"harassment":             0.9,
+		},
+		DefaultThreshold: 0,
+		FailOpen:         false,
+	}
+}
+
+// LoadPolicy read policy from json file, the value in the file override the default policy
+// so the file only need to contain the changed value
+func LoadPolicy(path string) (*Policy, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Failed to read moderation policy: " + err.Error())
+	}
+
+	policy := DefaultPolicy()
+	if err := json.Unmarshal(file, policy); err != nil {
+		return nil, errors.New("Failed to decode moderation policy: " + err.Error())
+	}
+
+	return policy, nil
+}
+
+// blockedCategories return the category blocked by the policy, sorted by name
+func (p *Policy) blockedCategories(result openai.OAModerationResult) []string {
+	var blocked []string
+
+	for category, score := range result.CategoryScores {
+		threshold, ok := p.Thresholds[category]
+		if !ok {
+			threshold = p.DefaultThreshold
+		}
+
+		if threshold > 0 && score >= threshold {
+			blocked = append(blocked, category)
+			continue
+		}
+
+		if threshold <= 0 && result.Categories[category] {
+			blocked = append(blocked, category)
+		}
+	}
+
+	sort.Strings(blocked)
+	return blocked
+}
+
+// BlockedError is returned when the text is blocked by the policy, use errors.As to inspect it
+type BlockedError struct {
+	Source     string   // SourceInput or SourceOutput
+	Categories []string // blocked category
+}
+
+func (e *BlockedError) Error() string {
+	return "Content blocked by moderation on " + e.Source + ": " + strings.Join(e.Categories, ", ")
+}
+
+// Moderator check the user input and the generated text before it used, nil Moderator allow everything
+type Moderator struct {
+	client openai.OpenAI
+	policy *Policy
+}
+
+func New(client openai.OpenAI, policy *Policy) *Moderator {
+	return &Moderator{
+		client: client,
+		policy: policy,
+	}
+}
+
+// CheckInput check the user input before any credit-consuming call, return *BlockedError if blocked
+func (m *Moderator) CheckInput(ctx context.Context, texts ...string) error {
+	return m.check(ctx, SourceInput, texts)
+}
+
+// CheckOutput check the generated text before returned to the user, return *BlockedError if blocked
+func (m *Moderator) CheckOutput(ctx context.Context, texts ...string) error {
+	return m.check(ctx, SourceOutput, texts)
+}
+
+func (m *Moderator) check(ctx context.Context, source string, texts []string) error {
+	if !m.enabled() {
+		return nil
+	}
+
+	// empty text skipped, all of it send on one request
+	input := make([]string, 0, len(texts))
+	for _, text := range texts {
+		if strings.TrimSpace(text) != "" {
+			input = append(input, text)
+		}
+	}
+
+	if len(input) == 0 {
+		return nil
+	}
+
+	resp, err := m.client.OpenAIModerationWithContext(ctx, &openai.OAReqModeration{
+		Input: input,
+		Model: m.policy.Model,
+	})
+	if err != nil {
+		if m.policy.FailOpen && ctx.Err() == nil {
+			log.Println("moderation failed, request allowed: ", err)
+			return nil
+		}
+		return err
+	}
+
+	blocked := make(map[string]bool)
+	for _, result := range resp.Results {
+		for _, category := range m.policy.blockedCategories(result) {
+			blocked[category] = true
+		}
+	}
+
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	categories := make([]string, 0, len(blocked))
+	for category := range blocked {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	log.Printf("moderation blocked %s: %s", source, strings.Join(categories, ", "))
+
+	return &BlockedError{
+		Source:     source,
+		Categories: categories,
+	}
+}
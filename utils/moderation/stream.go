@@ -0,0 +1,89 @@
+package moderation
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	// min characters of the generated text checked on one moderation request when streaming
+	streamChunkSize = 800
+	// characters of the previous chunk checked again with the next chunk, so the text cut between two chunk is still checked together
+	streamChunkOverlap = 200
+)
+
+// OutputStream hold the streamed text until it is checked by the moderation, so the blocked text is never sent to the user.
+// The text is checked and sent per chunk of about streamChunkSize characters, when the moderation is disabled the text is sent right away
+type OutputStream struct {
+	moderator *Moderator
+	ctx       context.Context
+	send      func(text string) error
+
+	pending strings.Builder
+	tail    string // end of the last checked chunk
+}
+
+// Stream create the output stream that send the checked text to send.
+//
+// Example usage:
+//
+//	output := moderator.Stream(ctx, func(text string) error {
+//	    return utils.WriteSSEEvent(w, "delta", fiber.Map{"text": text})
+//	})
+//
+//	resp, err := provider.GenerateStream(ctx, req, output.Write)
+//	if err == nil {
+//	    err = output.Flush()
+//	}
+func (m *Moderator) Stream(ctx context.Context, send func(text string) error) *OutputStream {
+	return &OutputStream{
+		moderator: m,
+		ctx:       ctx,
+		send:      send,
+	}
+}
+
+// Write add the streamed text, it is used as the onDelta callback. The text is sent after its chunk is checked,
+// *BlockedError is returned when the chunk is blocked so the generation is stopped
+func (s *OutputStream) Write(text string) error {
+	if !s.moderator.enabled() {
+		return s.send(text)
+	}
+
+	s.pending.WriteString(text)
+	if s.pending.Len() < streamChunkSize {
+		return nil
+	}
+
+	return s.flush()
+}
+
+// Flush check and send the rest of the text, must be called after the stream finished
+func (s *OutputStream) Flush() error {
+	if s.pending.Len() == 0 {
+		return nil
+	}
+
+	return s.flush()
+}
+
+func (s *OutputStream) flush() error {
+	chunk := s.pending.String()
+	s.pending.Reset()
+
+	if err := s.moderator.CheckOutput(s.ctx, s.tail+chunk); err != nil {
+		return err
+	}
+
+	checked := []rune(s.tail + chunk)
+	if len(checked) > streamChunkOverlap {
+		checked = checked[len(checked)-streamChunkOverlap:]
+	}
+	s.tail = string(checked)
+
+	return s.send(chunk)
+}
+
+func (m *Moderator) enabled() bool {
+	return m != nil && m.policy != nil && m.policy.Enabled
+}
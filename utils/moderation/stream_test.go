@@ -0,0 +1,166 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"scrapper-test/utils/llm/llmtest"
+	"strings"
+	"testing"
+)
+
+// newTestModerator create moderator on the fake server that flag violence on the input containing "BAD"
+func newTestModerator(t *testing.T, policy *Policy) (*Moderator, *llmtest.Server) {
+	t.Helper()
+
+	srv := llmtest.New()
+	t.Cleanup(srv.Close)
+
+	srv.Handle(llmtest.EndpointOpenAIModerations, func(req llmtest.Request) llmtest.Reply {
+		if strings.Contains(string(req.Body), "BAD") {
+			return llmtest.Moderation(map[string]float64{"violence": 0.99})
+		}
+		return llmtest.Moderation(nil)
+	})
+
+	client, err := srv.OpenAIClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(client, policy), srv
+}
+
+func TestOutputStream(t *testing.T) {
+	long := strings.Repeat("a", streamChunkSize)
+
+	tests := []struct {
+		name        string
+		deltas      []string
+		wantSent    string
+		wantBlocked bool
+		wantChecks  int
+	}{
+		{
+			name:       "short text sent on flush",
+			deltas:     []string{"hello ", "world"},
+			wantSent:   "hello world",
+			wantChecks: 1,
+		},
+		{
+			name:       "long text sent per chunk",
+			deltas:     []string{long, "b", long, "c"},
+			wantSent:   long + "b" + long + "c",
+			wantChecks: 3,
+		},
+		{
+			name:        "blocked chunk is not sent",
+			deltas:      []string{long, "BAD", long},
+			wantSent:    long,
+			wantBlocked: true,
+			wantChecks:  2,
+		},
+		{
+			name:        "blocked text on the last chunk is not sent",
+			deltas:      []string{"ok ", "BAD"},
+			wantSent:    "",
+			wantBlocked: true,
+			wantChecks:  1,
+		},
+		{
+			name:       "empty stream",
+			wantChecks: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			moderator, srv := newTestModerator(t, DefaultPolicy())
+
+			var sent strings.Builder
+			output := moderator.Stream(context.Background(), func(text string) error {
+				sent.WriteString(text)
+				return nil
+			})
+
+			var err error
+			for _, delta := range tt.deltas {
+				if err = output.Write(delta); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				err = output.Flush()
+			}
+
+			var blockedErr *BlockedError
+			if blocked := errors.As(err, &blockedErr); blocked != tt.wantBlocked {
+				t.Fatalf("error = %v, want blocked %v", err, tt.wantBlocked)
+			}
+			if !tt.wantBlocked && err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			if blockedErr != nil && blockedErr.Source != SourceOutput {
+				t.Errorf("blocked source = %s, want %s", blockedErr.Source, SourceOutput)
+			}
+
+			if sent.String() != tt.wantSent {
+				t.Errorf("sent %d characters, want %d", sent.Len(), len(tt.wantSent))
+			}
+			if got := len(srv.RequestsTo(llmtest.EndpointOpenAIModerations)); got != tt.wantChecks {
+				t.Errorf("moderation requests = %d, want %d", got, tt.wantChecks)
+			}
+		})
+	}
+}
+
+func TestOutputStreamCheckOverlap(t *testing.T) {
+	moderator, srv := newTestModerator(t, DefaultPolicy())
+
+	output := moderator.Stream(context.Background(), func(text string) error {
+		return nil
+	})
+
+	// "BA" end the first chunk and "D" start the second, the overlap check them together
+	first := strings.Repeat("a", streamChunkSize-2) + "BA"
+	if err := output.Write(first); err != nil {
+		t.Fatalf("first chunk error = %v", err)
+	}
+
+	var blockedErr *BlockedError
+	if err := output.Write("D" + strings.Repeat("a", streamChunkSize)); !errors.As(err, &blockedErr) {
+		t.Fatalf("error = %v, want blocked", err)
+	}
+
+	if got := len(srv.RequestsTo(llmtest.EndpointOpenAIModerations)); got != 2 {
+		t.Errorf("moderation requests = %d, want 2", got)
+	}
+}
+
+func TestOutputStreamDisabled(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Enabled = false
+	moderator, srv := newTestModerator(t, policy)
+
+	var deltas []string
+	output := moderator.Stream(context.Background(), func(text string) error {
+		deltas = append(deltas, text)
+		return nil
+	})
+
+	for _, delta := range []string{"BAD ", "text"} {
+		if err := output.Write(delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := output.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// sent right away without buffering and without moderation request
+	if len(deltas) != 2 {
+		t.Errorf("deltas = %q, want 2 delta", deltas)
+	}
+	if got := len(srv.RequestsTo(llmtest.EndpointOpenAIModerations)); got != 0 {
+		t.Errorf("moderation requests = %d, want 0", got)
+	}
+}
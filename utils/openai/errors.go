@@ -0,0 +1,107 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+var (
+	// ErrEmptyChoices is returned when the chat completions response has no choice to read the answer from
+	ErrEmptyChoices = errors.New("OpenAI response choices is empty")
+	// ErrContentFiltered is returned when the answer is stopped by OpenAI content filter (finish_reason "content_filter")
+	ErrContentFiltered = errors.New("OpenAI response is stopped by the content filter")
+)
+
+// RefusalError is returned when the model refuse to answer the request, mostly on structured output request
+// where the refusal is sent on the `refusal` field instead of the JSON content.
+//
+// Example usage:
+//
+//	message, err := openaiClient.OpenAIGetFirstContentDataResp(&content, true, &formatResponse, false, nil)
+//	var refusalErr *openai.RefusalError
+//	if errors.As(err, &refusalErr) {
+//	    log.Printf("model refused: %s", refusalErr.Refusal)
+//	}
+//
+// References:
+//   - Structured outputs refusal: https://platform.openai.com/docs/guides/structured-outputs#refusals
+type RefusalError struct {
+	Refusal string // the refusal message from the model, can be shown to the user
+}
+
+func (e *RefusalError) Error() string {
+	return "OpenAI model refused the request: " + e.Refusal
+}
+
+// TruncatedError is returned when the answer is cut because it reach the max completion tokens (finish_reason "length"),
+// the partial response is kept so the caller can still read it, the structured output JSON would be incomplete
+type TruncatedError struct {
+	Resp *OAChatCompletionResp
+}
+
+func (e *TruncatedError) Error() string {
+	return "OpenAI response is truncated: the answer reach the max tokens after " + strconv.Itoa(e.Resp.Usage.CompletionTokens) + " completion tokens"
+}
+
+// APIError is returned when OpenAI API respond with error, use errors.As to inspect it.
+//
+// Example usage:
+//
+//	resp, err := openaiClient.OpenAICreateImageDallE(&reqBody)
+//	var apiErr *openai.APIError
+//	if errors.As(err, &apiErr) && apiErr.Code == "content_policy_violation" {
+//	    log.Printf("prompt rejected, request id: %s", apiErr.RequestID)
+//	}
+//
+// References:
+//   - OpenAI error codes: https://platform.openai.com/docs/guides/error-codes/api-errors
+type APIError struct {
+	StatusCode int    // http status code
+	Type       string // error type like invalid_request_error, insufficient_quota, server_error
+	Code       string // more specific error code like invalid_api_key, content_policy_violation, rate_limit_exceeded
+	Message    string
+	RequestID  string // value of x-request-id header, used when contacting OpenAI support
+	Retryable  bool   // true if the same request can be sent again later
+}
+
+func (e *APIError) Error() string {
+	return "OpenAI API response error: " + strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode) + " with message: " + e.Message + " type: " + e.Type + " code: " + e.Code
+}
+
+// OpenAI error response body
+type oaRespError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+		Code    string `json:"code"`
+	} `json:"error"`
+}
+
+// newAPIError create APIError from non 200 response, the body is read but not closed
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    resp.Status,
+		RequestID:  resp.Header.Get("x-request-id"),
+		Retryable:  shouldRetry(resp, nil),
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return apiErr
+	}
+
+	var errOpenAI oaRespError
+	if err := json.Unmarshal(body, &errOpenAI); err == nil {
+		apiErr.Type = errOpenAI.Error.Type
+		apiErr.Code = errOpenAI.Error.Code
+		if errOpenAI.Error.Message != "" {
+			apiErr.Message = errOpenAI.Error.Message
+		}
+	}
+
+	return apiErr
+}
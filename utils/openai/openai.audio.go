@@ -0,0 +1,89 @@
+package openai
+
+import (
+	"errors"
+)
+
+// chat completions output modality and the model that support audio output
+const (
+	OAModalityText  = "text"
+	OAModalityAudio = "audio"
+
+	OAModelAudio = "gpt-4o-audio-preview"
+)
+
+// OACreateAudioOutput creates the modalities and the audio request block for chat completions with audio output.
+//
+// The returned value is set on the custom request body used by OpenAISendMessage, the model must support audio
+// output like `OAModelAudio`. The response message `Audio` contain the base64 encoded audio on `Data` and the spoken text
+// on `Transcript`, the message `Content` is empty on audio response.
+//
+// Parameters:
+//   - voice: The voice used by the model (alloy, ash, ballad, coral, echo, sage, shimmer, or verse), empty value is "alloy".
+//   - format: The audio format (wav, mp3, flac, opus, or pcm16), empty value is "mp3".
+//
+// Example usage:
+//
+//	modalities, audio := OACreateAudioOutput("alloy", "mp3")
+//
+//	resp, err := openaiAPIInstance.OpenAISendMessage(nil, false, nil, true, &OAReqBodyMessageCompletion{
+//	    Model:      OAModelAudio,
+//	    Messages:   content,
+//	    Modalities: modalities,
+//	    Audio:      audio,
+//	})
+//	if err != nil {
+//	    log.Fatalf("Failed to send message: %v", err)
+//	}
+//
+//	audioBytes, _ := base64.StdEncoding.DecodeString(resp.Choices[0].Message.Audio.Data)
+//	fmt.Println(resp.Choices[0].Message.Audio.Transcript)
+//
+// References:
+//   - Official OpenAI audio generation documentation: https://platform.openai.com/docs/guides/audio
+func OACreateAudioOutput(voice string, format string) ([]string, *OAAudioRequest) {
+	if voice == "" {
+		voice = "alloy"
+	}
+
+	if format == "" {
+		format = "mp3"
+	}
+
+	return []string{OAModalityText, OAModalityAudio}, &OAAudioRequest{
+		Voice:  voice,
+		Format: format,
+	}
+}
+
+// hasAudioOutput return true if the request ask for audio modality
+func (r *OAReqBodyMessageCompletion) hasAudioOutput() bool {
+	for _, modality := range r.Modalities {
+		if modality == OAModalityAudio {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateAudioOutput check the audio output request, the audio block is required with audio modality
+// and structured output is not supported together with audio output
+func (r *OAReqBodyMessageCompletion) validateAudioOutput() error {
+	if !r.hasAudioOutput() {
+		if r.Audio != nil {
+			return errors.New("modalities must contain audio when audio is provided")
+		}
+		return nil
+	}
+
+	if r.Audio == nil || r.Audio.Voice == "" || r.Audio.Format == "" {
+		return errors.New("audio voice and format must be provided when modalities contain audio")
+	}
+
+	if r.ResponseFormat != nil {
+		return errors.New("response format is not supported with audio output")
+	}
+
+	return nil
+}
@@ -9,15 +9,29 @@ package openai
 
 // ----------------- CHAT COMPLETIONS ----------------------
 type OAReqBodyMessageCompletion struct {
-	Messages         interface{}            `json:"messages"` // required
-	Model            string                 `json:"model"`    // required
-	Store            bool                   `json:"store,omitempty"`
-	Metadata         interface{}            `json:"metadata,omitempty"`
-	FrequencyPenalty float64                `json:"frequency_penalty,omitempty"`
-	LogitBias        map[string]interface{} `json:"logit_bias,omitempty"`
-	Logprobe         bool                   `json:"logprobe,omitempty"`
-	Modalities       []string               `json:"modalities,omitempty"`
-	ResponseFormat   map[string]interface{} `json:"response_format,omitempty"`
+	Messages            interface{}            `json:"messages"` // required
+	Model               string                 `json:"model"`    // required
+	Store               bool                   `json:"store,omitempty"`
+	Metadata            interface{}            `json:"metadata,omitempty"`
+	FrequencyPenalty    float64                `json:"frequency_penalty,omitempty"`
+	LogitBias           map[string]interface{} `json:"logit_bias,omitempty"`
+	Logprobe            bool                   `json:"logprobe,omitempty"`
+	MaxCompletionTokens int                    `json:"max_completion_tokens,omitempty"` // if 0 the model max output is used, the answer is cut with finish_reason "length"
+	Modalities          []string               `json:"modalities,omitempty"`            // ["text"] (default) or ["text", "audio"], use OACreateAudioOutput
+	Audio               *OAAudioRequest        `json:"audio,omitempty"`                 // required when modalities contain "audio"
+	ResponseFormat      map[string]interface{} `json:"response_format,omitempty"`
+	Stream              bool                   `json:"stream,omitempty"`
+	StreamOptions       *OAStreamOptions       `json:"stream_options,omitempty"`
+}
+
+// audio output request for chat completions with audio modality
+type OAAudioRequest struct {
+	Voice  string `json:"voice"`  // alloy, ash, ballad, coral, echo, sage, shimmer, or verse
+	Format string `json:"format"` // wav, mp3, flac, opus, or pcm16
+}
+
+type OAStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // if true, the last chunk before [DONE] will contain usage data
 }
 
 type OAMessageReq struct {
@@ -49,34 +63,61 @@ type OAChatCompletionResp struct {
 type OAChoice struct {
 	Index        int       `json:"index"`
 	Message      OAMessage `json:"message"`
-	Logprobs     *string   `json:"logprobs"` // Could be null, so pointer
-	FinishReason string    `json:"finish_reason"`
+	Logprobs     *string   `json:"logprobs"`      // Could be null, so pointer
+	FinishReason string    `json:"finish_reason"` // see OAFinishReason constants
 }
 
 type OAMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 	// support for audio output gpt-4o-audio-preview
-	Refusal string              `json:"refusal,omitempty"`
-	Audio   OAAudioDataResponse `json:"audio,omitempty"`
+	Refusal string               `json:"refusal,omitempty"` // the model refuse the request, the Content is empty, see RefusalError
+	Audio   *OAAudioDataResponse `json:"audio,omitempty"`   // only on audio output, the Content is empty and the text is on the transcript
 }
 
 type OAAudioDataResponse struct {
 	Id         string `json:"id"`
 	ExpiresAt  int64  `json:"expires_at"`
-	Data       string `json:"data"`
-	Transcript string `json:"transcript"`
+	Data       string `json:"data"`       // base64 encoded audio on the requested format
+	Transcript string `json:"transcript"` // the spoken text
 }
 
 type OAUsage struct {
 	PromptTokens           int          `json:"prompt_tokens"`
 	CompletionTokens       int          `json:"completion_tokens"`
 	TotalTokens            int          `json:"total_tokens"`
+	PromptTokensDetail     TokensDetail `json:"prompt_tokens_details"`
 	CompletionTokensDetail TokensDetail `json:"completion_tokens_details"`
 }
 
 type TokensDetail struct {
 	ReasoningTokens int `json:"reasoning_tokens"`
+	AudioTokens     int `json:"audio_tokens"`  // part of the prompt or completion tokens, priced differently from the text token
+	CachedTokens    int `json:"cached_tokens"` // only on prompt tokens, the prefix reused from the automatic prompt caching
+}
+
+// response COMPLETION STREAM OpenAI structure, each "data:" line on the server-sent events decoded to this struct
+//   - OpenAI Docs: https://platform.openai.com/docs/api-reference/chat/streaming
+type OAChatCompletionChunk struct {
+	ID                string          `json:"id"`
+	Object            string          `json:"object"` // chat.completion.chunk
+	Created           int64           `json:"created"`
+	Model             string          `json:"model"`
+	SystemFingerprint string          `json:"system_fingerprint"`
+	Choices           []OAChunkChoice `json:"choices"`
+	Usage             *OAUsage        `json:"usage"` // only on last chunk if stream_options.include_usage is true
+}
+
+type OAChunkChoice struct {
+	Index        int     `json:"index"`
+	Delta        OADelta `json:"delta"`
+	FinishReason *string `json:"finish_reason"` // null until the last chunk of the choice
+}
+
+type OADelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+	Refusal string `json:"refusal,omitempty"`
 }
 
 // ----------------- DALL E IMAGE GENERATIONS ------ Reference for Image Generation Request Body
@@ -117,3 +158,87 @@ type OATextToSpeechResp struct {
 	FormatAudio string `json:"format_audio"` // will be like ".mp3"
 	B64JSON     string `json:"b64_json"`
 }
+
+// ----------------- AUDIO TRANSCRIPTIONS (WHISPER) ------ Reference for Transcriptions Request Body
+// 	   - OpenAI Docs: https://platform.openai.com/docs/api-reference/audio/createTranscription
+type OAReqTranscription struct {
+	File                   []byte   // required, audio file content (flac, mp3, mp4, mpeg, mpga, m4a, ogg, wav, or webm), max 25 MB
+	FileName               string   // required, the audio format is detected from the file extension like "voice.webm"
+	Model                  string   // required (whisper-1)
+	Language               string   // optional ISO-639-1 language of the audio like "id" or "en", improve accuracy and latency
+	Prompt                 string   // optional text to guide the style or continue previous audio segment
+	ResponseFormat         string   // json or verbose_json (default), verbose_json contain the language, duration, and segments
+	Temperature            *float64 // optional (0 to 1)
+	TimestampGranularities []string // segment and/or word, only for verbose_json. word timestamp add extra latency
+}
+
+type OATranscriptionResp struct {
+	Task     string                   `json:"task"`
+	Language string                   `json:"language"`
+	Duration float64                  `json:"duration"` // audio duration in second
+	Text     string                   `json:"text"`
+	Segments []OATranscriptionSegment `json:"segments"`
+	Words    []OATranscriptionWord    `json:"words"`
+}
+
+type OATranscriptionSegment struct {
+	Id               int     `json:"id"`
+	Seek             int     `json:"seek"`
+	Start            float64 `json:"start"` // second
+	End              float64 `json:"end"`   // second
+	Text             string  `json:"text"`
+	Tokens           []int   `json:"tokens"`
+	Temperature      float64 `json:"temperature"`
+	AvgLogprob       float64 `json:"avg_logprob"`
+	CompressionRatio float64 `json:"compression_ratio"`
+	NoSpeechProb     float64 `json:"no_speech_prob"` // high value mean the segment is likely silence
+}
+
+type OATranscriptionWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// ----------------- MODERATIONS ------ Reference for Moderations Request Body
+// 	   - OpenAI Docs: https://platform.openai.com/docs/api-reference/moderations
+type OAReqModeration struct {
+	Input interface{} `json:"input"`           // required, string or []string
+	Model string      `json:"model,omitempty"` // omni-moderation-latest (default) or text-moderation-latest
+}
+
+type OAModerationResp struct {
+	ID      string               `json:"id"`
+	Model   string               `json:"model"`
+	Results []OAModerationResult `json:"results"` // one result for each input, on the same order
+}
+
+type OAModerationResult struct {
+	Flagged        bool               `json:"flagged"`
+	Categories     map[string]bool    `json:"categories"`      // like "harassment", "hate", "self-harm", "sexual/minors", "violence"
+	CategoryScores map[string]float64 `json:"category_scores"` // 0 to 1, higher mean more confidence
+}
+
+// ----------------- EMBEDDINGS ------ Reference for Embeddings Request Body
+// 	   - OpenAI Docs: https://platform.openai.com/docs/api-reference/embeddings
+type OAReqEmbeddings struct {
+	Input      interface{} `json:"input"`                // required, string or []string
+	Model      string      `json:"model"`                // text-embedding-3-small (default), text-embedding-3-large
+	Dimensions *int        `json:"dimensions,omitempty"` // shorten the vector, only on text-embedding-3 model
+}
+
+type OAEmbeddingsResp struct {
+	Object string            `json:"object"`
+	Data   []OAEmbeddingData `json:"data"` // one embedding for each input
+	Model  string            `json:"model"`
+	Usage  struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+type OAEmbeddingData struct {
+	Object    string    `json:"object"`
+	Index     int       `json:"index"` // position of the input
+	Embedding []float64 `json:"embedding"`
+}
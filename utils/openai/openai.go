@@ -2,33 +2,85 @@ package openai
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
+	// endpoint path, appended to the root url
+	OAPathTextCompletions       = "/chat/completions"
+	OAPathImageGenerationsDallE = "/images/generations"
+	OAPathTextToSpeech          = "/audio/speech"
+	OAPathTranscriptions        = "/audio/transcriptions"
+	OAPathModerations           = "/moderations"
+	OAPathEmbeddings            = "/embeddings"
+
 	OAUrlBase                  = "https://api.openai.com/v1"
-	OAUrlTextCompletions       = OAUrlBase + "/chat/completions"
-	OAUrlImageGenerationsDallE = OAUrlBase + "/images/generations"
-	OAUrlTextToSpeech          = OAUrlBase + "/audio/speech"
+	OAUrlTextCompletions       = OAUrlBase + OAPathTextCompletions
+	OAUrlImageGenerationsDallE = OAUrlBase + OAPathImageGenerationsDallE
+	OAUrlTextToSpeech          = OAUrlBase + OAPathTextToSpeech
+	OAUrlTranscriptions        = OAUrlBase + OAPathTranscriptions
+	OAUrlModerations           = OAUrlBase + OAPathModerations
+	OAUrlEmbeddings            = OAUrlBase + OAPathEmbeddings
+
+	// default embedding model and its vector dimension
+	OAEmbeddingModel           = "text-embedding-3-small"
+	OAEmbeddingModelDimensions = 1536
+
+	// max audio file size accepted by the transcriptions endpoint
+	OAMaxTranscriptionFileSize = 25 * 1024 * 1024
+
+	// why the model stopped generating, the value of `finish_reason` on the response choice
+	OAFinishReasonStop          = "stop"
+	OAFinishReasonLength        = "length" // the answer is cut, see TruncatedError
+	OAFinishReasonContentFilter = "content_filter"
+	OAFinishReasonToolCalls     = "tool_calls"
 )
 
 type OpenAI interface {
 	OpenAISendMessage(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAChatCompletionResp, error)
+	OpenAISendMessageWithContext(ctx context.Context, content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAChatCompletionResp, error)
 	OpenAIGetFirstContentDataResp(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAMessage, error)
+	OpenAIGetFirstContentDataRespWithContext(ctx context.Context, content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAMessage, error)
 	OpenAICreateImageDallE(req_body *OAReqImageGeneratorDallE) (*OAImageGeneratorDallEResp, error)
+	OpenAICreateImageDallEWithContext(ctx context.Context, req_body *OAReqImageGeneratorDallE) (*OAImageGeneratorDallEResp, error)
 	OpenAITextToSpeech(req_body *OAReqTextToSpeech) (*OATextToSpeechResp, error)
+	OpenAITextToSpeechWithContext(ctx context.Context, req_body *OAReqTextToSpeech) (*OATextToSpeechResp, error)
+	OpenAITranscribe(req_body *OAReqTranscription) (*OATranscriptionResp, error)
+	OpenAITranscribeWithContext(ctx context.Context, req_body *OAReqTranscription) (*OATranscriptionResp, error)
+	OpenAIModeration(req_body *OAReqModeration) (*OAModerationResp, error)
+	OpenAIModerationWithContext(ctx context.Context, req_body *OAReqModeration) (*OAModerationResp, error)
+	OpenAIEmbeddings(req_body *OAReqEmbeddings) (*OAEmbeddingsResp, error)
+	OpenAIEmbeddingsWithContext(ctx context.Context, req_body *OAReqEmbeddings) (*OAEmbeddingsResp, error)
+	OpenAISendMessageStream(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion, on_delta func(text string) error) (*OAChatCompletionResp, error)
+	OpenAISendMessageStreamWithContext(ctx context.Context, content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion, on_delta func(text string) error) (*OAChatCompletionResp, error)
+	OpenAIEstimateTokens(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OATokenEstimate, error)
 }
 
 // Config holds the configuration for OpenAI API client
 type Config struct {
 	httpClient    *http.Client
-	openAIBaseUrl string
-	openAIModel   string
+	openAIRootUrl string // every endpoint url is derived from this url
+	// per endpoint url override, if empty the url is derived from the root url
+	openAIBaseUrl             string // chat completions
+	openAIImageGenerationsUrl string
+	openAITextToSpeechUrl     string
+	openAITranscriptionsUrl   string
+	openAIModerationsUrl      string
+	openAIEmbeddingsUrl       string
+	openAIModel               string
+	retryPolicy               RetryPolicy
+	requestGate               RequestGate // nil mean every request is sent
 }
 
 // default configuration for OpenAI API client
@@ -37,8 +89,8 @@ func DefaultConfig() *Config {
 		httpClient: &http.Client{
 			Timeout: 60 * time.Second,
 		},
-		// user base url for chat completions endpoint with using gpt-4o-mini model
-		openAIBaseUrl: OAUrlTextCompletions,
+		// all endpoint derived from the official api root url, with using gpt-4o-mini model for chat completions
+		openAIRootUrl: OAUrlBase,
 		openAIModel:   "gpt-4o-mini",
 	}
 }
@@ -71,10 +123,17 @@ type ClientOption func(*Config)
 // Default Configuration Values:
 //   - httpClient: The HTTP client used for making requests. By default, the client has a
 //     timeout of 60 seconds (`http.Client{ Timeout: 60 * time.Second }`).
-//   - openAIBaseUrl: The default base URL for the OpenAI is set to the `/chat/completions` endpoint
-//     The default value is `"https://api.openai.com/v1/chat/completions"`.
+//   - openAIRootUrl: The root URL of the API, every endpoint URL is derived from it by appending the endpoint path
+//     (`/chat/completions`, `/images/generations`, `/audio/speech`, `/audio/transcriptions`, `/moderations`, `/embeddings`). The default value is `"https://api.openai.com/v1"`.
+//     Use `WithRootUrl` to point the whole client to an OpenAI-compatible server.
+//   - openAIBaseUrl: The chat completions URL override, by default empty so the URL is
+//     `"https://api.openai.com/v1/chat/completions"`. Image generations, text to speech, transcriptions, moderations, and
+//     embeddings have the same override with `WithImageGenerationsUrl`, `WithTextToSpeechUrl`, `WithTranscriptionsUrl`,
+//     `WithModerationsUrl`, and `WithEmbeddingsUrl`.
 //   - openAIModel: The default model for message processing is `"gpt-4o-mini"`, which specifies
 //     the Claude model version that will be used to generate responses.
+//   - retryPolicy: Failed request is not retried by default, use `WithRetryPolicy(DefaultRetryPolicy())` to retry
+//     rate limited and overloaded response.
 //
 // Example usage:
 //
@@ -128,20 +187,101 @@ func WithHTTPClient(httpClient *http.Client) ClientOption {
 	}
 }
 
-// custom base url setup if need using different endpoint maybe like dalle or whisper or other, use it on New function initiate
+// custom root url setup for all endpoint, like OpenAI-compatible gateway or local server "http://localhost:8080/v1",
+// empty value is ignored so it can be used directly with optional env, use it on New function initiate
+func WithRootUrl(rootUrl string) ClientOption {
+	return func(c *Config) {
+		if rootUrl != "" {
+			c.openAIRootUrl = strings.TrimSuffix(rootUrl, "/")
+		}
+	}
+}
+
+// custom full url for chat completions endpoint, override the url derived from root url, use it on New function initiate
 func WithBaseUrl(baseUrl string) ClientOption {
 	return func(c *Config) {
 		c.openAIBaseUrl = baseUrl
 	}
 }
 
-// custom model setup if need using different model maybe like gpt-4o or gpt-4o-turbo or other, use it on New function initiate
+// custom full url for dall-e image generations endpoint, override the url derived from root url, use it on New function initiate
+func WithImageGenerationsUrl(url string) ClientOption {
+	return func(c *Config) {
+		c.openAIImageGenerationsUrl = url
+	}
+}
+
+// custom full url for text to speech endpoint, override the url derived from root url, use it on New function initiate
+func WithTextToSpeechUrl(url string) ClientOption {
+	return func(c *Config) {
+		c.openAITextToSpeechUrl = url
+	}
+}
+
+// custom full url for speech to text transcriptions endpoint, override the url derived from root url, use it on New function initiate
+func WithTranscriptionsUrl(url string) ClientOption {
+	return func(c *Config) {
+		c.openAITranscriptionsUrl = url
+	}
+}
+
+// custom full url for moderations endpoint, override the url derived from root url, use it on New function initiate
+func WithModerationsUrl(url string) ClientOption {
+	return func(c *Config) {
+		c.openAIModerationsUrl = url
+	}
+}
+
+// custom full url for embeddings endpoint, override the url derived from root url, use it on New function initiate
+func WithEmbeddingsUrl(url string) ClientOption {
+	return func(c *Config) {
+		c.openAIEmbeddingsUrl = url
+	}
+}
+
+// custom model setup if need using different model maybe like gpt-4o or gpt-4o-turbo or other,
+// empty value is ignored so it can be used directly with optional env, use it on New function initiate
 func WithModel(model string) ClientOption {
 	return func(c *Config) {
-		c.openAIModel = model
+		if model != "" {
+			c.openAIModel = model
+		}
 	}
 }
 
+// endpointUrl return the override url if set, or the url derived from the root url
+func (c *Config) endpointUrl(override string, path string) string {
+	if override != "" {
+		return override
+	}
+
+	return c.openAIRootUrl + path
+}
+
+func (c *Config) chatCompletionsUrl() string {
+	return c.endpointUrl(c.openAIBaseUrl, OAPathTextCompletions)
+}
+
+func (c *Config) imageGenerationsUrl() string {
+	return c.endpointUrl(c.openAIImageGenerationsUrl, OAPathImageGenerationsDallE)
+}
+
+func (c *Config) textToSpeechUrl() string {
+	return c.endpointUrl(c.openAITextToSpeechUrl, OAPathTextToSpeech)
+}
+
+func (c *Config) transcriptionsUrl() string {
+	return c.endpointUrl(c.openAITranscriptionsUrl, OAPathTranscriptions)
+}
+
+func (c *Config) moderationsUrl() string {
+	return c.endpointUrl(c.openAIModerationsUrl, OAPathModerations)
+}
+
+func (c *Config) embeddingsUrl() string {
+	return c.endpointUrl(c.openAIEmbeddingsUrl, OAPathEmbeddings)
+}
+
 // OACreateResponseFormat creates a response format using a JSON Schema for OpenAI response format data requests.
 //
 // This function is used to generate a JSON Schema structure that can be passed as a parameter
@@ -151,6 +291,9 @@ func WithModel(model string) ClientOption {
 //   - jsonName: A string representing the name of the JSON schema.
 //   - jsonSchema: A map of string to interface, representing the schema data, specifically the properties
 //     of the schema as defined by the OpenAI structured output documentation.
+//   - strict: A boolean to enable the strict mode, the answer is guaranteed to follow the schema exactly.
+//     The schema is converted with OAStrictSchema, so every object property is required and no other property is allowed.
+//     Every object on the schema must have `properties`, otherwise OpenAI reject the request.
 //
 // Returns:
 //   - A map[string]interface{} representing the formatted response structure using JSON Schema,
@@ -170,7 +313,7 @@ func WithModel(model string) ClientOption {
 //		   },
 //		}
 //
-//		formattedResponse := OACreateResponseFormat("MySchema", jsonSchema)
+//		formattedResponse := OACreateResponseFormat("MySchema", jsonSchema, false)
 //		fmt.Printf("Formatted response: %v\n", formattedResponse)
 //
 // JSON Schema Structure:
@@ -197,14 +340,81 @@ func WithModel(model string) ClientOption {
 //	    }
 //	  }
 //	}
-func OACreateResponseFormat(jsonName string, jsonSchema map[string]interface{}) map[string]interface{} {
+func OACreateResponseFormat(jsonName string, jsonSchema map[string]interface{}, strict bool) map[string]interface{} {
+	format := map[string]interface{}{
+		"name":   jsonName,
+		"schema": jsonSchema,
+	}
+
+	if strict {
+		format["schema"] = OAStrictSchema(jsonSchema)
+		format["strict"] = true
+	}
+
 	return map[string]interface{}{
-		"type": "json_schema",
-		"json_schema": map[string]interface{}{
-			"name":   jsonName,
-			"schema": jsonSchema,
-		},
+		"type":        "json_schema",
+		"json_schema": format,
+	}
+}
+
+// OAStrictSchema return a copy of the JSON schema that follow the strict mode rule, every object with `properties`
+// get `additionalProperties: false` and all of its properties listed on `required` (sorted by name).
+// The nested schema on `properties`, `items`, `anyOf`, `$defs`, and `definitions` is converted too, the given schema is not changed.
+//
+// References:
+//   - Strict mode supported schemas: https://platform.openai.com/docs/guides/structured-outputs#supported-schemas
+func OAStrictSchema(jsonSchema map[string]interface{}) map[string]interface{} {
+	strict := make(map[string]interface{}, len(jsonSchema)+2)
+	for key, value := range jsonSchema {
+		strict[key] = value
+	}
+
+	if properties, ok := jsonSchema["properties"].(map[string]interface{}); ok {
+		strictProperties := make(map[string]interface{}, len(properties))
+		required := make([]string, 0, len(properties))
+		for name, property := range properties {
+			strictProperties[name] = strictSubSchema(property)
+			required = append(required, name)
+		}
+		sort.Strings(required)
+
+		strict["properties"] = strictProperties
+		strict["required"] = required
+		strict["additionalProperties"] = false
+	}
+
+	if items, ok := jsonSchema["items"]; ok {
+		strict["items"] = strictSubSchema(items)
+	}
+
+	if anyOf, ok := jsonSchema["anyOf"].([]interface{}); ok {
+		strictAnyOf := make([]interface{}, 0, len(anyOf))
+		for _, schema := range anyOf {
+			strictAnyOf = append(strictAnyOf, strictSubSchema(schema))
+		}
+		strict["anyOf"] = strictAnyOf
+	}
+
+	for _, key := range []string{"$defs", "definitions"} {
+		if defs, ok := jsonSchema[key].(map[string]interface{}); ok {
+			strictDefs := make(map[string]interface{}, len(defs))
+			for name, schema := range defs {
+				strictDefs[name] = strictSubSchema(schema)
+			}
+			strict[key] = strictDefs
+		}
+	}
+
+	return strict
+}
+
+// strictSubSchema convert the nested schema, other value like map[string]string leaf schema is kept as is
+func strictSubSchema(schema interface{}) interface{} {
+	if m, ok := schema.(map[string]interface{}); ok {
+		return OAStrictSchema(m)
 	}
+
+	return schema
 }
 
 // OACreateOneContentVision constructs a vision content payload for uploading an image (either as a URL or base64-encoded string)
@@ -350,7 +560,7 @@ func OACreateOneContentVision(media_type string, using_image_url bool, url_or_ba
 //	formatResponse := OACreateResponseFormat("WeatherResponse", map[string]interface{}{
 //	  "temperature": map[string]interface{}{"type": "string"},
 //	  "condition": map[string]interface{}{"type": "string"},
-//	})
+//	}, false)
 //
 //	response, err := openaiAPIInstance.OpenAISendMessage(&content, true, formatResponse, false, nil)
 //	if err != nil {
@@ -365,72 +575,26 @@ func OACreateOneContentVision(media_type string, using_image_url bool, url_or_ba
 // References:
 // - Official OpenAI API documentation: https://platform.openai.com/docs/api-reference/chat/create
 func (c *openaiAPI) OpenAISendMessage(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAChatCompletionResp, error) {
+	return c.OpenAISendMessageWithContext(context.Background(), content, with_format_response, format_response, with_custom_reqbody, req_body_custom)
+}
 
-	// var reqBody interface{}
-	var reqBody interface{}
-
-	if c.apiKey == "" {
-		return nil, errors.New("API Key is empty")
-	}
-
-	// check if with_format_response is true, format_response must be provided
-	if with_format_response && format_response == nil {
-		return nil, errors.New("format_response must be provided when with_format_response is true")
-	}
-
-	// check if with_custom_reqbody is true, req_body_custom must be provided
-	if with_custom_reqbody && req_body_custom.Messages == nil {
-		return nil, errors.New("req_body_custom must be provided when with_custom_reqbody is true")
-	}
-
-	// check if with_custom_reqbody is false, content must be provided
-	if !with_custom_reqbody && content == nil {
-		return nil, errors.New("content must be provided")
-	}
-
-	// create request body
-	if with_custom_reqbody {
-
-		if with_format_response {
-			req_body_custom.ResponseFormat = *format_response
-		}
-
-		reqBody = req_body_custom
-
-	} else {
-		reqData := OAReqBodyMessageCompletion{
-			Model:    c.config.openAIModel,
-			Messages: content,
-		}
-
-		// if using format response add response format to request body
-		if with_format_response {
-			reqData.ResponseFormat = *format_response
-		}
-
-		reqBody = reqData
-	}
+// OpenAISendMessageWithContext is the context-aware version of OpenAISendMessage, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAISendMessageWithContext(ctx context.Context, content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAChatCompletionResp, error) {
 
-	reqBodyJSON, err := json.Marshal(reqBody)
+	reqBody, err := c.createReqBody(content, with_format_response, format_response, with_custom_reqbody, req_body_custom)
 	if err != nil {
-		return nil, errors.New("Failed to marshal request body")
+		return nil, err
 	}
 
-	// send req to openai
-	req, err := http.NewRequest(http.MethodPost, c.config.openAIBaseUrl, bytes.NewBuffer(reqBodyJSON))
+	req, err := c.createRequest(ctx, c.config.chatCompletionsUrl(), reqBody)
 	if err != nil {
-		return nil, errors.New("Failed to create request")
+		return nil, err
 	}
 
-	// header setup
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.apiKey)
-
-	client := c.config.httpClient
-
-	resp, err := client.Do(req)
+	resp, err := c.doRequest(req)
 	if err != nil {
-		return nil, errors.New("Failed to send request: " + err.Error())
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("Failed to send request: %w", err)
 	}
 	defer func() {
 		if resp.StatusCode != http.StatusOK {
@@ -440,7 +604,7 @@ func (c *openaiAPI) OpenAISendMessage(content *[]OAMessageReq, with_format_respo
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("Failed to send request: " + resp.Status)
+		return nil, newAPIError(resp)
 	}
 
 	// decode response
@@ -464,7 +628,9 @@ func (c *openaiAPI) OpenAISendMessage(content *[]OAMessageReq, with_format_respo
 //
 // Returns:
 //   - A pointer to an OAMessage struct that contains the first content data from the response.
-//   - An error if the request to OpenAI fails.
+//   - An error if the request to OpenAI fails, ErrEmptyChoices when the response has no choice, *RefusalError when the model
+//     refuse to answer, *TruncatedError when the answer is cut by the max tokens (finish_reason "length"),
+//     and ErrContentFiltered when the answer is stopped by the content filter.
 //
 // Example usage:
 //
@@ -482,18 +648,49 @@ func (c *openaiAPI) OpenAISendMessage(content *[]OAMessageReq, with_format_respo
 // References:
 // - Official OpenAI API documentation: https://platform.openai.com/docs/api-reference/chat/create
 func (c *openaiAPI) OpenAIGetFirstContentDataResp(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAMessage, error) {
+	return c.OpenAIGetFirstContentDataRespWithContext(context.Background(), content, with_format_response, format_response, with_custom_reqbody, req_body_custom)
+}
+
+// OpenAIGetFirstContentDataRespWithContext is the context-aware version of OpenAIGetFirstContentDataResp, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAIGetFirstContentDataRespWithContext(ctx context.Context, content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAMessage, error) {
+
 	// send request to openai
-	resp, err := c.OpenAISendMessage(content, with_format_response, format_response, with_custom_reqbody, req_body_custom)
+	resp, err := c.OpenAISendMessageWithContext(ctx, content, with_format_response, format_response, with_custom_reqbody, req_body_custom)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := OACheckFirstChoice(resp); err != nil {
+		return nil, err
+	}
+
 	// get content first data
 	data := resp.Choices[0].Message
 
 	return &data, nil
 }
 
+// OACheckFirstChoice check the first choice of the chat completions response can be used as the answer,
+// the error is the same as OpenAIGetFirstContentDataResp. Use it after OpenAISendMessage or OpenAISendMessageStream
+// before decoding the structured output, so the refusal or the cut JSON is not decoded.
+func OACheckFirstChoice(resp *OAChatCompletionResp) error {
+	if len(resp.Choices) == 0 {
+		return ErrEmptyChoices
+	}
+
+	choice := resp.Choices[0]
+	switch {
+	case choice.Message.Refusal != "":
+		return &RefusalError{Refusal: choice.Message.Refusal}
+	case choice.FinishReason == OAFinishReasonLength:
+		return &TruncatedError{Resp: resp}
+	case choice.FinishReason == OAFinishReasonContentFilter:
+		return ErrContentFiltered
+	}
+
+	return nil
+}
+
 // OpenAICreateImageDallE generates images based on a text prompt using either the DALL-E 2 or DALL-E 3 model.
 //
 // This method constructs an HTTP request to OpenAI's image generation API, validates input requirements for each model,
@@ -572,6 +769,11 @@ func (c *openaiAPI) OpenAIGetFirstContentDataResp(content *[]OAMessageReq, with_
 // References:
 //   - OpenAI DALL E Image Generation API: https://platform.openai.com/docs/api-reference/images/create
 func (c *openaiAPI) OpenAICreateImageDallE(req_body *OAReqImageGeneratorDallE) (*OAImageGeneratorDallEResp, error) {
+	return c.OpenAICreateImageDallEWithContext(context.Background(), req_body)
+}
+
+// OpenAICreateImageDallEWithContext is the context-aware version of OpenAICreateImageDallE, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAICreateImageDallEWithContext(ctx context.Context, req_body *OAReqImageGeneratorDallE) (*OAImageGeneratorDallEResp, error) {
 
 	// ----------- input checker request
 	if req_body.Model == "" || (req_body.Model != "dall-e-2" && req_body.Model != "dall-e-3") {
@@ -613,7 +815,7 @@ func (c *openaiAPI) OpenAICreateImageDallE(req_body *OAReqImageGeneratorDallE) (
 	}
 
 	// create and send request
-	req, err := http.NewRequest(http.MethodPost, OAUrlImageGenerationsDallE, bytes.NewBuffer(reqBodyJson))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.imageGenerationsUrl(), bytes.NewBuffer(reqBodyJson))
 	if err != nil {
 		return nil, errors.New("Failed to create request")
 	}
@@ -621,11 +823,10 @@ func (c *openaiAPI) OpenAICreateImageDallE(req_body *OAReqImageGeneratorDallE) (
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	client := c.config.httpClient
-
-	resp, err := client.Do(req)
+	resp, err := c.doRequest(req)
 	if err != nil {
-		return nil, errors.New("Failed to send request: " + err.Error())
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("Failed to send request: %w", err)
 	}
 	defer func() {
 		if resp.StatusCode != http.StatusOK {
@@ -635,7 +836,7 @@ func (c *openaiAPI) OpenAICreateImageDallE(req_body *OAReqImageGeneratorDallE) (
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("Failed to send request: " + resp.Status)
+		return nil, newAPIError(resp)
 	}
 
 	var respDataDallE OAImageGeneratorDallEResp
@@ -688,6 +889,11 @@ func (c *openaiAPI) OpenAICreateImageDallE(req_body *OAReqImageGeneratorDallE) (
 // References:
 //   - TTS OpenAI: https://platform.openai.com/docs/api-reference/audio/createSpeech
 func (c *openaiAPI) OpenAITextToSpeech(req_body *OAReqTextToSpeech) (*OATextToSpeechResp, error) {
+	return c.OpenAITextToSpeechWithContext(context.Background(), req_body)
+}
+
+// OpenAITextToSpeechWithContext is the context-aware version of OpenAITextToSpeech, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAITextToSpeechWithContext(ctx context.Context, req_body *OAReqTextToSpeech) (*OATextToSpeechResp, error) {
 
 	// ----------- input checker request
 	if req_body.Model == "" || (req_body.Model != "tts-1" && req_body.Model != "tts-1-hd") {
@@ -702,7 +908,7 @@ func (c *openaiAPI) OpenAITextToSpeech(req_body *OAReqTextToSpeech) (*OATextToSp
 		return nil, errors.New("Voice must be en or en-GB")
 	}
 
-	if req_body.ResponseFormat != "" && (req_body.ResponseFormat == "mp3" && req_body.ResponseFormat == "opus" && req_body.ResponseFormat == "aac" && req_body.ResponseFormat == "flac" && req_body.ResponseFormat == "wav" && req_body.ResponseFormat == "pcm") {
+	if req_body.ResponseFormat != "" && (req_body.ResponseFormat != "mp3" && req_body.ResponseFormat != "opus" && req_body.ResponseFormat != "aac" && req_body.ResponseFormat != "flac" && req_body.ResponseFormat != "wav" && req_body.ResponseFormat != "pcm") {
 		return nil, errors.New("ResponseFormat must be mp3, opus, aac, flac, wav, or pcm")
 	}
 
@@ -722,7 +928,7 @@ func (c *openaiAPI) OpenAITextToSpeech(req_body *OAReqTextToSpeech) (*OATextToSp
 	}
 
 	// create req
-	req, err := http.NewRequest(http.MethodPost, OAUrlTextToSpeech, bytes.NewBuffer(reqBodyJson))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.textToSpeechUrl(), bytes.NewBuffer(reqBodyJson))
 	if err != nil {
 		return nil, errors.New("Failed to create request")
 	}
@@ -730,11 +936,10 @@ func (c *openaiAPI) OpenAITextToSpeech(req_body *OAReqTextToSpeech) (*OATextToSp
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+apiKey)
 
-	client := c.config.httpClient
-
-	resp, err := client.Do(req)
+	resp, err := c.doRequest(req)
 	if err != nil {
-		return nil, errors.New("Failed to send request: " + err.Error())
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("Failed to send request: %w", err)
 	}
 	defer func() {
 		if resp.StatusCode != http.StatusOK {
@@ -744,7 +949,7 @@ func (c *openaiAPI) OpenAITextToSpeech(req_body *OAReqTextToSpeech) (*OATextToSp
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, errors.New("Failed to send request: " + resp.Status)
+		return nil, newAPIError(resp)
 	}
 
 	// decode file mp3 response to encode base64
@@ -770,3 +975,369 @@ func (c *openaiAPI) OpenAITextToSpeech(req_body *OAReqTextToSpeech) (*OATextToSp
 
 	return &result, nil
 }
+
+// OpenAITranscribe converts an audio file into text using OpenAI's Whisper model.
+// The audio is uploaded as multipart form, and the response contain the full text and, when using `verbose_json`
+// response format (the default), the language, duration, and the segments with its start and end timestamp.
+//
+// Parameters:
+//   - req_body (*OAReqTranscription): A pointer to the OAReqTranscription struct containing the audio file and the options.
+//
+// Returns:
+//   - (*OATranscriptionResp, error): On success, returns a pointer to an OATranscriptionResp struct containing:
+//   - Text: The transcribed text.
+//   - Language, Duration, Segments: Only filled on `verbose_json` response format.
+//   - Words: Only filled when `TimestampGranularities` contain `"word"`.
+//     On failure, returns an error.
+//
+// Errors:
+//   - Returns an error if required fields are missing or invalid, including:
+//   - Empty File or FileName, or File larger than 25 MB.
+//   - Invalid Model (must be "whisper-1").
+//   - Invalid ResponseFormat (allowed values: "json", "verbose_json"), the text format is not supported because the response is decoded as JSON.
+//   - Temperature out of range (0 to 1).
+//   - Also returns an error if the API key is missing, or if any part of the HTTP request/response fails.
+//
+// Example Usage:
+//
+//	resp, err := openAI.OpenAITranscribe(&OAReqTranscription{
+//	    File:     audioBytes,
+//	    FileName: "voice.webm",
+//	    Model:    "whisper-1",
+//	    Language: "id",
+//	})
+//	if err != nil {
+//	    log.Fatalf("Transcription failed: %v", err)
+//	}
+//
+//	fmt.Println("Text:", resp.Text)
+//	for _, segment := range resp.Segments {
+//	    fmt.Printf("[%.2f - %.2f] %s\n", segment.Start, segment.End, segment.Text)
+//	}
+//
+// References:
+//   - Transcriptions OpenAI: https://platform.openai.com/docs/api-reference/audio/createTranscription
+func (c *openaiAPI) OpenAITranscribe(req_body *OAReqTranscription) (*OATranscriptionResp, error) {
+	return c.OpenAITranscribeWithContext(context.Background(), req_body)
+}
+
+// OpenAITranscribeWithContext is the context-aware version of OpenAITranscribe, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAITranscribeWithContext(ctx context.Context, req_body *OAReqTranscription) (*OATranscriptionResp, error) {
+
+	// ----------- input checker request
+	if len(req_body.File) == 0 || req_body.FileName == "" {
+		return nil, errors.New("Audio file and file name must be provided")
+	}
+
+	if len(req_body.File) > OAMaxTranscriptionFileSize {
+		return nil, errors.New("Audio file must be less than 25 MB")
+	}
+
+	if req_body.Model != "whisper-1" {
+		return nil, errors.New("Model must be whisper-1")
+	}
+
+	responseFormat := req_body.ResponseFormat
+	if responseFormat == "" {
+		responseFormat = "verbose_json"
+	}
+	if responseFormat != "json" && responseFormat != "verbose_json" {
+		return nil, errors.New("ResponseFormat must be json or verbose_json")
+	}
+
+	if req_body.Temperature != nil && (*req_body.Temperature < 0 || *req_body.Temperature > 1) {
+		return nil, errors.New("Temperature must be between 0 and 1")
+	}
+
+	apiKey := c.apiKey
+	if apiKey == "" {
+		return nil, errors.New("API Key is empty")
+	}
+
+	// create multipart body
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	fileWriter, err := writer.CreateFormFile("file", req_body.FileName)
+	if err != nil {
+		return nil, errors.New("Failed to create multipart body: " + err.Error())
+	}
+	if _, err := fileWriter.Write(req_body.File); err != nil {
+		return nil, errors.New("Failed to create multipart body: " + err.Error())
+	}
+
+	fields := [][2]string{
+		{"model", req_body.Model},
+		{"response_format", responseFormat},
+		{"language", req_body.Language},
+		{"prompt", req_body.Prompt},
+	}
+	if req_body.Temperature != nil {
+		fields = append(fields, [2]string{"temperature", strconv.FormatFloat(*req_body.Temperature, 'f', -1, 64)})
+	}
+	for _, granularity := range req_body.TimestampGranularities {
+		fields = append(fields, [2]string{"timestamp_granularities[]", granularity})
+	}
+
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		if err := writer.WriteField(field[0], field[1]); err != nil {
+			return nil, errors.New("Failed to create multipart body: " + err.Error())
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, errors.New("Failed to create multipart body: " + err.Error())
+	}
+
+	// create req, bytes.Reader body so the request can be retried
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.transcriptionsUrl(), bytes.NewReader(body.Bytes()))
+	if err != nil {
+		return nil, errors.New("Failed to create request")
+	}
+
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("Failed to send request: %w", err)
+	}
+	defer func() {
+		if resp.StatusCode != http.StatusOK {
+			io.ReadAll(resp.Body)
+		}
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var result OATranscriptionResp
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.New("Failed to decode response: " + err.Error())
+	}
+
+	return &result, nil
+}
+
+// OpenAIModeration checks whether the text is potentially harmful using OpenAI's moderation model.
+// The moderation endpoint is free to use, so it can be called on every user input and generated text.
+//
+// Parameters:
+//   - req_body (*OAReqModeration): A pointer to the OAReqModeration struct, `Input` is one string or list of string
+//     to check in one request, each input has its own result on the same order.
+//
+// Returns:
+//   - (*OAModerationResp, error): On success, returns a pointer to an OAModerationResp struct containing one result for each input:
+//   - Flagged: true if any category is flagged by OpenAI.
+//   - Categories: the flag for each category like "harassment", "violence", or "sexual/minors".
+//   - CategoryScores: the score (0 to 1) for each category, can be used to apply own threshold.
+//     On failure, returns an error.
+//
+// Example Usage:
+//
+//	resp, err := openAI.OpenAIModeration(&OAReqModeration{
+//	    Input: []string{"first text", "second text"},
+//	    Model: "omni-moderation-latest",
+//	})
+//	if err != nil {
+//	    log.Fatalf("Moderation failed: %v", err)
+//	}
+//
+//	for i, result := range resp.Results {
+//	    fmt.Println(i, result.Flagged, result.CategoryScores["violence"])
+//	}
+//
+// References:
+//   - Moderations OpenAI: https://platform.openai.com/docs/api-reference/moderations
+func (c *openaiAPI) OpenAIModeration(req_body *OAReqModeration) (*OAModerationResp, error) {
+	return c.OpenAIModerationWithContext(context.Background(), req_body)
+}
+
+// OpenAIModerationWithContext is the context-aware version of OpenAIModeration, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAIModerationWithContext(ctx context.Context, req_body *OAReqModeration) (*OAModerationResp, error) {
+	if req_body == nil || req_body.Input == nil {
+		return nil, errors.New("Input must be provided")
+	}
+
+	if c.apiKey == "" {
+		return nil, errors.New("API Key is empty")
+	}
+
+	req, err := c.createRequest(ctx, c.config.moderationsUrl(), req_body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("Failed to send request: %w", err)
+	}
+	defer func() {
+		if resp.StatusCode != http.StatusOK {
+			io.ReadAll(resp.Body)
+		}
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var result OAModerationResp
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.New("Failed to decode response: " + err.Error())
+	}
+
+	return &result, nil
+}
+
+// OpenAIEmbeddings creates the embedding vector of the input text, the vector can be compared with cosine similarity
+// to find semantically similar text.
+//
+// Parameters:
+//   - req_body (*OAReqEmbeddings): A pointer to the OAReqEmbeddings struct, `Input` is one string or list of string
+//     embedded in one request. If `Model` is empty `OAEmbeddingModel` is used.
+//
+// Returns:
+//   - (*OAEmbeddingsResp, error): On success, returns a pointer to an OAEmbeddingsResp struct containing one embedding
+//     for each input, `Index` is the position of the input. The OpenAI embedding is normalized to length 1,
+//     so the cosine similarity is the same as the dot product. `Usage` contain the input tokens used for the cost.
+//     On failure, returns an error.
+//
+// Example Usage:
+//
+//	resp, err := openAI.OpenAIEmbeddings(&OAReqEmbeddings{
+//	    Input: []string{"the story about the lighthouse", "medium roasting"},
+//	})
+//	if err != nil {
+//	    log.Fatalf("Embeddings failed: %v", err)
+//	}
+//
+//	for _, data := range resp.Data {
+//	    fmt.Println(data.Index, len(data.Embedding))
+//	}
+//
+// References:
+//   - Embeddings OpenAI: https://platform.openai.com/docs/api-reference/embeddings
+func (c *openaiAPI) OpenAIEmbeddings(req_body *OAReqEmbeddings) (*OAEmbeddingsResp, error) {
+	return c.OpenAIEmbeddingsWithContext(context.Background(), req_body)
+}
+
+// OpenAIEmbeddingsWithContext is the context-aware version of OpenAIEmbeddings, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAIEmbeddingsWithContext(ctx context.Context, req_body *OAReqEmbeddings) (*OAEmbeddingsResp, error) {
+	if req_body == nil || req_body.Input == nil {
+		return nil, errors.New("Input must be provided")
+	}
+
+	if c.apiKey == "" {
+		return nil, errors.New("API Key is empty")
+	}
+
+	// copy so the caller request not changed
+	reqBody := *req_body
+	if reqBody.Model == "" {
+		reqBody.Model = OAEmbeddingModel
+	}
+
+	req, err := c.createRequest(ctx, c.config.embeddingsUrl(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("Failed to send request: %w", err)
+	}
+	defer func() {
+		if resp.StatusCode != http.StatusOK {
+			io.ReadAll(resp.Body)
+		}
+		resp.Body.Close()
+	}()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	var result OAEmbeddingsResp
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, errors.New("Failed to decode response: " + err.Error())
+	}
+
+	return &result, nil
+}
+
+// createReqBody validate the input and create the chat completions request body used by OpenAISendMessage and OpenAISendMessageStream
+func (c *openaiAPI) createReqBody(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OAReqBodyMessageCompletion, error) {
+	if c.apiKey == "" {
+		return nil, errors.New("API Key is empty")
+	}
+
+	// check if with_format_response is true, format_response must be provided
+	if with_format_response && format_response == nil {
+		return nil, errors.New("format_response must be provided when with_format_response is true")
+	}
+
+	// check if with_custom_reqbody is true, req_body_custom must be provided
+	if with_custom_reqbody && (req_body_custom == nil || req_body_custom.Messages == nil) {
+		return nil, errors.New("req_body_custom must be provided when with_custom_reqbody is true")
+	}
+
+	// check if with_custom_reqbody is false, content must be provided
+	if !with_custom_reqbody && content == nil {
+		return nil, errors.New("content must be provided")
+	}
+
+	var reqBody OAReqBodyMessageCompletion
+
+	// create request body, custom body is copied so the caller data not changed when the stream flag is set
+	if with_custom_reqbody {
+		reqBody = *req_body_custom
+		if reqBody.Model == "" {
+			reqBody.Model = c.config.openAIModel
+		}
+	} else {
+		reqBody = OAReqBodyMessageCompletion{
+			Model:    c.config.openAIModel,
+			Messages: content,
+		}
+	}
+
+	// if using format response add response format to request body
+	if with_format_response {
+		reqBody.ResponseFormat = *format_response
+	}
+
+	if err := reqBody.validateAudioOutput(); err != nil {
+		return nil, err
+	}
+
+	return &reqBody, nil
+}
+
+// createRequest create the http request with json body and the authorization header
+func (c *openaiAPI) createRequest(ctx context.Context, url string, reqBody interface{}) (*http.Request, error) {
+	reqBodyJSON, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, errors.New("Failed to marshal request body")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(reqBodyJSON))
+	if err != nil {
+		return nil, errors.New("Failed to create request")
+	}
+
+	// header setup
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+
+	return req, nil
+}
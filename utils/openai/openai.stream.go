@@ -0,0 +1,160 @@
+package openai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// OpenAISendMessageStream sends a chat completion request with streaming enabled and forward each content token to on_delta.
+//
+// The parameters is the same as OpenAISendMessage, with additional `on_delta` callback that called for every
+// `chat.completion.chunk` that have content delta on the first choice. If `on_delta` return error, the stream is stopped
+// and the error returned, this can be used to stop the upstream request when the client is gone.
+//
+// Returns:
+//   - A pointer to `OAChatCompletionResp` build from the chunks, with one choice that contain the full concatenated content and
+//     the finish reason. The usage is filled from the last chunk because the request always set `stream_options.include_usage`.
+//   - An error if the request fails or the stream ended before `data: [DONE]`.
+//
+// Example usage:
+//
+//	content := []OAMessageReq{
+//	  {Role: "user", Content: "Tell me a story"},
+//	}
+//
+//	resp, err := openaiAPIInstance.OpenAISendMessageStream(&content, false, nil, false, nil, func(text string) error {
+//	    fmt.Print(text)
+//	    return nil
+//	})
+//	if err != nil {
+//	    log.Fatalf("Failed to stream message: %v", err)
+//	}
+//
+// References:
+//   - Official OpenAI streaming documentation: https://platform.openai.com/docs/api-reference/chat/streaming
+func (c *openaiAPI) OpenAISendMessageStream(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion, on_delta func(text string) error) (*OAChatCompletionResp, error) {
+	return c.OpenAISendMessageStreamWithContext(context.Background(), content, with_format_response, format_response, with_custom_reqbody, req_body_custom, on_delta)
+}
+
+// OpenAISendMessageStreamWithContext is the context-aware version of OpenAISendMessageStream, the upstream request is canceled when ctx is done or its deadline is exceeded.
+func (c *openaiAPI) OpenAISendMessageStreamWithContext(ctx context.Context, content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion, on_delta func(text string) error) (*OAChatCompletionResp, error) {
+
+	if on_delta == nil {
+		return nil, errors.New("on_delta callback must be provided")
+	}
+
+	reqBody, err := c.createReqBody(content, with_format_response, format_response, with_custom_reqbody, req_body_custom)
+	if err != nil {
+		return nil, err
+	}
+
+	// the audio chunk is not decoded by the stream, use OpenAISendMessage for audio output
+	if reqBody.hasAudioOutput() {
+		return nil, errors.New("audio output is not supported on stream, use OpenAISendMessage instead")
+	}
+	reqBody.Stream = true
+	reqBody.StreamOptions = &OAStreamOptions{
+		IncludeUsage: true,
+	}
+
+	req, err := c.createRequest(ctx, c.config.chatCompletionsUrl(), reqBody)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	resp, err := c.doRequest(req)
+	if err != nil {
+		// wrapped so the caller can check context.DeadlineExceeded or net timeout error
+		return nil, fmt.Errorf("Failed to send request: %w", err)
+	}
+	// not drain the body on close, so when on_delta stop the stream the upstream connection also stopped
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, newAPIError(resp)
+	}
+
+	result := OAChatCompletionResp{
+		Choices: []OAChoice{
+			{
+				Message: OAMessage{
+					Role: "assistant",
+				},
+			},
+		},
+	}
+	var text, refusal strings.Builder
+	isDone := false
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			isDone = true
+			break
+		}
+
+		var chunk OAChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, errors.New("Failed to decode stream chunk: " + err.Error())
+		}
+
+		result.ID = chunk.ID
+		result.Object = chunk.Object
+		result.Created = chunk.Created
+		result.Model = chunk.Model
+		result.SystemFingerprint = chunk.SystemFingerprint
+
+		if chunk.Usage != nil {
+			result.Usage = *chunk.Usage
+		}
+
+		// just follow the first choice, same as OpenAIGetFirstContentDataResp
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+
+			if choice.FinishReason != nil {
+				result.Choices[0].FinishReason = *choice.FinishReason
+			}
+
+			refusal.WriteString(choice.Delta.Refusal)
+
+			if choice.Delta.Content != "" {
+				text.WriteString(choice.Delta.Content)
+
+				if err := on_delta(choice.Delta.Content); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("Failed to read stream: " + err.Error())
+	}
+
+	if !isDone {
+		return nil, errors.New("Failed to read stream: stream ended before [DONE]")
+	}
+
+	result.Choices[0].Message.Content = text.String()
+	result.Choices[0].Message.Refusal = refusal.String()
+
+	return &result, nil
+}
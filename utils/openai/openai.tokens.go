@@ -0,0 +1,166 @@
+package openai
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// chat format overhead, every message is wrapped with the role and separator token,
+	// and every reply is primed with the assistant role
+	oaTokensPerMessage = 3
+	oaTokensPerReply   = 3
+	// image token on the default "auto"/"high" detail for 1024x1024 image, and on "low" detail
+	oaTokensPerImage    = 765
+	oaTokensPerImageLow = 85
+)
+
+// oaTokenPiece split the text like the tiktoken pre-tokenizer (cl100k_base and o200k_base):
+// contraction, word with its leading space, number up to 3 digit, symbol run, and whitespace run
+var oaTokenPiece = regexp.MustCompile(`'(?:[sdmtSDMT]|ll|ve|re|LL|VE|RE)| ?\p{L}+| ?\p{N}{1,3}| ?[^\s\p{L}\p{N}]+|\s+`)
+
+// estimate result of the chat completions request tokens
+type OATokenEstimate struct {
+	Model        string `json:"model"`         // the model the request would be sent to
+	PromptTokens int    `json:"prompt_tokens"` // approximate, see OAEstimateTokens
+}
+
+// OAEstimateTokens return the approximate token count of the text without calling the API.
+//
+// This is not the real BPE tokenizer, the text is split like the tiktoken pre-tokenizer and each piece is counted
+// from its length: short word is one token, long word is split every 4 letter, and letter without space
+// between word like Chinese or Japanese is one token each. It is good enough to guard the context window and
+// estimate the cost, but use the `usage` on the response for the billed tokens.
+//
+// Example usage:
+//
+//	tokens := OAEstimateTokens("Berikan roasting playful untuk konten Medium user berikut")
+func OAEstimateTokens(text string) int {
+	tokens := 0
+
+	for _, piece := range oaTokenPiece.FindAllString(text, -1) {
+		tokens += estimatePieceTokens(piece)
+	}
+
+	return tokens
+}
+
+// estimatePieceTokens count one pre-tokenized piece
+func estimatePieceTokens(piece string) int {
+	first, _ := utf8.DecodeRuneInString(piece)
+	if unicode.IsSpace(first) && len(piece) > 1 {
+		// leading space is merged to the next piece, only the content count
+		if next, _ := utf8.DecodeRuneInString(piece[1:]); !unicode.IsSpace(next) {
+			piece = piece[1:]
+		}
+	}
+
+	runes := []rune(piece)
+	switch {
+	case len(runes) == 0:
+		return 0
+
+	case unicode.IsSpace(runes[0]):
+		return 1
+
+	case unicode.IsLetter(runes[0]):
+		// script without space between word mostly use one token for each letter
+		if unicode.In(runes[0], unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai) {
+			return len(runes)
+		}
+		return (len(runes) + 3) / 4
+
+	case unicode.IsNumber(runes[0]):
+		return 1
+	}
+
+	// symbol run like "...", "{\"", or emoji
+	return (len(runes) + 1) / 2
+}
+
+// OAEstimateMessagesTokens return the approximate prompt tokens of the chat completions messages.
+//
+// The messages can be []OAMessageReq or any value with the same JSON shape, the content can be string or the vision
+// content list (see OACreateOneContentVision). The image is counted as the fixed image tokens on its detail, so large
+// image with "high" detail that is split into many tile is counted lower than the real tokens.
+func OAEstimateMessagesTokens(messages interface{}) (int, error) {
+	// decoded from JSON so every messages type with the same shape can be counted
+	messagesJSON, err := json.Marshal(messages)
+	if err != nil {
+		return 0, errors.New("Failed to estimate tokens: " + err.Error())
+	}
+
+	var decoded []struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(messagesJSON, &decoded); err != nil {
+		return 0, errors.New("Failed to estimate tokens: " + err.Error())
+	}
+
+	tokens := oaTokensPerReply
+	for _, message := range decoded {
+		tokens += oaTokensPerMessage + OAEstimateTokens(message.Role)
+
+		var text string
+		if err := json.Unmarshal(message.Content, &text); err == nil {
+			tokens += OAEstimateTokens(text)
+			continue
+		}
+
+		var parts []struct {
+			Type     string  `json:"type"`
+			Text     *string `json:"text"`
+			ImageUrl *struct {
+				Detail string `json:"detail"`
+			} `json:"image_url"`
+		}
+		if err := json.Unmarshal(message.Content, &parts); err != nil {
+			return 0, errors.New("Failed to estimate tokens: message content is not string or content list")
+		}
+
+		for _, part := range parts {
+			switch {
+			case part.Text != nil:
+				tokens += OAEstimateTokens(*part.Text)
+			case part.ImageUrl != nil && part.ImageUrl.Detail == "low":
+				tokens += oaTokensPerImageLow
+			case part.ImageUrl != nil:
+				tokens += oaTokensPerImage
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// OpenAIEstimateTokens return the approximate prompt tokens of the chat completions request without calling the API,
+// the parameter is the same as OpenAISendMessage so the estimated request is the same as the sent request.
+// The response format JSON schema is counted as text because it is added to the prompt by OpenAI
+func (c *openaiAPI) OpenAIEstimateTokens(content *[]OAMessageReq, with_format_response bool, format_response *map[string]interface{}, with_custom_reqbody bool, req_body_custom *OAReqBodyMessageCompletion) (*OATokenEstimate, error) {
+	reqBody, err := c.createReqBody(content, with_format_response, format_response, with_custom_reqbody, req_body_custom)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := OAEstimateMessagesTokens(reqBody.Messages)
+	if err != nil {
+		return nil, err
+	}
+
+	if reqBody.ResponseFormat != nil {
+		formatJSON, err := json.Marshal(reqBody.ResponseFormat)
+		if err != nil {
+			return nil, errors.New("Failed to estimate tokens: " + err.Error())
+		}
+		tokens += OAEstimateTokens(string(formatJSON))
+	}
+
+	return &OATokenEstimate{
+		Model:        reqBody.Model,
+		PromptTokens: tokens,
+	}, nil
+}
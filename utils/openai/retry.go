@@ -0,0 +1,243 @@
+package openai
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy holds the configuration for retrying failed request to OpenAI API
+type RetryPolicy struct {
+	MaxRetries int           // max retry after the first attempt, 0 mean no retry
+	BaseDelay  time.Duration // delay for the first retry, doubled on every next retry
+	MaxDelay   time.Duration // max delay between retry, if the server ask to wait longer than this the request is not retried
+}
+
+// DefaultRetryPolicy return the recommended retry policy, 3 retries start from 1 second and max 30 seconds wait
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries: 3,
+		BaseDelay:  1 * time.Second,
+		MaxDelay:   30 * time.Second,
+	}
+}
+
+// custom retry setup for rate limited or overloaded response, use it on New function initiate.
+// By default the client not retry any failed request
+func WithRetryPolicy(policy RetryPolicy) ClientOption {
+	return func(c *Config) {
+		c.retryPolicy = policy
+	}
+}
+
+// RequestGate is called once before the request is sent, the request fail with the returned error without being sent.
+// done is called with the final response after every retry, so the retried attempts of one request is one outcome.
+// breaker.Set.Gate return this function for the circuit breaker
+type RequestGate func(req *http.Request) (done func(resp *http.Response, err error), err error)
+
+// custom gate for every request like the circuit breaker, use it on New function initiate
+func WithRequestGate(gate RequestGate) ClientOption {
+	return func(c *Config) {
+		c.requestGate = gate
+	}
+}
+
+// doRequest send the request and retry it following the retry policy.
+//
+// Only failure that safe to retry is retried, which is the failure where OpenAI not process the request:
+// connection failure before the request sent, 408, 429 (except insufficient_quota) and 502/503/504 from the gateway.
+// The delay is taken from the `retry-after` header, then `x-ratelimit-reset-*` header when the limit is reached,
+// and fallback to exponential backoff with jitter. The last response is returned as it is when all retry failed,
+// so the caller still handle the error response. When the request context has deadline, the retry is stopped once
+// the wait would pass half of the time left, so a failing provider not use up the whole feature deadline.
+// The request gate is checked once before the first attempt and get the last response.
+//
+// References:
+//   - OpenAI error codes: https://platform.openai.com/docs/guides/error-codes/api-errors
+//   - OpenAI rate limits headers: https://platform.openai.com/docs/guides/rate-limits#rate-limits-in-headers
+func (c *openaiAPI) doRequest(req *http.Request) (resp *http.Response, err error) {
+	if gate := c.config.requestGate; gate != nil {
+		done, gateErr := gate(req)
+		if gateErr != nil {
+			return nil, gateErr
+		}
+		defer func() {
+			done(resp, err)
+		}()
+	}
+
+	policy := c.config.retryPolicy
+	retryUntil, hasDeadline := retryDeadline(req.Context())
+
+	for attempt := 0; ; attempt++ {
+		resp, err := c.config.httpClient.Do(req)
+
+		if attempt >= policy.MaxRetries || !shouldRetry(resp, err) {
+			return resp, err
+		}
+
+		delay := retryDelay(policy, attempt, resp)
+		if delay > policy.MaxDelay {
+			log.Printf("openai: not retrying request, server ask to wait %s which is longer than max delay %s", delay, policy.MaxDelay)
+			return resp, err
+		}
+		if hasDeadline && time.Now().Add(delay).After(retryUntil) {
+			log.Printf("openai: not retrying request, waiting %s is past half of the time left before the request deadline", delay)
+			return resp, err
+		}
+
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			// drain so the connection can be reused on the next attempt
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Printf("openai: request failed (%s), retrying in %s (retry %d/%d)", reason, delay, attempt+1, policy.MaxRetries)
+
+		if err := sleepWithContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+
+		req, err = cloneRequest(req)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDeadline return the time the retry must be started before, which is half of the time left until the context deadline.
+// The other half is kept for the last attempt and for the caller, so the failover to the other provider can still run
+func retryDeadline(ctx context.Context) (time.Time, bool) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return time.Time{}, false
+	}
+
+	now := time.Now()
+	return now.Add(deadline.Sub(now) / 2), true
+}
+
+// shouldRetry check if the failed request is safe to send again
+func shouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		// just retry when the connection failed before the request is sent,
+		// other error may happen after OpenAI already process the request
+		var opErr *net.OpError
+		return errors.As(err, &opErr) && opErr.Op == "dial"
+	}
+
+	// openai can explicitly tell if the request should be retried
+	switch resp.Header.Get("x-should-retry") {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		// out of quota also return 429, but it will not success until the billing is fixed
+		return !isInsufficientQuota(resp)
+	case http.StatusRequestTimeout, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// isInsufficientQuota check the error body code, the body is restored so the caller can still read it
+func isInsufficientQuota(resp *http.Response) bool {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(body, []byte(`"insufficient_quota"`))
+}
+
+// retryDelay get how long to wait before the next attempt
+func retryDelay(policy RetryPolicy, attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if delay, ok := retryAfter(resp.Header); ok {
+			return delay
+		}
+
+		// when the rate limit is reached, wait until the limit is reset
+		// the reset value is duration like "1s" or "6m0s"
+		for _, limit := range []string{"requests", "tokens"} {
+			if resp.Header.Get("x-ratelimit-remaining-"+limit) != "0" {
+				continue
+			}
+
+			reset, err := time.ParseDuration(resp.Header.Get("x-ratelimit-reset-" + limit))
+			if err == nil {
+				return reset
+			}
+		}
+	}
+
+	// exponential backoff with jitter, random between half and full delay
+	delay := policy.BaseDelay << attempt
+	if delay <= 0 || delay > policy.MaxDelay {
+		delay = policy.MaxDelay
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// retryAfter parse the retry-after header, the value can be in seconds or http date
+func retryAfter(header http.Header) (time.Duration, bool) {
+	value := header.Get("retry-after")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+
+	return 0, false
+}
+
+// cloneRequest create new request with fresh body for the next attempt
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	newReq := req.Clone(req.Context())
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, errors.New("request failed: " + err.Error())
+		}
+		newReq.Body = body
+	}
+
+	return newReq, nil
+}
+
+func sleepWithContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
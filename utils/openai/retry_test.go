@@ -0,0 +1,317 @@
+package openai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		header map[string]string
+		body   string
+		err    error
+		want   bool
+	}{
+		{name: "ok", status: http.StatusOK, want: false},
+		{name: "bad request", status: http.StatusBadRequest, want: false},
+		{name: "request timeout", status: http.StatusRequestTimeout, want: true},
+		{name: "rate limited", status: http.StatusTooManyRequests, body: `{"error":{"code":"rate_limit_exceeded"}}`, want: true},
+		{name: "insufficient quota", status: http.StatusTooManyRequests, body: `{"error":{"type":"insufficient_quota","code":"insufficient_quota"}}`, want: false},
+		{name: "internal server error", status: http.StatusInternalServerError, want: false},
+		{name: "bad gateway", status: http.StatusBadGateway, want: true},
+		{name: "service unavailable", status: http.StatusServiceUnavailable, want: true},
+		{name: "gateway timeout", status: http.StatusGatewayTimeout, want: true},
+		{name: "x-should-retry true", status: http.StatusInternalServerError, header: map[string]string{"x-should-retry": "true"}, want: true},
+		{name: "x-should-retry false", status: http.StatusTooManyRequests, header: map[string]string{"x-should-retry": "false"}, want: false},
+		{name: "dial error", err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{name: "read error", err: &net.OpError{Op: "read", Err: errors.New("connection reset")}, want: false},
+		{name: "other error", err: errors.New("unexpected EOF"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp *http.Response
+			if tt.err == nil {
+				resp = &http.Response{StatusCode: tt.status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(tt.body))}
+				for k, v := range tt.header {
+					resp.Header.Set(k, v)
+				}
+			}
+
+			if got := shouldRetry(resp, tt.err); got != tt.want {
+				t.Errorf("shouldRetry() = %v, want %v", got, tt.want)
+			}
+
+			// the body is still readable by the caller after checked
+			if resp != nil {
+				body, _ := io.ReadAll(resp.Body)
+				if string(body) != tt.body {
+					t.Errorf("body = %q, want %q", body, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOk bool
+	}{
+		{name: "empty", value: "", wantOk: false},
+		{name: "seconds", value: "3", want: 3 * time.Second, wantOk: true},
+		{name: "fraction seconds", value: "0.5", want: 500 * time.Millisecond, wantOk: true},
+		{name: "invalid", value: "soon", wantOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.value != "" {
+				header.Set("retry-after", tt.value)
+			}
+
+			got, ok := retryAfter(header)
+			if ok != tt.wantOk || got != tt.want {
+				t.Errorf("retryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+
+	t.Run("http date", func(t *testing.T) {
+		header := http.Header{}
+		header.Set("retry-after", time.Now().Add(10*time.Second).UTC().Format(http.TimeFormat))
+
+		got, ok := retryAfter(header)
+		if !ok || got <= 8*time.Second || got > 10*time.Second {
+			t.Errorf("retryAfter() = %v, %v, want about 10s", got, ok)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	headerTests := []struct {
+		name   string
+		header map[string]string
+		want   time.Duration
+	}{
+		{name: "retry-after header", header: map[string]string{"retry-after": "2"}, want: 2 * time.Second},
+		{name: "retry-after first", header: map[string]string{"retry-after": "2", "x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "6m0s"}, want: 2 * time.Second},
+		{name: "requests reset", header: map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "1s"}, want: time.Second},
+		{name: "tokens reset", header: map[string]string{"x-ratelimit-remaining-tokens": "0", "x-ratelimit-reset-tokens": "6m0s"}, want: 6 * time.Minute},
+		{name: "tokens reset in millisecond", header: map[string]string{"x-ratelimit-remaining-tokens": "0", "x-ratelimit-reset-tokens": "120ms"}, want: 120 * time.Millisecond},
+	}
+	for _, tt := range headerTests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			for k, v := range tt.header {
+				resp.Header.Set(k, v)
+			}
+
+			if got := retryDelay(policy, 0, resp); got != tt.want {
+				t.Errorf("retryDelay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("rate limit not reached use backoff", func(t *testing.T) {
+		resp := &http.Response{Header: http.Header{}}
+		resp.Header.Set("x-ratelimit-remaining-tokens", "10")
+		resp.Header.Set("x-ratelimit-reset-tokens", "6m0s")
+
+		if got := retryDelay(policy, 0, resp); got < 500*time.Millisecond || got > time.Second {
+			t.Errorf("retryDelay() = %v, want between 500ms and 1s", got)
+		}
+	})
+
+	backoff := []struct {
+		attempt int
+		min     time.Duration
+		max     time.Duration
+	}{
+		{attempt: 0, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 1, min: time.Second, max: 2 * time.Second},
+		{attempt: 2, min: 2 * time.Second, max: 4 * time.Second},
+		{attempt: 3, min: 2500 * time.Millisecond, max: 5 * time.Second},  // capped to max delay
+		{attempt: 70, min: 2500 * time.Millisecond, max: 5 * time.Second}, // overflow
+	}
+	for _, tt := range backoff {
+		for i := 0; i < 20; i++ {
+			if got := retryDelay(policy, tt.attempt, nil); got < tt.min || got > tt.max {
+				t.Fatalf("retryDelay(attempt %d) = %v, want between %v and %v", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+func TestDoRequest(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		policy       RetryPolicy
+		timeout      time.Duration
+		wantStatus   int
+		wantAttempts int32
+	}{
+		{
+			name:         "success after retry",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+			wantStatus:   http.StatusOK,
+			wantAttempts: 3,
+		},
+		{
+			name:         "last response returned when all retry failed",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusTooManyRequests},
+			policy:       RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 3,
+		},
+		{
+			name:         "no retry policy",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 1,
+		},
+		{
+			name:         "wait past half of the deadline is not retried",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: 800 * time.Millisecond, MaxDelay: time.Second},
+			timeout:      500 * time.Millisecond,
+			wantStatus:   http.StatusTooManyRequests,
+			wantAttempts: 1,
+		},
+		{
+			name:         "wait within half of the deadline is retried",
+			statuses:     []int{http.StatusTooManyRequests, http.StatusOK},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: 10 * time.Millisecond, MaxDelay: time.Second},
+			timeout:      5 * time.Second,
+			wantStatus:   http.StatusOK,
+			wantAttempts: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				i := atomic.AddInt32(&attempts, 1) - 1
+				w.WriteHeader(tt.statuses[i])
+			}))
+			defer srv.Close()
+
+			client := &openaiAPI{config: &Config{httpClient: srv.Client(), retryPolicy: tt.policy}}
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, srv.URL, strings.NewReader(`{}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			resp, err := client.doRequest(req)
+			if err != nil {
+				t.Fatalf("doRequest() error = %v", err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&attempts); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestDoRequestGate(t *testing.T) {
+	var attempts int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var gateCalls, doneCalls int
+	var doneStatus int
+	gate := func(req *http.Request) (func(resp *http.Response, err error), error) {
+		gateCalls++
+		return func(resp *http.Response, err error) {
+			doneCalls++
+			if resp != nil {
+				doneStatus = resp.StatusCode
+			}
+		}, nil
+	}
+
+	client := &openaiAPI{config: &Config{
+		httpClient:  srv.Client(),
+		retryPolicy: RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond},
+		requestGate: gate,
+	}}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := client.doRequest(req)
+	if err != nil {
+		t.Fatalf("doRequest() error = %v", err)
+	}
+	resp.Body.Close()
+
+	// the retried attempts is one request for the gate, done with the final response
+	if gateCalls != 1 || doneCalls != 1 {
+		t.Errorf("gate calls = %d, done calls = %d, want 1 and 1", gateCalls, doneCalls)
+	}
+	if doneStatus != http.StatusOK {
+		t.Errorf("done status = %d, want %d", doneStatus, http.StatusOK)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+
+	t.Run("gate error", func(t *testing.T) {
+		gateErr := errors.New("circuit open")
+		client.config.requestGate = func(req *http.Request) (func(resp *http.Response, err error), error) {
+			return nil, gateErr
+		}
+		atomic.StoreInt32(&attempts, 0)
+
+		req, err := http.NewRequest(http.MethodPost, srv.URL, strings.NewReader(`{}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := client.doRequest(req); !errors.Is(err, gateErr) {
+			t.Errorf("doRequest() error = %v, want %v", err, gateErr)
+		}
+		if got := atomic.LoadInt32(&attempts); got != 0 {
+			t.Errorf("attempts = %d, want 0", got)
+		}
+	})
+}
@@ -0,0 +1,201 @@
+package pricing
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"strings"
+)
+
+// ModelPrice is the token price of a chat model in USD per 1 million tokens
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"input_per_million"`
+	OutputPerMillion float64 `json:"output_per_million"`
+	// audio token price for model with audio input or output, 0 mean priced as text token
+	AudioInputPerMillion  float64 `json:"audio_input_per_million,omitempty"`
+	AudioOutputPerMillion float64 `json:"audio_output_per_million,omitempty"`
+	// prompt cache token price, 0 mean priced as input token
+	CacheReadPerMillion  float64 `json:"cache_read_per_million,omitempty"`
+	CacheWritePerMillion float64 `json:"cache_write_per_million,omitempty"`
+}
+
+// PriceTable convert the provider usage to USD cost and the USD cost to user credit
+type PriceTable struct {
+	// chat model price, the key is matched as prefix of the model name returned by the provider
+	// so "gpt-4o" match "gpt-4o-2024-08-06", the longest prefix is used
+	Models map[string]ModelPrice `json:"models"`
+	// used when the model not found in Models
+	DefaultModel ModelPrice `json:"default_model"`
+	// image price in USD per image, the key is "model:size" or "model:hd:size" for hd quality
+	Images map[string]float64 `json:"images"`
+	// text to speech price in USD per 1 million input characters, the key is the model name
+	TTSPerMillionChars map[string]float64 `json:"tts_per_million_chars"`
+	// speech to text price in USD per minute of audio, the key is the model name
+	TranscriptionPerMinute map[string]float64 `json:"transcription_per_minute"`
+	// embedding price in USD per 1 million input tokens, the key is the model name
+	EmbeddingPerMillion map[string]float64 `json:"embedding_per_million"`
+	// USD value of 1 credit
+	CreditValue float64 `json:"credit_value"`
+	// min credit charged for one success feature request
+	MinCredit int `json:"min_credit"`
+}
+
+// DefaultPriceTable return price table using the public price list of OpenAI and Anthropic
+//
+// References:
+//   - OpenAI pricing: https://openai.com/api/pricing
+//   - Anthropic pricing: https://www.anthropic.com/pricing#anthropic-api
+func DefaultPriceTable() *PriceTable {
+	return &PriceTable{
+		Models: map[string]ModelPrice{
+			"gpt-4o":            {InputPerMillion: 2.50, OutputPerMillion: 10.00, CacheReadPerMillion: 1.25},
+			"gpt-4o-mini":       {InputPerMillion: 0.15, OutputPerMillion: 0.60, CacheReadPerMillion: 0.075},
+			"gpt-4o-audio":      {InputPerMillion: 2.50, OutputPerMillion: 10.00, AudioInputPerMillion: 40.00, AudioOutputPerMillion: 80.00},
+			"claude-3-5-sonnet": {InputPerMillion: 3.00, OutputPerMillion: 15.00, CacheReadPerMillion: 0.30, CacheWritePerMillion: 3.75},
+			"claude-3-5-haiku":  {InputPerMillion: 0.80, OutputPerMillion: 4.00, CacheReadPerMillion: 0.08, CacheWritePerMillion: 1.00},
+			"claude-3-opus":     {InputPerMillion: 15.00, OutputPerMillion: 75.00, CacheReadPerMillion: 1.50, CacheWritePerMillion: 18.75},
+			"claude-3-haiku":    {InputPerMillion: 0.25, OutputPerMillion: 1.25, CacheReadPerMillion: 0.03, CacheWritePerMillion: 0.30},
+		},
+		DefaultModel: ModelPrice{InputPerMillion: 3.00, OutputPerMillion: 15.00},
+		Images: map[string]float64{
+			"dall-e-3:1024x1024":    0.040,
+			"dall-e-3:1024x1792":    0.080,
+			"dall-e-3:1792x1024":    0.080,
+			"dall-e-3:hd:1024x1024": 0.080,
+			"dall-e-3:hd:1024x1792": 0.120,
+			"dall-e-3:hd:1792x1024": 0.120,
+			"dall-e-2:256x256":      0.016,
+			"dall-e-2:512x512":      0.018,
+			"dall-e-2:1024x1024":    0.020,
+		},
+		TTSPerMillionChars: map[string]float64{
+			"tts-1":    15.00,
+			"tts-1-hd": 30.00,
+		},
+		TranscriptionPerMinute: map[string]float64{
+			"whisper-1": 0.006,
+		},
+		EmbeddingPerMillion: map[string]float64{
+			"text-embedding-3-small": 0.02,
+			"text-embedding-3-large": 0.13,
+			"text-embedding-ada-002": 0.10,
+		},
+		CreditValue: 0.01,
+		MinCredit:   1,
+	}
+}
+
+// LoadPriceTable read price table from json file, the value in the file override the default price table
+// so the file only need to contain the changed price
+func LoadPriceTable(path string) (*PriceTable, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.New("Failed to read price table: " + err.Error())
+	}
+
+	table := DefaultPriceTable()
+	if err := json.Unmarshal(file, table); err != nil {
+		return nil, errors.New("Failed to decode price table: " + err.Error())
+	}
+
+	if table.CreditValue <= 0 {
+		return nil, errors.New("Failed to load price table: credit_value must be greater than 0")
+	}
+
+	return table, nil
+}
+
+// ModelPrice return the price of the model, using the longest matched prefix
+func (t *PriceTable) ModelPrice(model string) ModelPrice {
+	price := t.DefaultModel
+	matched := ""
+
+	for name, p := range t.Models {
+		if strings.HasPrefix(model, name) && len(name) > len(matched) {
+			price = p
+			matched = name
+		}
+	}
+
+	return price
+}
+
+// TokenCost return the USD cost of chat completion usage
+func (t *PriceTable) TokenCost(model string, inputTokens int, outputTokens int) float64 {
+	price := t.ModelPrice(model)
+
+	return (float64(inputTokens)*price.InputPerMillion + float64(outputTokens)*price.OutputPerMillion) / 1_000_000
+}
+
+// AudioTokenCost return the USD cost of the audio tokens, the audio tokens is not included on TokenCost.
+// model without audio price is priced as text token
+func (t *PriceTable) AudioTokenCost(model string, audioInputTokens int, audioOutputTokens int) float64 {
+	price := t.ModelPrice(model)
+
+	inputPrice := price.AudioInputPerMillion
+	if inputPrice == 0 {
+		inputPrice = price.InputPerMillion
+	}
+
+	outputPrice := price.AudioOutputPerMillion
+	if outputPrice == 0 {
+		outputPrice = price.OutputPerMillion
+	}
+
+	return (float64(audioInputTokens)*inputPrice + float64(audioOutputTokens)*outputPrice) / 1_000_000
+}
+
+// CacheTokenCost return the USD cost of the prompt cache tokens, the cache tokens is not included on TokenCost.
+// model without cache price is priced as input token
+func (t *PriceTable) CacheTokenCost(model string, cacheReadTokens int, cacheWriteTokens int) float64 {
+	price := t.ModelPrice(model)
+
+	readPrice := price.CacheReadPerMillion
+	if readPrice == 0 {
+		readPrice = price.InputPerMillion
+	}
+
+	writePrice := price.CacheWritePerMillion
+	if writePrice == 0 {
+		writePrice = price.InputPerMillion
+	}
+
+	return (float64(cacheReadTokens)*readPrice + float64(cacheWriteTokens)*writePrice) / 1_000_000
+}
+
+// ImageCost return the USD cost of generating n image, empty quality is standard quality
+func (t *PriceTable) ImageCost(model string, quality string, size string, n int) float64 {
+	key := model + ":" + size
+	if quality == "hd" {
+		key = model + ":hd:" + size
+	}
+
+	return t.Images[key] * float64(n)
+}
+
+// TTSCost return the USD cost of text to speech input
+func (t *PriceTable) TTSCost(model string, input string) float64 {
+	return t.TTSPerMillionChars[model] * float64(len([]rune(input))) / 1_000_000
+}
+
+// TranscriptionCost return the USD cost of transcribing audio with the given duration in second
+func (t *PriceTable) TranscriptionCost(model string, seconds float64) float64 {
+	return t.TranscriptionPerMinute[model] * seconds / 60
+}
+
+// EmbeddingCost return the USD cost of embedding the input tokens
+func (t *PriceTable) EmbeddingCost(model string, tokens int) float64 {
+	return t.EmbeddingPerMillion[model] * float64(tokens) / 1_000_000
+}
+
+// Credits convert the USD cost to credit, rounded up and at least MinCredit
+func (t *PriceTable) Credits(cost float64) int {
+	// small epsilon so float error like 4.0000000001 not rounded up to 5
+	credits := int(math.Ceil(cost/t.CreditValue - 1e-9))
+	if credits < t.MinCredit {
+		credits = t.MinCredit
+	}
+
+	return credits
+}
@@ -0,0 +1,217 @@
+package pricing
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestModelPrice(t *testing.T) {
+	table := DefaultPriceTable()
+
+	tests := []struct {
+		model string
+		want  ModelPrice
+	}{
+		{model: "gpt-4o", want: table.Models["gpt-4o"]},
+		{model: "gpt-4o-2024-08-06", want: table.Models["gpt-4o"]},
+		// the longest prefix is used, not the first "gpt-4o" match
+		{model: "gpt-4o-mini", want: table.Models["gpt-4o-mini"]},
+		{model: "gpt-4o-mini-2024-07-18", want: table.Models["gpt-4o-mini"]},
+		{model: "gpt-4o-audio-preview", want: table.Models["gpt-4o-audio"]},
+		{model: "claude-3-5-sonnet-20240620", want: table.Models["claude-3-5-sonnet"]},
+		{model: "claude-3-5-haiku-20241022", want: table.Models["claude-3-5-haiku"]},
+		{model: "claude-3-haiku-20240307", want: table.Models["claude-3-haiku"]},
+		{model: "unknown-model", want: table.DefaultModel},
+		{model: "", want: table.DefaultModel},
+		// the key is matched as prefix, so the model that only contain the key is unknown
+		{model: "ft:gpt-4o-mini:org", want: table.DefaultModel},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			if got := table.ModelPrice(tt.model); got != tt.want {
+				t.Errorf("ModelPrice(%q) = %+v, want %+v", tt.model, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenCost(t *testing.T) {
+	table := DefaultPriceTable()
+
+	tests := []struct {
+		name         string
+		model        string
+		inputTokens  int
+		outputTokens int
+		want         float64
+	}{
+		{name: "zero tokens", model: "gpt-4o", want: 0},
+		{name: "input only", model: "gpt-4o", inputTokens: 1_000_000, want: 2.50},
+		{name: "output only", model: "gpt-4o", outputTokens: 1_000_000, want: 10.00},
+		{name: "input and output", model: "claude-3-5-sonnet-20240620", inputTokens: 1_000, outputTokens: 500, want: 0.0105},
+		{name: "unknown model use default price", model: "unknown-model", inputTokens: 1_000, outputTokens: 500, want: 0.0105},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := table.TokenCost(tt.model, tt.inputTokens, tt.outputTokens); math.Abs(got-tt.want) > 1e-12 {
+				t.Errorf("TokenCost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAudioAndCacheTokenCost(t *testing.T) {
+	table := DefaultPriceTable()
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{name: "audio price", got: table.AudioTokenCost("gpt-4o-audio-preview", 1_000_000, 1_000_000), want: 120.00},
+		{name: "audio without audio price priced as text", got: table.AudioTokenCost("gpt-4o", 1_000_000, 1_000_000), want: 12.50},
+		{name: "cache price", got: table.CacheTokenCost("claude-3-5-sonnet-20240620", 1_000_000, 1_000_000), want: 4.05},
+		{name: "cache without cache price priced as input", got: table.CacheTokenCost("unknown-model", 1_000_000, 1_000_000), want: 6.00},
+		{name: "zero tokens", got: table.CacheTokenCost("gpt-4o", 0, 0), want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.got-tt.want) > 1e-12 {
+				t.Errorf("cost = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredits(t *testing.T) {
+	tests := []struct {
+		name        string
+		creditValue float64
+		minCredit   int
+		cost        float64
+		want        int
+	}{
+		{name: "zero cost get min credit", creditValue: 0.01, minCredit: 1, cost: 0, want: 1},
+		{name: "small cost get min credit", creditValue: 0.01, minCredit: 1, cost: 0.0001, want: 1},
+		{name: "exact credit", creditValue: 0.01, minCredit: 1, cost: 0.04, want: 4},
+		// 0.01 * 3 is 0.030000000000000002 on float, must not be rounded up to 4
+		{name: "float error not rounded up", creditValue: 0.01, minCredit: 1, cost: 0.01 * 3, want: 3},
+		{name: "float error sum not rounded up", creditValue: 0.01, minCredit: 1, cost: 0.1 + 0.2, want: 30},
+		{name: "rounded up", creditValue: 0.01, minCredit: 1, cost: 0.0401, want: 5},
+		{name: "min credit floor", creditValue: 0.01, minCredit: 3, cost: 0.015, want: 3},
+		{name: "above min credit", creditValue: 0.01, minCredit: 3, cost: 0.035, want: 4},
+		{name: "no min credit", creditValue: 0.01, minCredit: 0, cost: 0, want: 0},
+		{name: "other credit value", creditValue: 0.05, minCredit: 1, cost: 0.12, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &PriceTable{CreditValue: tt.creditValue, MinCredit: tt.minCredit}
+
+			if got := table.Credits(tt.cost); got != tt.want {
+				t.Errorf("Credits(%v) = %d, want %d", tt.cost, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreditsFromUsage(t *testing.T) {
+	table := DefaultPriceTable()
+
+	tests := []struct {
+		name         string
+		model        string
+		inputTokens  int
+		outputTokens int
+		want         int
+	}{
+		{name: "zero tokens", model: "gpt-4o", want: 1},
+		{name: "short roast", model: "gpt-4o-mini", inputTokens: 1_500, outputTokens: 600, want: 1},
+		// 2000 * 3 / 1M + 2560 * 15 / 1M = 0.0444 USD
+		{name: "max output sonnet", model: "claude-3-5-sonnet-20240620", inputTokens: 2_000, outputTokens: 2_560, want: 5},
+		{name: "unknown model", model: "unknown-model", inputTokens: 10_000, outputTokens: 0, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := table.Credits(table.TokenCost(tt.model, tt.inputTokens, tt.outputTokens)); got != tt.want {
+				t.Errorf("credits = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFixedPriceCost(t *testing.T) {
+	table := DefaultPriceTable()
+
+	tests := []struct {
+		name string
+		got  float64
+		want float64
+	}{
+		{name: "image", got: table.ImageCost("dall-e-3", "", "1792x1024", 1), want: 0.080},
+		{name: "hd image", got: table.ImageCost("dall-e-3", "hd", "1024x1024", 2), want: 0.160},
+		{name: "unknown image size", got: table.ImageCost("dall-e-3", "", "10x10", 1), want: 0},
+		{name: "tts per character", got: table.TTSCost("tts-1", "héllo"), want: 15.00 * 5 / 1_000_000},
+		{name: "unknown tts model", got: table.TTSCost("tts-2", "hello"), want: 0},
+		{name: "transcription per minute", got: table.TranscriptionCost("whisper-1", 90), want: 0.009},
+		{name: "embedding", got: table.EmbeddingCost("text-embedding-3-small", 1_000_000), want: 0.02},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if math.Abs(tt.got-tt.want) > 1e-12 {
+				t.Errorf("cost = %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPriceTable(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "override", content: `{"credit_value": 0.02, "models": {"my-model": {"input_per_million": 1, "output_per_million": 2}}}`},
+		{name: "invalid json", content: `{`, wantErr: true},
+		{name: "zero credit value", content: `{"credit_value": 0}`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "price.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			table, err := LoadPriceTable(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPriceTable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if table.CreditValue != 0.02 {
+				t.Errorf("CreditValue = %v, want 0.02", table.CreditValue)
+			}
+			// the default price is kept for the model not on the file
+			if _, ok := table.Models["gpt-4o"]; !ok {
+				t.Error("default gpt-4o price is removed")
+			}
+			if got := table.ModelPrice("my-model-v2"); got.InputPerMillion != 1 || got.OutputPerMillion != 2 {
+				t.Errorf("ModelPrice(my-model-v2) = %+v", got)
+			}
+		})
+	}
+
+	if _, err := LoadPriceTable(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadPriceTable() with missing file error = nil")
+	}
+}
@@ -1,23 +1,62 @@
 package utils
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"net/http"
 	"scrapper-test/models"
+	"scrapper-test/utils/breaker"
 	"strings"
 
 	"github.com/gocolly/colly"
 )
 
+// transport used by every scrapper, replaced with SetScrapperTransport
+var scrapperTransport http.RoundTripper = http.DefaultTransport
+
+// SetScrapperTransport set the transport used by the scrapper, like the circuit breaker transport. Call it before the server started
+func SetScrapperTransport(transport http.RoundTripper) {
+	scrapperTransport = transport
+}
+
+// contextTransport attach the context to every request made by the colly collector,
+// so the scrapping stopped when the context is done
+type contextTransport struct {
+	ctx  context.Context
+	base http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req.WithContext(t.ctx))
+}
+
+// newCollectorWithContext create colly collector that bound to the context
+func newCollectorWithContext(ctx context.Context, options ...func(*colly.Collector)) *colly.Collector {
+	c := colly.NewCollector(options...)
+	c.WithTransport(&contextTransport{
+		ctx:  ctx,
+		base: scrapperTransport,
+	})
+
+	return c
+}
+
 func MediumProfileScrapper(username string) models.MediumProfileReturn {
+	returnPromptData, _ := MediumProfileScrapperWithContext(context.Background(), username)
+	return returnPromptData
+}
+
+// MediumProfileScrapperWithContext is the context-aware version of MediumProfileScrapper, error returned when the context is done before the scrapping finished
+func MediumProfileScrapperWithContext(ctx context.Context, username string) (models.MediumProfileReturn, error) {
 	username = strings.TrimSpace(username)
 	var returnPromptData models.MediumProfileReturn
 
 	if username == "" {
 		returnPromptData.PromptData = "malah kasih username kosong kocak nih"
-		return returnPromptData
+		return returnPromptData, nil
 	}
 
-	c := colly.NewCollector(
+	c := newCollectorWithContext(ctx,
 		colly.AllowedDomains("medium.com"),
 	)
 
@@ -59,7 +98,7 @@ func MediumProfileScrapper(username string) models.MediumProfileReturn {
 		} else {
 			returnPromptData.PromptData = "Your Profile Data: \n"
 			returnPromptData.PromptData += "Name: " + Profile.Name + "\n"
-			returnPromptData.PromptData += "Follower: " + fmt.Sprintf("%d", Profile.Follower) + "\n"
+			returnPromptData.PromptData += "Follower: " + Profile.Follower + "\n"
 			returnPromptData.PromptData += "Photo: " + Profile.Photo + "\n"
 			returnPromptData.PromptData += "Bio: " + Profile.Bio + "\n"
 			returnPromptData.PromptData += "Your Post Data: \n"
@@ -76,17 +115,30 @@ func MediumProfileScrapper(username string) models.MediumProfileReturn {
 		}
 	})
 
-	c.Visit("https://medium.com/@" + username)
+	// the visit error is ignored except the open circuit, so the feature fail fast when medium.com is down
+	if err := c.Visit("https://medium.com/@" + username); errors.Is(err, breaker.ErrOpen) {
+		return returnPromptData, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return returnPromptData, err
+	}
 
 	returnPromptData.MediumProfileUser = Profile.MediumProfileUser
 
-	return returnPromptData
+	return returnPromptData, nil
 }
 
 func GetBakuHantamTopic() []models.BakuHantamTopicList {
+	BakuHantamTopicList, _ := GetBakuHantamTopicWithContext(context.Background())
+	return BakuHantamTopicList
+}
+
+// GetBakuHantamTopicWithContext is the context-aware version of GetBakuHantamTopic
+func GetBakuHantamTopicWithContext(ctx context.Context) ([]models.BakuHantamTopicList, error) {
 	var BakuHantamTopicList []models.BakuHantamTopicList
 
-	c := colly.NewCollector(
+	c := newCollectorWithContext(ctx,
 		colly.AllowedDomains("bakuhantam.dev"),
 	)
 
@@ -107,13 +159,23 @@ func GetBakuHantamTopic() []models.BakuHantamTopicList {
 
 	c.Visit("https://bakuhantam.dev")
 
-	return BakuHantamTopicList
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return BakuHantamTopicList, nil
 }
 
 func DetailBakuHantamData(topic string) []models.BHTopicDetail {
+	BakuHantamDetail, _ := DetailBakuHantamDataWithContext(context.Background(), topic)
+	return BakuHantamDetail
+}
+
+// DetailBakuHantamDataWithContext is the context-aware version of DetailBakuHantamData
+func DetailBakuHantamDataWithContext(ctx context.Context, topic string) ([]models.BHTopicDetail, error) {
 	var BakuHantamDetail []models.BHTopicDetail
 
-	c := colly.NewCollector(
+	c := newCollectorWithContext(ctx,
 		colly.AllowedDomains("bakuhantam.dev"),
 	)
 
@@ -143,5 +205,9 @@ func DetailBakuHantamData(topic string) []models.BHTopicDetail {
 
 	c.Visit("https://bakuhantam.dev" + topic)
 
-	return BakuHantamDetail
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return BakuHantamDetail, nil
 }
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// SetSSEHeaders set the response headers needed for server-sent events response
+func SetSSEHeaders(c *fiber.Ctx) {
+	c.Set("Content-Type", "text/event-stream")
+	c.Set("Cache-Control", "no-cache")
+	c.Set("Connection", "keep-alive")
+	c.Set("X-Accel-Buffering", "no") // disable buffering on nginx proxy
+}
+
+// WriteSSEEvent write one server-sent event with json data and flush it to the client,
+// error returned when the client connection is already closed
+func WriteSSEEvent(w *bufio.Writer, event string, data interface{}) error {
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, dataJson); err != nil {
+		return err
+	}
+
+	return w.Flush()
+}
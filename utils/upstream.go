@@ -0,0 +1,41 @@
+package utils
+
+// upstream service name, used as the circuit breaker name
+const (
+	UPSTREAM_CLAUDE      = "claude"
+	UPSTREAM_OPENAI_CHAT = "openai_chat"
+	UPSTREAM_DALLE       = "dall_e"
+	UPSTREAM_TTS         = "tts"
+	UPSTREAM_MEDIUM      = "medium"
+)
+
+// DegradedUpstream is the upstream with open circuit shown on the index page, with the features that use it
+type DegradedUpstream struct {
+	Name        string
+	DisplayName string
+	Features    string
+}
+
+var upstreamFeatures = map[string]DegradedUpstream{
+	UPSTREAM_CLAUDE:      {DisplayName: "Claude", Features: "Medium Roast, Story Generator, and Creative Content with Claude model"},
+	UPSTREAM_OPENAI_CHAT: {DisplayName: "OpenAI GPT", Features: "Medium Roast, Story Generator, and Creative Content with GPT model, story narration"},
+	UPSTREAM_DALLE:       {DisplayName: "DALL-E", Features: "Creative Content image generation"},
+	UPSTREAM_TTS:         {DisplayName: "OpenAI TTS", Features: "Creative Content text to speech"},
+	UPSTREAM_MEDIUM:      {DisplayName: "medium.com", Features: "Medium Roast"},
+}
+
+// DegradedUpstreams return the display data of the upstream names, in the same order
+func DegradedUpstreams(names []string) []DegradedUpstream {
+	degraded := make([]DegradedUpstream, 0, len(names))
+	for _, name := range names {
+		upstream, ok := upstreamFeatures[name]
+		if !ok {
+			upstream = DegradedUpstream{DisplayName: name}
+		}
+		upstream.Name = name
+
+		degraded = append(degraded, upstream)
+	}
+
+	return degraded
+}